@@ -0,0 +1,100 @@
+package api
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/urusofam/jsondb/storage"
+)
+
+// compoundDB создает базу данных с коллекцией o и составным индексом по полям t, s, c.
+// Любое из полей может отсутствовать в документе
+func compoundDB(t *testing.T) (*DB, *Collection) {
+	t.Helper()
+	
+	db := NewDB()
+	coll := randomCollection(t, db, "o", 1, 400, map[string]fieldGen{
+		"t": mixed(numbers(4), numbers(4), numbers(4), oneOf(missing)),
+		"s": oneOf("open", "closed", "new", "open", "closed", "new", missing),
+		"c": mixed(numbers(50), numbers(50), numbers(50), oneOf(missing)),
+	})
+	if err := coll.CreateIndex("t, s, c", IndexTypeBTree, 4); err != nil {
+		t.Fatal(err)
+	}
+	return db, coll
+}
+
+func TestCompoundIndexMatchesFullScan(t *testing.T) {
+	db, coll := compoundDB(t)
+	
+	// Изменения после создания индекса
+	for i := 0; i < 400; i += 7 {
+		id := fmt.Sprintf("d%03d", i)
+		if i%2 == 0 {
+			coll.DeleteDocument(id)
+		} else {
+			coll.UpdateDocument(storage.Document{ID: id, Content: map[string]interface{}{"t": 1.0, "s": "open", "c": float64(i % 30)}})
+		}
+	}
+	
+	for _, where := range []string{
+		"t = 1 AND s = 'open' AND c > 20",
+		"t = 1 AND s = 'open' AND c >= 20 AND c < 30",
+		"t = 1 AND s = 'open' AND c = 7",
+		"t = 2 AND s = 'new'",
+		"t = 2",
+		"t > 1",
+		"t = 2 AND c < 10",
+		"t = 3 AND s IS NULL",
+		"t = 1 AND s = 'open' AND c IS MISSING",
+		"t = 1 OR t = 3",
+	} {
+		q := "SELECT _id, t, s, c FROM o WHERE " + where
+		checkScan(t, db, q)
+		if plan, _ := db.Explain(q); !strings.Contains(plan.String(), "составному индексу") {
+			t.Errorf("%s: составной индекс не используется:\n%s", where, plan)
+		}
+	}
+	
+	if err := coll.CreateIndex("a[*],b", IndexTypeBTree, 0); err == nil {
+		t.Fatal("создан составной индекс по пути с [*]")
+	}
+	if err := coll.DropIndex("t,s,c"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCompoundIndexOrder(t *testing.T) {
+	db, _ := compoundDB(t)
+	
+	// Порядок строк совпадает с сортировкой при полном просмотре. Без сортировки
+	// выполняются запросы, порядок которых задается обходом индекса; _id в конце
+	// ORDER BY исключает произвол при равных ключах и требует сортировки
+	for _, c := range []struct {
+		q      string
+		sorted bool
+	}{
+		{"SELECT _id, c FROM o WHERE t = 1 AND s = 'open' ORDER BY c, _id", true},
+		{"SELECT _id, c FROM o WHERE t = 1 AND s = 'open' ORDER BY c DESC, _id", true},
+		{"SELECT c FROM o WHERE t = 1 AND s = 'open' ORDER BY c DESC NULLS LAST", false},
+		{"SELECT c FROM o WHERE t = 1 AND s = 'open' ORDER BY c DESC LIMIT 5", false},
+		{"SELECT c FROM o WHERE t = 1 AND s = 'open' AND c > 3 ORDER BY c DESC LIMIT 5", false},
+		{"SELECT c FROM o WHERE t = 1 AND s = 'open' AND c > 3 ORDER BY c LIMIT 5 OFFSET 2", false},
+		{"SELECT s, c FROM o WHERE t = 1 ORDER BY s, c", false},
+		{"SELECT s, c FROM o WHERE t = 1 ORDER BY s DESC, c DESC", false},
+		{"SELECT t, s, c FROM o WHERE t = 1 ORDER BY t, s NULLS FIRST, c NULLS FIRST LIMIT 7", false},
+		{"SELECT _id, c FROM o WHERE t = 2 ORDER BY c, _id", true},
+	} {
+		got, err := db.Query(c.q)
+		if err != nil {
+			t.Fatalf("%s: %v", c.q, err)
+		}
+		if want := scanRows(t, db, c.q); fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("%s: %v, полный просмотр дает %v", c.q, got, want)
+		}
+		if plan, _ := db.Explain(c.q); strings.Contains(plan.String(), "Sort") != c.sorted {
+			t.Errorf("%s: сортировка в плане %v, ожидалось %v:\n%s", c.q, !c.sorted, c.sorted, plan)
+		}
+	}
+}
@@ -3,8 +3,10 @@ package api
 import (
 	"errors"
 	"fmt"
+	"io"
 	"sync"
 
+	"github.com/urusofam/jsondb/config"
 	"github.com/urusofam/jsondb/index"
 	"github.com/urusofam/jsondb/query"
 	"github.com/urusofam/jsondb/storage"
@@ -17,6 +19,15 @@ type DB struct {
 	Executor    *query.QueryExecutor
 	Functions   *query.FunctionRegistry
 	Mutex       sync.RWMutex
+	
+	// Config содержит конфигурацию базы данных, открытой через Open;
+	// для базы, созданной NewDB, равен nil
+	Config *config.DBConfig
+	
+	catalogMu sync.Mutex
+	
+	// txLog - журнал фиксаций транзакций; nil для базы данных в памяти
+	txLog *txLog
 }
 
 // NewDB создает новую базу данных
@@ -30,37 +41,81 @@ func NewDB() *DB {
 		Executor:    query.NewQueryExecutor(qCollections),
 		Functions:   query.NewFunctionRegistry(),
 	}
+	db.Executor.Functions = db.Functions
 	
 	return db
 }
 
-// CreateCollection создает новую коллекцию
-func (db *DB) CreateCollection(name string, storage storage.Storage) error {
+// CreateCollection создает новую коллекцию. Хранилище, не реализующее
+// storage.Snapshotter, оборачивается в storage.VersionedStorage, чтобы
+// запросы читали согласованный снимок. Обертка запускает фоновую очистку
+// версий, которая останавливается только в Close, поэтому базу данных
+// нужно закрывать. Для хранилища на диске восстанавливаются индексы из
+// каталога коллекции
+func (db *DB) CreateCollection(name string, store storage.Storage) error {
 	db.Mutex.Lock()
 	defer db.Mutex.Unlock()
 	
+	if err := db.addCollection(name, store); err != nil {
+		return err
+	}
+	return db.saveCatalog()
+}
+
+// addCollection регистрирует коллекцию, не перезаписывая каталог.
+// Вызывается с захваченной блокировкой базы данных
+func (db *DB) addCollection(name string, store storage.Storage) error {
 	if _, ok := db.Collections[name]; ok {
 		return fmt.Errorf("коллекция %s уже существует", name)
 	}
 	
+	// Хранилище, которое само выдает снимки, не оборачивается повторно
+	if _, ok := store.(storage.Snapshotter); !ok {
+		store = storage.NewVersionedStorage(store, storage.DefaultVacuumInterval)
+	}
+	
 	collection := &Collection{
-		Name:    name,
-		Storage: storage,
-		Indexes: make(map[string]index.Index),
+		Name:         name,
+		Storage:      store,
+		Indexes:      make(map[string]index.Index),
+		definitions:  make(map[string]IndexDefinition),
+		defaultOrder: db.defaultBTreeOrder(),
+	}
+	
+	// Восстановить индексы, сохраненные в каталоге коллекции на диске
+	if err := collection.loadIndexes(); err != nil {
+		return fmt.Errorf("загрузка индексов коллекции %s: %w", name, err)
 	}
+	collection.onChange = db.catalogChanged
 	
 	db.Collections[name] = collection
 	
 	// Обновить коллекции для исполнителя запросов
+	db.refreshExecutor()
+	
+	return nil
+}
+
+// defaultBTreeOrder возвращает порядок B-дерева для индексов, созданных без явного порядка
+func (db *DB) defaultBTreeOrder() int {
+	if db.Config != nil && db.Config.DefaultBTreeOrder > 0 {
+		return db.Config.DefaultBTreeOrder
+	}
+	return config.DefaultConfig().DefaultBTreeOrder
+}
+
+// refreshExecutor пересоздает исполнитель запросов для текущего набора коллекций
+func (db *DB) refreshExecutor() {
 	qCollections := make(map[string]query.Collection)
 	for name, coll := range db.Collections {
 		qCollections[name] = query.Collection{
 			Storage: coll.Storage,
+			Indexes: coll.Indexes,
+			Lock:    coll.Mutex.RLocker(),
 		}
 	}
 	db.Executor = query.NewQueryExecutor(qCollections)
-	
-	return nil
+	db.Executor.Functions = db.Functions
 }
 
 // GetCollection возвращает коллекцию
@@ -81,26 +136,107 @@ func (db *DB) DropCollection(name string) error {
 	db.Mutex.Lock()
 	defer db.Mutex.Unlock()
 	
-	if _, ok := db.Collections[name]; !ok {
+	coll, ok := db.Collections[name]
+	if !ok {
 		return fmt.Errorf("коллекция %s не найдена", name)
 	}
 	
 	delete(db.Collections, name)
+	coll.onChange = nil
 	
 	// Обновить коллекции для исполнителя запросов
-	qCollections := make(map[string]query.Collection)
-	for name, coll := range db.Collections {
-		qCollections[name] = query.Collection{
-			Storage: coll.Storage,
+	db.refreshExecutor()
+	
+	err := db.saveCatalog()
+	
+	// Освободить ресурсы хранилища, например журнал файлового хранилища
+	if closer, ok := coll.Storage.(io.Closer); ok {
+		if closeErr := closer.Close(); err == nil {
+			err = closeErr
 		}
 	}
-	db.Executor = query.NewQueryExecutor(qCollections)
 	
-	return nil
+	return err
+}
+
+// Close закрывает хранилища всех коллекций: сохраняет данные индексов,
+// останавливает фоновую очистку версий и закрывает журналы хранилищ на диске
+func (db *DB) Close() error {
+	db.Mutex.Lock()
+	defer db.Mutex.Unlock()
+	
+	// Записать каталог до закрытия хранилищ, пока доступны их параметры
+	result := db.saveCatalog()
+	for _, coll := range db.Collections {
+		if err := coll.close(); err != nil && result == nil {
+			result = fmt.Errorf("коллекция %s: %w", coll.Name, err)
+		}
+	}
+	if err := db.txLog.close(); err != nil && result == nil {
+		result = fmt.Errorf("журнал транзакций: %w", err)
+	}
+	db.txLog = nil
+	
+	return result
 }
 
-// Query выполняет запрос
+// Query выполняет запрос SELECT или инструкцию INSERT, UPDATE, DELETE.
+// Для инструкции изменения возвращается одна строка с полем affected -
+// числом затронутых документов
 func (db *DB) Query(queryStr string) ([]map[string]interface{}, error) {
+	stmt, err := db.Parser.ParseStatement(queryStr)
+	if err != nil {
+		return nil, err
+	}
+	
+	q, ok := stmt.(*query.Query)
+	if !ok {
+		affected, err := db.exec(stmt)
+		if err != nil {
+			return nil, err
+		}
+		return affectedRows(affected), nil
+	}
+	
+	db.Mutex.RLock()
+	defer db.Mutex.RUnlock()
+	
+	return db.Executor.Execute(q)
+}
+
+// Exec выполняет инструкцию INSERT, UPDATE или DELETE и возвращает число
+// затронутых документов. Инструкция выполняется в отдельной транзакции через
+// методы коллекций, поэтому индексы обновляются, а при ошибке или нарушении
+// ограничения не применяется ни одно изменение
+func (db *DB) Exec(queryStr string) (int, error) {
+	stmt, err := db.Parser.ParseStatement(queryStr)
+	if err != nil {
+		return 0, err
+	}
+	return db.exec(stmt)
+}
+
+// exec выполняет инструкцию изменения в отдельной транзакции
+func (db *DB) exec(stmt query.Statement) (int, error) {
+	tx := db.Begin()
+	
+	tx.mu.Lock()
+	affected, err := tx.exec(stmt)
+	tx.mu.Unlock()
+	
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return affected, nil
+}
+
+// Explain выполняет запрос и возвращает план выполнения со статистикой.
+// Префикс EXPLAIN в строке запроса необязателен
+func (db *DB) Explain(queryStr string) (*query.PlanNode, error) {
 	db.Mutex.RLock()
 	defer db.Mutex.RUnlock()
 	
@@ -109,7 +245,7 @@ func (db *DB) Query(queryStr string) ([]map[string]interface{}, error) {
 		return nil, err
 	}
 	
-	return db.Executor.Execute(query)
+	return db.Executor.Explain(query)
 }
 
 // Collection предоставляет операции над коллекцией
@@ -118,9 +254,29 @@ type Collection struct {
 	Storage storage.Storage
 	Indexes map[string]index.Index
 	Mutex   sync.RWMutex
+	
+	// definitions содержит определения индексов для каталога коллекции
+	definitions map[string]IndexDefinition
+	closed      bool
+	
+	// defaultOrder - порядок B-дерева для индексов, созданных с порядком 0
+	defaultOrder int
+	
+	// onChange вызывается после изменения набора индексов без
+	// удержания блокировки коллекции
+	onChange func() error
 }
 
-// InsertDocument вставляет документ в коллекцию
+// changed сообщает об изменении набора индексов коллекции
+func (c *Collection) changed() error {
+	if c.onChange == nil {
+		return nil
+	}
+	return c.onChange()
+}
+
+// InsertDocument вставляет документ в коллекцию. Если документ с таким ID
+// уже есть или нарушено ограничение уникального индекса, возвращается *index.DuplicateKeyError
 func (c *Collection) InsertDocument(doc storage.Document) error {
 	c.Mutex.Lock()
 	defer c.Mutex.Unlock()
@@ -128,19 +284,11 @@ func (c *Collection) InsertDocument(doc storage.Document) error {
 	if doc.ID == "" {
 		return errors.New("ID документа обязателен")
 	}
-	
-	if err := c.Storage.Save(doc); err != nil {
-		return err
+	if _, err := c.Storage.Get(doc.ID); err == nil {
+		return &index.DuplicateKeyError{Field: "_id", Value: doc.ID, ID: doc.ID}
 	}
 	
-	// Обновить индексы
-	for _, idx := range c.Indexes {
-		if err := idx.Add(doc); err != nil {
-			return err
-		}
-	}
-	
-	return nil
+	return c.write(doc.ID, &doc)
 }
 
 // GetDocument получает документ из коллекции
@@ -151,7 +299,8 @@ func (c *Collection) GetDocument(id string) (storage.Document, error) {
 	return c.Storage.Get(id)
 }
 
-// UpdateDocument обновляет документ в коллекции
+// UpdateDocument обновляет документ в коллекции. При нарушении ограничения
+// уникального индекса возвращается *index.DuplicateKeyError
 func (c *Collection) UpdateDocument(doc storage.Document) error {
 	c.Mutex.Lock()
 	defer c.Mutex.Unlock()
@@ -160,92 +309,220 @@ func (c *Collection) UpdateDocument(doc storage.Document) error {
 		return errors.New("ID документа обязателен")
 	}
 	
-	// Удалить документ из индексов
-	oldDoc, err := c.Storage.Get(doc.ID)
-	if err == nil {
+	return c.write(doc.ID, &doc)
+}
+
+// DeleteDocument удаляет документ из коллекции
+func (c *Collection) DeleteDocument(id string) error {
+	c.Mutex.Lock()
+	defer c.Mutex.Unlock()
+	
+	return c.write(id, nil)
+}
+
+// write сохраняет документ (или удаляет его, если doc равен nil) в хранилище
+// и обновляет индексы. Ограничения уникальности проверяются до изменений;
+// если изменение все же не удалось, прежняя версия документа возвращается
+// в хранилище и индексы. Вызывается с захваченной блокировкой коллекции
+func (c *Collection) write(id string, doc *storage.Document) error {
+	if doc != nil {
 		for _, idx := range c.Indexes {
-			if err := idx.Remove(oldDoc.ID); err != nil {
-				return err
+			if constraint, ok := idx.(index.Constraint); ok {
+				if err := constraint.Check(*doc); err != nil {
+					return err
+				}
 			}
 		}
 	}
 	
-	if err := c.Storage.Save(doc); err != nil {
+	var previous *storage.Document
+	if old, err := c.Storage.Get(id); err == nil {
+		previous = &old
+	}
+	
+	stored, err := c.apply(id, doc)
+	if err != nil {
+		c.restore(id, previous, stored)
 		return err
 	}
+	return nil
+}
+
+// apply выполняет изменение документа в индексах и хранилище и сообщает,
+// успело ли измениться хранилище
+func (c *Collection) apply(id string, doc *storage.Document) (bool, error) {
+	// Удалить прежнюю версию документа из индексов
+	for _, idx := range c.Indexes {
+		if err := idx.Remove(id); err != nil {
+			return false, err
+		}
+	}
+	
+	if doc == nil {
+		if err := c.Storage.Delete(id); err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+	
+	if err := c.Storage.Save(*doc); err != nil {
+		return false, err
+	}
 	
 	// Обновить индексы
 	for _, idx := range c.Indexes {
-		if err := idx.Add(doc); err != nil {
-			return err
+		if err := idx.Add(*doc); err != nil {
+			return true, err
 		}
 	}
 	
-	return nil
+	return true, nil
 }
 
-// DeleteDocument удаляет документ из коллекции
-func (c *Collection) DeleteDocument(id string) error {
-	c.Mutex.Lock()
-	defer c.Mutex.Unlock()
+// restore возвращает прежнюю версию документа после неудачного изменения:
+// в хранилище, если оно успело измениться, и во все индексы
+func (c *Collection) restore(id string, previous *storage.Document, stored bool) {
+	if stored {
+		if previous != nil {
+			c.Storage.Save(*previous)
+		} else {
+			c.Storage.Delete(id)
+		}
+	}
 	
-	// Удалить документ из индексов
 	for _, idx := range c.Indexes {
-		if err := idx.Remove(id); err != nil {
-			return err
+		idx.Remove(id)
+		if previous != nil {
+			idx.Add(*previous)
 		}
 	}
-	
-	return c.Storage.Delete(id)
 }
 
-// CreateIndex создает индекс по полю
+// CreateIndex создает индекс по полю. Составной индекс задается списком
+// полей через запятую, например "tenant_id,status,created_at". Порядок
+// B-дерева order <= 0 заменяется порядком по умолчанию из конфигурации базы данных
 func (c *Collection) CreateIndex(field string, indexType string, order int) error {
+	if err := c.createIndex(field, indexType, order); err != nil {
+		return err
+	}
+	return c.changed()
+}
+
+// createIndex создает индекс и сохраняет его определение в каталоге коллекции
+func (c *Collection) createIndex(field string, indexType string, order int) error {
 	c.Mutex.Lock()
 	defer c.Mutex.Unlock()
 	
+	name, fields, err := indexFields(field)
+	if err != nil {
+		return err
+	}
+	field = name
+	
 	if _, ok := c.Indexes[field]; ok {
 		return fmt.Errorf("индекс по полю %s уже существует", field)
 	}
 	
-	var idx index.Index
+	if order <= 0 {
+		order = c.defaultOrder
+	}
 	
-	switch indexType {
-	case "btree":
-		idx = index.NewBTreeIndex(field, order)
-	default:
-		return fmt.Errorf("неизвестный тип индекса: %s", indexType)
+	def := IndexDefinition{Field: field, Fields: fields, Type: indexType, Order: order}
+	idx, err := c.buildIndex(def)
+	if err != nil {
+		return err
 	}
 	
+	if bt, ok := idx.(*index.BTreeIndex); ok {
+		def.Order = bt.Order
+	}
 	c.Indexes[field] = idx
+	c.definitions[field] = def
+	
+	// Сохранить определение индекса в каталоге коллекции
+	if err := c.saveCatalog(); err != nil {
+		delete(c.Indexes, field)
+		delete(c.definitions, field)
+		return err
+	}
 	
-	// Добавить все документы в индекс
+	return nil
+}
+
+// buildIndex создает индекс по определению и добавляет в него все документы коллекции
+func (c *Collection) buildIndex(def IndexDefinition) (index.Index, error) {
+	var bt *index.BTreeIndex
+	
+	switch def.Type {
+	case IndexTypeFullText, IndexTypeHash:
+		if len(def.Fields) > 0 {
+			return nil, fmt.Errorf("индекс типа %s строится только по одному полю", def.Type)
+		}
+		if def.Type == IndexTypeHash {
+			return c.fillIndex(index.NewHashIndex(def.Field))
+		}
+		return c.fillIndex(index.NewFullTextIndex(def.Field))
+	case IndexTypeBTree:
+		bt = index.NewBTreeIndex(def.Field, def.Order)
+	case IndexTypeUnique:
+		bt = index.NewUniqueBTreeIndex(def.Field, def.Order, false)
+	case IndexTypeUniqueSparse:
+		bt = index.NewUniqueBTreeIndex(def.Field, def.Order, true)
+	default:
+		return nil, fmt.Errorf("неизвестный тип индекса: %s", def.Type)
+	}
+	
+	if len(def.Fields) > 0 {
+		compound := index.NewCompoundIndex(def.Fields, def.Order)
+		compound.Unique, compound.Sparse = bt.Unique, bt.Sparse
+		bt = compound
+	}
+	return c.fillIndex(bt)
+}
+
+// fillIndex добавляет в индекс все документы коллекции
+func (c *Collection) fillIndex(idx index.Index) (index.Index, error) {
 	docs, err := c.Storage.List()
 	if err != nil {
-		return err
+		return nil, err
 	}
 	
 	for _, doc := range docs {
 		if err := idx.Add(doc); err != nil {
-			return err
+			return nil, err
 		}
 	}
 	
-	return nil
+	return idx, nil
 }
 
 // DropIndex удаляет индекс
 func (c *Collection) DropIndex(field string) error {
+	if err := c.dropIndex(field); err != nil {
+		return err
+	}
+	return c.changed()
+}
+
+// dropIndex удаляет индекс, его определение и сохраненные данные
+func (c *Collection) dropIndex(field string) error {
 	c.Mutex.Lock()
 	defer c.Mutex.Unlock()
 	
+	if name, _, err := indexFields(field); err == nil {
+		field = name
+	}
 	if _, ok := c.Indexes[field]; !ok {
 		return fmt.Errorf("индекс по полю %s не найден", field)
 	}
 	
 	delete(c.Indexes, field)
+	delete(c.definitions, field)
 	
-	return nil
+	if err := c.saveCatalog(); err != nil {
+		return err
+	}
+	return c.removeIndexData(field)
 }
 
 // FindByIndex находит документы используя индекс
@@ -263,6 +540,78 @@ func (c *Collection) FindByIndex(field string, value interface{}) ([]storage.Doc
 		return nil, err
 	}
 	
+	return c.loadDocuments(ids), nil
+}
+
+// FindRange находит документы, значения поля которых попадают в диапазон.
+// Документы возвращаются в порядке возрастания значения поля
+func (c *Collection) FindRange(field string, r index.Range) ([]storage.Document, error) {
+	c.Mutex.RLock()
+	defer c.Mutex.RUnlock()
+	
+	idx, err := c.orderedIndex(field)
+	if err != nil {
+		return nil, err
+	}
+	
+	ids, err := idx.SearchRange(field, r)
+	if err != nil {
+		return nil, err
+	}
+	
+	return c.loadDocuments(ids), nil
+}
+
+// FindSorted возвращает документы, упорядоченные по значению индексированного поля.
+// Документы без этого поля в результат не попадают
+func (c *Collection) FindSorted(field string, descending bool) ([]storage.Document, error) {
+	c.Mutex.RLock()
+	defer c.Mutex.RUnlock()
+	
+	idx, err := c.orderedIndex(field)
+	if err != nil {
+		return nil, err
+	}
+	
+	// Документ, проиндексированный по пути с [*], выводится по первому из своих ключей
+	ids := make([]string, 0)
+	seen := make(map[string]bool)
+	collect := func(key interface{}, keyIDs []string) bool {
+		for _, id := range keyIDs {
+			if !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+		return true
+	}
+	
+	if descending {
+		idx.Descend(index.Range{}, collect)
+	} else {
+		idx.Ascend(index.Range{}, collect)
+	}
+	
+	return c.loadDocuments(ids), nil
+}
+
+// orderedIndex возвращает индекс по полю, поддерживающий упорядоченный обход
+func (c *Collection) orderedIndex(field string) (index.OrderedIndex, error) {
+	idx, ok := c.Indexes[field]
+	if !ok {
+		return nil, fmt.Errorf("индекс по полю %s не найден", field)
+	}
+	
+	ordered, ok := idx.(index.OrderedIndex)
+	if !ok {
+		return nil, fmt.Errorf("индекс по полю %s не поддерживает поиск по диапазону", field)
+	}
+	
+	return ordered, nil
+}
+
+// loadDocuments загружает документы по списку ID, сохраняя их порядок
+func (c *Collection) loadDocuments(ids []string) []storage.Document {
 	docs := make([]storage.Document, 0, len(ids))
 	
 	for _, id := range ids {
@@ -274,11 +623,22 @@ func (c *Collection) FindByIndex(field string, value interface{}) ([]storage.Doc
 		docs = append(docs, doc)
 	}
 	
-	return docs, nil
+	return docs
 }
 
-// ListDocuments возвращает все документы в коллекции
+// ListDocuments возвращает все документы в коллекции.
+// Документы читаются из снимка, поэтому чтение не блокирует запись
+// и не видит частично примененных транзакций
 func (c *Collection) ListDocuments() ([]storage.Document, error) {
+	if snapshotter, ok := c.Storage.(storage.Snapshotter); ok {
+		c.Mutex.RLock()
+		snapshot := snapshotter.Snapshot()
+		c.Mutex.RUnlock()
+		defer snapshot.Release()
+		
+		return snapshot.List()
+	}
+	
 	c.Mutex.RLock()
 	defer c.Mutex.RUnlock()
 	
@@ -290,6 +650,10 @@ func (c *Collection) Size() (int, error) {
 	c.Mutex.RLock()
 	defer c.Mutex.RUnlock()
 	
+	if counter, ok := c.Storage.(storage.Counter); ok {
+		return counter.Count()
+	}
+	
 	docs, err := c.Storage.List()
 	if err != nil {
 		return 0, err
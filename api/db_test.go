@@ -0,0 +1,202 @@
+package api
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/urusofam/jsondb/config"
+	"github.com/urusofam/jsondb/index"
+	"github.com/urusofam/jsondb/query"
+	"github.com/urusofam/jsondb/storage"
+)
+
+// rowSet возвращает строки результата в виде строки, не зависящей от их порядка
+func rowSet(rows []map[string]interface{}) string {
+	keys := make([]string, len(rows))
+	for i, row := range rows {
+		keys[i] = fmt.Sprint(row)
+	}
+	sort.Strings(keys)
+	return fmt.Sprint(keys)
+}
+
+// checkScan проверяет, что запросы возвращают те же строки, что и полный
+// просмотр коллекций без индексов
+func checkScan(t *testing.T, db *DB, queries ...string) {
+	t.Helper()
+	
+	for _, q := range queries {
+		got, err := db.Query(q)
+		if err != nil {
+			t.Fatalf("%s: %v", q, err)
+		}
+		if want := scanRows(t, db, q); rowSet(got) != rowSet(want) {
+			t.Fatalf("%s: %v, полный просмотр дает %v", q, rowSet(got), rowSet(want))
+		}
+	}
+}
+
+// scanRows выполняет запрос полным просмотром коллекций без индексов
+func scanRows(t *testing.T, db *DB, q string) []map[string]interface{} {
+	t.Helper()
+	
+	scan := make(map[string]query.Collection)
+	for name, coll := range db.Collections {
+		scan[name] = query.Collection{Storage: coll.Storage, Indexes: map[string]index.Index{}}
+	}
+	executor := query.NewQueryExecutor(scan)
+	executor.Functions = db.Functions
+	
+	parsed, err := db.Parser.Parse(q)
+	if err != nil {
+		t.Fatalf("%s: %v", q, err)
+	}
+	rows, err := executor.Execute(parsed)
+	if err != nil {
+		t.Fatalf("%s: полный просмотр: %v", q, err)
+	}
+	return rows
+}
+
+// fieldGen возвращает случайное значение поля документа или missing
+type fieldGen func(rnd *rand.Rand) interface{}
+
+// missing - значение fieldGen для поля, которого нет в документе
+var missing = new(struct{})
+
+// oneOf выбирает одно из значений с равной вероятностью
+func oneOf(values ...interface{}) fieldGen {
+	return func(rnd *rand.Rand) interface{} {
+		return values[rnd.Intn(len(values))]
+	}
+}
+
+// numbers возвращает целые числа от 0 до n-1
+func numbers(n int) fieldGen {
+	return func(rnd *rand.Rand) interface{} {
+		return float64(rnd.Intn(n))
+	}
+}
+
+// texts возвращает строки prefix0 ... prefix{n-1}
+func texts(prefix string, n int) fieldGen {
+	return func(rnd *rand.Rand) interface{} {
+		return fmt.Sprint(prefix, rnd.Intn(n))
+	}
+}
+
+// refs возвращает ID документов d000 ... d{n-1}, созданных randomCollection
+func refs(n int) fieldGen {
+	return func(rnd *rand.Rand) interface{} {
+		return fmt.Sprintf("d%03d", rnd.Intn(n))
+	}
+}
+
+// mixed выбирает один из генераторов с равной вероятностью
+func mixed(gens ...fieldGen) fieldGen {
+	return func(rnd *rand.Rand) interface{} {
+		return gens[rnd.Intn(len(gens))](rnd)
+	}
+}
+
+// randomCollection создает коллекцию name из n документов d000, d001, ...,
+// поля которых заполняют генераторы fields. Документы зависят только от seed
+func randomCollection(t *testing.T, db *DB, name string, seed int64, n int, fields map[string]fieldGen) *Collection {
+	t.Helper()
+	
+	if err := db.CreateCollection(name, storage.NewMemoryStorage()); err != nil {
+		t.Fatal(err)
+	}
+	coll, _ := db.GetCollection(name)
+	
+	names := make([]string, 0, len(fields))
+	for field := range fields {
+		names = append(names, field)
+	}
+	sort.Strings(names)
+	
+	rnd := rand.New(rand.NewSource(seed))
+	for i := 0; i < n; i++ {
+		content := make(map[string]interface{})
+		for _, field := range names {
+			if value := fields[field](rnd); value != missing {
+				content[field] = value
+			}
+		}
+		if err := coll.InsertDocument(storage.Document{ID: fmt.Sprintf("d%03d", i), Content: content}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return coll
+}
+
+// randomConditions сравнивает с полным просмотром запрос q с n случайными
+// условиями WHERE, составленными из atoms с помощью AND, OR и NOT
+func randomConditions(t *testing.T, db *DB, q string, seed int64, n int, atoms ...string) {
+	t.Helper()
+	
+	rnd := rand.New(rand.NewSource(seed))
+	for i := 0; i < n; i++ {
+		cond := atoms[rnd.Intn(len(atoms))]
+		for rnd.Intn(2) == 0 {
+			op := []string{" AND ", " OR ", " AND NOT "}[rnd.Intn(3)]
+			cond = "(" + cond + op + atoms[rnd.Intn(len(atoms))] + ")"
+		}
+		if rnd.Intn(4) == 0 {
+			cond = "NOT " + cond
+		}
+		checkScan(t, db, q+" WHERE "+cond)
+	}
+}
+
+func TestCreateIndexUsesDefaultOrder(t *testing.T) {
+	db := NewDB()
+	if err := db.CreateCollection("c", storage.NewMemoryStorage()); err != nil {
+		t.Fatal(err)
+	}
+	coll, _ := db.GetCollection("c")
+	if err := coll.CreateIndex("a", IndexTypeBTree, 0); err != nil {
+		t.Fatal(err)
+	}
+	if order := coll.Indexes["a"].(*index.BTreeIndex).Order; order != config.DefaultConfig().DefaultBTreeOrder {
+		t.Fatalf("порядок индекса %d, ожидался %d", order, config.DefaultConfig().DefaultBTreeOrder)
+	}
+	
+	cfg := config.NewFileStorageConfig(t.TempDir(), false)
+	cfg.DefaultBTreeOrder = 7
+	db, err := Open(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	coll, err = db.NewCollection("c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, field := range []string{"a", "b,c"} {
+		if err := coll.CreateIndex(field, IndexTypeUnique, -1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	
+	// Порядок сохраняется в каталоге и не зависит от конфигурации при повторном открытии
+	cfg.DefaultBTreeOrder = 4
+	db, err = Open(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	coll, _ = db.GetCollection("c")
+	for _, field := range []string{"a", "b,c"} {
+		if def := coll.definitions[field]; def.Order != 7 {
+			t.Fatalf("индекс %s сохранен с порядком %d, ожидался 7", field, def.Order)
+		}
+		if order := coll.Indexes[field].(*index.BTreeIndex).Order; order != 7 {
+			t.Fatalf("индекс %s открыт с порядком %d, ожидался 7", field, order)
+		}
+	}
+}
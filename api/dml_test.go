@@ -0,0 +1,192 @@
+package api
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/urusofam/jsondb/index"
+	"github.com/urusofam/jsondb/query"
+	"github.com/urusofam/jsondb/storage"
+)
+
+// dmlDB создает базу данных с коллекцией u из трех документов,
+// вставленных инструкциями INSERT
+func dmlDB(t *testing.T) (*DB, *Collection) {
+	t.Helper()
+	
+	db := NewDB()
+	db.CreateCollection("u", storage.NewMemoryStorage())
+	coll, _ := db.GetCollection("u")
+	n, err := db.Exec(`INSERT INTO u VALUES {"_id":"1","name":"a","age":20,"addr":{"city":"M"}}, {"_id":"2","name":"b","age":40}; `)
+	if err != nil || n != 2 {
+		t.Fatalf("INSERT: %d, %v", n, err)
+	}
+	rows, err := db.Query(`insert into u values {"_id": "3", "name": "c}{\"", "age": 60, "tags": ["x", "y"]}`)
+	if err != nil || rows[0]["affected"] != 1 {
+		t.Fatalf("INSERT через Query: %v, %v", rows, err)
+	}
+	return db, coll
+}
+
+func TestDML(t *testing.T) {
+	db, coll := dmlDB(t)
+	if err := coll.CreateIndex("age", IndexTypeBTree, 4); err != nil {
+		t.Fatal(err)
+	}
+	if doc, _ := coll.GetDocument("3"); doc.Content["name"] != `c}{"` {
+		t.Fatalf("строка со скобками и кавычкой разобрана неверно: %v", doc.Content)
+	}
+	
+	if n, err := db.Exec("UPDATE u SET status = 'old', addr.city = UPPER(name), prev = age WHERE age >= 40"); err != nil || n != 2 {
+		t.Fatalf("UPDATE: %d, %v", n, err)
+	}
+	doc, _ := coll.GetDocument("2")
+	if doc.Content["status"] != "old" || doc.Content["addr"].(map[string]interface{})["city"] != "B" || doc.Content["prev"] != 40.0 {
+		t.Fatalf("документ 2 изменен неверно: %v", doc.Content)
+	}
+	
+	if n, err := db.Exec("UPDATE u SET age = 21 WHERE _id = '1'"); err != nil || n != 1 {
+		t.Fatalf("UPDATE: %d, %v", n, err)
+	}
+	if docs, _ := coll.FindByIndex("age", 20); len(docs) != 0 {
+		t.Fatal("индекс содержит прежнее значение")
+	}
+	checkScan(t, db,
+		"SELECT _id FROM u WHERE age = 21",
+		"SELECT _id, prev FROM u WHERE age >= 40",
+		"SELECT _id FROM u WHERE age < 50 AND status IS MISSING",
+	)
+	
+	if n, err := db.Exec(`UPDATE u SET meta = {"a": [1, {"b": 2}]} WHERE name = 'a'`); err != nil || n != 1 {
+		t.Fatalf("UPDATE объектом: %d, %v", n, err)
+	}
+	if rows, _ := db.Query("SELECT meta.a[1].b AS b FROM u WHERE _id = '1'"); rows[0]["b"] != 2.0 {
+		t.Fatalf("вложенный объект записан неверно: %v", rows)
+	}
+	
+	for _, step := range []struct {
+		q    string
+		want int
+	}{
+		{"DELETE FROM u WHERE status = 'old'", 2},
+		{"DELETE FROM u WHERE age > 1000", 0},
+		{"DELETE FROM u", 1},
+	} {
+		if n, err := db.Exec(step.q); err != nil || n != step.want {
+			t.Fatalf("%s: %d, %v, ожидалось %d", step.q, n, err, step.want)
+		}
+	}
+	if size, _ := coll.Size(); size != 0 {
+		t.Fatalf("после удаления осталось %d документов", size)
+	}
+	if ids, _ := coll.FindRange("age", index.Range{}); len(ids) != 0 {
+		t.Fatalf("индекс содержит удаленные документы: %v", ids)
+	}
+}
+
+func TestDMLErrors(t *testing.T) {
+	db, coll := dmlDB(t)
+	coll.CreateIndex("name", IndexTypeUnique, 4)
+	
+	var dup *index.DuplicateKeyError
+	_, err := db.Exec(`INSERT INTO u VALUES {"_id":"9","name":"z"}, {"_id":"1","name":"q"}`)
+	if !errors.As(err, &dup) || dup.Field != "_id" {
+		t.Fatalf("ожидалась ошибка дубликата _id, получено %v", err)
+	}
+	if _, err := coll.GetDocument("9"); err == nil {
+		t.Fatal("инструкция применена частично")
+	}
+	
+	if _, err := db.Exec("UPDATE u SET name = 'same'"); !errors.As(err, &dup) {
+		t.Fatalf("ожидалась ошибка дубликата, получено %v", err)
+	}
+	if count(t, db, "SELECT _id FROM u WHERE name = 'same'") != 0 {
+		t.Fatal("инструкция применена частично")
+	}
+	
+	for _, q := range []string{
+		"UPDATE u SET age = age + 0",
+		"UPDATE u SET _id = 'x'",
+		"UPDATE u SET tags[*] = 1",
+		"UPDATE u SET n = COUNT(*)",
+		"UPDATE u SET n = MATCH(name, 'a')",
+		`INSERT INTO u VALUES {"_id":"x"`,
+		"EXPLAIN DELETE FROM u",
+		"DELETE u",
+		"SELECT * FROM u",
+		"UPDATE nope SET x = 1",
+	} {
+		if _, err := db.Exec(q); err == nil {
+			t.Errorf("%s: ожидалась ошибка", q)
+		}
+	}
+	if _, err := db.Explain("DELETE FROM u"); err == nil {
+		t.Fatal("EXPLAIN для инструкции изменения должен вернуть ошибку")
+	}
+	if _, err := db.Exec("UPDATE u SET x = NOPE(name)"); err == nil || !strings.Contains(err.Error(), "NOPE") {
+		t.Fatalf("ошибка должна содержать имя функции: %v", err)
+	}
+}
+
+func TestInsertRequiresID(t *testing.T) {
+	db, coll := dmlDB(t)
+	
+	for q, row := range map[string]string{
+		`INSERT INTO u VALUES {"name":"noid"}`:                         "документ 1",
+		`INSERT INTO u VALUES {"_id":"7","name":"x"}, {"name":"noid"}`: "документ 2",
+		`INSERT INTO u VALUES {"_id":"7"}, {"_id":"8"}, {"_id":5}`:     "документ 3",
+		`INSERT INTO u VALUES {"_id":""}`:                              "документ 1",
+	} {
+		_, err := db.Exec(q)
+		var perr *query.ParseError
+		if !errors.As(err, &perr) || !strings.Contains(err.Error(), row) || !strings.Contains(err.Error(), "_id") {
+			t.Fatalf("%s: ожидалась ошибка разбора для %q без _id, получено %v", q, row, err)
+		}
+	}
+	if size, _ := coll.Size(); size != 3 {
+		t.Fatalf("документы без _id вставлены частично: %d документов", size)
+	}
+	
+	if n, err := db.Exec(`INSERT INTO u VALUES {"_id":"7","name":"x"}, {"_id":"8","name":"y"}`); err != nil || n != 2 {
+		t.Fatalf("INSERT с _id: %d, %v", n, err)
+	}
+	doc, err := coll.GetDocument("8")
+	if err != nil || doc.Content["name"] != "y" {
+		t.Fatalf("документ 8: %v, %v", doc, err)
+	}
+	if _, ok := doc.Content["_id"]; ok {
+		t.Fatal("поле _id сохранено в содержимом документа")
+	}
+}
+
+func TestDMLInTx(t *testing.T) {
+	db, coll := dmlDB(t)
+	
+	tx := db.Begin()
+	if n, err := tx.Exec("UPDATE u SET age = 99 WHERE age < 50"); err != nil || n != 2 {
+		t.Fatalf("UPDATE: %d, %v", n, err)
+	}
+	if rows, _ := tx.Query("SELECT _id FROM u WHERE age = 99"); len(rows) != 2 {
+		t.Fatalf("транзакция не видит своих изменений: %v", rows)
+	}
+	if rows, err := tx.Query("DELETE FROM u WHERE age = 99"); err != nil || rows[0]["affected"] != 2 {
+		t.Fatalf("DELETE: %v, %v", rows, err)
+	}
+	if size, _ := coll.Size(); size != 3 {
+		t.Fatal("изменения применены до фиксации")
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if size, _ := coll.Size(); size != 1 {
+		t.Fatalf("после фиксации осталось %d документов, ожидался 1", size)
+	}
+	
+	tx = db.Begin()
+	tx.Exec("UPDATE u SET age = 1")
+	coll.UpdateDocument(storage.Document{ID: "3", Content: map[string]interface{}{"age": 5.0}})
+	if err := tx.Commit(); !errors.Is(err, ErrTxConflict) {
+		t.Fatalf("ожидалась ErrTxConflict, получено %v", err)
+	}
+}
@@ -0,0 +1,106 @@
+package api
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/urusofam/jsondb/storage"
+)
+
+// fullTextDB создает базу данных с коллекцией a из текстов на русском и английском
+func fullTextDB(t *testing.T) (*DB, *Collection) {
+	t.Helper()
+	
+	db := NewDB()
+	db.CreateCollection("a", storage.NewMemoryStorage())
+	coll, _ := db.GetCollection("a")
+	for id, text := range map[string]interface{}{
+		"1": "Базы данных и полнотекстовый поиск",
+		"2": "Поиск по базе данных: базы, базы, базы",
+		"3": "Go programming language",
+		"4": "Programs written in Go are fast; go go go",
+		"5": "Кошки и собаки",
+		"6": []interface{}{"Поиск собаки", "lost dog"},
+		"7": 42.0,
+	} {
+		coll.InsertDocument(storage.Document{ID: id, Content: map[string]interface{}{"body": text, "n": id}})
+	}
+	return db, coll
+}
+
+func TestFullTextIndexMatchesFullScan(t *testing.T) {
+	queries := []string{
+		"SELECT _id, MATCH(body, 'база данных') AS s FROM a WHERE MATCH(body, 'база данных')",
+		"SELECT _id, MATCH(body, 'поиск собак') AS s FROM a WHERE MATCH(body, 'поиск собак')",
+		"SELECT _id FROM a WHERE MATCH(body, 'programming') OR n = '5'",
+		"SELECT _id FROM a WHERE MATCH(body, 'go') > 0.5 AND NOT MATCH(body, 'fast')",
+		"SELECT _id FROM a WHERE MATCH(body, 'the and')",
+		"SELECT _id, MATCH(body, 'dogs') AS s FROM a ORDER BY s DESC, _id",
+		"SELECT COUNT(*) AS n FROM a WHERE MATCH(body, 'поиск')",
+		"SELECT n, COUNT(*) AS c FROM a WHERE MATCH(body, 'go') GROUP BY n ORDER BY n",
+		"SELECT _id FROM a WHERE body = 'Кошки и собаки'",
+	}
+	
+	db, coll := fullTextDB(t)
+	unindexed := make(map[string]string)
+	for _, q := range queries {
+		rows, err := db.Query(q)
+		if err != nil {
+			t.Fatalf("%s: %v", q, err)
+		}
+		unindexed[q] = fmt.Sprint(rows)
+	}
+	
+	if err := coll.CreateIndex("body", IndexTypeFullText, 0); err != nil {
+		t.Fatal(err)
+	}
+	// Результаты с индексом и без него совпадают вместе с порядком по релевантности
+	for _, q := range queries {
+		rows, err := db.Query(q)
+		if err != nil {
+			t.Fatalf("%s: %v", q, err)
+		}
+		if got := fmt.Sprint(rows); got != unindexed[q] {
+			t.Errorf("%s: %s, без индекса %s", q, got, unindexed[q])
+		}
+	}
+	checkScan(t, db, queries...)
+	
+	if rows, _ := db.Query("SELECT _id FROM a WHERE MATCH(body, 'база данных')"); fmt.Sprint(rows) != "[map[_id:2] map[_id:1]]" {
+		t.Fatalf("документы не упорядочены по релевантности: %v", rows)
+	}
+	plan, err := db.Explain("SELECT _id FROM a WHERE MATCH(body, 'go')")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text := plan.String(); !strings.Contains(text, "FullTextSearch по индексу body: 'go'") || strings.Contains(text, "Sort") {
+		t.Fatalf("полнотекстовый индекс не используется:\n%s", text)
+	}
+	if _, err := db.Query("SELECT MATCH(body, 'go') FROM a GROUP BY n"); err == nil {
+		t.Fatal("MATCH вне GROUP BY принят")
+	}
+	if err := coll.CreateIndex("body,n", IndexTypeFullText, 0); err == nil {
+		t.Fatal("создан составной полнотекстовый индекс")
+	}
+}
+
+func TestFullTextIndexMaintained(t *testing.T) {
+	db, coll := fullTextDB(t)
+	if err := coll.CreateIndex("body", IndexTypeFullText, 0); err != nil {
+		t.Fatal(err)
+	}
+	coll.UpdateDocument(storage.Document{ID: "5", Content: map[string]interface{}{"body": "собака ищет базу"}})
+	coll.DeleteDocument("2")
+	coll.UpdateDocument(storage.Document{ID: "3", Content: map[string]interface{}{"body": "go база", "n": "3"}})
+	
+	checkScan(t, db,
+		"SELECT _id FROM a WHERE MATCH(body, 'базы')",
+		"SELECT _id FROM a WHERE MATCH(body, 'programming')",
+		"SELECT _id, MATCH(body, 'собаки база') AS s FROM a WHERE MATCH(body, 'собаки база')",
+	)
+	docs, err := coll.FindByIndex("body", "собаки")
+	if err != nil || len(docs) != 2 {
+		t.Fatalf("поиск по индексу: %v, %v", docs, err)
+	}
+}
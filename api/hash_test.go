@@ -0,0 +1,60 @@
+package api
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/urusofam/jsondb/index"
+	"github.com/urusofam/jsondb/storage"
+)
+
+func TestHashIndexMatchesFullScan(t *testing.T) {
+	db := NewDB()
+	coll := randomCollection(t, db, "s", 1, 300, map[string]fieldGen{
+		"n":   numbers(10),
+		"tok": mixed(texts("t", 20), texts("t", 20), texts("t", 20), numbers(5), oneOf(nil, missing)),
+	})
+	if err := coll.CreateIndex("tok", IndexTypeHash, 0); err != nil {
+		t.Fatal(err)
+	}
+	
+	// Изменения после создания индекса
+	for i := 0; i < 300; i += 11 {
+		coll.UpdateDocument(storage.Document{ID: fmt.Sprintf("d%03d", i), Content: map[string]interface{}{"tok": "t1", "n": 0.0}})
+		coll.DeleteDocument(fmt.Sprintf("d%03d", i+5))
+	}
+	
+	for where, lookup := range map[string]bool{
+		"tok = 't1'":                true,
+		"tok = 3":                   true,
+		"tok = 3.0 AND n > 4":       true,
+		"tok IN ('t2', 't7', 1)":    true,
+		"tok = 't1' OR tok = 't19'": true,
+		"tok IS NULL":               false,
+		"tok > 't5'":                false,
+		"tok != 't1'":               false,
+	} {
+		q := "SELECT _id FROM s WHERE " + where
+		checkScan(t, db, q)
+		plan, err := db.Explain(q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.Contains(plan.String(), "IndexLookup по индексу tok"); got != lookup {
+			t.Errorf("%s: поиск по хеш-индексу %v, ожидалось %v:\n%s", where, got, lookup, plan)
+		}
+	}
+	checkScan(t, db, "SELECT tok, COUNT(*) AS c FROM s WHERE tok = 't1' OR n = 2 GROUP BY tok")
+	
+	docs, _ := coll.FindByIndex("tok", "t1")
+	if rows, _ := db.Query("SELECT _id FROM s WHERE tok = 't1'"); len(docs) != len(rows) {
+		t.Fatalf("FindByIndex: %d документов, запрос дает %d", len(docs), len(rows))
+	}
+	if _, err := coll.FindRange("tok", index.AtLeast("a")); err == nil {
+		t.Fatal("поиск диапазона по хеш-индексу принят")
+	}
+	if err := coll.CreateIndex("tok,n", IndexTypeHash, 0); err == nil {
+		t.Fatal("создан составной хеш-индекс")
+	}
+}
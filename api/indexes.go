@@ -0,0 +1,281 @@
+package api
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/urusofam/jsondb/index"
+	"github.com/urusofam/jsondb/storage"
+)
+
+// indexDirName - поддиректория коллекции с каталогом индексов и их данными
+const indexDirName = ".indexes"
+
+// indexCatalogName - имя файла каталога индексов
+const indexCatalogName = "catalog.json"
+
+// Типы индексов
+const (
+	// IndexTypeBTree - индекс B-дерева
+	IndexTypeBTree = "btree"
+	// IndexTypeUnique - уникальный индекс B-дерева; документ без поля
+	// считается имеющим значение null
+	IndexTypeUnique = "unique"
+	// IndexTypeUniqueSparse - уникальный индекс B-дерева, пропускающий документы без поля
+	IndexTypeUniqueSparse = "unique-sparse"
+	// IndexTypeFullText - полнотекстовый инвертированный индекс для MATCH;
+	// его данные не сохраняются и строятся заново при открытии коллекции
+	IndexTypeFullText = "fulltext"
+	// IndexTypeHash - хеш-индекс для поиска на равенство; как и полнотекстовый,
+	// строится заново при открытии коллекции
+	IndexTypeHash = "hash"
+)
+
+// IndexDefinition описывает индекс в каталоге коллекции
+// Для составного индекса Field содержит имя индекса - поля через запятую,
+// а Fields - список полей
+type IndexDefinition struct {
+	Field  string   `json:"field"`
+	Fields []string `json:"fields,omitempty"`
+	Type   string   `json:"type"`
+	Order  int      `json:"order,omitempty"`
+}
+
+// indexCatalog - содержимое файла каталога индексов
+type indexCatalog struct {
+	Indexes []IndexDefinition `json:"indexes"`
+}
+
+// persistent возвращает хранилище коллекции на диске, если оно есть
+func (c *Collection) persistent() (storage.Persistent, bool) {
+	store := c.Storage
+	if versioned, ok := store.(*storage.VersionedStorage); ok {
+		store = versioned.Base
+	}
+	
+	persistent, ok := store.(storage.Persistent)
+	return persistent, ok
+}
+
+// indexDir возвращает директорию каталога индексов или пустую строку
+// для коллекций в памяти
+func (c *Collection) indexDir() string {
+	persistent, ok := c.persistent()
+	if !ok {
+		return ""
+	}
+	return filepath.Join(persistent.Path(), indexDirName)
+}
+
+// indexFields разбирает поле индекса или список полей составного индекса
+// через запятую. Возвращает имя индекса и поля составного индекса
+// (nil для индекса по одному полю)
+func indexFields(field string) (string, []string, error) {
+	parts := strings.Split(field, ",")
+	if len(parts) == 1 {
+		_, err := storage.ParsePath(field)
+		return field, nil, err
+	}
+	
+	fields := make([]string, len(parts))
+	seen := make(map[string]bool)
+	for i, part := range parts {
+		part = strings.TrimSpace(part)
+		if _, err := storage.ParsePath(part); err != nil {
+			return "", nil, err
+		}
+		if storage.HasWildcard(part) {
+			return "", nil, fmt.Errorf("составной индекс не поддерживает пути с [*]: %s", part)
+		}
+		if seen[part] {
+			return "", nil, fmt.Errorf("поле %s повторяется в составном индексе", part)
+		}
+		seen[part] = true
+		fields[i] = part
+	}
+	
+	return index.CompoundName(fields), fields, nil
+}
+
+// indexDataPath возвращает путь к файлу данных индекса по полю
+func indexDataPath(dir, field string) string {
+	return filepath.Join(dir, hex.EncodeToString([]byte(field))+".btree")
+}
+
+// Definitions возвращает определения индексов коллекции, упорядоченные по полю
+func (c *Collection) Definitions() []IndexDefinition {
+	c.Mutex.RLock()
+	defer c.Mutex.RUnlock()
+	
+	return c.sortedDefinitions()
+}
+
+// sortedDefinitions возвращает определения индексов, упорядоченные по полю
+func (c *Collection) sortedDefinitions() []IndexDefinition {
+	defs := make([]IndexDefinition, 0, len(c.definitions))
+	for _, def := range c.definitions {
+		defs = append(defs, def)
+	}
+	sort.Slice(defs, func(i, j int) bool {
+		return defs[i].Field < defs[j].Field
+	})
+	return defs
+}
+
+// saveCatalog записывает определения индексов в каталог коллекции
+func (c *Collection) saveCatalog() error {
+	dir := c.indexDir()
+	if dir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	
+	data, err := json.MarshalIndent(indexCatalog{Indexes: c.sortedDefinitions()}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return storage.WriteFileAtomic(filepath.Join(dir, indexCatalogName), data)
+}
+
+// removeIndexData удаляет сохраненные данные индекса
+func (c *Collection) removeIndexData(field string) error {
+	dir := c.indexDir()
+	if dir == "" {
+		return nil
+	}
+	
+	if err := os.Remove(indexDataPath(dir, field)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// loadIndexes восстанавливает индексы из каталога коллекции. Данные индекса
+// загружаются с диска, если их контрольная сумма совпадает с отпечатком
+// хранилища; иначе индекс считается устаревшим и строится заново по документам
+func (c *Collection) loadIndexes() error {
+	dir := c.indexDir()
+	if dir == "" {
+		return nil
+	}
+	
+	data, err := os.ReadFile(filepath.Join(dir, indexCatalogName))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	
+	var catalog indexCatalog
+	if err := json.Unmarshal(data, &catalog); err != nil {
+		return fmt.Errorf("каталог индексов поврежден: %w", err)
+	}
+	
+	persistent, _ := c.persistent()
+	fingerprint, err := persistent.Fingerprint()
+	if err != nil {
+		return err
+	}
+	
+	for _, def := range catalog.Indexes {
+		idx, ok := loadIndexData(dir, def, fingerprint)
+		if !ok {
+			if idx, err = c.buildIndex(def); err != nil {
+				return fmt.Errorf("индекс по полю %s: %w", def.Field, err)
+			}
+		}
+		
+		c.Indexes[def.Field] = idx
+		c.definitions[def.Field] = def
+	}
+	
+	return nil
+}
+
+// loadIndexData загружает сохраненные данные индекса, если они
+// соответствуют определению и текущему отпечатку хранилища
+func loadIndexData(dir string, def IndexDefinition, fingerprint string) (index.Index, bool) {
+	unique := def.Type == IndexTypeUnique || def.Type == IndexTypeUniqueSparse
+	if def.Type != IndexTypeBTree && !unique {
+		return nil, false
+	}
+	
+	data, err := os.ReadFile(indexDataPath(dir, def.Field))
+	if err != nil {
+		return nil, false
+	}
+	
+	bt, checksum, err := index.LoadBTreeIndex(bytes.NewReader(data))
+	if err != nil || checksum != fingerprint || bt.Field != def.Field || bt.Order != def.Order {
+		return nil, false
+	}
+	if bt.Unique != unique || bt.Sparse != (def.Type == IndexTypeUniqueSparse) {
+		return nil, false
+	}
+	return bt, true
+}
+
+// saveIndexes сбрасывает хранилище на диск и сохраняет данные индексов
+// вместе с отпечатком хранилища
+func (c *Collection) saveIndexes() error {
+	dir := c.indexDir()
+	if dir == "" || len(c.definitions) == 0 {
+		return nil
+	}
+	
+	persistent, _ := c.persistent()
+	if err := persistent.Checkpoint(); err != nil {
+		return err
+	}
+	fingerprint, err := persistent.Fingerprint()
+	if err != nil {
+		return err
+	}
+	
+	for field := range c.definitions {
+		bt, ok := c.Indexes[field].(*index.BTreeIndex)
+		if !ok {
+			continue
+		}
+		
+		var buf bytes.Buffer
+		if err := bt.Save(&buf, fingerprint); err != nil {
+			return err
+		}
+		if err := storage.WriteFileAtomic(indexDataPath(dir, field), buf.Bytes()); err != nil {
+			return err
+		}
+	}
+	
+	return nil
+}
+
+// close сохраняет данные индексов и закрывает хранилище коллекции.
+// Повторный вызов ничего не делает
+func (c *Collection) close() error {
+	c.Mutex.Lock()
+	defer c.Mutex.Unlock()
+	
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	
+	err := c.saveIndexes()
+	if closer, ok := c.Storage.(io.Closer); ok {
+		if closeErr := closer.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
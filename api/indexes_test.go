@@ -0,0 +1,203 @@
+package api
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/urusofam/jsondb/config"
+	"github.com/urusofam/jsondb/index"
+	"github.com/urusofam/jsondb/storage"
+)
+
+func TestIndexesPersistAcrossRestarts(t *testing.T) {
+	for _, storageType := range []config.StorageType{config.StorageTypeFile, config.StorageTypePaged} {
+		t.Run(string(storageType), func(t *testing.T) {
+			dir := t.TempDir()
+			cfg := &config.DBConfig{StorageType: storageType, DataDir: dir, UseCache: true}
+			open := func() (*DB, *Collection) {
+				t.Helper()
+				
+				store, err := NewCollectionStorage(cfg, "users")
+				if err != nil {
+					t.Fatal(err)
+				}
+				db := NewDB()
+				if err := db.CreateCollection("users", store); err != nil {
+					t.Fatal(err)
+				}
+				coll, _ := db.GetCollection("users")
+				return db, coll
+			}
+			// loaded проверяет, загружаются ли сохраненные данные индекса age без перестроения
+			loaded := func() bool {
+				t.Helper()
+				
+				store, err := NewCollectionStorage(cfg, "users")
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer store.(io.Closer).Close()
+				persistent := store.(storage.Persistent)
+				if err := persistent.Checkpoint(); err != nil {
+					t.Fatal(err)
+				}
+				fingerprint, err := persistent.Fingerprint()
+				if err != nil {
+					t.Fatal(err)
+				}
+				_, ok := loadIndexData(filepath.Join(dir, "users", indexDirName), IndexDefinition{Field: "age", Type: IndexTypeBTree, Order: 4}, fingerprint)
+				return ok
+			}
+			queries := []string{
+				"SELECT _id FROM users WHERE age = 25",
+				"SELECT _id FROM users WHERE age > 26 OR tags[*] = 'z'",
+				"SELECT _id FROM users WHERE tags[*] = 'x' AND age <= 30",
+			}
+			
+			db, coll := open()
+			for i, age := range []float64{30, 25, 40} {
+				coll.InsertDocument(storage.Document{ID: string(rune('a' + i)), Content: map[string]interface{}{"age": age, "tags": []interface{}{"x", "y"}}})
+			}
+			if err := coll.CreateIndex("age", IndexTypeBTree, 4); err != nil {
+				t.Fatal(err)
+			}
+			coll.CreateIndex("tags[*]", IndexTypeBTree, 0)
+			coll.CreateIndex("gone", IndexTypeBTree, 0)
+			coll.DropIndex("gone")
+			if err := db.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if err := db.Close(); err != nil {
+				t.Fatalf("повторное закрытие: %v", err)
+			}
+			if !loaded() {
+				t.Fatal("данные индекса не загружаются после закрытия базы данных")
+			}
+			
+			db, coll = open()
+			if len(coll.Indexes) != 2 {
+				t.Fatalf("восстановлено %d индексов, ожидалось 2", len(coll.Indexes))
+			}
+			if order := coll.Indexes["age"].(*index.BTreeIndex).Order; order != 4 {
+				t.Fatalf("индекс восстановлен с порядком %d, ожидался 4", order)
+			}
+			checkScan(t, db, queries...)
+			if err := db.Close(); err != nil {
+				t.Fatal(err)
+			}
+			
+			// Документы изменены в обход индексов: сохраненные данные устарели
+			store, err := NewCollectionStorage(cfg, "users")
+			if err != nil {
+				t.Fatal(err)
+			}
+			store.Save(storage.Document{ID: "d", Content: map[string]interface{}{"age": 25.0, "tags": []interface{}{"z"}}})
+			store.Delete("a")
+			if err := store.(io.Closer).Close(); err != nil {
+				t.Fatal(err)
+			}
+			if loaded() {
+				t.Fatal("устаревшие данные индекса загружаются")
+			}
+			
+			db, coll = open()
+			checkScan(t, db, queries...)
+			if docs, _ := coll.FindByIndex("age", 25.0); len(docs) != 2 {
+				t.Fatalf("перестроенный индекс нашел %d документов, ожидалось 2", len(docs))
+			}
+			if err := db.Close(); err != nil {
+				t.Fatal(err)
+			}
+			
+			// Поврежденные данные индексов перестраиваются
+			files, _ := filepath.Glob(filepath.Join(dir, "users", indexDirName, "*.btree"))
+			if len(files) != 2 {
+				t.Fatalf("файлов данных индексов %d, ожидалось 2", len(files))
+			}
+			for _, file := range files {
+				os.WriteFile(file, []byte("{bad"), 0644)
+			}
+			db, _ = open()
+			defer db.Close()
+			checkScan(t, db, queries...)
+		})
+	}
+}
+
+// persistedIndexes - индексы разных типов, которые восстанавливаются после повторного
+// открытия базы данных, с запросом по каждому и проверкой особенностей типа
+var persistedIndexes = []struct {
+	field, typ, query string
+	check             func(t *testing.T, coll *Collection)
+}{
+	{"age", IndexTypeBTree, "SELECT _id FROM c WHERE age > 2", nil},
+	{"email", IndexTypeUniqueSparse, "SELECT _id FROM c WHERE email >= 'b'", func(t *testing.T, coll *Collection) {
+		if bt := coll.Indexes["email"].(*index.BTreeIndex); !bt.Unique || !bt.Sparse {
+			t.Fatal("флаги уникального индекса потеряны")
+		}
+		if err := coll.InsertDocument(udoc("d", "email", "a@x")); err == nil {
+			t.Fatal("уникальный индекс принял дубликат")
+		}
+		if err := coll.InsertDocument(udoc("e", "t", 2.0)); err != nil {
+			t.Fatal(err)
+		}
+	}},
+	{"t,s", IndexTypeUnique, "SELECT _id FROM c WHERE t = 1 AND s >= 'x'", func(t *testing.T, coll *Collection) {
+		if err := coll.InsertDocument(udoc("f", "t", 1.0, "s", "y")); err == nil {
+			t.Fatal("составной уникальный индекс принял дубликат")
+		}
+	}},
+	{"body", IndexTypeFullText, "SELECT _id FROM c WHERE MATCH(body, 'world goodbye')", func(t *testing.T, coll *Collection) {
+		if docs, err := coll.FindByIndex("body", "world"); err != nil || len(docs) != 1 || docs[0].ID != "a" {
+			t.Fatalf("поиск по полнотекстовому индексу: %v, %v", docs, err)
+		}
+	}},
+	{"tok", IndexTypeHash, "SELECT _id FROM c WHERE tok = 't1'", nil},
+}
+
+func TestIndexTypesPersist(t *testing.T) {
+	cfg := config.NewFileStorageConfig(t.TempDir(), false)
+	db, err := Open(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	coll, _ := db.NewCollection("c")
+	for _, doc := range []storage.Document{
+		udoc("a", "age", 1.0, "email", "a@x", "t", 1.0, "s", "x", "body", "hello worlds", "tok", "t1"),
+		udoc("b", "age", 5.0, "email", "b@x", "t", 1.0, "s", "y", "body", "goodbye", "tok", "t2"),
+		udoc("c", "age", 3.0, "body", "nothing", "tok", "t1"),
+	} {
+		if err := coll.InsertDocument(doc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	types := make(map[string]string)
+	for _, idx := range persistedIndexes {
+		if err := coll.CreateIndex(idx.field, idx.typ, 0); err != nil {
+			t.Fatal(err)
+		}
+		types[idx.field] = fmt.Sprintf("%T", coll.Indexes[idx.field])
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	
+	db, err = Open(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	coll, _ = db.GetCollection("c")
+	for _, idx := range persistedIndexes {
+		if got := fmt.Sprintf("%T", coll.Indexes[idx.field]); got != types[idx.field] {
+			t.Fatalf("индекс %s восстановлен как %s, ожидался %s", idx.field, got, types[idx.field])
+		}
+		checkScan(t, db, idx.query)
+		if idx.check != nil {
+			idx.check(t, coll)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package api
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/urusofam/jsondb/storage"
+)
+
+// joinDB создает базу данных с коллекциями users и orders; часть заказов
+// ссылается на отсутствующих пользователей или не содержит поля user
+func joinDB(t *testing.T) *DB {
+	t.Helper()
+	
+	db := NewDB()
+	city := oneOf("M", "P", "K")
+	randomCollection(t, db, "users", 1, 30, map[string]fieldGen{"name": texts("n", 30), "city": city})
+	randomCollection(t, db, "orders", 2, 150, map[string]fieldGen{
+		"total": numbers(100),
+		"city":  city,
+		"user":  mixed(refs(35), refs(35), refs(35), oneOf(missing)),
+	})
+	return db
+}
+
+// joinPlan возвращает текст плана запроса
+func joinPlan(t *testing.T, db *DB, q string) string {
+	t.Helper()
+	
+	plan, err := db.Explain(q)
+	if err != nil {
+		t.Fatalf("%s: %v", q, err)
+	}
+	return plan.String()
+}
+
+// joinPairs вычисляет перебором всех пар ID пользователя и заказа, для которых выполнено cond.
+// При left пользователь без пары дает строку с пустым ID заказа
+func joinPairs(t *testing.T, db *DB, left bool, cond func(u, o storage.Document) bool) string {
+	t.Helper()
+	
+	users, _ := db.GetCollection("users")
+	orders, _ := db.GetCollection("orders")
+	allUsers, _ := users.Storage.List()
+	allOrders, _ := orders.Storage.List()
+	
+	rows := make([]map[string]interface{}, 0)
+	for _, u := range allUsers {
+		matched := false
+		for _, o := range allOrders {
+			if cond(u, o) {
+				rows = append(rows, map[string]interface{}{"u": u.ID, "o": o.ID})
+				matched = true
+			}
+		}
+		if left && !matched {
+			rows = append(rows, map[string]interface{}{"u": u.ID, "o": nil})
+		}
+	}
+	return rowSet(rows)
+}
+
+// joinIDs возвращает пары ID из строк результата вида {u: {_id}, o: {_id}}
+func joinIDs(rows []map[string]interface{}) string {
+	pairs := make([]map[string]interface{}, len(rows))
+	for i, row := range rows {
+		pair := map[string]interface{}{"u": nil, "o": nil}
+		for alias := range pair {
+			if doc, ok := row[alias].(map[string]interface{}); ok {
+				pair[alias] = doc["_id"]
+			}
+		}
+		pairs[i] = pair
+	}
+	return rowSet(pairs)
+}
+
+func TestJoinMatchesNestedLoop(t *testing.T) {
+	db := joinDB(t)
+	byUser := func(u, o storage.Document) bool { return o.Content["user"] == u.ID }
+	byUserAndCity := func(u, o storage.Document) bool { return byUser(u, o) && o.Content["city"] == u.Content["city"] }
+	
+	for _, c := range []struct {
+		q      string
+		left   bool
+		cond   func(u, o storage.Document) bool
+		method string
+	}{
+		{"SELECT u._id, o._id FROM users u JOIN orders o ON o.user = u._id", false, byUser, "HashJoin"},
+		{"SELECT u._id, o._id FROM orders o INNER JOIN users AS u ON u._id = o.user", false, byUser, "IndexNestedLoopJoin"},
+		{"SELECT u._id, o._id FROM users u JOIN orders o ON o.user = u._id OR o.total > 97", false,
+			func(u, o storage.Document) bool { return byUser(u, o) || o.Content["total"].(float64) > 97 }, "NestedLoopJoin"},
+		{"SELECT u._id, o._id FROM users u LEFT OUTER JOIN orders o ON o.user = u._id AND o.city = u.city", true, byUserAndCity, "HashJoin"},
+		{"SELECT u._id, o._id FROM users u JOIN orders o ON o.user = u._id WHERE u.city = 'M' AND o.total >= 50", false,
+			func(u, o storage.Document) bool {
+				return byUser(u, o) && u.Content["city"] == "M" && o.Content["total"].(float64) >= 50
+			}, "HashJoin"},
+	} {
+		rows, err := db.Query(c.q)
+		if err != nil {
+			t.Fatalf("%s: %v", c.q, err)
+		}
+		if got, want := joinIDs(rows), joinPairs(t, db, c.left, c.cond); got != want {
+			t.Fatalf("%s: %s, перебор пар дает %s", c.q, got, want)
+		}
+		if plan := joinPlan(t, db, c.q); !strings.Contains(plan, c.method) {
+			t.Fatalf("%s: ожидался %s:\n%s", c.q, c.method, plan)
+		}
+	}
+	
+	// С индексами соединение выполняется поиском по индексу, а результаты не меняются
+	orders, _ := db.GetCollection("orders")
+	users, _ := db.GetCollection("users")
+	if err := orders.CreateIndex("user", IndexTypeHash, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := users.CreateIndex("city", IndexTypeBTree, 4); err != nil {
+		t.Fatal(err)
+	}
+	q := "SELECT u._id, o._id FROM users u JOIN orders o ON o.user = u._id WHERE u.city = 'P'"
+	if plan := joinPlan(t, db, q); !strings.Contains(plan, "IndexNestedLoopJoin") || !strings.Contains(plan, "по индексу user") {
+		t.Fatalf("индекс user не используется:\n%s", plan)
+	}
+	checkScan(t, db,
+		q,
+		"SELECT u.name, o.total FROM users u LEFT JOIN orders o ON o.user = u._id AND o.city = u.city",
+		"SELECT u.city, COUNT(*) AS n, SUM(o.total) AS s FROM users u JOIN orders o ON o.user = u._id GROUP BY u.city",
+		"SELECT a._id, b._id FROM users a JOIN users b ON a.city = b.city AND a._id < b._id WHERE a.city = 'K'",
+		"SELECT * FROM users u JOIN orders o ON o.user = u._id JOIN users v ON v.city = o.city WHERE o.total < 10",
+	)
+}
+
+func TestJoinResultShape(t *testing.T) {
+	db := NewDB()
+	db.CreateCollection("users", storage.NewMemoryStorage())
+	db.CreateCollection("orders", storage.NewMemoryStorage())
+	if _, err := db.Exec(`INSERT INTO users VALUES {"_id":"u1","name":"ann","city":"M"}, {"_id":"u2","name":"bob","city":"P"}, {"_id":"u3","name":"cid","city":"M"}`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO orders VALUES {"_id":"o1","user":"u1","total":10,"city":"M"}, {"_id":"o2","user":"u1","total":20,"city":"P"}, {"_id":"o3","user":"u2","total":5,"city":"P"}, {"_id":"o4","user":"zz","total":1}`); err != nil {
+		t.Fatal(err)
+	}
+	
+	for q, want := range map[string]string{
+		"SELECT u.name, o.total FROM users u JOIN orders o ON o.user = u._id ORDER BY o.total":                                                            "[map[o:map[total:5] u:map[name:bob]] map[o:map[total:10] u:map[name:ann]] map[o:map[total:20] u:map[name:ann]]]",
+		"SELECT u.name, o._id FROM users u LEFT JOIN orders o ON o.user = u._id AND o.city = u.city ORDER BY u.name, o._id":                               "[map[o:map[_id:o1] u:map[name:ann]] map[o:map[_id:o3] u:map[name:bob]] map[u:map[name:cid]]]",
+		"SELECT u.city, COUNT(*) AS n, SUM(o.total) AS s FROM users u JOIN orders o ON o.user = u._id WHERE u.city = 'M' AND o.total > 1 GROUP BY u.city": "[map[n:2 s:30 u:map[city:M]]]",
+		"SELECT a.name, b.name AS other FROM users a JOIN users b ON a.city = b.city AND a._id < b._id":                                                   "[map[a:map[name:ann] other:cid]]",
+		"SELECT v.name FROM users u JOIN orders o ON o.user = u._id JOIN users v ON v._id = o.user WHERE o._id = 'o3'":                                    "[map[v:map[name:bob]]]",
+	} {
+		rows, err := db.Query(q)
+		if err != nil {
+			t.Fatalf("%s: %v", q, err)
+		}
+		if got := fmt.Sprint(rows); got != want {
+			t.Errorf("%s: %s, ожидалось %s", q, got, want)
+		}
+	}
+	
+	for _, q := range []string{
+		"SELECT name FROM users u JOIN orders o ON o.user = u._id",
+		"SELECT u.name FROM users u JOIN orders u ON u.user = u._id",
+		"SELECT u.name FROM users u JOIN orders o ON o.user = x._id",
+		"SELECT u.name FROM users u JOIN orders o ON o.user = p._id JOIN users p ON p._id = u._id",
+		"SELECT u.name FROM users u JOIN nope o ON o.user = u._id",
+		"SELECT u.name FROM users u JOIN orders o ON COUNT(*) = 1",
+		"SELECT u.name FROM users u JOIN orders o",
+	} {
+		if _, err := db.Query(q); err == nil {
+			t.Errorf("%s: ожидалась ошибка", q)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package api
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/urusofam/jsondb/storage"
+)
+
+func TestQueriesReadConsistentSnapshot(t *testing.T) {
+	db := NewDB()
+	defer db.Close()
+	db.CreateCollection("acc", storage.NewMemoryStorage())
+	coll, _ := db.GetCollection("acc")
+	coll.CreateIndex("bal", IndexTypeBTree, 4)
+	for i := 0; i < 20; i++ {
+		coll.InsertDocument(storage.Document{ID: fmt.Sprint(i), Content: map[string]interface{}{"bal": 100.0}})
+	}
+	
+	// Переводы между счетами сохраняют общую сумму 2000
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for k := 0; ; k++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			tx := db.Begin()
+			from, _ := tx.Get("acc", fmt.Sprint(k%20))
+			to, _ := tx.Get("acc", fmt.Sprint((k+7)%20))
+			from.Content = map[string]interface{}{"bal": from.Content["bal"].(float64) - 1}
+			to.Content = map[string]interface{}{"bal": to.Content["bal"].(float64) + 1}
+			tx.Update("acc", from)
+			tx.Update("acc", to)
+			tx.Commit()
+		}
+	}()
+	
+	sum := func(rows []map[string]interface{}) float64 {
+		total := 0.0
+		for _, row := range rows {
+			total += row["bal"].(float64)
+		}
+		return total
+	}
+	for n := 0; n < 300; n++ {
+		rows, err := db.Query("SELECT SUM(bal) AS s, COUNT(*) AS n FROM acc")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rows[0]["s"] != 2000.0 || rows[0]["n"] != 20 {
+			t.Fatalf("полный просмотр видит несогласованное состояние: %v", rows)
+		}
+		
+		// Выборка по индексу и загрузка документов читают один снимок
+		rows, err = db.Query("SELECT bal FROM acc WHERE bal >= 0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if total := sum(rows); total != 2000 || len(rows) != 20 {
+			t.Fatalf("выборка по индексу видит несогласованное состояние: сумма %v, %d строк", total, len(rows))
+		}
+	}
+	close(stop)
+	wg.Wait()
+	
+	checkScan(t, db, "SELECT _id, bal FROM acc WHERE bal >= 100", "SELECT _id FROM acc WHERE bal < 100 OR bal > 101")
+	vs := coll.Storage.(*storage.VersionedStorage)
+	vs.Vacuum()
+	if n := vs.Versions(); n != 0 {
+		t.Fatalf("после очистки осталось %d версий", n)
+	}
+}
+
+func TestCreateCollectionKeepsSnapshotter(t *testing.T) {
+	db := NewDB()
+	defer db.Close()
+	
+	versioned := storage.NewVersionedStorage(storage.NewMemoryStorage(), 0)
+	db.CreateCollection("versioned", versioned)
+	db.CreateCollection("plain", storage.NewMemoryStorage())
+	
+	coll, _ := db.GetCollection("versioned")
+	if coll.Storage != storage.Storage(versioned) {
+		t.Fatalf("хранилище со снимками обернуто повторно: %T", coll.Storage)
+	}
+	coll, _ = db.GetCollection("plain")
+	if _, ok := coll.Storage.(*storage.VersionedStorage); !ok {
+		t.Fatalf("хранилище без снимков не обернуто: %T", coll.Storage)
+	}
+}
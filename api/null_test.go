@@ -0,0 +1,155 @@
+package api
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/urusofam/jsondb/query"
+	"github.com/urusofam/jsondb/storage"
+)
+
+// nullDB создает базу данных с коллекцией n, в которой поле a числовое,
+// строковое, логическое, null или отсутствует
+func nullDB(t *testing.T) (*DB, *Collection) {
+	t.Helper()
+	
+	db := NewDB()
+	db.CreateCollection("n", storage.NewMemoryStorage())
+	if _, err := db.Exec(`INSERT INTO n VALUES {"_id":"1","a":1,"b":"x"}, {"_id":"2","a":null,"b":null}, {"_id":"3","b":"y"}, {"_id":"4","a":"s","b":"x"}, {"_id":"5","a":true,"tags":[null]}, {"_id":"6","a":5,"tags":["q"]}`); err != nil {
+		t.Fatal(err)
+	}
+	coll, _ := db.GetCollection("n")
+	return db, coll
+}
+
+func TestNullSemanticsWithIndexes(t *testing.T) {
+	db, coll := nullDB(t)
+	
+	for _, typ := range []string{"", IndexTypeBTree, IndexTypeHash} {
+		if typ != "" {
+			coll.DropIndex("a")
+			if err := coll.CreateIndex("a", typ, 4); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for where, want := range map[string]string{
+			"a IS NULL":               "2,3",
+			"a IS NOT NULL":           "1,4,5,6",
+			"a IS MISSING":            "3",
+			"a IS NOT MISSING":        "1,2,4,5,6",
+			"NOT a IS NULL":           "1,4,5,6",
+			"a = NULL":                "",
+			"a != NULL":               "",
+			"a = 1":                   "1",
+			"a != 1":                  "4,5,6",
+			"NOT a = 1":               "4,5,6",
+			"a <= 5":                  "1,6",
+			"a < 5 OR a IS NULL":      "1,2,3",
+			"NOT (a > 1 AND b = 'x')": "1,3,4,5",
+			"a > 1 OR b = 'x'":        "1,4,6",
+			"a = 's'":                 "4",
+			"a IN (1, 5)":             "1,6",
+			"a IN (1, NULL)":          "1",
+			"a NOT IN (1, NULL)":      "",
+			"a NOT IN (1)":            "4,5,6",
+			"a NOT BETWEEN 0 AND 2":   "4,5,6",
+			"b NOT LIKE 'x%'":         "3",
+			"COALESCE(a, b) = 'y'":    "3",
+			"COALESCE(a, b, 0) = 0":   "2",
+			"tags[*] IS NULL":         "1,2,3,4,5",
+			"tags[*] IS NOT MISSING":  "5,6",
+			"NULL IS NULL AND a = 1":  "1",
+		} {
+			q := "SELECT _id FROM n WHERE " + where + " ORDER BY _id"
+			if got := resultIDs(t, db, q); got != want {
+				t.Errorf("индекс %q: %s: %s, ожидалось %s", typ, where, got, want)
+			}
+		}
+		if got := resultIDs(t, db, "SELECT _id FROM n WHERE a IS NOT NULL ORDER BY a LIMIT 2"); got != "5,1" {
+			t.Errorf("индекс %q: первые строки по a: %s", typ, got)
+		}
+	}
+	
+	rows, err := db.Query("SELECT _id, COALESCE(a, b, 'none') AS v FROM n ORDER BY _id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(rows); got != "[map[_id:1 v:1] map[_id:2 v:none] map[_id:3 v:y] map[_id:4 v:s] map[_id:5 v:true] map[_id:6 v:5]]" {
+		t.Fatalf("COALESCE: %s", got)
+	}
+	if n, err := db.Exec("UPDATE n SET a = COALESCE(a, 0) WHERE a IS NULL"); err != nil || n != 2 {
+		t.Fatalf("UPDATE: %d, %v", n, err)
+	}
+	if got := resultIDs(t, db, "SELECT _id FROM n WHERE a = 0 ORDER BY _id"); got != "2,3" {
+		t.Fatalf("после UPDATE: %s", got)
+	}
+}
+
+func TestNullConditionsMatchFullScan(t *testing.T) {
+	db := NewDB()
+	value := mixed(numbers(10), numbers(10), texts("s", 3), oneOf(nil, missing))
+	coll := randomCollection(t, db, "n", 1, 200, map[string]fieldGen{"a": value, "b": value})
+	coll.CreateIndex("a", IndexTypeBTree, 4)
+	coll.CreateIndex("b", IndexTypeHash, 0)
+	
+	randomConditions(t, db, "SELECT _id, a, b FROM n", 1, 300,
+		"a IS NULL", "a IS NOT NULL", "a IS MISSING", "b IS NOT MISSING", "a = NULL", "a = 3",
+		"a != 3", "a < 5", "b >= 7", "a IN (1, NULL)", "b NOT IN (2, NULL)", "b NOT IN (2)",
+		"a BETWEEN 2 AND 6", "COALESCE(a, b) = 4", "b = 's1'", "a > 's0'",
+	)
+}
+
+func TestNullPlans(t *testing.T) {
+	db, coll := nullDB(t)
+	coll.CreateIndex("a", IndexTypeBTree, 4)
+	db.Exec(`INSERT INTO n VALUES {"_id":"7"}`)
+	
+	for q, want := range map[string]string{
+		"SELECT _id FROM n WHERE a IS NOT NULL ORDER BY a LIMIT 2": "IndexRange по индексу a: (NULL, +∞), порядок a ASC NULLS LAST, первые 2",
+		"SELECT _id FROM n WHERE a IS NOT NULL AND a < 5":          "IndexRange по индексу a: (-∞, 5)",
+		"SELECT _id FROM n WHERE a IS NOT MISSING":                 "IndexScan по индексу a",
+		"SELECT _id FROM n WHERE a IS NULL":                        "FullScan",
+	} {
+		if plan := joinPlan(t, db, q); !strings.Contains(plan, want) {
+			t.Errorf("%s: ожидалось %s:\n%s", q, want, plan)
+		}
+	}
+	if got := resultIDs(t, db, "SELECT _id FROM n WHERE a IS NULL ORDER BY _id"); got != "2,3,7" {
+		t.Fatalf("a IS NULL: %s", got)
+	}
+	
+	// Уникальный неразреженный индекс хранит отсутствующее поле под ключом NULL
+	db.CreateCollection("u", storage.NewMemoryStorage())
+	db.Exec(`INSERT INTO u VALUES {"_id":"1","k":1}, {"_id":"2"}, {"_id":"3","k":"z"}`)
+	u, _ := db.GetCollection("u")
+	if err := u.CreateIndex("k", IndexTypeUnique, 4); err != nil {
+		t.Fatal(err)
+	}
+	for where, want := range map[string]string{
+		"k IS NULL":        "2",
+		"k IS MISSING":     "2",
+		"k IS NOT NULL":    "1,3",
+		"k IS NOT MISSING": "1,3",
+	} {
+		if got := resultIDs(t, db, "SELECT _id FROM u WHERE "+where+" ORDER BY _id"); got != want {
+			t.Errorf("%s: %s, ожидалось %s", where, got, want)
+		}
+	}
+	if plan := joinPlan(t, db, "SELECT _id FROM u WHERE k IS MISSING"); !strings.Contains(plan, "IndexLookup по индексу k: = NULL") {
+		t.Fatalf("IS MISSING не использует уникальный индекс:\n%s", plan)
+	}
+	
+	for _, q := range []string{
+		"SELECT _id FROM n WHERE a IS 1",
+		"SELECT _id FROM n WHERE a IS NOT",
+		"SELECT COALESCE() FROM n",
+	} {
+		if _, err := db.Query(q); err == nil {
+			t.Errorf("%s: ожидалась ошибка", q)
+		}
+	}
+	if err := query.NewFunctionRegistry().Register("COALESCE", func(s string) string { return s }); err == nil {
+		t.Fatal("зарегистрирована функция с именем COALESCE")
+	}
+}
@@ -0,0 +1,272 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/urusofam/jsondb/config"
+	"github.com/urusofam/jsondb/storage"
+)
+
+// catalogFileName - имя файла каталога базы данных в DataDir
+const catalogFileName = "catalog.json"
+
+// catalogVersion - версия формата каталога базы данных
+const catalogVersion = 1
+
+// StorageOptions содержит параметры хранилища коллекции
+type StorageOptions struct {
+	UseCache      bool `json:"use_cache,omitempty"`
+	PageSize      int  `json:"page_size,omitempty"`
+	PageCacheSize int  `json:"page_cache_size,omitempty"`
+}
+
+// CollectionEntry описывает коллекцию в каталоге базы данных
+type CollectionEntry struct {
+	Name    string             `json:"name"`
+	Storage config.StorageType `json:"storage"`
+	Options StorageOptions     `json:"options"`
+	Indexes []IndexDefinition  `json:"indexes"`
+}
+
+// dbCatalog - содержимое файла каталога базы данных
+type dbCatalog struct {
+	Version     int               `json:"version"`
+	Collections []CollectionEntry `json:"collections"`
+}
+
+// Open открывает базу данных по конфигурации. Для хранения на диске
+// коллекции, их хранилища и индексы восстанавливаются по каталогу
+// DataDir/catalog.json. Если каталога нет, коллекциями считаются
+// поддиректории DataDir, а каталог создается. Транзакции, фиксация которых
+// была прервана сбоем, доводятся до конца по журналу транзакций.
+// Для хранения в памяти возвращается пустая база данных
+func Open(cfg *config.DBConfig) (*DB, error) {
+	if cfg == nil {
+		cfg = config.DefaultConfig()
+	}
+	
+	db := NewDB()
+	db.Config = cfg
+	
+	if cfg.StorageType == config.StorageTypeMemory || cfg.StorageType == "" {
+		return db, nil
+	}
+	if cfg.StorageType != config.StorageTypeFile && cfg.StorageType != config.StorageTypePaged {
+		return nil, fmt.Errorf("неизвестный тип хранения: %s", cfg.StorageType)
+	}
+	if err := os.MkdirAll(cfg.DataDir, 0755); err != nil {
+		return nil, fmt.Errorf("создание директории данных: %w", err)
+	}
+	
+	entries, err := readCatalog(cfg)
+	if err != nil {
+		return nil, err
+	}
+	
+	// До успешного открытия каталог не перезаписывается: иначе ошибка
+	// в одной коллекции удалила бы из него все следующие
+	for _, entry := range entries {
+		if err := db.openCollection(entry); err != nil {
+			db.abandon()
+			return nil, fmt.Errorf("коллекция %s: %w", entry.Name, err)
+		}
+	}
+	
+	txLog, err := db.openTxLog()
+	if err != nil {
+		db.abandon()
+		return nil, err
+	}
+	db.txLog = txLog
+	
+	db.Mutex.Lock()
+	defer db.Mutex.Unlock()
+	
+	if err := db.saveCatalog(); err != nil {
+		db.abandon()
+		return nil, err
+	}
+	return db, nil
+}
+
+// abandon закрывает хранилища открытых коллекций и журнал транзакций
+// базы данных, которую не удалось открыть. В отличие от Close, каталог
+// и индексы не записываются
+func (db *DB) abandon() {
+	for _, coll := range db.Collections {
+		if closer, ok := coll.Storage.(io.Closer); ok {
+			closer.Close()
+		}
+	}
+	db.txLog.close()
+	db.txLog = nil
+}
+
+// readCatalog читает каталог базы данных или, если его нет,
+// строит описания коллекций по поддиректориям DataDir
+func readCatalog(cfg *config.DBConfig) ([]CollectionEntry, error) {
+	data, err := os.ReadFile(filepath.Join(cfg.DataDir, catalogFileName))
+	if err == nil {
+		var catalog dbCatalog
+		if err := json.Unmarshal(data, &catalog); err != nil {
+			return nil, fmt.Errorf("каталог базы данных поврежден: %w", err)
+		}
+		if catalog.Version > catalogVersion {
+			return nil, fmt.Errorf("неподдерживаемая версия каталога базы данных: %d", catalog.Version)
+		}
+		return catalog.Collections, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+	
+	dirs, err := os.ReadDir(cfg.DataDir)
+	if err != nil {
+		return nil, err
+	}
+	
+	entries := make([]CollectionEntry, 0)
+	for _, dir := range dirs {
+		if !dir.IsDir() {
+			continue
+		}
+		
+		entry := CollectionEntry{
+			Name:    dir.Name(),
+			Storage: cfg.StorageType,
+			Options: optionsFromConfig(cfg),
+		}
+		
+		// Тип хранилища определяется по содержимому директории
+		if _, err := os.Stat(filepath.Join(cfg.DataDir, dir.Name(), "data.pages")); err == nil {
+			entry.Storage = config.StorageTypePaged
+		} else if entry.Storage == config.StorageTypePaged {
+			entry.Storage = config.StorageTypeFile
+		}
+		
+		entries = append(entries, entry)
+	}
+	
+	return entries, nil
+}
+
+// optionsFromConfig возвращает параметры хранилища из конфигурации
+func optionsFromConfig(cfg *config.DBConfig) StorageOptions {
+	return StorageOptions{
+		UseCache:      cfg.UseCache,
+		PageSize:      cfg.PageSize,
+		PageCacheSize: cfg.PageCacheSize,
+	}
+}
+
+// openCollection открывает коллекцию по описанию из каталога. Индексы,
+// отсутствующие в каталоге самой коллекции, строятся заново
+func (db *DB) openCollection(entry CollectionEntry) error {
+	cfg := *db.Config
+	cfg.StorageType = entry.Storage
+	cfg.UseCache = entry.Options.UseCache
+	cfg.PageSize = entry.Options.PageSize
+	cfg.PageCacheSize = entry.Options.PageCacheSize
+	
+	store, err := NewCollectionStorage(&cfg, entry.Name)
+	if err != nil {
+		return err
+	}
+	
+	db.Mutex.Lock()
+	err = db.addCollection(entry.Name, store)
+	db.Mutex.Unlock()
+	if err != nil {
+		if closer, ok := store.(interface{ Close() error }); ok {
+			closer.Close()
+		}
+		return err
+	}
+	
+	coll, _ := db.GetCollection(entry.Name)
+	for _, def := range entry.Indexes {
+		if _, ok := coll.Indexes[def.Field]; ok {
+			continue
+		}
+		if err := coll.createIndex(def.Field, def.Type, def.Order); err != nil {
+			return err
+		}
+	}
+	
+	return nil
+}
+
+// NewCollection создает коллекцию с хранилищем, заданным конфигурацией базы данных
+func (db *DB) NewCollection(name string) (*Collection, error) {
+	cfg := db.Config
+	if cfg == nil {
+		cfg = config.NewMemoryStorageConfig()
+	}
+	
+	if _, err := db.GetCollection(name); err == nil {
+		return nil, fmt.Errorf("коллекция %s уже существует", name)
+	}
+	
+	store, err := NewCollectionStorage(cfg, name)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.CreateCollection(name, store); err != nil {
+		return nil, err
+	}
+	
+	return db.GetCollection(name)
+}
+
+// catalogChanged перезаписывает каталог после изменения индексов коллекции
+func (db *DB) catalogChanged() error {
+	db.Mutex.RLock()
+	defer db.Mutex.RUnlock()
+	
+	return db.saveCatalog()
+}
+
+// saveCatalog записывает каталог базы данных. Коллекции в памяти в каталог
+// не попадают. Вызывается с захваченной блокировкой базы данных
+func (db *DB) saveCatalog() error {
+	if db.Config == nil || db.Config.StorageType == config.StorageTypeMemory || db.Config.StorageType == "" {
+		return nil
+	}
+	
+	db.catalogMu.Lock()
+	defer db.catalogMu.Unlock()
+	
+	catalog := dbCatalog{Version: catalogVersion, Collections: make([]CollectionEntry, 0)}
+	for name, coll := range db.Collections {
+		entry := CollectionEntry{Name: name, Indexes: coll.Definitions()}
+		
+		persistent, _ := coll.persistent()
+		switch store := persistent.(type) {
+		case *storage.FileStorage:
+			entry.Storage = config.StorageTypeFile
+			entry.Options.UseCache = store.UseCache
+		case *storage.PagedStorage:
+			entry.Storage = config.StorageTypePaged
+			entry.Options.PageSize = store.Stats().PageSize
+			entry.Options.PageCacheSize = db.Config.PageCacheSize
+		default:
+			continue
+		}
+		
+		catalog.Collections = append(catalog.Collections, entry)
+	}
+	sort.Slice(catalog.Collections, func(i, j int) bool {
+		return catalog.Collections[i].Name < catalog.Collections[j].Name
+	})
+	
+	data, err := json.MarshalIndent(catalog, "", "  ")
+	if err != nil {
+		return err
+	}
+	return storage.WriteFileAtomic(filepath.Join(db.Config.DataDir, catalogFileName), data)
+}
@@ -0,0 +1,190 @@
+package api
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/urusofam/jsondb/config"
+	"github.com/urusofam/jsondb/storage"
+)
+
+func TestOpenRestoresCatalog(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.NewFileStorageConfig(dir, false)
+	db, err := Open(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	users, err := db.NewCollection("users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pcfg := config.NewPagedStorageConfig(dir)
+	pcfg.PageSize = 2048
+	store, err := NewCollectionStorage(pcfg, "events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CreateCollection("events", store); err != nil {
+		t.Fatal(err)
+	}
+	events, _ := db.GetCollection("events")
+	for i := 0; i < 40; i++ {
+		users.InsertDocument(storage.Document{ID: fmt.Sprint("u", i), Content: map[string]interface{}{"age": float64(i % 9), "email": fmt.Sprint(i, "@x")}})
+		events.InsertDocument(storage.Document{ID: fmt.Sprint("e", i), Content: map[string]interface{}{"user": fmt.Sprint("u", i%7), "kind": fmt.Sprint("k", i%3)}})
+	}
+	for _, field := range []string{"age", "email"} {
+		if err := users.CreateIndex(field, IndexTypeBTree, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := events.CreateIndex("user", IndexTypeBTree, 4); err != nil {
+		t.Fatal(err)
+	}
+	
+	data, _ := os.ReadFile(filepath.Join(dir, catalogFileName))
+	for _, want := range []string{`"users"`, `"events"`, `"paged"`, `"page_size": 2048`, `"email"`, `"order": 4`} {
+		if !strings.Contains(string(data), want) {
+			t.Fatalf("каталог не содержит %s: %s", want, data)
+		}
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	
+	db, err = Open(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if len(db.Collections) != 2 {
+		t.Fatalf("открыто %d коллекций, ожидалось 2", len(db.Collections))
+	}
+	events, _ = db.GetCollection("events")
+	if _, ok := events.Storage.(*storage.VersionedStorage).Base.(*storage.PagedStorage); !ok {
+		t.Fatal("коллекция events открыта не со страничным хранилищем")
+	}
+	for name, fields := range map[string][]string{"users": {"age", "email"}, "events": {"user"}} {
+		coll, _ := db.GetCollection(name)
+		for _, field := range fields {
+			if _, ok := coll.Indexes[field]; !ok {
+				t.Fatalf("индекс %s коллекции %s не восстановлен", field, name)
+			}
+		}
+	}
+	checkScan(t, db,
+		"SELECT _id FROM users WHERE age = 3",
+		"SELECT _id FROM users WHERE age > 5 AND email >= '3'",
+		"SELECT _id FROM events WHERE user = 'u2'",
+		"SELECT _id FROM events WHERE kind = 'k1' AND user = 'u4'",
+	)
+	
+	if err := db.DropCollection("events"); err != nil {
+		t.Fatal(err)
+	}
+	data, _ = os.ReadFile(filepath.Join(dir, catalogFileName))
+	if strings.Contains(string(data), `"events"`) {
+		t.Fatalf("удаленная коллекция осталась в каталоге: %s", data)
+	}
+}
+
+func TestOpenDiscoversCollections(t *testing.T) {
+	dir := t.TempDir()
+	
+	// Каталога нет: коллекции определяются по поддиректориям
+	pcfg := config.NewPagedStorageConfig(dir)
+	store, err := NewCollectionStorage(pcfg, "paged")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Save(storage.Document{ID: "a", Content: map[string]interface{}{}})
+	store.(interface{ Close() error }).Close()
+	os.MkdirAll(filepath.Join(dir, "files"), 0755)
+	
+	db, err := Open(config.NewFileStorageConfig(dir, false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	files, err := db.GetCollection("files")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := files.persistent(); !ok {
+		t.Fatal("коллекция files открыта не на диске")
+	}
+	paged, err := db.GetCollection("paged")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc, err := paged.GetDocument("a"); err != nil {
+		t.Fatalf("документ страничного хранилища не найден: %v, %v", doc, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, catalogFileName)); err != nil {
+		t.Fatalf("каталог не создан: %v", err)
+	}
+}
+
+func TestOpenRejectsInvalidCatalog(t *testing.T) {
+	for content, want := range map[string]string{
+		`{bad`:                               "каталог базы данных поврежден",
+		`{"version": 99, "collections": []}`: "неподдерживаемая версия каталога",
+	} {
+		dir := t.TempDir()
+		os.WriteFile(filepath.Join(dir, catalogFileName), []byte(content), 0644)
+		if _, err := Open(config.NewFileStorageConfig(dir, false)); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: %v, ожидалась ошибка %q", content, err, want)
+		}
+	}
+}
+
+func TestOpenFailureKeepsCatalog(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.NewFileStorageConfig(dir, false)
+	db, err := Open(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a", "b", "c"} {
+		coll, err := db.NewCollection(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		coll.InsertDocument(storage.Document{ID: name, Content: map[string]interface{}{"n": 1.0}})
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	before, _ := os.ReadFile(filepath.Join(dir, catalogFileName))
+	
+	// Коллекцию b нельзя открыть: на месте ее директории лежит файл
+	os.Rename(filepath.Join(dir, "b"), filepath.Join(dir, "b.saved"))
+	os.WriteFile(filepath.Join(dir, "b"), []byte("x"), 0644)
+	if _, err := Open(cfg); err == nil {
+		t.Fatal("открыта база данных с неоткрываемой коллекцией")
+	}
+	after, _ := os.ReadFile(filepath.Join(dir, catalogFileName))
+	if string(after) != string(before) {
+		t.Fatalf("каталог изменен неудачным открытием:\n%s\nбыло:\n%s", after, before)
+	}
+	
+	os.Remove(filepath.Join(dir, "b"))
+	os.Rename(filepath.Join(dir, "b.saved"), filepath.Join(dir, "b"))
+	db, err = Open(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for _, name := range []string{"a", "b", "c"} {
+		coll, err := db.GetCollection(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := coll.GetDocument(name); err != nil {
+			t.Fatalf("документ коллекции %s не найден: %v", name, err)
+		}
+	}
+}
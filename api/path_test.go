@@ -0,0 +1,67 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/urusofam/jsondb/storage"
+)
+
+func TestPathIndexesMatchFullScan(t *testing.T) {
+	db := NewDB()
+	db.CreateCollection("users", storage.NewMemoryStorage())
+	coll, _ := db.GetCollection("users")
+	for i := 0; i < 30; i++ {
+		var content map[string]interface{}
+		data := fmt.Sprintf(`{"name":"n%02d","address":{"city":"c%d","zip":"%02d"},"tags":["t%d","t%d"]}`, i, i%3, i, i%4, (i+1)%4)
+		if err := json.Unmarshal([]byte(data), &content); err != nil {
+			t.Fatal(err)
+		}
+		coll.InsertDocument(storage.Document{ID: fmt.Sprint("u", i), Content: content})
+	}
+	for _, field := range []string{"address.city", "tags[*]"} {
+		if err := coll.CreateIndex(field, IndexTypeBTree, 4); err != nil {
+			t.Fatal(err)
+		}
+	}
+	
+	// Изменения документов после создания индексов отражаются в индексах по путям
+	coll.UpdateDocument(storage.Document{ID: "u1", Content: map[string]interface{}{
+		"name":    "n01",
+		"address": map[string]interface{}{"city": "c2"},
+		"tags":    []interface{}{"t9", "t2", "t2"},
+	}})
+	coll.UpdateDocument(storage.Document{ID: "u4", Content: map[string]interface{}{"name": "n04", "tags": "t1"}})
+	coll.DeleteDocument("u2")
+	
+	queries := []string{
+		"SELECT name, address.city, tags[0] FROM users WHERE address.city = 'c1'",
+		"SELECT name FROM users WHERE address.city = 'c2' OR address.city = 'c0'",
+		"SELECT name FROM users WHERE tags[*] = 't2'",
+		"SELECT name FROM users WHERE tags[*] = 't9' OR tags[*] = 't1'",
+		"SELECT name FROM users WHERE tags[*] >= 't2' AND tags[*] < 't3'",
+		"SELECT name FROM users WHERE address.city > 'c0' AND tags[*] = 't3'",
+		"SELECT name FROM users WHERE address.city IS MISSING",
+		"SELECT address.city, COUNT(*) AS n FROM users GROUP BY address.city",
+	}
+	checkScan(t, db, queries...)
+	
+	for q, want := range map[string]int{
+		"SELECT name FROM users WHERE tags[*] = 't2'":      14,
+		"SELECT name FROM users WHERE tags[*] = 't9'":      1,
+		"SELECT name FROM users WHERE address.city = 'c2'": 10,
+	} {
+		if got := count(t, db, q); got != want {
+			t.Errorf("%s: %d строк, ожидалось %d", q, got, want)
+		}
+	}
+	
+	rows, err := db.Query("SELECT address.city AS city FROM users ORDER BY address.zip DESC NULLS LAST LIMIT 2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(rows) != "[map[city:c2] map[city:c1]]" {
+		t.Fatalf("сортировка по вложенному полю: %v", rows)
+	}
+}
@@ -0,0 +1,27 @@
+package api
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/urusofam/jsondb/config"
+	"github.com/urusofam/jsondb/storage"
+)
+
+// NewCollectionStorage создает хранилище коллекции name согласно типу
+// хранения из конфигурации. Файловые хранилища размещаются в поддиректории
+// DataDir с именем коллекции
+func NewCollectionStorage(cfg *config.DBConfig, name string) (storage.Storage, error) {
+	dir := filepath.Join(cfg.DataDir, name)
+	
+	switch cfg.StorageType {
+	case config.StorageTypeMemory, "":
+		return storage.NewMemoryStorage(), nil
+	case config.StorageTypeFile:
+		return storage.NewFileStorage(dir, cfg.UseCache)
+	case config.StorageTypePaged:
+		return storage.NewPagedStorage(dir, cfg.PageSize, cfg.PageCacheSize)
+	}
+	
+	return nil, fmt.Errorf("неизвестный тип хранения: %s", cfg.StorageType)
+}
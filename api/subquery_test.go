@@ -0,0 +1,199 @@
+package api
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/urusofam/jsondb/storage"
+)
+
+// subqueryIDs возвращает отсортированные ID документов коллекции, для которых выполнено cond
+func subqueryIDs(t *testing.T, db *DB, collection string, cond func(doc storage.Document) bool) string {
+	t.Helper()
+	
+	coll, err := db.GetCollection(collection)
+	if err != nil {
+		t.Fatal(err)
+	}
+	docs, _ := coll.Storage.List()
+	ids := make([]string, 0)
+	for _, doc := range docs {
+		if cond(doc) {
+			ids = append(ids, doc.ID)
+		}
+	}
+	sort.Strings(ids)
+	return strings.Join(ids, ",")
+}
+
+// resultIDs возвращает значения _id строк результата через запятую в порядке строк
+func resultIDs(t *testing.T, db *DB, q string) string {
+	t.Helper()
+	
+	rows, err := db.Query(q)
+	if err != nil {
+		t.Fatalf("%s: %v", q, err)
+	}
+	ids := make([]string, len(rows))
+	for i, row := range rows {
+		ids[i] = fmt.Sprint(row["_id"])
+	}
+	return strings.Join(ids, ",")
+}
+
+func TestSubqueriesMatchBruteForce(t *testing.T) {
+	db := joinDB(t)
+	users, _ := db.GetCollection("users")
+	allUsers, _ := users.Storage.List()
+	city := make(map[string]interface{})
+	for _, u := range allUsers {
+		city[u.ID] = u.Content["city"]
+	}
+	orders, _ := db.GetCollection("orders")
+	allOrders, _ := orders.Storage.List()
+	hasOrder := func(u storage.Document, cond func(o storage.Document) bool) bool {
+		for _, o := range allOrders {
+			if o.Content["user"] == u.ID && cond(o) {
+				return true
+			}
+		}
+		return false
+	}
+	anyOrder := func(o storage.Document) bool { return true }
+	
+	cases := []struct {
+		q          string
+		collection string
+		cond       func(doc storage.Document) bool
+	}{
+		{"SELECT _id FROM orders WHERE user IN (SELECT _id FROM users WHERE city = 'M') ORDER BY _id", "orders",
+			func(o storage.Document) bool { u, ok := o.Content["user"].(string); return ok && city[u] == "M" }},
+		{"SELECT _id FROM orders WHERE user NOT IN (SELECT _id FROM users WHERE city = 'M') ORDER BY _id", "orders",
+			func(o storage.Document) bool { u, ok := o.Content["user"].(string); return ok && city[u] != "M" }},
+		{"SELECT _id FROM users u WHERE EXISTS (SELECT * FROM orders o WHERE o.user = u._id) ORDER BY _id", "users",
+			func(u storage.Document) bool { return hasOrder(u, anyOrder) }},
+		{"SELECT _id FROM users WHERE NOT EXISTS (SELECT * FROM orders WHERE orders.user = users._id) ORDER BY _id", "users",
+			func(u storage.Document) bool { return !hasOrder(u, anyOrder) }},
+		{"SELECT _id FROM users u WHERE EXISTS (SELECT * FROM orders o WHERE o.user = u._id AND o.city = u.city AND o.total > 50) ORDER BY _id", "users",
+			func(u storage.Document) bool {
+				return hasOrder(u, func(o storage.Document) bool {
+					return o.Content["city"] == u.Content["city"] && o.Content["total"].(float64) > 50
+				})
+			}},
+		{"SELECT _id FROM users WHERE city IN ('M', 'XX') ORDER BY _id", "users",
+			func(u storage.Document) bool { return u.Content["city"] == "M" }},
+		{"SELECT _id FROM users WHERE NOT city IN ('M') ORDER BY _id", "users",
+			func(u storage.Document) bool { return u.Content["city"] != "M" }},
+		{"SELECT _id FROM users WHERE EXISTS (SELECT * FROM orders WHERE total > 1000) ORDER BY _id", "users",
+			func(u storage.Document) bool { return false }},
+	}
+	
+	check := func(stage string) {
+		for _, c := range cases {
+			if got, want := resultIDs(t, db, c.q), subqueryIDs(t, db, c.collection, c.cond); got != want {
+				t.Fatalf("%s: %s: %s, перебор дает %s", stage, c.q, got, want)
+			}
+		}
+	}
+	check("без индексов")
+	
+	orders.CreateIndex("user", IndexTypeBTree, 4)
+	users.CreateIndex("city", IndexTypeHash, 0)
+	check("с индексами")
+	queries := make([]string, len(cases))
+	for i, c := range cases {
+		queries[i] = c.q
+	}
+	checkScan(t, db, queries...)
+	checkScan(t, db,
+		"SELECT u._id, o._id FROM users u JOIN orders o ON o.user = u._id WHERE u.city IN ('M', 'P') AND o.total IN (SELECT total FROM orders WHERE total < 15)",
+		"SELECT _id FROM users WHERE _id IN (SELECT user FROM orders GROUP BY user HAVING SUM(total) > 300)",
+	)
+	
+	q := "SELECT _id FROM orders WHERE user IN (SELECT _id FROM users WHERE city = 'M')"
+	if plan := joinPlan(t, db, q); !strings.Contains(plan, "Union") || !strings.Contains(plan, "IndexLookup по индексу user") {
+		t.Fatalf("индекс user не используется для IN с подзапросом:\n%s", plan)
+	}
+}
+
+func TestSubqueryStatements(t *testing.T) {
+	db := NewDB()
+	db.CreateCollection("users", storage.NewMemoryStorage())
+	db.CreateCollection("orders", storage.NewMemoryStorage())
+	if _, err := db.Exec(`INSERT INTO users VALUES {"_id":"u1","name":"ann","country":"RU"}, {"_id":"u2","name":"bob","country":"US"}, {"_id":"u3","name":"cid","country":"RU"}, {"_id":"u4","name":"dan"}`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO orders VALUES {"_id":"o1","user_id":"u1","total":10}, {"_id":"o2","user_id":"u1","total":20}, {"_id":"o3","user_id":"u2","total":5}, {"_id":"o4","user_id":"u3","total":1}, {"_id":"o5","total":7}`); err != nil {
+		t.Fatal(err)
+	}
+	
+	for q, want := range map[string]string{
+		"SELECT _id FROM users u WHERE EXISTS (SELECT * FROM orders o WHERE o.user_id = u._id AND EXISTS (SELECT * FROM users v WHERE v._id = o.user_id AND v.country = u.country AND o.total > 15)) ORDER BY _id": "u1",
+		"SELECT _id FROM users WHERE _id IN (SELECT user_id FROM orders GROUP BY user_id HAVING SUM(total) > 6) ORDER BY _id":                                                                                      "u1",
+		"SELECT _id FROM orders WHERE user_id NOT IN (SELECT _id FROM users WHERE country = 'RU') ORDER BY _id":                                                                                                    "o3",
+	} {
+		if got := resultIDs(t, db, q); got != want {
+			t.Errorf("%s: %s, ожидалось %s", q, got, want)
+		}
+	}
+	
+	if n, err := db.Exec("DELETE FROM users WHERE NOT EXISTS (SELECT * FROM orders WHERE orders.user_id = users._id)"); err != nil || n != 1 {
+		t.Fatalf("DELETE: %d, %v", n, err)
+	}
+	if n, err := db.Exec("UPDATE orders SET vip = true WHERE user_id IN (SELECT _id FROM users WHERE country = 'RU')"); err != nil || n != 3 {
+		t.Fatalf("UPDATE: %d, %v", n, err)
+	}
+	
+	for _, q := range []string{
+		"SELECT _id FROM users WHERE _id IN (SELECT * FROM orders)",
+		"SELECT _id FROM users WHERE _id IN (SELECT _id, total FROM orders)",
+		"SELECT _id FROM users WHERE _id IN (SELECT _id FROM nope)",
+		"SELECT _id FROM users WHERE EXISTS (SELECT * FROM orders WHERE FOO(total) = 1)",
+		"SELECT _id FROM users WHERE _id IN ()",
+		"SELECT _id FROM users WHERE 15 < (1)",
+	} {
+		if _, err := db.Query(q); err == nil {
+			t.Errorf("%s: ожидалась ошибка", q)
+		}
+	}
+	
+	// Массивы и объекты совпадают только с равными им значениями
+	db.CreateCollection("values", storage.NewMemoryStorage())
+	db.CreateCollection("allowed", storage.NewMemoryStorage())
+	if _, err := db.Exec(`INSERT INTO values VALUES {"_id":"v1","v":["a b"]}, {"_id":"v2","v":["a","b"]}, {"_id":"v3","v":[1]}, {"_id":"v4","v":["1"]}, {"_id":"v5","v":{"k":1}}, {"_id":"v6","v":{"k":"1"}}, {"_id":"v7","v":{"k":[1,2]}}, {"_id":"v8","v":{"k":["1,2"]}}`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO allowed VALUES {"_id":"a1","v":["a","b"]}, {"_id":"a2","v":[1]}, {"_id":"a3","v":{"k":"1"}}, {"_id":"a4","v":{"k":[1,2]}}`); err != nil {
+		t.Fatal(err)
+	}
+	for q, want := range map[string]string{
+		"SELECT _id FROM values WHERE v IN (SELECT v FROM allowed) ORDER BY _id":     "v2,v3,v6,v7",
+		"SELECT _id FROM values WHERE v NOT IN (SELECT v FROM allowed) ORDER BY _id": "v1,v4,v5,v8",
+	} {
+		if got := resultIDs(t, db, q); got != want {
+			t.Errorf("%s: %s, ожидалось %s", q, got, want)
+		}
+	}
+	
+	// Подзапросы при конкурентной записи не приводят к взаимоблокировке
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				db.Query("SELECT _id FROM users u WHERE EXISTS (SELECT * FROM orders o WHERE o.user_id = u._id)")
+			}
+		}()
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				db.Exec(fmt.Sprintf(`INSERT INTO orders VALUES {"_id":"x%d_%d","user_id":"u1"}`, i, j))
+			}
+		}(i)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,531 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+
+	"github.com/urusofam/jsondb/index"
+	"github.com/urusofam/jsondb/query"
+	"github.com/urusofam/jsondb/storage"
+)
+
+// ErrTxDone возвращается при обращении к завершенной транзакции
+var ErrTxDone = errors.New("транзакция уже завершена")
+
+// ErrTxConflict возвращается при фиксации транзакции, если прочитанный или
+// изменяемый ею документ был изменен другой транзакцией или напрямую
+var ErrTxConflict = errors.New("конфликт транзакций: документ изменен параллельно")
+
+// txKey идентифицирует документ в пределах базы данных
+type txKey struct {
+	collection string
+	id         string
+}
+
+// txState описывает состояние документа: Doc равен nil, если документа нет
+type txState struct {
+	Doc *storage.Document
+}
+
+// Tx представляет транзакцию над несколькими коллекциями.
+// Изменения накапливаются в транзакции и не видны другим читателям до Commit.
+// Commit применяет их к хранилищам и индексам атомарно: при ошибке уже
+// примененные изменения откатываются. В базе данных на диске все изменения
+// транзакции до применения записываются в журнал транзакций одной записью,
+// поэтому после сбоя фиксация доводится до конца. Транзакция оптимистическая: при фиксации
+// проверяется, что документы, прочитанные через Get или измененные транзакцией,
+// не изменились с момента первого обращения к ним
+type Tx struct {
+	db     *DB
+	writes map[txKey]txState
+	order  []txKey
+	base   map[txKey]txState
+	done   bool
+	mu     sync.Mutex
+}
+
+// Begin начинает новую транзакцию
+func (db *DB) Begin() *Tx {
+	return &Tx{
+		db:     db,
+		writes: make(map[txKey]txState),
+		order:  make([]txKey, 0),
+		base:   make(map[txKey]txState),
+	}
+}
+
+// collection возвращает коллекцию по имени
+func (tx *Tx) collection(name string) (*Collection, error) {
+	if tx.done {
+		return nil, ErrTxDone
+	}
+	return tx.db.GetCollection(name)
+}
+
+// read возвращает состояние документа, видимое транзакции, и запоминает
+// исходное состояние документа для проверки конфликтов
+func (tx *Tx) read(coll *Collection, id string) txState {
+	key := txKey{collection: coll.Name, id: id}
+	if state, ok := tx.writes[key]; ok {
+		return state
+	}
+	
+	state := loadState(coll, id)
+	if _, ok := tx.base[key]; !ok {
+		tx.base[key] = state
+	}
+	return state
+}
+
+// loadState читает текущее состояние документа из хранилища коллекции
+func loadState(coll *Collection, id string) txState {
+	coll.Mutex.RLock()
+	defer coll.Mutex.RUnlock()
+	
+	doc, err := coll.Storage.Get(id)
+	if err != nil {
+		return txState{}
+	}
+	return txState{Doc: &doc}
+}
+
+// stage записывает изменение документа в транзакцию
+func (tx *Tx) stage(coll *Collection, id string, doc *storage.Document) {
+	tx.read(coll, id)
+	
+	key := txKey{collection: coll.Name, id: id}
+	if _, ok := tx.writes[key]; !ok {
+		tx.order = append(tx.order, key)
+	}
+	tx.writes[key] = txState{Doc: doc}
+}
+
+// Insert вставляет документ в коллекцию в рамках транзакции.
+// Ограничения уникальных индексов проверяются при фиксации
+func (tx *Tx) Insert(collection string, doc storage.Document) error {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	
+	coll, err := tx.collection(collection)
+	if err != nil {
+		return err
+	}
+	if doc.ID == "" {
+		return errors.New("ID документа обязателен")
+	}
+	if tx.read(coll, doc.ID).Doc != nil {
+		return &index.DuplicateKeyError{Field: "_id", Value: doc.ID, ID: doc.ID}
+	}
+	
+	tx.stage(coll, doc.ID, &doc)
+	return nil
+}
+
+// Update обновляет документ в коллекции в рамках транзакции
+func (tx *Tx) Update(collection string, doc storage.Document) error {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	
+	coll, err := tx.collection(collection)
+	if err != nil {
+		return err
+	}
+	if doc.ID == "" {
+		return errors.New("ID документа обязателен")
+	}
+	
+	tx.stage(coll, doc.ID, &doc)
+	return nil
+}
+
+// Delete удаляет документ из коллекции в рамках транзакции
+func (tx *Tx) Delete(collection string, id string) error {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	
+	coll, err := tx.collection(collection)
+	if err != nil {
+		return err
+	}
+	if tx.read(coll, id).Doc == nil {
+		return fmt.Errorf("документ %s не найден в коллекции %s", id, collection)
+	}
+	
+	tx.stage(coll, id, nil)
+	return nil
+}
+
+// Get возвращает документ с учетом изменений, сделанных в транзакции
+func (tx *Tx) Get(collection string, id string) (storage.Document, error) {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	
+	coll, err := tx.collection(collection)
+	if err != nil {
+		return storage.Document{}, err
+	}
+	
+	state := tx.read(coll, id)
+	if state.Doc == nil {
+		return storage.Document{}, fmt.Errorf("документ %s не найден в коллекции %s", id, collection)
+	}
+	return *state.Doc, nil
+}
+
+// Query выполняет запрос с учетом изменений, сделанных в транзакции.
+// Коллекции, измененные транзакцией, читаются полным просмотром, так как
+// их индексы еще не содержат этих изменений. Инструкции INSERT, UPDATE и
+// DELETE выполняются как Exec и возвращают строку с полем affected
+func (tx *Tx) Query(queryStr string) ([]map[string]interface{}, error) {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	
+	if tx.done {
+		return nil, ErrTxDone
+	}
+	
+	stmt, err := tx.db.Parser.ParseStatement(queryStr)
+	if err != nil {
+		return nil, err
+	}
+	q, ok := stmt.(*query.Query)
+	if !ok {
+		affected, err := tx.exec(stmt)
+		if err != nil {
+			return nil, err
+		}
+		return affectedRows(affected), nil
+	}
+	
+	tx.db.Mutex.RLock()
+	defer tx.db.Mutex.RUnlock()
+	
+	return tx.executor().Execute(q)
+}
+
+// executor создает исполнитель запросов, видящий изменения транзакции.
+// Вызывается с захваченной блокировкой чтения базы данных
+func (tx *Tx) executor() *query.QueryExecutor {
+	qCollections := make(map[string]query.Collection)
+	for name, coll := range tx.db.Collections {
+		qColl := query.Collection{
+			Storage: coll.Storage,
+			Indexes: coll.Indexes,
+			Lock:    coll.Mutex.RLocker(),
+		}
+		
+		if overlay := tx.overlay(name); len(overlay) > 0 {
+			qColl.Storage = &txView{base: coll.Storage, writes: overlay}
+			qColl.Indexes = make(map[string]index.Index)
+		}
+		
+		qCollections[name] = qColl
+	}
+	
+	executor := query.NewQueryExecutor(qCollections)
+	executor.Functions = tx.db.Functions
+	return executor
+}
+
+// Exec выполняет в транзакции инструкцию INSERT, UPDATE или DELETE и
+// возвращает число затронутых документов. Изменения видны последующим
+// запросам транзакции и применяются при Commit
+func (tx *Tx) Exec(queryStr string) (int, error) {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	
+	stmt, err := tx.db.Parser.ParseStatement(queryStr)
+	if err != nil {
+		return 0, err
+	}
+	return tx.exec(stmt)
+}
+
+// exec выполняет инструкцию изменения. Все изменения инструкции сначала
+// вычисляются и только затем записываются в транзакцию, поэтому при ошибке
+// транзакция остается без изменений. Вызывается с захваченной tx.mu
+func (tx *Tx) exec(stmt query.Statement) (int, error) {
+	if tx.done {
+		return 0, ErrTxDone
+	}
+	
+	var (
+		name   string
+		where  *query.Condition
+		set    []*query.Assignment
+		remove bool
+	)
+	
+	switch s := stmt.(type) {
+	case *query.Insert:
+		return tx.insertAll(s.Into, s.Documents)
+	case *query.Update:
+		name, where, set = s.Collection, s.Where, s.Set
+	case *query.Delete:
+		name, where, remove = s.From, s.Where, true
+	default:
+		return 0, errors.New("ожидалась инструкция INSERT, UPDATE или DELETE")
+	}
+	
+	coll, err := tx.collection(name)
+	if err != nil {
+		return 0, err
+	}
+	
+	tx.db.Mutex.RLock()
+	executor := tx.executor()
+	tx.db.Mutex.RUnlock()
+	
+	docs, err := executor.Select(name, where)
+	if err != nil {
+		return 0, err
+	}
+	
+	changes := make([]*storage.Document, len(docs))
+	for i := range docs {
+		// Документ, измененный после выборки, дает конфликт, а не потерянное обновление
+		if !sameState(tx.read(coll, docs[i].ID), txState{Doc: &docs[i]}) {
+			return 0, fmt.Errorf("%w: %s в коллекции %s", ErrTxConflict, docs[i].ID, name)
+		}
+		if remove {
+			continue
+		}
+		
+		updated, err := executor.Assign(docs[i], set)
+		if err != nil {
+			return 0, err
+		}
+		changes[i] = &updated
+	}
+	
+	for i, doc := range docs {
+		tx.stage(coll, doc.ID, changes[i])
+	}
+	return len(docs), nil
+}
+
+// insertAll вставляет документы в транзакции, если ни один из них
+// не нарушает уникальность _id
+func (tx *Tx) insertAll(collection string, docs []storage.Document) (int, error) {
+	coll, err := tx.collection(collection)
+	if err != nil {
+		return 0, err
+	}
+	
+	seen := make(map[string]bool)
+	for _, doc := range docs {
+		if seen[doc.ID] || tx.read(coll, doc.ID).Doc != nil {
+			return 0, &index.DuplicateKeyError{Field: "_id", Value: doc.ID, ID: doc.ID}
+		}
+		seen[doc.ID] = true
+	}
+	
+	for i := range docs {
+		tx.stage(coll, docs[i].ID, &docs[i])
+	}
+	return len(docs), nil
+}
+
+// affectedRows возвращает результат инструкции изменения для Query
+func affectedRows(affected int) []map[string]interface{} {
+	return []map[string]interface{}{{"affected": affected}}
+}
+
+// overlay возвращает изменения транзакции в коллекции
+func (tx *Tx) overlay(collection string) map[string]txState {
+	result := make(map[string]txState)
+	for key, state := range tx.writes {
+		if key.collection == collection {
+			result[key.id] = state
+		}
+	}
+	return result
+}
+
+// Rollback отменяет транзакцию
+func (tx *Tx) Rollback() error {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	
+	if tx.done {
+		return ErrTxDone
+	}
+	
+	tx.done = true
+	return nil
+}
+
+// Commit фиксирует транзакцию. На время фиксации захватываются блокировки
+// всех изменяемых коллекций, поэтому читатели видят либо все изменения
+// транзакции, либо ни одного
+func (tx *Tx) Commit() error {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	
+	if tx.done {
+		return ErrTxDone
+	}
+	tx.done = true
+	
+	tx.db.Mutex.RLock()
+	defer tx.db.Mutex.RUnlock()
+	
+	// Блокировки захватываются в порядке имен коллекций, чтобы
+	// параллельные фиксации не могли взаимно заблокироваться
+	names := make([]string, 0)
+	collections := make(map[string]*Collection)
+	for key := range tx.base {
+		if _, ok := collections[key.collection]; ok {
+			continue
+		}
+		
+		coll, ok := tx.db.Collections[key.collection]
+		if !ok {
+			return fmt.Errorf("коллекция %s не найдена", key.collection)
+		}
+		collections[key.collection] = coll
+		names = append(names, key.collection)
+	}
+	sort.Strings(names)
+	
+	for _, name := range names {
+		collections[name].Mutex.Lock()
+		defer collections[name].Mutex.Unlock()
+	}
+	
+	// Проверить, что документы не изменились с момента чтения
+	for key, state := range tx.base {
+		doc, err := collections[key.collection].Storage.Get(key.id)
+		current := txState{}
+		if err == nil {
+			current.Doc = &doc
+		}
+		if !sameState(state, current) {
+			return fmt.Errorf("%w: %s в коллекции %s", ErrTxConflict, key.id, key.collection)
+		}
+	}
+	
+	logged, err := tx.db.txLog.begin(tx.journal())
+	if err != nil {
+		return fmt.Errorf("фиксация транзакции: %w", err)
+	}
+	
+	// Применить изменения, запоминая, как их откатить
+	err = tx.apply(collections)
+	if finishErr := tx.db.txLog.finish(logged); err == nil && finishErr != nil {
+		err = fmt.Errorf("фиксация транзакции: %w", finishErr)
+	}
+	return err
+}
+
+// apply применяет изменения транзакции к коллекциям, а при ошибке откатывает их
+func (tx *Tx) apply(collections map[string]*Collection) error {
+	for i, key := range tx.order {
+		coll := collections[key.collection]
+		if err := coll.write(key.id, tx.writes[key].Doc); err != nil {
+			return tx.undo(collections, i, err)
+		}
+	}
+	return nil
+}
+
+// journal возвращает изменения транзакции для журнала транзакций
+func (tx *Tx) journal() []txWrite {
+	writes := make([]txWrite, len(tx.order))
+	for i, key := range tx.order {
+		writes[i] = txWrite{
+			Collection: key.collection,
+			ID:         key.id,
+			Before:     tx.base[key].Doc,
+			After:      tx.writes[key].Doc,
+		}
+	}
+	return writes
+}
+
+// undo откатывает изменения с номерами до applied включительно
+// и возвращает исходную ошибку фиксации
+func (tx *Tx) undo(collections map[string]*Collection, applied int, cause error) error {
+	for i := applied; i >= 0; i-- {
+		key := tx.order[i]
+		coll := collections[key.collection]
+		
+		old := tx.base[key].Doc
+		if old == nil {
+			// Документа не было: удалить его, если он успел появиться
+			if _, err := coll.Storage.Get(key.id); err != nil {
+				for _, idx := range coll.Indexes {
+					idx.Remove(key.id)
+				}
+				continue
+			}
+		}
+		
+		if err := coll.write(key.id, old); err != nil {
+			return fmt.Errorf("фиксация транзакции: %v; откат не завершен: %w", cause, err)
+		}
+	}
+	
+	return fmt.Errorf("фиксация транзакции: %w", cause)
+}
+
+// sameState сравнивает два состояния документа
+func sameState(a, b txState) bool {
+	if a.Doc == nil || b.Doc == nil {
+		return a.Doc == nil && b.Doc == nil
+	}
+	return reflect.DeepEqual(a.Doc.Content, b.Doc.Content)
+}
+
+// txView представляет хранилище коллекции с наложенными изменениями
+// транзакции. Используется только для чтения в Tx.Query
+type txView struct {
+	base   storage.Storage
+	writes map[string]txState
+}
+
+// Save не поддерживается представлением
+func (v *txView) Save(doc storage.Document) error {
+	return errors.New("представление транзакции доступно только для чтения")
+}
+
+// Delete не поддерживается представлением
+func (v *txView) Delete(id string) error {
+	return errors.New("представление транзакции доступно только для чтения")
+}
+
+// Get возвращает документ с учетом изменений транзакции
+func (v *txView) Get(id string) (storage.Document, error) {
+	if state, ok := v.writes[id]; ok {
+		if state.Doc == nil {
+			return storage.Document{}, errors.New("документ не найден")
+		}
+		return *state.Doc, nil
+	}
+	return v.base.Get(id)
+}
+
+// List возвращает документы с учетом изменений транзакции
+func (v *txView) List() ([]storage.Document, error) {
+	docs, err := v.base.List()
+	if err != nil {
+		return nil, err
+	}
+	
+	result := make([]storage.Document, 0, len(docs)+len(v.writes))
+	for _, doc := range docs {
+		if _, ok := v.writes[doc.ID]; !ok {
+			result = append(result, doc)
+		}
+	}
+	for _, state := range v.writes {
+		if state.Doc != nil {
+			result = append(result, *state.Doc)
+		}
+	}
+	
+	return result, nil
+}
@@ -0,0 +1,263 @@
+package api
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/urusofam/jsondb/config"
+	"github.com/urusofam/jsondb/index"
+	"github.com/urusofam/jsondb/storage"
+)
+
+// failStorage возвращает ошибку при сохранении или удалении документа с ID failID
+type failStorage struct {
+	*storage.MemoryStorage
+	failID string
+}
+
+func (f *failStorage) Save(doc storage.Document) error {
+	if doc.ID == f.failID {
+		return errors.New("ошибка записи")
+	}
+	return f.MemoryStorage.Save(doc)
+}
+
+func (f *failStorage) Delete(id string) error {
+	if id == f.failID {
+		return errors.New("ошибка записи")
+	}
+	return f.MemoryStorage.Delete(id)
+}
+
+// count возвращает число строк результата запроса
+func count(t *testing.T, db *DB, q string) int {
+	t.Helper()
+	
+	rows, err := db.Query(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(rows)
+}
+
+func TestTxCommitAndRollback(t *testing.T) {
+	db := NewDB()
+	db.CreateCollection("orders", storage.NewMemoryStorage())
+	inv := &failStorage{MemoryStorage: storage.NewMemoryStorage()}
+	db.CreateCollection("inventory", inv)
+	orders, _ := db.GetCollection("orders")
+	inventory, _ := db.GetCollection("inventory")
+	orders.CreateIndex("total", IndexTypeBTree, 4)
+	inventory.CreateIndex("qty", IndexTypeBTree, 4)
+	inventory.InsertDocument(storage.Document{ID: "apple", Content: map[string]interface{}{"qty": 10.0}})
+	
+	tx := db.Begin()
+	tx.Insert("orders", storage.Document{ID: "o1", Content: map[string]interface{}{"total": 5.0}})
+	tx.Update("inventory", storage.Document{ID: "apple", Content: map[string]interface{}{"qty": 9.0}})
+	if n := count(t, db, "SELECT * FROM orders"); n != 0 {
+		t.Fatal("изменения транзакции видны до фиксации")
+	}
+	rows, err := tx.Query("SELECT * FROM orders WHERE total = 5")
+	if err != nil || len(rows) != 1 {
+		t.Fatalf("транзакция не видит своих изменений: %v, %v", rows, err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if count(t, db, "SELECT * FROM orders WHERE total = 5") != 1 || count(t, db, "SELECT * FROM inventory WHERE qty = 9") != 1 {
+		t.Fatal("изменения транзакции не применены")
+	}
+	if err := tx.Commit(); err != ErrTxDone {
+		t.Fatalf("ожидалась ErrTxDone, получено %v", err)
+	}
+	
+	// Ошибка хранилища откатывает все изменения транзакции
+	inv.failID = "pear"
+	tx = db.Begin()
+	tx.Delete("orders", "o1")
+	tx.Update("inventory", storage.Document{ID: "apple", Content: map[string]interface{}{"qty": 1.0}})
+	tx.Insert("inventory", storage.Document{ID: "pear", Content: map[string]interface{}{"qty": 3.0}})
+	if err := tx.Commit(); err == nil {
+		t.Fatal("ожидалась ошибка фиксации")
+	}
+	if count(t, db, "SELECT * FROM orders WHERE total = 5") != 1 {
+		t.Fatal("удаленный документ o1 не восстановлен")
+	}
+	if count(t, db, "SELECT * FROM inventory WHERE qty = 9") != 1 || count(t, db, "SELECT * FROM inventory WHERE qty = 1") != 0 {
+		t.Fatal("документ apple не восстановлен")
+	}
+	if ids, _ := inventory.FindByIndex("qty", 1.0); len(ids) != 0 {
+		t.Fatalf("индекс содержит откаченное значение: %v", ids)
+	}
+	
+	tx = db.Begin()
+	if err := tx.Delete("orders", "zz"); err == nil {
+		t.Fatal("удаление отсутствующего документа должно вернуть ошибку")
+	}
+	tx.Rollback()
+	if err := tx.Insert("orders", storage.Document{ID: "x"}); err != ErrTxDone {
+		t.Fatalf("ожидалась ErrTxDone, получено %v", err)
+	}
+}
+
+func TestTxConflict(t *testing.T) {
+	db := NewDB()
+	db.CreateCollection("inventory", storage.NewMemoryStorage())
+	inventory, _ := db.GetCollection("inventory")
+	inventory.InsertDocument(storage.Document{ID: "apple", Content: map[string]interface{}{"qty": 10.0}})
+	
+	// Прочитанный документ изменен после чтения
+	tx := db.Begin()
+	doc, _ := tx.Get("inventory", "apple")
+	doc.Content = map[string]interface{}{"qty": 8.0}
+	tx.Update("inventory", doc)
+	inventory.UpdateDocument(storage.Document{ID: "apple", Content: map[string]interface{}{"qty": 7.0}})
+	if err := tx.Commit(); !errors.Is(err, ErrTxConflict) {
+		t.Fatalf("ожидалась ErrTxConflict, получено %v", err)
+	}
+	
+	// Две транзакции вставляют один документ: вторая получает конфликт
+	first, second := db.Begin(), db.Begin()
+	first.Insert("inventory", storage.Document{ID: "pear", Content: map[string]interface{}{"qty": 1.0}})
+	second.Insert("inventory", storage.Document{ID: "pear", Content: map[string]interface{}{"qty": 2.0}})
+	if err := first.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := second.Commit(); !errors.Is(err, ErrTxConflict) {
+		t.Fatalf("ожидалась ErrTxConflict, получено %v", err)
+	}
+	
+	doc, _ = inventory.GetDocument("pear")
+	if doc.Content["qty"] != 1.0 {
+		t.Fatalf("конфликтующая транзакция изменила документ: %v", doc.Content)
+	}
+}
+
+func TestTxUniqueViolationRollsBack(t *testing.T) {
+	db := NewDB()
+	db.CreateCollection("users", storage.NewMemoryStorage())
+	db.CreateCollection("log", storage.NewMemoryStorage())
+	users, _ := db.GetCollection("users")
+	users.CreateIndex("email", IndexTypeUnique, 4)
+	users.InsertDocument(storage.Document{ID: "a", Content: map[string]interface{}{"email": "a@x"}})
+	
+	tx := db.Begin()
+	tx.Insert("log", storage.Document{ID: "l1", Content: map[string]interface{}{"msg": "created"}})
+	tx.Update("users", storage.Document{ID: "a", Content: map[string]interface{}{"email": "c@x"}})
+	tx.Insert("users", storage.Document{ID: "b", Content: map[string]interface{}{"email": "c@x"}})
+	if err := tx.Commit(); !errors.Is(err, index.ErrDuplicateKey) {
+		t.Fatalf("ожидалась ошибка дубликата, получено %v", err)
+	}
+	
+	if count(t, db, "SELECT * FROM log") != 0 {
+		t.Fatal("изменение другой коллекции не откачено")
+	}
+	if count(t, db, "SELECT * FROM users WHERE email = 'a@x'") != 1 || count(t, db, "SELECT * FROM users WHERE email = 'c@x'") != 0 {
+		t.Fatal("документ a не восстановлен")
+	}
+	if err := users.InsertDocument(storage.Document{ID: "b", Content: map[string]interface{}{"email": "c@x"}}); err != nil {
+		t.Fatalf("уникальный индекс сохранил откаченное значение: %v", err)
+	}
+}
+
+// appendTxRecords дописывает записи в журнал транзакций закрытой базы данных
+func appendTxRecords(t *testing.T, dir string, records ...txRecord) {
+	t.Helper()
+	
+	journal, _, err := storage.OpenJournal(filepath.Join(dir, txLogFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer journal.Close()
+	for _, record := range records {
+		if err := journal.Append(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestTxRecoveryIsAtomicAcrossCollections(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.NewFileStorageConfig(dir, false)
+	db, err := Open(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	orders, _ := db.NewCollection("orders")
+	inventory, _ := db.NewCollection("inventory")
+	inventory.CreateIndex("qty", IndexTypeBTree, 4)
+	inventory.CreateIndex("sku", IndexTypeUnique, 4)
+	apple := storage.Document{ID: "apple", Content: map[string]interface{}{"qty": 10.0, "sku": "A"}}
+	inventory.InsertDocument(apple)
+	
+	tx := db.Begin()
+	tx.Insert("orders", storage.Document{ID: "o0", Content: map[string]interface{}{"total": 1.0}})
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if st, _ := os.Stat(filepath.Join(dir, txLogFileName)); st.Size() != 0 {
+		t.Fatalf("журнал транзакций не очищен после фиксации: %d байт", st.Size())
+	}
+	
+	// Сбой посреди фиксации: заказ уже записан, остаток еще нет
+	o1 := storage.Document{ID: "o1", Content: map[string]interface{}{"total": 5.0}}
+	orders.InsertDocument(o1)
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	appendTxRecords(t, dir,
+		txRecord{ID: 1, Writes: []txWrite{
+			{Collection: "orders", ID: "o1", After: &o1},
+			{Collection: "inventory", ID: "apple", Before: &apple, After: &storage.Document{ID: "apple", Content: map[string]interface{}{"qty": 9.0, "sku": "A"}}},
+		}},
+		txRecord{ID: 2, Writes: []txWrite{
+			{Collection: "orders", ID: "o0"},
+		}},
+		txRecord{ID: 2, Done: true},
+	)
+	
+	db, err = Open(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count(t, db, "SELECT * FROM inventory WHERE qty = 9") != 1 || count(t, db, "SELECT * FROM orders") != 2 {
+		t.Fatal("прерванная фиксация не доведена до конца")
+	}
+	if st, _ := os.Stat(filepath.Join(dir, txLogFileName)); st.Size() != 0 {
+		t.Fatalf("журнал транзакций не очищен после восстановления: %d байт", st.Size())
+	}
+	
+	// Фиксация, которую невозможно довести до конца, откатывается целиком
+	inventory, _ = db.GetCollection("inventory")
+	pear := storage.Document{ID: "pear", Content: map[string]interface{}{"qty": 1.0, "sku": "P"}}
+	inventory.InsertDocument(pear)
+	o2 := storage.Document{ID: "o2", Content: map[string]interface{}{"total": 7.0}}
+	orders, _ = db.GetCollection("orders")
+	orders.InsertDocument(o2)
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	appendTxRecords(t, dir, txRecord{ID: 1, Writes: []txWrite{
+		{Collection: "orders", ID: "o2", After: &o2},
+		{Collection: "inventory", ID: "pear", Before: &pear, After: &storage.Document{ID: "pear", Content: map[string]interface{}{"qty": 0.0, "sku": "A"}}},
+	}})
+	
+	db, err = Open(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for q, want := range map[string]int{
+		"SELECT * FROM orders WHERE _id = 'o2'":     0,
+		"SELECT * FROM inventory WHERE qty = 1":     1,
+		"SELECT * FROM inventory WHERE sku = 'A'":   1,
+		"SELECT * FROM inventory WHERE qty = 0":     0,
+		"SELECT * FROM orders WHERE total IN (1,5)": 2,
+	} {
+		if got := count(t, db, q); got != want {
+			t.Fatalf("%s: %d строк, ожидалось %d", q, got, want)
+		}
+	}
+}
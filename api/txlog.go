@@ -0,0 +1,171 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/urusofam/jsondb/storage"
+)
+
+// txLogFileName - имя журнала транзакций в DataDir
+const txLogFileName = "transactions.log"
+
+// txRecord - запись журнала транзакций: все изменения транзакции вместе
+// с прежними состояниями документов или отметка Done о завершении фиксации
+type txRecord struct {
+	ID     uint64    `json:"id"`
+	Done   bool      `json:"done,omitempty"`
+	Writes []txWrite `json:"writes,omitempty"`
+}
+
+// txWrite описывает изменение документа: Before и After равны nil,
+// если документа до или после изменения нет
+type txWrite struct {
+	Collection string            `json:"collection"`
+	ID         string            `json:"id"`
+	Before     *storage.Document `json:"before,omitempty"`
+	After      *storage.Document `json:"after,omitempty"`
+}
+
+// txLog - журнал фиксаций транзакций базы данных на диске. Транзакция
+// записывается в журнал целиком до применения к коллекциям; после применения
+// или отката в журнал добавляется отметка о завершении. Журнал очищается,
+// когда незавершенных фиксаций не остается
+type txLog struct {
+	mu      sync.Mutex
+	journal *storage.Journal
+	next    uint64
+	active  int
+}
+
+// begin записывает изменения транзакции в журнал и возвращает номер записи.
+// Для базы данных в памяти журнала нет, и begin ничего не делает
+func (l *txLog) begin(writes []txWrite) (uint64, error) {
+	if l == nil || len(writes) == 0 {
+		return 0, nil
+	}
+	
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	
+	l.next++
+	if err := l.journal.Append(txRecord{ID: l.next, Writes: writes}); err != nil {
+		return 0, err
+	}
+	l.active++
+	return l.next, nil
+}
+
+// finish отмечает фиксацию с номером id завершенной
+func (l *txLog) finish(id uint64) error {
+	if l == nil || id == 0 {
+		return nil
+	}
+	
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	
+	l.active--
+	if l.active == 0 {
+		return l.journal.Reset()
+	}
+	return l.journal.Append(txRecord{ID: id, Done: true})
+}
+
+// close закрывает журнал
+func (l *txLog) close() error {
+	if l == nil {
+		return nil
+	}
+	return l.journal.Close()
+}
+
+// openTxLog открывает журнал транзакций и доводит до конца фиксации,
+// прерванные сбоем. Вызывается из Open после открытия всех коллекций
+func (db *DB) openTxLog() (*txLog, error) {
+	journal, records, err := storage.OpenJournal(filepath.Join(db.Config.DataDir, txLogFileName))
+	if err != nil {
+		return nil, err
+	}
+	
+	pending := make(map[uint64][]txWrite)
+	for i, data := range records {
+		var record txRecord
+		if err := json.Unmarshal(data, &record); err != nil {
+			journal.Close()
+			return nil, fmt.Errorf("журнал транзакций: запись %d: %w", i+1, err)
+		}
+		if record.Done {
+			delete(pending, record.ID)
+		} else {
+			pending[record.ID] = record.Writes
+		}
+	}
+	
+	ids := make([]uint64, 0, len(pending))
+	for id := range pending {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	
+	for _, id := range ids {
+		if err := db.replayTx(pending[id]); err != nil {
+			journal.Close()
+			return nil, fmt.Errorf("журнал транзакций: транзакция %d: %w", id, err)
+		}
+	}
+	if err := journal.Reset(); err != nil {
+		journal.Close()
+		return nil, err
+	}
+	
+	return &txLog{journal: journal}, nil
+}
+
+// replayTx повторяет фиксацию, прерванную сбоем: применяет все изменения
+// транзакции, а если это не удается - возвращает прежние состояния всех
+// ее документов, так как до сбоя могла быть применена любая их часть.
+// Изменения коллекций, которых больше нет, пропускаются
+func (db *DB) replayTx(writes []txWrite) error {
+	for _, w := range writes {
+		coll, ok := db.Collections[w.Collection]
+		if !ok {
+			continue
+		}
+		if err := coll.restoreState(w.ID, w.After); err != nil {
+			return db.revertTx(writes)
+		}
+	}
+	return nil
+}
+
+// revertTx возвращает документам изменений writes прежние состояния
+func (db *DB) revertTx(writes []txWrite) error {
+	for i := len(writes) - 1; i >= 0; i-- {
+		coll, ok := db.Collections[writes[i].Collection]
+		if !ok {
+			continue
+		}
+		if err := coll.restoreState(writes[i].ID, writes[i].Before); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// restoreState приводит документ к состоянию doc; удаление отсутствующего
+// документа ничего не делает
+func (c *Collection) restoreState(id string, doc *storage.Document) error {
+	c.Mutex.Lock()
+	defer c.Mutex.Unlock()
+	
+	if doc == nil {
+		if _, err := c.Storage.Get(id); err != nil {
+			return nil
+		}
+	}
+	return c.write(id, doc)
+}
@@ -0,0 +1,146 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/urusofam/jsondb/index"
+	"github.com/urusofam/jsondb/storage"
+)
+
+// udoc создает документ из пар ключ-значение
+func udoc(id string, kv ...interface{}) storage.Document {
+	m := map[string]interface{}{}
+	for i := 0; i < len(kv); i += 2 {
+		m[kv[i].(string)] = kv[i+1]
+	}
+	return storage.Document{ID: id, Content: m}
+}
+
+func TestUniqueIndexConstraint(t *testing.T) {
+	db := NewDB()
+	db.CreateCollection("u", storage.NewMemoryStorage())
+	c, _ := db.GetCollection("u")
+	if err := c.CreateIndex("email", IndexTypeUnique, 4); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.InsertDocument(udoc("a", "email", "x@x")); err != nil {
+		t.Fatal(err)
+	}
+	err := c.InsertDocument(udoc("b", "email", "x@x"))
+	var dup *index.DuplicateKeyError
+	if !errors.As(err, &dup) || dup.ID != "a" || !errors.Is(err, index.ErrDuplicateKey) {
+		t.Fatalf("ожидалась ошибка дубликата, получено %v", err)
+	}
+	if _, err := c.GetDocument("b"); err == nil {
+		t.Fatal("документ b сохранен несмотря на дубликат")
+	}
+	// Дубликат _id
+	err = c.InsertDocument(udoc("a", "email", "y@y"))
+	if !errors.As(err, &dup) || dup.Field != "_id" {
+		t.Fatalf("ожидалась ошибка дубликата, получено %v", err)
+	}
+	// Обновление документа с прежним значением допустимо
+	if err := c.UpdateDocument(udoc("a", "email", "x@x", "n", 1.0)); err != nil {
+		t.Fatal(err)
+	}
+	// Без поля может быть только один документ
+	if err := c.InsertDocument(udoc("m1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.InsertDocument(udoc("m2")); err == nil {
+		t.Fatal("второй документ без поля принят")
+	}
+	// Обновление, нарушающее уникальность
+	c.InsertDocument(udoc("c", "email", "z@z"))
+	if err := c.UpdateDocument(udoc("c", "email", "x@x")); err == nil {
+		t.Fatal("обновление с дубликатом принято")
+	}
+	d, _ := c.GetDocument("c")
+	if d.Content["email"] != "z@z" {
+		t.Fatal("документ c изменен")
+	}
+	res, _ := db.Query("SELECT * FROM u WHERE email = 'z@z'")
+	if len(res) != 1 {
+		t.Fatal(res)
+	}
+	// Уникальный индекс нельзя создать по полю с повторами
+	c.CreateIndex("n", IndexTypeBTree, 4)
+	c.InsertDocument(udoc("d", "email", "q", "k", 1.0))
+	c.InsertDocument(udoc("e", "email", "w", "k", 1.0))
+	if err := c.CreateIndex("k", IndexTypeUniqueSparse, 4); err == nil {
+		t.Fatal("создан уникальный индекс по полю с повторами")
+	}
+	if err := c.CreateIndex("k2", IndexTypeUniqueSparse, 4); err != nil {
+		t.Fatal(err)
+	}
+	tx := db.Begin()
+	if err := tx.Insert("u", udoc("a")); err == nil {
+		t.Fatal("транзакция приняла дубликат _id")
+	}
+	tx.Insert("u", udoc("f", "email", "w"))
+	if err := tx.Commit(); !errors.Is(err, index.ErrDuplicateKey) {
+		t.Fatal(err)
+	}
+	checkScan(t, db,
+		"SELECT _id FROM u WHERE email = 'x@x'",
+		"SELECT _id FROM u WHERE email >= 'w' AND email < 'z'",
+		"SELECT _id FROM u WHERE email IS MISSING",
+		"SELECT _id FROM u WHERE k2 IS NULL OR email = 'q'",
+	)
+}
+
+func TestWriteRestoresIndexesOnFailure(t *testing.T) {
+	store := &failStorage{MemoryStorage: storage.NewMemoryStorage()}
+	db := NewDB()
+	db.CreateCollection("u", store)
+	c, _ := db.GetCollection("u")
+	c.CreateIndex("email", IndexTypeUnique, 4)
+	c.CreateIndex("age", IndexTypeHash, 0)
+	if err := c.InsertDocument(udoc("a", "email", "x@x", "age", 30.0)); err != nil {
+		t.Fatal(err)
+	}
+	
+	ids := func(q string) string {
+		t.Helper()
+		rows, err := db.Query(q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fmt.Sprint(rows)
+	}
+	check := func() {
+		t.Helper()
+		if got := ids("SELECT _id FROM u WHERE email = 'x@x' AND age = 30"); got != "[map[_id:a]]" {
+			t.Fatalf("индексы потеряли прежнюю версию документа: %s", got)
+		}
+		if got := ids("SELECT _id FROM u WHERE email = 'y@y' OR age = 40"); got != "[]" {
+			t.Fatalf("индексы содержат неудавшуюся версию документа: %s", got)
+		}
+	}
+	
+	store.failID = "a"
+	if err := c.UpdateDocument(udoc("a", "email", "y@y", "age", 40.0)); err == nil {
+		t.Fatal("ожидалась ошибка записи")
+	}
+	check()
+	if err := c.DeleteDocument("a"); err == nil {
+		t.Fatal("ожидалась ошибка удаления")
+	}
+	check()
+	store.failID = "b"
+	if err := c.InsertDocument(udoc("b", "email", "y@y", "age", 40.0)); err == nil {
+		t.Fatal("ожидалась ошибка записи")
+	}
+	check()
+	
+	// После неудачных изменений уникальный индекс по-прежнему принимает значения
+	store.failID = ""
+	if err := c.InsertDocument(udoc("b", "email", "y@y", "age", 40.0)); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.InsertDocument(udoc("c", "email", "x@x")); err == nil {
+		t.Fatal("уникальный индекс потерял значение документа a")
+	}
+}
@@ -10,6 +10,7 @@ import (
 
 	"github.com/urusofam/jsondb/api"
 	"github.com/urusofam/jsondb/config"
+	"github.com/urusofam/jsondb/index"
 	"github.com/urusofam/jsondb/storage"
 )
 
@@ -32,63 +33,19 @@ func NewCLI(config *config.DBConfig) *CLI {
 }
 
 // Run запускает интерактивный цикл обработки команд
-// LoadExistingCollections загружает существующие коллекции из директории данных
+// LoadExistingCollections открывает базу данных по каталогу в директории данных
 func (cli *CLI) LoadExistingCollections() error {
-	// Убедимся, что директория для данных существует
-	if err := os.MkdirAll(cli.Config.DataDir, 0755); err != nil {
-		return fmt.Errorf("ошибка при создании директории данных: %v", err)
-	}
-
-	// Получить список директорий в папке данных
-	entries, err := os.ReadDir(cli.Config.DataDir)
+	db, err := api.Open(cli.Config)
 	if err != nil {
-		return fmt.Errorf("ошибка чтения директории данных: %v", err)
+		return fmt.Errorf("ошибка открытия базы данных: %v", err)
 	}
 
-	// Счетчик загруженных коллекций
-	loadedCount := 0
-
-	// Для каждой директории создать коллекцию
-	for _, entry := range entries {
-		if !entry.IsDir() {
-			continue // Пропустить файлы, нас интересуют только директории
-		}
-
-		collectionName := entry.Name()
-		
-		// Проверить, существует ли уже такая коллекция
-		if _, err := cli.DB.GetCollection(collectionName); err == nil {
-			continue // Коллекция уже существует, пропускаем
-		}
-
-		// Создать хранилище для коллекции
-		collectionPath := filepath.Join(cli.Config.DataDir, collectionName)
-		var collectionStorage storage.Storage
-		
-		if cli.Config.StorageType == config.StorageTypeFile {
-			fs, err := storage.NewFileStorage(collectionPath, cli.Config.UseCache)
-			if err != nil {
-				fmt.Printf("Предупреждение: не удалось создать хранилище для коллекции %s: %v\n", 
-					collectionName, err)
-				continue
-			}
-			collectionStorage = fs
-		} else {
-			collectionStorage = storage.NewMemoryStorage()
-		}
-
-		// Создать коллекцию
-		if err := cli.DB.CreateCollection(collectionName, collectionStorage); err != nil {
-			fmt.Printf("Предупреждение: не удалось загрузить коллекцию %s: %v\n", 
-				collectionName, err)
-			continue
-		}
+	// Заменить пустую базу данных, созданную в NewCLI
+	cli.DB.Close()
+	cli.DB = db
 
-		loadedCount++
-	}
-
-	if loadedCount > 0 {
-		fmt.Printf("Загружено существующих коллекций: %d\n", loadedCount)
+	if len(db.Collections) > 0 {
+		fmt.Printf("Загружено существующих коллекций: %d\n", len(db.Collections))
 	}
 
 	return nil
@@ -136,6 +93,10 @@ func (cli *CLI) Run() {
 		}
 	}
 
+	if err := cli.DB.Close(); err != nil {
+		fmt.Printf("Ошибка при закрытии базы данных: %v\n", err)
+	}
+
 	fmt.Println("Выход из программы")
 }
 
@@ -170,6 +131,8 @@ func (cli *CLI) handleCommand(command, args string) error {
 		return cli.dropIndexCommand(args)
 	case "query":
 		return cli.queryCommand(args)
+	case "functions":
+		return cli.functionsCommand()
 	default:
 		return fmt.Errorf("неизвестная команда: %s", command)
 	}
@@ -191,15 +154,29 @@ func (cli *CLI) printHelp() {
 	fmt.Println("  update <collection> <id> <json>    - обновить документ")
 	fmt.Println("  delete <collection> <id>           - удалить документ")
 	fmt.Println("  list-docs <collection> [limit]     - показать документы в коллекции")
-	fmt.Println("  create-index <collection> <field>  - создать индекс по полю")
+	fmt.Println("  create-index <collection> <field> [type] - создать индекс по полю (btree, unique, unique-sparse, fulltext, hash)")
 	fmt.Println("  drop-index <collection> <field>    - удалить индекс")
-	fmt.Println("  query <sql>                        - выполнить SQL-подобный запрос")
+	fmt.Println("  query <sql>                        - выполнить SQL-подобный запрос или INSERT, UPDATE, DELETE")
+	fmt.Println("  query EXPLAIN <sql>                - показать план выполнения запроса")
+	fmt.Println("  functions                          - показать функции, доступные в запросах")
 	fmt.Println()
 	fmt.Println("Примеры:")
 	fmt.Println("  create-collection users")
 	fmt.Println("  insert users {\"_id\":\"user1\",\"name\":\"Иван\",\"age\":30,\"email\":\"ivan@example.com\"}")
 	fmt.Println("  create-index users age")
+	fmt.Println("  create-index users email unique")
+	fmt.Println("  create-index orders tenant_id,status,created_at")
+	fmt.Println("  create-index articles body fulltext")
+	fmt.Println("  create-index sessions token hash")
 	fmt.Println("  query SELECT * FROM users WHERE age > 25")
+	fmt.Println("  query EXPLAIN SELECT * FROM users WHERE age > 25")
+	fmt.Println("  query UPDATE users SET status = 'inactive' WHERE age > 60")
+	fmt.Println("  query INSERT INTO users VALUES {\"_id\":\"user2\",\"name\":\"Анна\"}")
+	fmt.Println("  query SELECT title FROM articles WHERE MATCH(body, 'базы данных') LIMIT 10")
+	fmt.Println("  query SELECT u.name, o.total FROM users u JOIN orders o ON o.user_id = u._id")
+	fmt.Println("  query SELECT * FROM orders WHERE user_id IN (SELECT _id FROM users WHERE country = 'RU')")
+	fmt.Println("  query SELECT name FROM users WHERE name LIKE 'Ив%' AND age BETWEEN 18 AND 30")
+	fmt.Println("  query SELECT name, COALESCE(phone, 'нет') AS phone FROM users WHERE email IS NOT NULL")
 }
 
 // listCommand выводит список файлов
@@ -248,26 +225,8 @@ func (cli *CLI) createCollectionCommand(name string) error {
 		return fmt.Errorf("коллекция %s уже существует", name)
 	}
 
-	// Создать директорию для коллекции
-	collectionPath := filepath.Join(cli.Config.DataDir, name)
-	if err := os.MkdirAll(collectionPath, 0755); err != nil {
-		return err
-	}
-
-	// Создать хранилище
-	var collectionStorage storage.Storage
-	if cli.Config.StorageType == config.StorageTypeFile {
-		fs, err := storage.NewFileStorage(collectionPath, cli.Config.UseCache)
-		if err != nil {
-			return err
-		}
-		collectionStorage = fs
-	} else {
-		collectionStorage = storage.NewMemoryStorage()
-	}
-
-	// Создать коллекцию
-	if err := cli.DB.CreateCollection(name, collectionStorage); err != nil {
+	// Создать коллекцию с хранилищем из конфигурации базы данных
+	if _, err := cli.DB.NewCollection(name); err != nil {
 		return err
 	}
 
@@ -314,8 +273,8 @@ func (cli *CLI) dropCollectionCommand(name string) error {
 		return err
 	}
 
-	// Если используется файловое хранилище, удалить файлы
-	if cli.Config.StorageType == config.StorageTypeFile {
+	// Если используется файловое или страничное хранилище, удалить файлы
+	if cli.Config.StorageType == config.StorageTypeFile || cli.Config.StorageType == config.StorageTypePaged {
 		collectionPath := filepath.Join(cli.Config.DataDir, name)
 		if err := os.RemoveAll(collectionPath); err != nil {
 			fmt.Printf("Предупреждение: не удалось удалить файлы коллекции: %v\n", err)
@@ -548,13 +507,17 @@ func (cli *CLI) listDocumentsCommand(args string) error {
 // createIndexCommand создает индекс
 func (cli *CLI) createIndexCommand(args string) error {
 	// Разбор аргументов
-	parts := strings.SplitN(args, " ", 2)
-	if len(parts) < 2 {
-		return fmt.Errorf("требуется указать имя коллекции и поле для индексации")
+	parts := strings.Fields(args)
+	if len(parts) < 2 || len(parts) > 3 {
+		return fmt.Errorf("требуется указать имя коллекции, поле для индексации и, при необходимости, тип индекса")
 	}
 
 	collectionName := parts[0]
 	field := parts[1]
+	indexType := api.IndexTypeBTree
+	if len(parts) == 3 {
+		indexType = parts[2]
+	}
 
 	// Получить коллекцию
 	collection, err := cli.DB.GetCollection(collectionName)
@@ -563,11 +526,14 @@ func (cli *CLI) createIndexCommand(args string) error {
 	}
 
 	// Создать индекс
-	if err := collection.CreateIndex(field, "btree", cli.Config.DefaultBTreeOrder); err != nil {
+	if err := collection.CreateIndex(field, indexType, cli.Config.DefaultBTreeOrder); err != nil {
 		return err
 	}
 
 	fmt.Printf("Индекс по полю %s успешно создан\n", field)
+	if hash, ok := collection.Indexes[field].(*index.HashIndex); ok {
+		fmt.Printf("Ключей: %d, корзин: %d, память: %d байт\n", hash.KeyCount(), hash.Buckets(), hash.MemoryUsage())
+	}
 	return nil
 }
 
@@ -603,6 +569,30 @@ func (cli *CLI) queryCommand(queryStr string) error {
 		return fmt.Errorf("требуется указать запрос")
 	}
 
+	// Для EXPLAIN вывести план выполнения запроса
+	if strings.HasPrefix(strings.ToUpper(queryStr), "EXPLAIN ") {
+		plan, err := cli.DB.Explain(queryStr)
+		if err != nil {
+			return err
+		}
+
+		fmt.Println("План выполнения запроса:")
+		fmt.Print(plan)
+		return nil
+	}
+
+	// Для INSERT, UPDATE и DELETE вывести число затронутых документов
+	switch strings.ToUpper(strings.SplitN(queryStr, " ", 2)[0]) {
+	case "INSERT", "UPDATE", "DELETE":
+		affected, err := cli.DB.Exec(queryStr)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Затронуто документов: %d\n", affected)
+		return nil
+	}
+
 	// Выполнить запрос
 	results, err := cli.DB.Query(queryStr)
 	if err != nil {
@@ -628,3 +618,17 @@ func (cli *CLI) queryCommand(queryStr string) error {
 
 	return nil
 }
+
+// functionsCommand выводит функции, доступные в запросах
+func (cli *CLI) functionsCommand() error {
+	fmt.Println("Доступные функции:")
+	for _, fn := range cli.DB.Functions.List() {
+		kind := "встроенная"
+		if !fn.Builtin {
+			kind = "пользовательская"
+		}
+		fmt.Printf("  %-45s (%s)\n", fn.Signature(), kind)
+	}
+
+	return nil
+}
@@ -8,21 +8,30 @@ const (
 	StorageTypeMemory StorageType = "memory"
 	// StorageTypeFile для файлового хранения
 	StorageTypeFile StorageType = "file"
+	// StorageTypePaged для хранения коллекции в одном файле страниц
+	StorageTypePaged StorageType = "paged"
 )
 
 // DBConfig содержит конфигурацию базы данных
 type DBConfig struct {
-	// Тип хранения (память или файл)
+	// Тип хранения (память, файл на документ или файл страниц)
 	StorageType StorageType
 	
-	// DataDir используется для хранения файлов (только для StorageTypeFile)
+	// DataDir используется для хранения файлов (для StorageTypeFile и StorageTypePaged)
 	DataDir string
 	
 	// UseCache указывает, должен ли файловый механизм хранения использовать кэш
 	UseCache bool
 	
-	// DefaultBTreeOrder определяет порядок B-дерева по умолчанию для индексов
+	// DefaultBTreeOrder определяет порядок B-дерева по умолчанию для индексов:
+	// максимальное число потомков узла (не меньше 3)
 	DefaultBTreeOrder int
+	
+	// PageSize - размер страницы для StorageTypePaged; 0 - размер по умолчанию
+	PageSize int
+	
+	// PageCacheSize - число страниц в кэше для StorageTypePaged; 0 - значение по умолчанию
+	PageCacheSize int
 }
 
 // DefaultConfig возвращает конфигурацию по умолчанию
@@ -45,6 +54,15 @@ func NewFileStorageConfig(dataDir string, useCache bool) *DBConfig {
 	}
 }
 
+// NewPagedStorageConfig создает конфигурацию для страничного хранения
+func NewPagedStorageConfig(dataDir string) *DBConfig {
+	return &DBConfig{
+		StorageType:      StorageTypePaged,
+		DataDir:          dataDir,
+		DefaultBTreeOrder: 5,
+	}
+}
+
 // NewMemoryStorageConfig создает конфигурацию для хранения в памяти
 func NewMemoryStorageConfig() *DBConfig {
 	return &DBConfig{
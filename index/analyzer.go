@@ -0,0 +1,74 @@
+package index
+
+import (
+	"strings"
+	"unicode"
+)
+
+// stopWords содержит частые английские и русские слова, не влияющие на поиск
+var stopWords = makeSet(
+	// Английские
+	"a", "about", "above", "after", "again", "against", "all", "am", "an", "and", "any", "are", "as", "at",
+	"be", "because", "been", "before", "being", "below", "between", "both", "but", "by",
+	"can", "did", "do", "does", "doing", "down", "during", "each", "few", "for", "from", "further",
+	"had", "has", "have", "having", "he", "her", "here", "hers", "herself", "him", "himself", "his", "how",
+	"i", "if", "in", "into", "is", "it", "its", "itself", "just", "me", "more", "most", "my", "myself",
+	"no", "nor", "not", "now", "of", "off", "on", "once", "only", "or", "other", "our", "ours", "ourselves",
+	"out", "over", "own", "same", "she", "should", "so", "some", "such", "than", "that", "the", "their",
+	"theirs", "them", "themselves", "then", "there", "these", "they", "this", "those", "through", "to",
+	"too", "under", "until", "up", "very", "was", "we", "were", "what", "when", "where", "which", "while",
+	"who", "whom", "why", "will", "with", "would", "you", "your", "yours", "yourself", "yourselves",
+	// Русские
+	"а", "без", "более", "бы", "был", "была", "были", "было", "быть", "в", "вам", "вас", "весь", "во", "вот",
+	"все", "всего", "всех", "вы", "где", "да", "даже", "для", "до", "его", "ее", "ей", "ему", "если", "есть",
+	"еще", "же", "за", "здесь", "и", "из", "или", "им", "их", "к", "как", "ко", "когда", "кто", "ли", "либо",
+	"мне", "может", "мы", "на", "надо", "наш", "не", "него", "нее", "нет", "ни", "них", "но", "ну", "о", "об",
+	"однако", "он", "она", "они", "оно", "от", "очень", "по", "под", "при", "с", "со", "так", "также", "такой",
+	"там", "те", "тем", "то", "того", "тоже", "той", "только", "том", "ты", "у", "уже", "хотя", "чего", "чей",
+	"чем", "что", "чтобы", "чье", "чья", "эта", "эти", "это", "я",
+)
+
+// makeSet создает множество строк
+func makeSet(words ...string) map[string]bool {
+	set := make(map[string]bool, len(words))
+	for _, word := range words {
+		set[word] = true
+	}
+	return set
+}
+
+// Analyze разбивает текст на термы полнотекстового индекса: слова из букв
+// и цифр приводятся к нижнему регистру, стоп-слова отбрасываются, а
+// английские и русские слова сводятся к основе
+func Analyze(text string) []string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	
+	terms := make([]string, 0, len(words))
+	for _, word := range words {
+		word = strings.ReplaceAll(word, "ё", "е")
+		if stopWords[word] {
+			continue
+		}
+		terms = append(terms, stem(word))
+	}
+	return terms
+}
+
+// stem сводит слово к основе стеммером языка, к алфавиту которого оно относится
+func stem(word string) string {
+	latin, cyrillic := true, true
+	for _, r := range word {
+		latin = latin && r >= 'a' && r <= 'z'
+		cyrillic = cyrillic && r >= 'а' && r <= 'я'
+	}
+	
+	switch {
+	case latin:
+		return stemEnglish(word)
+	case cyrillic:
+		return stemRussian(word)
+	}
+	return word
+}
@@ -2,6 +2,8 @@ package index
 
 import (
 	"errors"
+	"fmt"
+	"sort"
 
 	"github.com/urusofam/jsondb/storage"
 )
@@ -18,6 +20,9 @@ type Index interface {
 	Search(field string, value interface{}) ([]string, error)
 }
 
+// MinBTreeOrder минимально допустимый порядок B-дерева
+const MinBTreeOrder = 3
+
 // BTreeNode представляет узел в B-дереве
 type BTreeNode struct {
 	Keys     []interface{}
@@ -26,198 +31,399 @@ type BTreeNode struct {
 	IsLeaf   bool
 }
 
-// BTreeIndex реализует интерфейс Index используя B-дерево
+// BTreeIndex реализует интерфейс Index используя B-дерево.
+// Order задает максимальное число потомков узла: узел хранит не более
+// Order-1 ключей, а любой узел, кроме корня, - не менее ceil(Order/2)-1.
+// Field может быть путем к вложенному полю; для пути с [*] документ индексируется
+// под каждым различным значением элементов массива.
+// Уникальный индекс (Unique) не допускает двух документов с одним ключом
 type BTreeIndex struct {
 	Root     *BTreeNode
 	Field    string
 	Order    int
-	DocIDs   map[string]interface{} // Сопоставляет ID документов со значениями полей
+	DocIDs   map[string]interface{} // Сопоставляет ID документов со значениями полей (для пути с [*] - со списками значений)
+	Fields   []string               // Поля составного индекса; nil для индекса по одному полю
+	Unique   bool                   // Значения поля уникальны
+	Sparse   bool                   // Документы без поля не индексируются (только для Unique)
+	
+	keyCount int  // Количество различных ключей в дереве
+	multiKey bool // Путь содержит [*]
+}
+
+// Statistics предоставляет статистику индекса для оценки стоимости запросов
+type Statistics interface {
+	// Len возвращает количество проиндексированных документов
+	Len() int
+	
+	// KeyCount возвращает количество различных значений в индексе
+	KeyCount() int
 }
 
 // NewBTreeIndex создает новый индекс B-дерева
 func NewBTreeIndex(field string, order int) *BTreeIndex {
+	if order < MinBTreeOrder {
+		order = MinBTreeOrder
+	}
+	
 	return &BTreeIndex{
-		Root: &BTreeNode{
-			Keys:     make([]interface{}, 0),
-			Values:   make([][]string, 0),
-			Children: make([]*BTreeNode, 0),
-			IsLeaf:   true,
-		},
-		Field:  field,
-		Order:  order,
-		DocIDs: make(map[string]interface{}),
+		Root:     newBTreeNode(true),
+		Field:    field,
+		Order:    order,
+		DocIDs:   make(map[string]interface{}),
+		multiKey: storage.HasWildcard(field),
+	}
+}
+
+// NewUniqueBTreeIndex создает уникальный индекс B-дерева. Если sparse равен
+// false, документ без поля индексируется со значением null, поэтому такой
+// документ может быть только один; разреженный индекс такие документы пропускает
+func NewUniqueBTreeIndex(field string, order int, sparse bool) *BTreeIndex {
+	bt := NewBTreeIndex(field, order)
+	bt.Unique = true
+	bt.Sparse = sparse
+	return bt
+}
+
+// Len возвращает количество проиндексированных документов
+func (bt *BTreeIndex) Len() int {
+	return len(bt.DocIDs)
+}
+
+// KeyCount возвращает количество различных значений в индексе
+func (bt *BTreeIndex) KeyCount() int {
+	return bt.keyCount
+}
+
+// newBTreeNode создает пустой узел B-дерева
+func newBTreeNode(isLeaf bool) *BTreeNode {
+	return &BTreeNode{
+		Keys:     make([]interface{}, 0),
+		Values:   make([][]string, 0),
+		Children: make([]*BTreeNode, 0),
+		IsLeaf:   isLeaf,
 	}
 }
 
-// Add добавляет документ в индекс
+// maxKeys возвращает максимальное количество ключей в узле
+func (bt *BTreeIndex) maxKeys() int {
+	return bt.Order - 1
+}
+
+// minKeys возвращает минимальное количество ключей в узле, кроме корня
+func (bt *BTreeIndex) minKeys() int {
+	return (bt.Order+1)/2 - 1
+}
+
+// Add добавляет документ в индекс. Для уникального индекса
+// нарушение ограничения возвращается как *DuplicateKeyError
 func (bt *BTreeIndex) Add(doc storage.Document) error {
-	value, ok := getNestedValue(doc.Content, bt.Field)
-	if !ok {
+	keys, indexed := bt.extract(doc)
+	if indexed {
+		if err := bt.check(doc.ID, keys); err != nil {
+			return err
+		}
+	}
+	
+	// Повторное добавление документа заменяет его прежнее значение
+	if _, ok := bt.DocIDs[doc.ID]; ok {
+		if err := bt.Remove(doc.ID); err != nil {
+			return err
+		}
+	}
+	
+	if !indexed {
 		return nil // Поле не существует, нечего индексировать
 	}
 	
-	bt.DocIDs[doc.ID] = value
+	if bt.multiKey {
+		bt.DocIDs[doc.ID] = keys
+	} else {
+		bt.DocIDs[doc.ID] = keys[0]
+	}
+	for _, key := range keys {
+		if err := bt.insert(key, doc.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Check проверяет, что документ можно добавить в индекс, не нарушив
+// ограничение уникальности. Прежняя версия документа конфликтом не считается
+func (bt *BTreeIndex) Check(doc storage.Document) error {
+	keys, ok := bt.extract(doc)
+	if !ok {
+		return nil
+	}
+	return bt.check(doc.ID, keys)
+}
+
+// extract возвращает ключи, под которыми индексируется документ.
+// Второе значение равно false, если документ не индексируется
+func (bt *BTreeIndex) extract(doc storage.Document) ([]interface{}, bool) {
+	if len(bt.Fields) > 0 {
+		key, ok := bt.tuple(doc)
+		if ok || (bt.Unique && !bt.Sparse) {
+			return []interface{}{key}, true
+		}
+		return nil, false
+	}
 	
-	// Найти, куда вставить значение в B-дереве
-	ids, _ := bt.Search(bt.Field, value)
+	keys := make([]interface{}, 0)
 	
-	// Проверяем, есть ли уже этот ID в списке
-	found := false
-	for _, id := range ids {
-		if id == doc.ID {
-			found = true
-			break
+	if !bt.multiKey {
+		if value, ok := storage.ResolvePath(doc.Content, bt.Field); ok {
+			keys = append(keys, value)
+		}
+	} else {
+		// Каждое различное значение элементов массива становится отдельным ключом
+		for _, value := range storage.ResolveAll(doc.Content, bt.Field) {
+			duplicate := false
+			for _, key := range keys {
+				if compare(key, value) == 0 {
+					duplicate = true
+					break
+				}
+			}
+			if !duplicate {
+				keys = append(keys, value)
+			}
 		}
 	}
 	
-	// Если ID ещё нет в списке, добавляем его
-	if !found {
-		ids = append(ids, doc.ID)
+	if len(keys) > 0 {
+		return keys, true
+	}
+	
+	// Уникальный неразреженный индекс считает отсутствующее поле равным null
+	if bt.Unique && !bt.Sparse {
+		return []interface{}{nil}, true
+	}
+	return nil, false
+}
+
+// check проверяет, что ключи не заняты другими документами
+func (bt *BTreeIndex) check(id string, keys []interface{}) error {
+	if !bt.Unique {
+		return nil
+	}
+	
+	for _, key := range keys {
+		node, pos := bt.find(bt.Root, key)
+		if node == nil {
+			continue
+		}
+		for _, other := range node.Values[pos] {
+			if other != id {
+				return &DuplicateKeyError{Field: bt.Field, Value: key, ID: other}
+			}
+		}
 	}
 	
-	// Обновить или вставить значение
-	return bt.insert(bt.Root, value, ids)
+	return nil
+}
+
+// docKeys возвращает ключи, под которыми проиндексирован документ
+func (bt *BTreeIndex) docKeys(value interface{}) []interface{} {
+	if bt.multiKey {
+		return value.([]interface{})
+	}
+	return []interface{}{value}
 }
 
-// getNestedValue получает вложенные значения из JSON документа
-func getNestedValue(content map[string]interface{}, field string) (interface{}, bool) {
-	// Для простоты предположим, что вложенность отсутствует
-	val, ok := content[field]
-	return val, ok
+// insert добавляет ID документа к ключу B-дерева, создавая ключ при необходимости
+func (bt *BTreeIndex) insert(key interface{}, docID string) error {
+	// Ключ уже существует, дополняем список документов
+	if node, pos := bt.find(bt.Root, key); node != nil {
+		node.Values[pos] = append(node.Values[pos], docID)
+		return nil
+	}
+	
+	bt.insertKey(bt.Root, key, []string{docID})
+	bt.keyCount++
+	
+	// Переполненный корень разделяется, и дерево растет в высоту
+	if len(bt.Root.Keys) > bt.maxKeys() {
+		oldRoot := bt.Root
+		bt.Root = newBTreeNode(false)
+		bt.Root.Children = append(bt.Root.Children, oldRoot)
+		bt.splitChild(bt.Root, 0)
+	}
+	
+	return nil
 }
 
-// insert добавляет значение в B-дерево
-func (bt *BTreeIndex) insert(node *BTreeNode, key interface{}, docIDs []string) error {
-	// Реализация логики вставки B-дерева
-	// Это упрощенная версия
+// insertKey вставляет новый ключ в поддерево, разделяя переполненные узлы на обратном пути
+func (bt *BTreeIndex) insertKey(node *BTreeNode, key interface{}, docIDs []string) {
+	pos := position(node, key)
 	
 	if node.IsLeaf {
-		// Вставить ключ и docIDs в правильную позицию
-		pos := 0
-		for pos < len(node.Keys) && compare(node.Keys[pos], key) < 0 {
-			pos++
-		}
-		
+		node.Keys = insertKeyAt(node.Keys, pos, key)
+		node.Values = insertValueAt(node.Values, pos, docIDs)
+		return
+	}
+	
+	bt.insertKey(node.Children[pos], key, docIDs)
+	bt.splitIfNeeded(node, pos)
+}
+
+// position возвращает позицию первого ключа узла, не меньшего key
+func position(node *BTreeNode, key interface{}) int {
+	return sort.Search(len(node.Keys), func(i int) bool {
+		return compare(node.Keys[i], key) >= 0
+	})
+}
+
+// find находит узел и позицию ключа в поддереве
+func (bt *BTreeIndex) find(node *BTreeNode, key interface{}) (*BTreeNode, int) {
+	for {
+		pos := position(node, key)
 		if pos < len(node.Keys) && compare(node.Keys[pos], key) == 0 {
-			// Ключ уже существует, обновляем docIDs
-			node.Values[pos] = docIDs
-			return nil
-		}
-		
-		// Вставить новый ключ и docIDs
-		node.Keys = append(node.Keys, nil)
-		node.Values = append(node.Values, nil)
-		
-		for i := len(node.Keys) - 1; i > pos; i-- {
-			node.Keys[i] = node.Keys[i-1]
-			node.Values[i] = node.Values[i-1]
-		}
-		
-		node.Keys[pos] = key
-		node.Values[pos] = docIDs
-		
-		// Разделить узел при необходимости
-		return bt.splitIfNeeded(node)
-	} else {
-		// Найти дочерний узел для вставки
-		pos := 0
-		for pos < len(node.Keys) && compare(node.Keys[pos], key) < 0 {
-			pos++
+			return node, pos
 		}
 		
-		if pos < len(node.Keys) && compare(node.Keys[pos], key) == 0 {
-			// Ключ уже существует, обновляем docIDs
-			node.Values[pos] = docIDs
-			return nil
+		if node.IsLeaf {
+			return nil, 0
 		}
 		
-		// Вставка в дочерний узел
-		childPos := pos
-		if pos == len(node.Keys) {
-			childPos = len(node.Children) - 1
-		}
-		return bt.insert(node.Children[childPos], key, docIDs)
+		node = node.Children[pos]
 	}
 }
 
-// compare сравнивает два значения
+// Compare сравнивает два значения в порядке ключей индекса
+func Compare(a, b interface{}) int {
+	return compare(a, b)
+}
+
+// compare сравнивает два значения.
+// Значения разных типов упорядочиваются по рангу типа, чтобы порядок ключей
+// в дереве оставался полным: null < bool < число < строка < массив < прочее.
+// Массивы, в том числе ключи составного индекса, сравниваются лексикографически
 func compare(a, b interface{}) int {
-	// Реализация логики сравнения для разных типов
-	// Это упрощенная версия
+	ra, rb := typeRank(a), typeRank(b)
+	if ra != rb {
+		if ra < rb {
+			return -1
+		}
+		return 1
+	}
 	
 	switch v1 := a.(type) {
 	case string:
-		if v2, ok := b.(string); ok {
-			if v1 < v2 {
-				return -1
-			} else if v1 > v2 {
-				return 1
-			}
-			return 0
-		}
-	case float64:
-		if v2, ok := b.(float64); ok {
-			if v1 < v2 {
-				return -1
-			} else if v1 > v2 {
-				return 1
-			}
-			return 0
-		}
-		// Преобразование int в float64 для сравнения
-		if v2, ok := b.(int); ok {
-			v2f := float64(v2)
-			if v1 < v2f {
-				return -1
-			} else if v1 > v2f {
-				return 1
-			}
-			return 0
-		}
-	case int:
-		if v2, ok := b.(int); ok {
-			if v1 < v2 {
-				return -1
-			} else if v1 > v2 {
-				return 1
-			}
-			return 0
-		}
-		// Преобразование float64 в int для сравнения
-		if v2, ok := b.(float64); ok {
-			v1f := float64(v1)
-			if v1f < v2 {
-				return -1
-			} else if v1f > v2 {
-				return 1
-			}
-			return 0
+		v2 := b.(string)
+		if v1 < v2 {
+			return -1
+		} else if v1 > v2 {
+			return 1
 		}
+		return 0
 	case bool:
-		if v2, ok := b.(bool); ok {
-			if !v1 && v2 {
-				return -1
-			} else if v1 && !v2 {
-				return 1
-			}
-			return 0
+		v2 := b.(bool)
+		if !v1 && v2 {
+			return -1
+		} else if v1 && !v2 {
+			return 1
+		}
+		return 0
+	case nil:
+		return 0
+	case []interface{}:
+		return compareTuples(v1, b.([]interface{}))
+	}
+	
+	if ra == rankNumber {
+		f1, _ := toFloat(a)
+		f2, _ := toFloat(b)
+		if f1 < f2 {
+			return -1
+		} else if f1 > f2 {
+			return 1
 		}
+		return 0
 	}
 	
+	// Составные значения сравниваются по их текстовому представлению
+	s1, s2 := fmt.Sprint(a), fmt.Sprint(b)
+	if s1 < s2 {
+		return -1
+	} else if s1 > s2 {
+		return 1
+	}
 	return 0
 }
 
-// splitIfNeeded разделяет узел, если он имеет слишком много ключей
-func (bt *BTreeIndex) splitIfNeeded(node *BTreeNode) error {
-	if len(node.Keys) <= bt.Order {
-		return nil // Нет необходимости разделять
+// Ранги типов для упорядочивания значений разных типов
+const (
+	rankNull = iota
+	rankBool
+	rankNumber
+	rankString
+	rankArray
+	rankOther
+	rankMax
+)
+
+// typeRank возвращает ранг типа значения
+func typeRank(v interface{}) int {
+	switch v.(type) {
+	case nil:
+		return rankNull
+	case bool:
+		return rankBool
+	case int, int64, float64:
+		return rankNumber
+	case string:
+		return rankString
+	case []interface{}:
+		return rankArray
+	case maxKey:
+		return rankMax
+	}
+	return rankOther
+}
+
+// toFloat приводит числовое значение к float64
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	}
+	return 0, false
+}
+
+// splitIfNeeded разделяет потомка node.Children[i], если он имеет слишком много ключей
+func (bt *BTreeIndex) splitIfNeeded(node *BTreeNode, i int) {
+	if len(node.Children[i].Keys) <= bt.maxKeys() {
+		return // Нет необходимости разделять
 	}
 	
-	// Реализация разделения узла B-дерева
-	// Для простоты сейчас оставим как есть
-	// В реальной реализации здесь должно быть разделение по правилам B-дерева
+	bt.splitChild(node, i)
+}
+
+// splitChild делит потомка пополам и поднимает медианный ключ в родителя
+func (bt *BTreeIndex) splitChild(parent *BTreeNode, i int) {
+	child := parent.Children[i]
+	mid := len(child.Keys) / 2
 	
-	return nil
+	right := newBTreeNode(child.IsLeaf)
+	right.Keys = append(right.Keys, child.Keys[mid+1:]...)
+	right.Values = append(right.Values, child.Values[mid+1:]...)
+	if !child.IsLeaf {
+		right.Children = append(right.Children, child.Children[mid+1:]...)
+		child.Children = child.Children[:mid+1]
+	}
+	
+	medianKey, medianIDs := child.Keys[mid], child.Values[mid]
+	child.Keys = child.Keys[:mid]
+	child.Values = child.Values[:mid]
+	
+	parent.Keys = insertKeyAt(parent.Keys, i, medianKey)
+	parent.Values = insertValueAt(parent.Values, i, medianIDs)
+	parent.Children = insertChildAt(parent.Children, i+1, right)
 }
 
 // Remove удаляет документ из индекса
@@ -227,72 +433,150 @@ func (bt *BTreeIndex) Remove(id string) error {
 		return nil // Документ не проиндексирован
 	}
 	
-	// Найти IDs документов для этого значения
-	ids, _ := bt.Search(bt.Field, value)
+	delete(bt.DocIDs, id)
+	
+	for _, key := range bt.docKeys(value) {
+		if err := bt.removeID(key, id); err != nil {
+			return err
+		}
+	}
+	
+	return nil
+}
+
+// removeID удаляет ID документа из списка ключа, удаляя опустевший ключ из дерева
+func (bt *BTreeIndex) removeID(value interface{}, id string) error {
+	node, pos := bt.find(bt.Root, value)
+	if node == nil {
+		return fmt.Errorf("значение документа %s отсутствует в индексе", id)
+	}
 	
 	// Удалить ID документа
-	newIDs := make([]string, 0, len(ids)-1)
-	for _, docID := range ids {
+	newIDs := make([]string, 0, len(node.Values[pos]))
+	for _, docID := range node.Values[pos] {
 		if docID != id {
 			newIDs = append(newIDs, docID)
 		}
 	}
 	
-	delete(bt.DocIDs, id)
-	
 	if len(newIDs) > 0 {
-		// Обновить значение
-		return bt.insert(bt.Root, value, newIDs)
-	} else {
-		// Удалить значение
-		return bt.remove(bt.Root, value)
+		node.Values[pos] = newIDs
+		return nil
+	}
+	
+	// Удалить значение
+	bt.remove(bt.Root, value)
+	bt.keyCount--
+	
+	// Опустевший внутренний корень заменяется единственным потомком
+	if len(bt.Root.Keys) == 0 && !bt.Root.IsLeaf {
+		bt.Root = bt.Root.Children[0]
 	}
+	
+	return nil
 }
 
-// remove удаляет значение из B-дерева
-func (bt *BTreeIndex) remove(node *BTreeNode, key interface{}) error {
-	// Реализация логики удаления B-дерева
-	// Для простоты сейчас реализуем базовую версию
-	if !node.IsLeaf {
-		// Найти позицию в текущем узле
-		pos := 0
-		for pos < len(node.Keys) && compare(node.Keys[pos], key) < 0 {
-			pos++
-		}
-		
-		if pos < len(node.Keys) && compare(node.Keys[pos], key) == 0 {
-			// Ключ найден в этом узле
-			// Удаляем ключ и его значения
-			copy(node.Keys[pos:], node.Keys[pos+1:])
-			copy(node.Values[pos:], node.Values[pos+1:])
-			node.Keys = node.Keys[:len(node.Keys)-1]
-			node.Values = node.Values[:len(node.Values)-1]
-			return nil
+// remove удаляет ключ из поддерева, восстанавливая заполненность узлов на обратном пути
+func (bt *BTreeIndex) remove(node *BTreeNode, key interface{}) {
+	pos := position(node, key)
+	found := pos < len(node.Keys) && compare(node.Keys[pos], key) == 0
+	
+	if node.IsLeaf {
+		if found {
+			node.Keys = removeKeyAt(node.Keys, pos)
+			node.Values = removeValueAt(node.Values, pos)
 		}
-		
-		// Ключ должен быть в дочернем узле
-		childPos := pos
-		if childPos >= len(node.Children) {
-			childPos = len(node.Children) - 1
+		return
+	}
+	
+	if found {
+		// Заменяем ключ его предшественником из левого поддерева
+		// и удаляем предшественника из листа
+		pred := node.Children[pos]
+		for !pred.IsLeaf {
+			pred = pred.Children[len(pred.Children)-1]
 		}
-		return bt.remove(node.Children[childPos], key)
+		last := len(pred.Keys) - 1
+		node.Keys[pos], node.Values[pos] = pred.Keys[last], pred.Values[last]
+		key = pred.Keys[last]
+	}
+	
+	bt.remove(node.Children[pos], key)
+	bt.rebalance(node, pos)
+}
+
+// rebalance восполняет недостаток ключей у потомка node.Children[i]
+// заимствованием у соседа или слиянием с ним
+func (bt *BTreeIndex) rebalance(node *BTreeNode, i int) {
+	if len(node.Children[i].Keys) >= bt.minKeys() {
+		return
+	}
+	
+	if i > 0 && len(node.Children[i-1].Keys) > bt.minKeys() {
+		bt.borrowFromLeft(node, i)
+		return
+	}
+	
+	if i < len(node.Children)-1 && len(node.Children[i+1].Keys) > bt.minKeys() {
+		bt.borrowFromRight(node, i)
+		return
+	}
+	
+	if i > 0 {
+		bt.merge(node, i-1)
 	} else {
-		// Поиск ключа в листовом узле
-		pos := 0
-		for pos < len(node.Keys) && compare(node.Keys[pos], key) < 0 {
-			pos++
-		}
-		
-		if pos < len(node.Keys) && compare(node.Keys[pos], key) == 0 {
-			// Удаляем ключ и его значения
-			copy(node.Keys[pos:], node.Keys[pos+1:])
-			copy(node.Values[pos:], node.Values[pos+1:])
-			node.Keys = node.Keys[:len(node.Keys)-1]
-			node.Values = node.Values[:len(node.Values)-1]
-			return nil
-		}
+		bt.merge(node, i)
+	}
+}
+
+// borrowFromLeft переносит ключ из левого соседа через родителя
+func (bt *BTreeIndex) borrowFromLeft(parent *BTreeNode, i int) {
+	child, left := parent.Children[i], parent.Children[i-1]
+	last := len(left.Keys) - 1
+	
+	child.Keys = insertKeyAt(child.Keys, 0, parent.Keys[i-1])
+	child.Values = insertValueAt(child.Values, 0, parent.Values[i-1])
+	parent.Keys[i-1], parent.Values[i-1] = left.Keys[last], left.Values[last]
+	left.Keys = removeKeyAt(left.Keys, last)
+	left.Values = removeValueAt(left.Values, last)
+	
+	if !left.IsLeaf {
+		lastChild := len(left.Children) - 1
+		child.Children = insertChildAt(child.Children, 0, left.Children[lastChild])
+		left.Children = removeChildAt(left.Children, lastChild)
 	}
-	return nil
+}
+
+// borrowFromRight переносит ключ из правого соседа через родителя
+func (bt *BTreeIndex) borrowFromRight(parent *BTreeNode, i int) {
+	child, right := parent.Children[i], parent.Children[i+1]
+	
+	child.Keys = append(child.Keys, parent.Keys[i])
+	child.Values = append(child.Values, parent.Values[i])
+	parent.Keys[i], parent.Values[i] = right.Keys[0], right.Values[0]
+	right.Keys = removeKeyAt(right.Keys, 0)
+	right.Values = removeValueAt(right.Values, 0)
+	
+	if !right.IsLeaf {
+		child.Children = append(child.Children, right.Children[0])
+		right.Children = removeChildAt(right.Children, 0)
+	}
+}
+
+// merge сливает потомков parent.Children[i] и parent.Children[i+1]
+// вместе с разделяющим их ключом родителя
+func (bt *BTreeIndex) merge(parent *BTreeNode, i int) {
+	left, right := parent.Children[i], parent.Children[i+1]
+	
+	left.Keys = append(left.Keys, parent.Keys[i])
+	left.Values = append(left.Values, parent.Values[i])
+	left.Keys = append(left.Keys, right.Keys...)
+	left.Values = append(left.Values, right.Values...)
+	left.Children = append(left.Children, right.Children...)
+	
+	parent.Keys = removeKeyAt(parent.Keys, i)
+	parent.Values = removeValueAt(parent.Values, i)
+	parent.Children = removeChildAt(parent.Children, i+1)
 }
 
 // Search ищет документы по полю и значению
@@ -306,26 +590,161 @@ func (bt *BTreeIndex) Search(field string, value interface{}) ([]string, error)
 
 // search находит ID документов в B-дереве
 func (bt *BTreeIndex) search(node *BTreeNode, key interface{}) []string {
-	// Реализация логики поиска B-дерева
-	// Это упрощенная версия
+	found, pos := bt.find(node, key)
+	if found == nil {
+		return []string{}
+	}
+	
+	// Возвращаем копию, чтобы вызывающий код не мог испортить узел
+	return copyIDs(found.Values[pos])
+}
+
+// Validate проверяет инварианты B-дерева: упорядоченность ключей,
+// заполненность узлов, одинаковую глубину листьев и согласованность с DocIDs
+func (bt *BTreeIndex) Validate() error {
+	leafDepth := -1
+	total, keys := 0, 0
+	if err := bt.validate(bt.Root, nil, nil, 0, &leafDepth, &total, &keys); err != nil {
+		return err
+	}
+	
+	if keys != bt.keyCount {
+		return fmt.Errorf("в дереве %d ключей, счетчик ключей равен %d", keys, bt.keyCount)
+	}
+	
+	refs := 0
+	for _, value := range bt.DocIDs {
+		refs += len(bt.docKeys(value))
+	}
+	if total != refs {
+		return fmt.Errorf("в дереве %d ссылок на документы, в DocIDs %d", total, refs)
+	}
+	
+	for id, value := range bt.DocIDs {
+		for _, key := range bt.docKeys(value) {
+			found := false
+			for _, docID := range bt.search(bt.Root, key) {
+				if docID == id {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return fmt.Errorf("документ %s не найден по своему значению %v", id, key)
+			}
+		}
+	}
 	
-	pos := 0
-	for pos < len(node.Keys) && compare(node.Keys[pos], key) < 0 {
-		pos++
+	return nil
+}
+
+// validate рекурсивно проверяет поддерево, ключи которого лежат строго между lower и upper
+func (bt *BTreeIndex) validate(node *BTreeNode, lower, upper *interface{}, depth int, leafDepth, total, keys *int) error {
+	if len(node.Keys) != len(node.Values) {
+		return fmt.Errorf("число ключей (%d) и списков документов (%d) не совпадает", len(node.Keys), len(node.Values))
 	}
 	
-	if pos < len(node.Keys) && compare(node.Keys[pos], key) == 0 {
-		return node.Values[pos]
+	if len(node.Keys) > bt.maxKeys() {
+		return fmt.Errorf("узел переполнен: %d ключей при максимуме %d", len(node.Keys), bt.maxKeys())
 	}
 	
+	if node != bt.Root && len(node.Keys) < bt.minKeys() {
+		return fmt.Errorf("узел недозаполнен: %d ключей при минимуме %d", len(node.Keys), bt.minKeys())
+	}
+	
+	for i, key := range node.Keys {
+		if i > 0 && compare(node.Keys[i-1], key) >= 0 {
+			return fmt.Errorf("ключи узла не упорядочены: %v >= %v", node.Keys[i-1], key)
+		}
+		if lower != nil && compare(*lower, key) >= 0 {
+			return fmt.Errorf("ключ %v не больше нижней границы %v", key, *lower)
+		}
+		if upper != nil && compare(key, *upper) >= 0 {
+			return fmt.Errorf("ключ %v не меньше верхней границы %v", key, *upper)
+		}
+		if len(node.Values[i]) == 0 {
+			return fmt.Errorf("ключ %v не ссылается ни на один документ", key)
+		}
+		if bt.Unique && len(node.Values[i]) > 1 {
+			return fmt.Errorf("ключ %v уникального индекса ссылается на %d документов", key, len(node.Values[i]))
+		}
+		*total += len(node.Values[i])
+	}
+	*keys += len(node.Keys)
+	
 	if node.IsLeaf {
-		return []string{}
+		if len(node.Children) != 0 {
+			return errors.New("у листа есть потомки")
+		}
+		if *leafDepth == -1 {
+			*leafDepth = depth
+		} else if *leafDepth != depth {
+			return fmt.Errorf("листья находятся на разной глубине: %d и %d", *leafDepth, depth)
+		}
+		return nil
 	}
 	
-	// Спуск в соответствующий дочерний узел
-	childPos := pos
-	if childPos >= len(node.Children) {
-		childPos = len(node.Children) - 1
+	if len(node.Children) != len(node.Keys)+1 {
+		return fmt.Errorf("у внутреннего узла %d ключей и %d потомков", len(node.Keys), len(node.Children))
 	}
-	return bt.search(node.Children[childPos], key)
+	
+	for i, child := range node.Children {
+		childLower, childUpper := lower, upper
+		if i > 0 {
+			childLower = &node.Keys[i-1]
+		}
+		if i < len(node.Keys) {
+			childUpper = &node.Keys[i]
+		}
+		if err := bt.validate(child, childLower, childUpper, depth+1, leafDepth, total, keys); err != nil {
+			return err
+		}
+	}
+	
+	return nil
+}
+
+// insertKeyAt вставляет ключ в срез на позицию pos
+func insertKeyAt(keys []interface{}, pos int, key interface{}) []interface{} {
+	keys = append(keys, nil)
+	copy(keys[pos+1:], keys[pos:])
+	keys[pos] = key
+	return keys
+}
+
+// insertValueAt вставляет список документов в срез на позицию pos
+func insertValueAt(values [][]string, pos int, ids []string) [][]string {
+	values = append(values, nil)
+	copy(values[pos+1:], values[pos:])
+	values[pos] = ids
+	return values
+}
+
+// insertChildAt вставляет потомка в срез на позицию pos
+func insertChildAt(children []*BTreeNode, pos int, child *BTreeNode) []*BTreeNode {
+	children = append(children, nil)
+	copy(children[pos+1:], children[pos:])
+	children[pos] = child
+	return children
+}
+
+// removeKeyAt удаляет ключ из среза по позиции pos
+func removeKeyAt(keys []interface{}, pos int) []interface{} {
+	copy(keys[pos:], keys[pos+1:])
+	keys[len(keys)-1] = nil
+	return keys[:len(keys)-1]
+}
+
+// removeValueAt удаляет список документов из среза по позиции pos
+func removeValueAt(values [][]string, pos int) [][]string {
+	copy(values[pos:], values[pos+1:])
+	values[len(values)-1] = nil
+	return values[:len(values)-1]
+}
+
+// removeChildAt удаляет потомка из среза по позиции pos
+func removeChildAt(children []*BTreeNode, pos int) []*BTreeNode {
+	copy(children[pos:], children[pos+1:])
+	children[len(children)-1] = nil
+	return children[:len(children)-1]
 }
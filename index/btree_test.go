@@ -0,0 +1,101 @@
+package index
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/urusofam/jsondb/storage"
+)
+
+// checkBTree сравнивает результаты поиска по индексу с полным перебором эталонной карты
+func checkBTree(t *testing.T, bt *BTreeIndex, model map[string]interface{}) {
+	t.Helper()
+	
+	want := map[interface{}][]string{}
+	for id, value := range model {
+		want[value] = append(want[value], id)
+	}
+	if bt.Len() != len(model) {
+		t.Fatalf("в индексе %d документов, ожидалось %d", bt.Len(), len(model))
+	}
+	if bt.KeyCount() != len(want) {
+		t.Fatalf("в индексе %d ключей, ожидалось %d", bt.KeyCount(), len(want))
+	}
+	
+	for value, ids := range want {
+		got, err := bt.Search(bt.Field, value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sort.Strings(got)
+		sort.Strings(ids)
+		if fmt.Sprint(got) != fmt.Sprint(ids) {
+			t.Fatalf("поиск %v: %v, ожидалось %v", value, got, ids)
+		}
+	}
+	if got, _ := bt.Search(bt.Field, -1.0); len(got) != 0 {
+		t.Fatalf("поиск отсутствующего значения вернул %v", got)
+	}
+}
+
+func TestBTreeRandomInsertDelete(t *testing.T) {
+	for _, order := range []int{3, 4, 5, 32} {
+		t.Run(fmt.Sprintf("order=%d", order), func(t *testing.T) {
+			rnd := rand.New(rand.NewSource(int64(order)))
+			bt := NewBTreeIndex("v", order)
+			model := map[string]interface{}{}
+			
+			for step := 0; step < 4000; step++ {
+				id := fmt.Sprintf("d%d", rnd.Intn(400))
+				if rnd.Intn(3) == 0 {
+					if err := bt.Remove(id); err != nil {
+						t.Fatal(err)
+					}
+					delete(model, id)
+				} else {
+					var value interface{} = float64(rnd.Intn(100))
+					switch rnd.Intn(20) {
+					case 0:
+						value = nil
+					case 1, 2:
+						value = fmt.Sprint("s", rnd.Intn(20))
+					}
+					if err := bt.Add(storage.Document{ID: id, Content: map[string]interface{}{"v": value}}); err != nil {
+						t.Fatal(err)
+					}
+					model[id] = value
+				}
+				
+				if err := bt.Validate(); err != nil {
+					t.Fatalf("шаг %d: %v", step, err)
+				}
+				if step%100 == 0 {
+					checkBTree(t, bt, model)
+				}
+			}
+			checkBTree(t, bt, model)
+			
+			// Удаление всех документов оставляет пустое корректное дерево
+			for id := range model {
+				if err := bt.Remove(id); err != nil {
+					t.Fatal(err)
+				}
+				delete(model, id)
+				if err := bt.Validate(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			checkBTree(t, bt, model)
+		})
+	}
+}
+
+func TestBTreeOrderIsClamped(t *testing.T) {
+	for _, order := range []int{-1, 0, 1, 2} {
+		if bt := NewBTreeIndex("v", order); bt.Order != MinBTreeOrder {
+			t.Fatalf("порядок %d: получен %d, ожидался %d", order, bt.Order, MinBTreeOrder)
+		}
+	}
+}
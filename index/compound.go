@@ -0,0 +1,128 @@
+package index
+
+import (
+	"strings"
+
+	"github.com/urusofam/jsondb/storage"
+)
+
+// CompoundIndex определяет индекс по нескольким полям. Ключ индекса - кортеж
+// значений полей в порядке KeyFields; кортежи сравниваются лексикографически
+type CompoundIndex interface {
+	OrderedIndex
+	
+	// KeyFields возвращает поля индекса в порядке сравнения ключей
+	KeyFields() []string
+	
+	// ScanPrefix обходит ключи, начинающиеся с prefix, у которых значение
+	// следующего поля попадает в диапазон r, пока fn возвращает true
+	ScanPrefix(prefix []interface{}, r Range, descending bool, fn func(key []interface{}, ids []string) bool)
+}
+
+// maxKey больше любого ключа индекса. Используется только в границах
+// диапазонов, чтобы захватить все кортежи с заданным началом
+type maxKey struct{}
+
+// CompoundName возвращает имя составного индекса по списку полей
+func CompoundName(fields []string) string {
+	return strings.Join(fields, ",")
+}
+
+// NewCompoundIndex создает составной индекс B-дерева по списку полей.
+// Документ индексируется, если в нем есть первое поле; отсутствующие
+// остальные поля входят в ключ как null
+func NewCompoundIndex(fields []string, order int) *BTreeIndex {
+	bt := NewBTreeIndex(CompoundName(fields), order)
+	bt.Fields = append([]string(nil), fields...)
+	bt.multiKey = false
+	return bt
+}
+
+// KeyFields возвращает поля составного индекса или nil для индекса по одному полю
+func (bt *BTreeIndex) KeyFields() []string {
+	return bt.Fields
+}
+
+// tuple возвращает ключ составного индекса для документа.
+// Второе значение равно false, если в документе нет первого поля
+func (bt *BTreeIndex) tuple(doc storage.Document) ([]interface{}, bool) {
+	key := make([]interface{}, len(bt.Fields))
+	found := false
+	
+	for i, field := range bt.Fields {
+		value, ok := storage.ResolvePath(doc.Content, field)
+		if ok {
+			key[i] = value
+		}
+		if i == 0 {
+			found = ok
+		}
+	}
+	
+	return key, found
+}
+
+// compareTuples сравнивает кортежи лексикографически; кортеж,
+// являющийся началом другого, меньше его
+func compareTuples(a, b []interface{}) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if c := compare(a[i], b[i]); c != 0 {
+			return c
+		}
+	}
+	
+	switch {
+	case len(a) < len(b):
+		return -1
+	case len(a) > len(b):
+		return 1
+	}
+	return 0
+}
+
+// ScanPrefix обходит ключи, начинающиеся с prefix, у которых значение
+// следующего поля попадает в диапазон r, по возрастанию или по убыванию
+func (bt *BTreeIndex) ScanPrefix(prefix []interface{}, r Range, descending bool, fn func(key []interface{}, ids []string) bool) {
+	pos := len(prefix)
+	
+	// Границы кортежей: [prefix, нижняя граница] и [prefix, верхняя граница, max].
+	// Ограничение одностороннего диапазона типом границы проверяется для каждого ключа
+	lower := &Bound{Value: tupleWith(prefix), Inclusive: true}
+	if r.Lower != nil {
+		if r.Lower.Inclusive {
+			lower.Value = tupleWith(prefix, r.Lower.Value)
+		} else {
+			lower = &Bound{Value: tupleWith(prefix, r.Lower.Value, maxKey{})}
+		}
+	}
+	
+	upper := &Bound{Value: tupleWith(prefix, maxKey{}), Inclusive: true}
+	if r.Upper != nil {
+		if r.Upper.Inclusive {
+			upper.Value = tupleWith(prefix, r.Upper.Value, maxKey{})
+		} else {
+			upper = &Bound{Value: tupleWith(prefix, r.Upper.Value)}
+		}
+	}
+	
+	visit := func(key interface{}, ids []string) bool {
+		tuple := key.([]interface{})
+		if pos < len(tuple) && !r.Contains(tuple[pos]) {
+			return true
+		}
+		return fn(tuple, ids)
+	}
+	
+	if descending {
+		bt.Descend(Range{Lower: lower, Upper: upper}, visit)
+	} else {
+		bt.Ascend(Range{Lower: lower, Upper: upper}, visit)
+	}
+}
+
+// tupleWith возвращает новый кортеж из prefix и значений values
+func tupleWith(prefix []interface{}, values ...interface{}) []interface{} {
+	result := make([]interface{}, 0, len(prefix)+len(values))
+	result = append(result, prefix...)
+	return append(result, values...)
+}
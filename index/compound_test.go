@@ -0,0 +1,140 @@
+package index
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/urusofam/jsondb/storage"
+)
+
+// compoundTree строит составной индекс по полям a, b, c и эталонную карту
+// кортежей проиндексированных документов
+func compoundTree(t *testing.T, order int) (*BTreeIndex, map[string][]interface{}) {
+	t.Helper()
+	
+	rnd := rand.New(rand.NewSource(int64(order)))
+	bt := NewCompoundIndex([]string{"a", "b", "c"}, order)
+	model := map[string][]interface{}{}
+	for i := 0; i < 500; i++ {
+		content := map[string]interface{}{}
+		if rnd.Intn(15) > 0 {
+			content["a"] = float64(rnd.Intn(4))
+		}
+		switch rnd.Intn(8) {
+		case 0:
+		case 1:
+			content["b"] = nil
+		case 2:
+			content["b"] = fmt.Sprint("s", rnd.Intn(5))
+		default:
+			content["b"] = float64(rnd.Intn(30))
+		}
+		if rnd.Intn(4) > 0 {
+			content["c"] = float64(rnd.Intn(10))
+		}
+		
+		id := fmt.Sprintf("d%03d", i)
+		if err := bt.Add(storage.Document{ID: id, Content: content}); err != nil {
+			t.Fatal(err)
+		}
+		if a, ok := content["a"]; ok {
+			model[id] = []interface{}{a, content["b"], content["c"]}
+		}
+	}
+	
+	for i := 0; i < 500; i += 4 {
+		id := fmt.Sprintf("d%03d", i)
+		if err := bt.Remove(id); err != nil {
+			t.Fatal(err)
+		}
+		delete(model, id)
+	}
+	if err := bt.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	return bt, model
+}
+
+func TestCompoundScanMatchesFullScan(t *testing.T) {
+	for _, order := range []int{3, 4, 32} {
+		bt, model := compoundTree(t, order)
+		for _, c := range []struct {
+			prefix []interface{}
+			r      Range
+		}{
+			{nil, Range{}},
+			{nil, Between(1.0, 2.0)},
+			{[]interface{}{1.0}, Range{}},
+			{[]interface{}{1.0}, GreaterThan(10.0)},
+			{[]interface{}{2.0}, Between(5.0, 15.0)},
+			{[]interface{}{3.0}, Prefix("s")},
+			{[]interface{}{0.0}, NotNull()},
+			{[]interface{}{0.0}, LessThan(3.0)},
+			{[]interface{}{1.0, 7.0}, Range{}},
+			{[]interface{}{2.0, 12.0}, AtLeast(4.0)},
+			{[]interface{}{1.0, nil}, AtMost(5.0)},
+			{[]interface{}{9.0}, Range{}},
+		} {
+			var want []string
+			for id, tuple := range model {
+				if compareTuples(tuple[:len(c.prefix)], c.prefix) == 0 && c.r.Contains(tuple[len(c.prefix)]) {
+					want = append(want, id)
+				}
+			}
+			sort.Strings(want)
+			
+			for _, desc := range []bool{false, true} {
+				var got []string
+				var prev []interface{}
+				bt.ScanPrefix(c.prefix, c.r, desc, func(key []interface{}, ids []string) bool {
+					if prev != nil && (compareTuples(prev, key) < 0) == desc {
+						t.Fatalf("порядок %d: ключ %v после %v", order, key, prev)
+					}
+					prev = key
+					got = append(got, ids...)
+					return true
+				})
+				sort.Strings(got)
+				if fmt.Sprint(got) != fmt.Sprint(want) {
+					t.Fatalf("порядок %d, начало %v, диапазон %v, по убыванию %v: %d документов, полный перебор дает %d",
+						order, c.prefix, c.r, desc, len(got), len(want))
+				}
+			}
+		}
+	}
+}
+
+func TestCompoundIndexKeys(t *testing.T) {
+	bt := NewCompoundIndex([]string{"a", "b"}, 4)
+	if got := bt.KeyFields(); fmt.Sprint(got) != "[a b]" || bt.Field != "a,b" {
+		t.Fatalf("поля индекса %v, имя %s", got, bt.Field)
+	}
+	if NewBTreeIndex("a", 4).KeyFields() != nil {
+		t.Fatal("у индекса по одному полю есть поля составного ключа")
+	}
+	
+	docs := []storage.Document{
+		{ID: "1", Content: map[string]interface{}{"a": 1.0, "b": 2.0}},
+		{ID: "2", Content: map[string]interface{}{"a": 1.0}},
+		{ID: "3", Content: map[string]interface{}{"b": 2.0}},
+		{ID: "4", Content: map[string]interface{}{"a": 1.0, "b": []interface{}{1.0, 2.0}}},
+	}
+	for _, doc := range docs {
+		if err := bt.Add(doc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	
+	// Документ без первого поля не индексируется, отсутствующее второе поле входит в ключ как null,
+	// массив входит в ключ целиком
+	var keys []string
+	bt.ScanPrefix(nil, Range{}, false, func(key []interface{}, ids []string) bool {
+		keys = append(keys, fmt.Sprint(key, ids))
+		return true
+	})
+	if got := fmt.Sprint(keys); got != "[[1 <nil>] [2] [1 2] [1] [1 [1 2]] [4]]" {
+		t.Fatalf("ключи индекса: %s", got)
+	}
+}
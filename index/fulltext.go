@@ -0,0 +1,183 @@
+package index
+
+import (
+	"errors"
+	"math"
+	"sort"
+
+	"github.com/urusofam/jsondb/storage"
+)
+
+// Параметры ранжирования BM25
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// FullTextIndex реализует инвертированный индекс для полнотекстового поиска.
+// Текст поля (строка или массив строк) разбивается на термы функцией Analyze;
+// для каждого терма хранится список документов с частотой терма в них
+type FullTextIndex struct {
+	Field    string
+	Postings map[string]map[string]int // Терм -> ID документа -> частота терма
+	Lengths  map[string]int            // Сопоставляет ID документов с числом термов
+	
+	terms       map[string][]string // Различные термы документа, для удаления
+	totalLength int                 // Суммарное число термов во всех документах
+}
+
+// NewFullTextIndex создает новый полнотекстовый индекс
+func NewFullTextIndex(field string) *FullTextIndex {
+	return &FullTextIndex{
+		Field:    field,
+		Postings: make(map[string]map[string]int),
+		Lengths:  make(map[string]int),
+		terms:    make(map[string][]string),
+	}
+}
+
+// Len возвращает количество проиндексированных документов
+func (ft *FullTextIndex) Len() int {
+	return len(ft.Lengths)
+}
+
+// KeyCount возвращает количество различных термов в индексе
+func (ft *FullTextIndex) KeyCount() int {
+	return len(ft.Postings)
+}
+
+// Add добавляет документ в индекс, заменяя его предыдущую версию
+func (ft *FullTextIndex) Add(doc storage.Document) error {
+	if err := ft.Remove(doc.ID); err != nil {
+		return err
+	}
+	
+	terms := make([]string, 0)
+	for _, value := range storage.ResolveAll(doc.Content, ft.Field) {
+		terms = appendTerms(terms, value)
+	}
+	if len(terms) == 0 {
+		return nil
+	}
+	
+	distinct := make([]string, 0)
+	for _, term := range terms {
+		postings, ok := ft.Postings[term]
+		if !ok {
+			postings = make(map[string]int)
+			ft.Postings[term] = postings
+		}
+		if postings[doc.ID] == 0 {
+			distinct = append(distinct, term)
+		}
+		postings[doc.ID]++
+	}
+	
+	ft.Lengths[doc.ID] = len(terms)
+	ft.terms[doc.ID] = distinct
+	ft.totalLength += len(terms)
+	return nil
+}
+
+// appendTerms добавляет термы строки или массива строк
+func appendTerms(terms []string, value interface{}) []string {
+	switch v := value.(type) {
+	case string:
+		return append(terms, Analyze(v)...)
+	case []interface{}:
+		for _, item := range v {
+			terms = appendTerms(terms, item)
+		}
+	}
+	return terms
+}
+
+// Remove удаляет документ из индекса
+func (ft *FullTextIndex) Remove(id string) error {
+	length, ok := ft.Lengths[id]
+	if !ok {
+		return nil // Документ не проиндексирован
+	}
+	
+	for _, term := range ft.terms[id] {
+		postings := ft.Postings[term]
+		delete(postings, id)
+		if len(postings) == 0 {
+			delete(ft.Postings, term)
+		}
+	}
+	
+	delete(ft.Lengths, id)
+	delete(ft.terms, id)
+	ft.totalLength -= length
+	return nil
+}
+
+// Search ищет документы, содержащие хотя бы один терм запроса value.
+// Результат упорядочен по убыванию релевантности
+func (ft *FullTextIndex) Search(field string, value interface{}) ([]string, error) {
+	if field != ft.Field {
+		return nil, errors.New("несоответствие поля индекса")
+	}
+	
+	text, ok := value.(string)
+	if !ok {
+		return nil, errors.New("полнотекстовый запрос должен быть строкой")
+	}
+	
+	scores := ft.Score(text)
+	ids := make([]string, 0, len(scores))
+	for id := range scores {
+		ids = append(ids, id)
+	}
+	
+	sort.Slice(ids, func(i, j int) bool {
+		if scores[ids[i]] != scores[ids[j]] {
+			return scores[ids[i]] > scores[ids[j]]
+		}
+		return ids[i] < ids[j]
+	})
+	return ids, nil
+}
+
+// Score вычисляет релевантность BM25 документов, содержащих хотя бы один терм запроса
+func (ft *FullTextIndex) Score(query string) map[string]float64 {
+	scores := make(map[string]float64)
+	if len(ft.Lengths) == 0 {
+		return scores
+	}
+	
+	n := float64(len(ft.Lengths))
+	avgLength := float64(ft.totalLength) / n
+	
+	seen := make(map[string]bool)
+	for _, term := range Analyze(query) {
+		if seen[term] {
+			continue
+		}
+		seen[term] = true
+		
+		postings := ft.Postings[term]
+		df := float64(len(postings))
+		idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+		
+		for id, tf := range postings {
+			norm := 1 - bm25B + bm25B*float64(ft.Lengths[id])/avgLength
+			scores[id] += idf * float64(tf) * (bm25K1 + 1) / (float64(tf) + bm25K1*norm)
+		}
+	}
+	
+	return scores
+}
+
+// Estimate оценивает число документов, содержащих хотя бы один терм запроса
+func (ft *FullTextIndex) Estimate(query string) int {
+	result := 0
+	for _, term := range Analyze(query) {
+		result += len(ft.Postings[term])
+	}
+	if result > len(ft.Lengths) {
+		result = len(ft.Lengths)
+	}
+	return result
+}
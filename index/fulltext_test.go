@@ -0,0 +1,174 @@
+package index
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/urusofam/jsondb/storage"
+)
+
+func TestStemEnglish(t *testing.T) {
+	for word, want := range map[string]string{
+		"caresses": "caress", "ponies": "poni", "cats": "cat", "feed": "feed", "agreed": "agre",
+		"plastered": "plaster", "motoring": "motor", "sing": "sing", "conflated": "conflat",
+		"hopping": "hop", "filing": "file", "happy": "happi", "relational": "relat",
+		"conditional": "condit", "generalization": "gener", "hopefulness": "hope",
+		"electrical": "electr", "adjustment": "adjust", "adoption": "adopt", "controlling": "control",
+		"running": "run", "runs": "run", "databases": "databas", "searching": "search",
+	} {
+		if got := stemEnglish(word); got != want {
+			t.Errorf("%s: основа %s, ожидалась %s", word, got, want)
+		}
+	}
+}
+
+func TestStemRussian(t *testing.T) {
+	for word, want := range map[string]string{
+		"вазы": "ваз", "вазе": "ваз", "красивая": "красив", "красивые": "красив",
+		"бегающий": "бега", "книги": "книг", "книгами": "книг", "думающая": "дума",
+		"читали": "чита", "прекраснейший": "прекрасн", "радость": "радост",
+		"каменный": "камен", "поисковых": "поисков", "поиск": "поиск", "базы": "баз", "данных": "дан",
+		"бывшие": "бывш",
+	} {
+		if got := stemRussian(word); got != want {
+			t.Errorf("%s: основа %s, ожидалась %s", word, got, want)
+		}
+	}
+}
+
+func TestAnalyze(t *testing.T) {
+	for text, want := range map[string]string{
+		"The quick, brown FOXES!":       "[quick brown fox]",
+		"Базы данных и ёлки":            "[баз дан елк]",
+		"go2 и the and":                 "[go2]",
+		"":                              "[]",
+		"e-mail: user@example.com 2024": "[e mail user exampl com 2024]",
+	} {
+		if got := fmt.Sprint(Analyze(text)); got != want {
+			t.Errorf("%q: термы %s, ожидались %s", text, got, want)
+		}
+	}
+}
+
+// fullTextWords - словарь случайных текстов для сравнения индекса с перебором
+var fullTextWords = []string{
+	"fox", "foxes", "running", "runs", "database", "databases", "quick", "the", "and",
+	"база", "базы", "данных", "поиск", "поиска", "кошки", "и", "собака", "собаки",
+}
+
+func TestFullTextSearchMatchesFullScan(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	ft := NewFullTextIndex("body")
+	model := map[string]interface{}{}
+	text := func() string {
+		words := make([]string, rnd.Intn(6))
+		for i := range words {
+			words[i] = fullTextWords[rnd.Intn(len(fullTextWords))]
+		}
+		return strings.Join(words, " ")
+	}
+	
+	for i := 0; i < 300; i++ {
+		id := fmt.Sprint("d", rnd.Intn(150))
+		var body interface{} = text()
+		switch rnd.Intn(5) {
+		case 0:
+			body = []interface{}{text(), 1.0, text()}
+		case 1:
+			body = 42.0
+		}
+		doc := storage.Document{ID: id, Content: map[string]interface{}{"body": body}}
+		if rnd.Intn(6) == 0 {
+			if err := ft.Remove(id); err != nil {
+				t.Fatal(err)
+			}
+			delete(model, id)
+			continue
+		}
+		if err := ft.Add(doc); err != nil {
+			t.Fatal(err)
+		}
+		model[id] = body
+	}
+	
+	total := 0
+	for id, body := range model {
+		terms := appendTerms(nil, body)
+		total += len(terms)
+		if len(terms) > 0 && ft.Lengths[id] != len(terms) {
+			t.Fatalf("%s: длина %d, ожидалась %d", id, ft.Lengths[id], len(terms))
+		}
+	}
+	if ft.totalLength != total {
+		t.Fatalf("суммарная длина %d, ожидалась %d", ft.totalLength, total)
+	}
+	
+	for _, q := range []string{"fox", "running database", "базы данных", "the and", "собак кошка", "nothing"} {
+		queryTerms := makeSet(Analyze(q)...)
+		var want []string
+		for id, body := range model {
+			for _, term := range appendTerms(nil, body) {
+				if queryTerms[term] {
+					want = append(want, id)
+					break
+				}
+			}
+		}
+		sort.Strings(want)
+		
+		got, err := ft.Search("body", q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		scores := ft.Score(q)
+		for i := 1; i < len(got); i++ {
+			if scores[got[i-1]] < scores[got[i]] {
+				t.Fatalf("%s: документ %s выше более релевантного %s", q, got[i-1], got[i])
+			}
+		}
+		sort.Strings(got)
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Fatalf("%s: %v, перебор дает %v", q, got, want)
+		}
+		if estimate := ft.Estimate(q); estimate < len(want) || estimate > ft.Len() {
+			t.Fatalf("%s: оценка %d при %d документах", q, estimate, len(want))
+		}
+	}
+}
+
+func TestFullTextRanking(t *testing.T) {
+	ft := NewFullTextIndex("body")
+	for id, text := range map[string]string{
+		"1": "The quick brown fox jumps",
+		"2": "Foxes are running fast, fox fox",
+		"3": "Базы данных и поиск",
+		"4": "Полнотекстовый поиск в базе данных",
+	} {
+		ft.Add(storage.Document{ID: id, Content: map[string]interface{}{"body": text}})
+	}
+	
+	if ids, _ := ft.Search("body", "fox"); fmt.Sprint(ids) != "[2 1]" {
+		t.Fatalf("fox: %v, релевантность %v", ids, ft.Score("fox"))
+	}
+	if ids, _ := ft.Search("body", "база данных"); len(ids) != 2 {
+		t.Fatalf("база данных: %v", ids)
+	}
+	if _, err := ft.Search("body", 1.0); err == nil {
+		t.Fatal("нестроковый запрос принят")
+	}
+	if _, err := ft.Search("title", "fox"); err == nil {
+		t.Fatal("поиск по чужому полю принят")
+	}
+	
+	ft.Remove("2")
+	ft.Add(storage.Document{ID: "3", Content: map[string]interface{}{"body": []interface{}{"fox", "lazy dog"}}})
+	if ids, _ := ft.Search("body", "FOX"); fmt.Sprint(ids) != "[1 3]" && fmt.Sprint(ids) != "[3 1]" || ft.Len() != 3 {
+		t.Fatalf("после изменений: %v, документов %d", ids, ft.Len())
+	}
+	if postings := ft.Postings["баз"]; len(postings) != 1 {
+		t.Fatalf("терм баз: %v", postings)
+	}
+}
@@ -0,0 +1,301 @@
+package index
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"unsafe"
+
+	"github.com/urusofam/jsondb/storage"
+)
+
+// Параметры размера хеш-таблицы
+const (
+	// minHashBuckets - начальное и минимальное число корзин
+	minHashBuckets = 16
+	// maxHashLoad - доля заполнения, при превышении которой таблица увеличивается вдвое
+	maxHashLoad = 0.75
+	// minHashLoad - доля заполнения, ниже которой таблица уменьшается вдвое
+	minHashLoad = 0.125
+)
+
+// hashEntry - ключ хеш-таблицы с ID документов; записи одной корзины образуют цепочку
+type hashEntry struct {
+	key  interface{}
+	hash uint64
+	ids  []string
+	next *hashEntry
+}
+
+// HashIndex реализует интерфейс Index хеш-таблицей с цепочками.
+// Поддерживает только поиск на равенство, зато за O(1) в среднем.
+// Ключи сравниваются так же, как в B-дереве: 1 и 1.0 - один ключ.
+// Таблица удваивается при заполнении больше 3/4 и уменьшается вдвое
+// при заполнении меньше 1/8
+type HashIndex struct {
+	Field  string
+	DocIDs map[string]interface{} // Сопоставляет ID документов со значениями полей (для пути с [*] - со списками значений)
+	
+	buckets  []*hashEntry
+	keyCount int  // Количество различных ключей в таблице
+	multiKey bool // Путь содержит [*]
+}
+
+// NewHashIndex создает новый хеш-индекс
+func NewHashIndex(field string) *HashIndex {
+	return &HashIndex{
+		Field:    field,
+		DocIDs:   make(map[string]interface{}),
+		buckets:  make([]*hashEntry, minHashBuckets),
+		multiKey: storage.HasWildcard(field),
+	}
+}
+
+// Len возвращает количество проиндексированных документов
+func (h *HashIndex) Len() int {
+	return len(h.DocIDs)
+}
+
+// KeyCount возвращает количество различных значений в индексе
+func (h *HashIndex) KeyCount() int {
+	return h.keyCount
+}
+
+// Buckets возвращает текущее число корзин таблицы
+func (h *HashIndex) Buckets() int {
+	return len(h.buckets)
+}
+
+// MemoryUsage оценивает объем памяти в байтах, занимаемый индексом:
+// корзины, записи ключей, списки ID и таблицу DocIDs
+func (h *HashIndex) MemoryUsage() int {
+	const (
+		pointerSize = int(unsafe.Sizeof(uintptr(0)))
+		stringSize  = int(unsafe.Sizeof(""))
+		entrySize   = int(unsafe.Sizeof(hashEntry{}))
+		valueSize   = int(unsafe.Sizeof(interface{}(nil)))
+	)
+	
+	total := len(h.buckets) * pointerSize
+	for _, entry := range h.buckets {
+		for ; entry != nil; entry = entry.next {
+			total += entrySize + valueMemory(entry.key) + cap(entry.ids)*stringSize
+			for _, id := range entry.ids {
+				total += len(id)
+			}
+		}
+	}
+	
+	for id, value := range h.DocIDs {
+		total += stringSize + len(id) + valueSize
+		if h.multiKey {
+			total += cap(value.([]interface{})) * valueSize
+		}
+	}
+	return total
+}
+
+// valueMemory оценивает память, занимаемую данными значения вне интерфейса
+func valueMemory(v interface{}) int {
+	switch val := v.(type) {
+	case string:
+		return len(val)
+	case []interface{}:
+		total := cap(val) * int(unsafe.Sizeof(interface{}(nil)))
+		for _, item := range val {
+			total += valueMemory(item)
+		}
+		return total
+	case int, int64, float64:
+		return 8
+	}
+	return 0
+}
+
+// Add добавляет документ в индекс, заменяя его предыдущую версию
+func (h *HashIndex) Add(doc storage.Document) error {
+	if err := h.Remove(doc.ID); err != nil {
+		return err
+	}
+	
+	if !h.multiKey {
+		value, ok := storage.ResolvePath(doc.Content, h.Field)
+		if !ok {
+			return nil
+		}
+		h.insert(value, doc.ID)
+		h.DocIDs[doc.ID] = value
+		return nil
+	}
+	
+	// Каждое различное значение элементов массива становится отдельным ключом
+	keys := make([]interface{}, 0)
+	for _, value := range storage.ResolveAll(doc.Content, h.Field) {
+		duplicate := false
+		for _, key := range keys {
+			if compare(key, value) == 0 {
+				duplicate = true
+				break
+			}
+		}
+		if !duplicate {
+			h.insert(value, doc.ID)
+			keys = append(keys, value)
+		}
+	}
+	if len(keys) > 0 {
+		h.DocIDs[doc.ID] = keys
+	}
+	return nil
+}
+
+// insert добавляет ID документа к ключу, создавая ключ при необходимости
+func (h *HashIndex) insert(key interface{}, id string) {
+	if entry := h.lookup(key); entry != nil {
+		entry.ids = append(entry.ids, id)
+		return
+	}
+	
+	sum := hashKey(key)
+	i := sum % uint64(len(h.buckets))
+	h.buckets[i] = &hashEntry{key: key, hash: sum, ids: []string{id}, next: h.buckets[i]}
+	h.keyCount++
+	
+	if float64(h.keyCount) > maxHashLoad*float64(len(h.buckets)) {
+		h.resize(len(h.buckets) * 2)
+	}
+}
+
+// lookup находит запись ключа или возвращает nil
+func (h *HashIndex) lookup(key interface{}) *hashEntry {
+	sum := hashKey(key)
+	for entry := h.buckets[sum%uint64(len(h.buckets))]; entry != nil; entry = entry.next {
+		if entry.hash == sum && compare(entry.key, key) == 0 {
+			return entry
+		}
+	}
+	return nil
+}
+
+// resize перераспределяет записи по новому числу корзин
+func (h *HashIndex) resize(size int) {
+	buckets := make([]*hashEntry, size)
+	for _, entry := range h.buckets {
+		for entry != nil {
+			next := entry.next
+			i := entry.hash % uint64(size)
+			entry.next = buckets[i]
+			buckets[i] = entry
+			entry = next
+		}
+	}
+	h.buckets = buckets
+}
+
+// Remove удаляет документ из индекса
+func (h *HashIndex) Remove(id string) error {
+	value, ok := h.DocIDs[id]
+	if !ok {
+		return nil // Документ не проиндексирован
+	}
+	
+	delete(h.DocIDs, id)
+	
+	keys := []interface{}{value}
+	if h.multiKey {
+		keys = value.([]interface{})
+	}
+	for _, key := range keys {
+		if err := h.removeID(key, id); err != nil {
+			return err
+		}
+	}
+	
+	if len(h.buckets) > minHashBuckets && float64(h.keyCount) < minHashLoad*float64(len(h.buckets)) {
+		h.resize(len(h.buckets) / 2)
+	}
+	return nil
+}
+
+// removeID удаляет ID документа из списка ключа, удаляя опустевший ключ из таблицы
+func (h *HashIndex) removeID(key interface{}, id string) error {
+	sum := hashKey(key)
+	link := &h.buckets[sum%uint64(len(h.buckets))]
+	for entry := *link; entry != nil; link, entry = &entry.next, entry.next {
+		if entry.hash != sum || compare(entry.key, key) != 0 {
+			continue
+		}
+		
+		for i, other := range entry.ids {
+			if other == id {
+				entry.ids = append(entry.ids[:i], entry.ids[i+1:]...)
+				break
+			}
+		}
+		if len(entry.ids) == 0 {
+			*link = entry.next
+			h.keyCount--
+		}
+		return nil
+	}
+	
+	return fmt.Errorf("ключ %s не найден в хеш-индексе", formatKey(key))
+}
+
+// Search ищет документы по полю и значению
+func (h *HashIndex) Search(field string, value interface{}) ([]string, error) {
+	if field != h.Field {
+		return nil, errors.New("несоответствие поля индекса")
+	}
+	
+	entry := h.lookup(value)
+	if entry == nil {
+		return []string{}, nil
+	}
+	
+	// Возвращаем копию, чтобы вызывающий код не мог испортить запись
+	return copyIDs(entry.ids), nil
+}
+
+// hashKey вычисляет хеш значения, согласованный с compare:
+// равные значения разных числовых типов имеют одинаковый хеш
+func hashKey(v interface{}) uint64 {
+	h := fnv.New64a()
+	writeHashKey(h, v)
+	return h.Sum64()
+}
+
+// writeHashKey записывает в хеш ранг типа и каноническое представление значения
+func writeHashKey(h io.Writer, v interface{}) {
+	rank := typeRank(v)
+	h.Write([]byte{byte(rank)})
+	
+	switch val := v.(type) {
+	case string:
+		h.Write([]byte(val))
+	case bool:
+		if val {
+			h.Write([]byte{1})
+		}
+	case []interface{}:
+		for _, item := range val {
+			writeHashKey(h, item)
+		}
+	case nil:
+	default:
+		if rank == rankNumber {
+			f, _ := toFloat(v)
+			if f == 0 {
+				f = 0 // -0 и 0 равны
+			}
+			var buf [8]byte
+			binary.LittleEndian.PutUint64(buf[:], math.Float64bits(f))
+			h.Write(buf[:])
+			return
+		}
+		h.Write([]byte(fmt.Sprint(v)))
+	}
+}
@@ -0,0 +1,97 @@
+package index
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/urusofam/jsondb/storage"
+)
+
+func TestHashIndexMatchesBTree(t *testing.T) {
+	rnd := rand.New(rand.NewSource(3))
+	h := NewHashIndex("k")
+	bt := NewBTreeIndex("k", 4)
+	values := []interface{}{1, 1.0, 2.5, "a", "b", true, false, nil, []interface{}{1, "x"}, 0.0, int64(7), float32(2.5)}
+	
+	// Хеш-индекс и B-дерево с одинаковой историей изменений находят одни и те же документы
+	for i := 0; i < 3000; i++ {
+		id := fmt.Sprint(rnd.Intn(500))
+		if rnd.Intn(4) == 0 {
+			h.Remove(id)
+			bt.Remove(id)
+			continue
+		}
+		var value interface{} = float64(rnd.Intn(1000))
+		if rnd.Intn(2) == 0 {
+			value = values[rnd.Intn(len(values))]
+		}
+		doc := storage.Document{ID: id, Content: map[string]interface{}{"k": value}}
+		if err := h.Add(doc); err != nil {
+			t.Fatal(err)
+		}
+		if err := bt.Add(doc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if h.Len() != bt.Len() || h.KeyCount() != bt.KeyCount() {
+		t.Fatalf("документов %d и %d, ключей %d и %d", h.Len(), bt.Len(), h.KeyCount(), bt.KeyCount())
+	}
+	
+	probes := append(values, 5, 5.0, 999.0, "zz", -0.0, uint8(7))
+	for i := 0; i < 1000; i++ {
+		probes = append(probes, i)
+	}
+	for _, value := range probes {
+		got, _ := h.Search("k", value)
+		want, _ := bt.Search("k", value)
+		sort.Strings(got)
+		sort.Strings(want)
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Fatalf("%#v: %v, B-дерево дает %v", value, got, want)
+		}
+	}
+	if _, err := h.Search("x", 1.0); err == nil {
+		t.Fatal("поиск по чужому полю принят")
+	}
+}
+
+func TestHashIndexResize(t *testing.T) {
+	h := NewHashIndex("k")
+	for i := 0; i < 500; i++ {
+		h.Add(storage.Document{ID: fmt.Sprint(i), Content: map[string]interface{}{"k": float64(i)}})
+	}
+	if h.Buckets() < 16 || float64(h.KeyCount()) > 0.75*float64(h.Buckets()) {
+		t.Fatalf("корзин %d при %d ключах", h.Buckets(), h.KeyCount())
+	}
+	
+	// Таблица уменьшается до начального размера после удаления всех ключей
+	buckets, memory := h.Buckets(), h.MemoryUsage()
+	for i := 0; i < 500; i++ {
+		if err := h.Remove(fmt.Sprint(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if h.Len() != 0 || h.KeyCount() != 0 || h.Buckets() != 16 || buckets <= 16 || h.MemoryUsage() >= memory {
+		t.Fatalf("после удаления: документов %d, ключей %d, корзин %d (было %d), память %d (было %d)",
+			h.Len(), h.KeyCount(), h.Buckets(), buckets, h.MemoryUsage(), memory)
+	}
+}
+
+func TestHashIndexMultiKey(t *testing.T) {
+	h := NewHashIndex("t[*]")
+	h.Add(storage.Document{ID: "1", Content: map[string]interface{}{"t": []interface{}{"a", "b", "a"}}})
+	h.Add(storage.Document{ID: "2", Content: map[string]interface{}{"t": []interface{}{"b"}}})
+	if ids, _ := h.Search("t[*]", "b"); len(ids) != 2 || h.KeyCount() != 2 {
+		t.Fatalf("b: %v, ключей %d", ids, h.KeyCount())
+	}
+	if ids, _ := h.Search("t[*]", "a"); fmt.Sprint(ids) != "[1]" {
+		t.Fatalf("повторное значение массива проиндексировано дважды: %v", ids)
+	}
+	
+	h.Remove("1")
+	if ids, _ := h.Search("t[*]", "a"); len(ids) != 0 || h.KeyCount() != 1 {
+		t.Fatalf("после удаления: %v, ключей %d", ids, h.KeyCount())
+	}
+}
@@ -0,0 +1,109 @@
+package index
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/urusofam/jsondb/storage"
+)
+
+// btreeFile описывает сохраненное на диск B-дерево
+type btreeFile struct {
+	Field    string     `json:"field"`
+	Fields   []string   `json:"fields,omitempty"`
+	Order    int        `json:"order"`
+	Unique   bool       `json:"unique,omitempty"`
+	Sparse   bool       `json:"sparse,omitempty"`
+	Checksum string     `json:"checksum"`
+	Root     *BTreeNode `json:"root"`
+}
+
+// Save записывает B-дерево вместе с контрольной суммой данных коллекции,
+// по которой оно построено. По ней при загрузке определяется, не устарел ли индекс
+func (bt *BTreeIndex) Save(w io.Writer, checksum string) error {
+	return json.NewEncoder(w).Encode(btreeFile{
+		Field:    bt.Field,
+		Fields:   bt.Fields,
+		Order:    bt.Order,
+		Unique:   bt.Unique,
+		Sparse:   bt.Sparse,
+		Checksum: checksum,
+		Root:     bt.Root,
+	})
+}
+
+// LoadBTreeIndex читает B-дерево, записанное Save, и возвращает его вместе
+// с сохраненной контрольной суммой данных. Структура дерева проверяется
+func LoadBTreeIndex(r io.Reader) (*BTreeIndex, string, error) {
+	var file btreeFile
+	if err := json.NewDecoder(r).Decode(&file); err != nil {
+		return nil, "", fmt.Errorf("чтение индекса: %w", err)
+	}
+	if file.Root == nil {
+		return nil, "", fmt.Errorf("индекс по полю %s не содержит дерева", file.Field)
+	}
+	
+	var bt *BTreeIndex
+	if len(file.Fields) > 0 {
+		if file.Field != CompoundName(file.Fields) {
+			return nil, "", fmt.Errorf("имя составного индекса %s не соответствует полям %v", file.Field, file.Fields)
+		}
+		for _, field := range file.Fields {
+			if _, err := storage.ParsePath(field); err != nil {
+				return nil, "", err
+			}
+		}
+		bt = NewCompoundIndex(file.Fields, file.Order)
+	} else {
+		if _, err := storage.ParsePath(file.Field); err != nil {
+			return nil, "", err
+		}
+		bt = NewBTreeIndex(file.Field, file.Order)
+	}
+	bt.Unique = file.Unique
+	bt.Sparse = file.Sparse
+	bt.Root = file.Root
+	
+	err := bt.restore(bt.Root)
+	if err == nil {
+		err = bt.Validate()
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("индекс по полю %s поврежден: %w", file.Field, err)
+	}
+	
+	return bt, file.Checksum, nil
+}
+
+// restore восстанавливает DocIDs и счетчик ключей по узлам дерева
+func (bt *BTreeIndex) restore(node *BTreeNode) error {
+	if node == nil {
+		return errors.New("отсутствует узел дерева")
+	}
+	if len(node.Keys) != len(node.Values) {
+		return fmt.Errorf("число ключей (%d) и списков документов (%d) не совпадает", len(node.Keys), len(node.Values))
+	}
+	
+	for i, key := range node.Keys {
+		bt.keyCount++
+		for _, id := range node.Values[i] {
+			if !bt.multiKey {
+				bt.DocIDs[id] = key
+				continue
+			}
+			
+			keys, _ := bt.DocIDs[id].([]interface{})
+			bt.DocIDs[id] = append(keys, key)
+		}
+	}
+	
+	for _, child := range node.Children {
+		if err := bt.restore(child); err != nil {
+			return err
+		}
+	}
+	
+	return nil
+}
@@ -0,0 +1,260 @@
+package index
+
+import (
+	"errors"
+	"math"
+	"sort"
+)
+
+// OrderedIndex определяет индекс, поддерживающий поиск по диапазону и упорядоченный обход
+type OrderedIndex interface {
+	Index
+	
+	// SearchRange ищет документы, значения поля которых попадают в диапазон
+	SearchRange(field string, r Range) ([]string, error)
+	
+	// Ascend обходит ключи диапазона по возрастанию, пока fn возвращает true
+	Ascend(r Range, fn func(key interface{}, ids []string) bool)
+	
+	// Descend обходит ключи диапазона по убыванию, пока fn возвращает true
+	Descend(r Range, fn func(key interface{}, ids []string) bool)
+}
+
+// Bound задает границу диапазона
+type Bound struct {
+	Value     interface{}
+	Inclusive bool
+}
+
+// Range задает диапазон значений ключа.
+// Отсутствующая граница означает отсутствие ограничения с этой стороны.
+// Односторонний диапазон ограничен типом значения границы: "> 25"
+// не включает строки и логические значения. Исключение - нижняя граница
+// NULL: диапазон NotNull содержит значения всех типов, кроме NULL
+type Range struct {
+	Lower *Bound
+	Upper *Bound
+}
+
+// GreaterThan возвращает диапазон значений больше v
+func GreaterThan(v interface{}) Range {
+	return Range{Lower: &Bound{Value: v}}
+}
+
+// AtLeast возвращает диапазон значений больше или равных v
+func AtLeast(v interface{}) Range {
+	return Range{Lower: &Bound{Value: v, Inclusive: true}}
+}
+
+// LessThan возвращает диапазон значений меньше v
+func LessThan(v interface{}) Range {
+	return Range{Upper: &Bound{Value: v}}
+}
+
+// AtMost возвращает диапазон значений меньше или равных v
+func AtMost(v interface{}) Range {
+	return Range{Upper: &Bound{Value: v, Inclusive: true}}
+}
+
+// NotNull возвращает диапазон всех значений, кроме NULL
+func NotNull() Range {
+	return Range{Lower: &Bound{Value: nil}}
+}
+
+// Between возвращает диапазон значений от low до high включительно
+func Between(low, high interface{}) Range {
+	return Range{
+		Lower: &Bound{Value: low, Inclusive: true},
+		Upper: &Bound{Value: high, Inclusive: true},
+	}
+}
+
+// Prefix возвращает диапазон строк, начинающихся с prefix
+func Prefix(prefix string) Range {
+	r := Range{Lower: &Bound{Value: prefix, Inclusive: true}}
+	
+	// Верхняя граница - наименьшая строка, большая всех строк с этим префиксом
+	upper := []byte(prefix)
+	for len(upper) > 0 && upper[len(upper)-1] == 0xff {
+		upper = upper[:len(upper)-1]
+	}
+	if len(upper) > 0 {
+		upper[len(upper)-1]++
+		r.Upper = &Bound{Value: string(upper)}
+	}
+	
+	return r
+}
+
+// belowLower проверяет, лежит ли ключ ниже нижней границы диапазона
+func (r Range) belowLower(key interface{}) bool {
+	if r.Lower == nil {
+		return r.Upper != nil && typeRank(key) < typeRank(r.Upper.Value)
+	}
+	
+	c := compare(key, r.Lower.Value)
+	return c < 0 || (c == 0 && !r.Lower.Inclusive)
+}
+
+// aboveUpper проверяет, лежит ли ключ выше верхней границы диапазона
+func (r Range) aboveUpper(key interface{}) bool {
+	if r.Upper == nil {
+		return r.Lower != nil && r.Lower.Value != nil && typeRank(key) > typeRank(r.Lower.Value)
+	}
+	
+	c := compare(key, r.Upper.Value)
+	return c > 0 || (c == 0 && !r.Upper.Inclusive)
+}
+
+// Contains проверяет, попадает ли значение в диапазон
+func (r Range) Contains(key interface{}) bool {
+	return !r.belowLower(key) && !r.aboveUpper(key)
+}
+
+// rankMin возвращает наименьшее значение ранга типа, если оно существует
+func rankMin(rank int) (interface{}, bool) {
+	switch rank {
+	case rankNull:
+		return nil, true
+	case rankBool:
+		return false, true
+	case rankNumber:
+		return math.Inf(-1), true
+	case rankString:
+		return "", true
+	}
+	return nil, false
+}
+
+// lowerStart возвращает позицию первого ключа узла, который может попасть в диапазон
+func (r Range) lowerStart(node *BTreeNode) int {
+	if r.Lower != nil {
+		return position(node, r.Lower.Value)
+	}
+	
+	if r.Upper != nil {
+		if min, ok := rankMin(typeRank(r.Upper.Value)); ok {
+			return position(node, min)
+		}
+	}
+	
+	return 0
+}
+
+// upperEnd возвращает позицию за последним ключом узла, который может попасть в диапазон
+func (r Range) upperEnd(node *BTreeNode) int {
+	if r.Upper != nil {
+		return sort.Search(len(node.Keys), func(i int) bool {
+			return compare(node.Keys[i], r.Upper.Value) > 0
+		})
+	}
+	
+	if r.Lower != nil && r.Lower.Value != nil {
+		if next, ok := rankMin(typeRank(r.Lower.Value) + 1); ok {
+			return position(node, next)
+		}
+	}
+	
+	return len(node.Keys)
+}
+
+// SearchRange ищет документы, значения поля которых попадают в диапазон.
+// ID возвращаются в порядке возрастания значений
+func (bt *BTreeIndex) SearchRange(field string, r Range) ([]string, error) {
+	if field != bt.Field {
+		return nil, errors.New("несоответствие поля индекса")
+	}
+	
+	result := make([]string, 0)
+	seen := make(map[string]bool)
+	bt.Ascend(r, func(key interface{}, ids []string) bool {
+		for _, id := range ids {
+			// Для пути с [*] документ может встретиться под несколькими ключами
+			if bt.multiKey {
+				if seen[id] {
+					continue
+				}
+				seen[id] = true
+			}
+			result = append(result, id)
+		}
+		return true
+	})
+	
+	return result, nil
+}
+
+// Ascend обходит ключи диапазона по возрастанию, пока fn возвращает true
+func (bt *BTreeIndex) Ascend(r Range, fn func(key interface{}, ids []string) bool) {
+	bt.ascend(bt.Root, r, fn)
+}
+
+// ascend выполняет обход поддерева по возрастанию; возвращает false, если обход прерван
+func (bt *BTreeIndex) ascend(node *BTreeNode, r Range, fn func(key interface{}, ids []string) bool) bool {
+	for i := r.lowerStart(node); i <= len(node.Keys); i++ {
+		if !node.IsLeaf {
+			if !bt.ascend(node.Children[i], r, fn) {
+				return false
+			}
+		}
+		
+		if i == len(node.Keys) {
+			break
+		}
+		
+		key := node.Keys[i]
+		if r.aboveUpper(key) {
+			return false
+		}
+		if r.belowLower(key) {
+			continue
+		}
+		
+		if !fn(key, copyIDs(node.Values[i])) {
+			return false
+		}
+	}
+	
+	return true
+}
+
+// Descend обходит ключи диапазона по убыванию, пока fn возвращает true
+func (bt *BTreeIndex) Descend(r Range, fn func(key interface{}, ids []string) bool) {
+	bt.descend(bt.Root, r, fn)
+}
+
+// descend выполняет обход поддерева по убыванию; возвращает false, если обход прерван
+func (bt *BTreeIndex) descend(node *BTreeNode, r Range, fn func(key interface{}, ids []string) bool) bool {
+	for i := r.upperEnd(node); i >= 0; i-- {
+		if !node.IsLeaf {
+			if !bt.descend(node.Children[i], r, fn) {
+				return false
+			}
+		}
+		
+		if i == 0 {
+			break
+		}
+		
+		key := node.Keys[i-1]
+		if r.belowLower(key) {
+			return false
+		}
+		if r.aboveUpper(key) {
+			continue
+		}
+		
+		if !fn(key, copyIDs(node.Values[i-1])) {
+			return false
+		}
+	}
+	
+	return true
+}
+
+// copyIDs возвращает копию списка ID документов
+func copyIDs(ids []string) []string {
+	result := make([]string, len(ids))
+	copy(result, ids)
+	return result
+}
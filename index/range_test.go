@@ -0,0 +1,154 @@
+package index
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/urusofam/jsondb/storage"
+)
+
+// rangeTree строит индекс со значениями разных типов и эталонную карту его содержимого
+func rangeTree(t *testing.T, order int) (*BTreeIndex, map[string]interface{}) {
+	t.Helper()
+	
+	rnd := rand.New(rand.NewSource(int64(order)))
+	bt := NewBTreeIndex("v", order)
+	model := map[string]interface{}{}
+	for i := 0; i < 600; i++ {
+		var value interface{} = float64(rnd.Intn(100))
+		switch rnd.Intn(6) {
+		case 0:
+			value = fmt.Sprintf("s%02d", rnd.Intn(30))
+		case 1:
+			value = rnd.Intn(2) == 0
+		case 2:
+			value = nil
+		}
+		id := fmt.Sprint("d", i)
+		if err := bt.Add(storage.Document{ID: id, Content: map[string]interface{}{"v": value}}); err != nil {
+			t.Fatal(err)
+		}
+		model[id] = value
+	}
+	
+	// Часть документов удаляется, чтобы обход шел по дереву после слияний узлов
+	for i := 0; i < 600; i += 3 {
+		id := fmt.Sprint("d", i)
+		if err := bt.Remove(id); err != nil {
+			t.Fatal(err)
+		}
+		delete(model, id)
+	}
+	return bt, model
+}
+
+func TestRangeScanMatchesFullScan(t *testing.T) {
+	ranges := []Range{
+		GreaterThan(50.0),
+		AtLeast(50.0),
+		LessThan(20.0),
+		AtMost(20),
+		Between(10.0, 30.0),
+		Between(30.0, 10.0),
+		Prefix("s1"),
+		GreaterThan("s05"),
+		LessThan(true),
+		NotNull(),
+		{},
+		{Lower: &Bound{Value: 3.0}, Upper: &Bound{Value: "s03", Inclusive: true}},
+	}
+	
+	for _, order := range []int{3, 4, 32} {
+		bt, model := rangeTree(t, order)
+		for _, r := range ranges {
+			var want []string
+			for id, value := range model {
+				if r.Contains(value) {
+					want = append(want, id)
+				}
+			}
+			sort.Strings(want)
+			
+			got, err := bt.SearchRange("v", r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sort.Strings(got)
+			if fmt.Sprint(got) != fmt.Sprint(want) {
+				t.Fatalf("порядок %d, диапазон %v: %d документов, полный перебор дает %d", order, r, len(got), len(want))
+			}
+			
+			var keys []interface{}
+			ascended := 0
+			bt.Ascend(r, func(key interface{}, ids []string) bool {
+				if len(keys) > 0 && compare(keys[len(keys)-1], key) >= 0 {
+					t.Fatalf("Ascend: ключ %v после %v", key, keys[len(keys)-1])
+				}
+				if !r.Contains(key) {
+					t.Fatalf("Ascend: ключ %v вне диапазона %v", key, r)
+				}
+				keys = append(keys, key)
+				ascended += len(ids)
+				return true
+			})
+			
+			descended := 0
+			bt.Descend(r, func(key interface{}, ids []string) bool {
+				if len(keys) == 0 || compare(keys[len(keys)-1], key) != 0 {
+					t.Fatalf("Descend: ключ %v не совпадает с обходом по возрастанию %v", key, keys)
+				}
+				keys = keys[:len(keys)-1]
+				descended += len(ids)
+				return true
+			})
+			if ascended != len(want) || descended != len(want) || len(keys) != 0 {
+				t.Fatalf("порядок %d, диапазон %v: Ascend %d, Descend %d, ожидалось %d", order, r, ascended, descended, len(want))
+			}
+		}
+	}
+}
+
+func TestRangeBoundsByType(t *testing.T) {
+	for _, c := range []struct {
+		r     Range
+		value interface{}
+		want  bool
+	}{
+		{GreaterThan(25.0), 30.0, true},
+		{GreaterThan(25.0), 25.0, false},
+		{AtLeast(25.0), 25.0, true},
+		{GreaterThan(25.0), "abc", false},
+		{GreaterThan(25.0), true, false},
+		{LessThan(25.0), nil, false},
+		{LessThan(25.0), false, false},
+		{LessThan("b"), 1.0, false},
+		{NotNull(), nil, false},
+		{NotNull(), false, true},
+		{NotNull(), "x", true},
+		{Prefix("ab"), "abz", true},
+		{Prefix("ab"), "ac", false},
+		{Prefix("a\xff"), "a\xff\xff", true},
+		{Prefix("a\xff"), "b", false},
+		{Range{}, nil, true},
+	} {
+		if got := c.r.Contains(c.value); got != c.want {
+			t.Errorf("%v содержит %#v: %v, ожидалось %v", c.r, c.value, got, c.want)
+		}
+	}
+}
+
+func TestRangeScanStops(t *testing.T) {
+	bt, _ := rangeTree(t, 4)
+	for _, scan := range []func(Range, func(interface{}, []string) bool){bt.Ascend, bt.Descend} {
+		n := 0
+		scan(Range{}, func(key interface{}, ids []string) bool {
+			n++
+			return n < 3
+		})
+		if n != 3 {
+			t.Fatalf("обход не остановлен: %d ключей", n)
+		}
+	}
+}
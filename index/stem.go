@@ -0,0 +1,350 @@
+package index
+
+import "strings"
+
+// porterStemmer хранит состояние стеммера Портера для английского слова
+type porterStemmer struct {
+	b []byte
+}
+
+// stemEnglish сводит английское слово к основе по алгоритму Портера
+func stemEnglish(word string) string {
+	if len(word) <= 2 {
+		return word
+	}
+	
+	s := &porterStemmer{b: []byte(word)}
+	s.step1a()
+	s.step1b()
+	s.step1c()
+	s.replaceFirst(0, porterStep2)
+	s.replaceFirst(0, porterStep3)
+	s.step4()
+	s.step5()
+	return string(s.b)
+}
+
+// porterStep2 и porterStep3 - замены суффиксов шагов 2 и 3 (при m > 0).
+// Более длинные суффиксы стоят раньше совпадающих с ними коротких
+var porterStep2 = [][2]string{
+	{"ational", "ate"}, {"tional", "tion"}, {"enci", "ence"}, {"anci", "ance"},
+	{"izer", "ize"}, {"abli", "able"}, {"alli", "al"}, {"entli", "ent"},
+	{"eli", "e"}, {"ousli", "ous"}, {"ization", "ize"}, {"ation", "ate"},
+	{"ator", "ate"}, {"alism", "al"}, {"iveness", "ive"}, {"fulness", "ful"},
+	{"ousness", "ous"}, {"aliti", "al"}, {"iviti", "ive"}, {"biliti", "ble"},
+}
+
+var porterStep3 = [][2]string{
+	{"icate", "ic"}, {"ative", ""}, {"alize", "al"}, {"iciti", "ic"},
+	{"ical", "ic"}, {"ful", ""}, {"ness", ""},
+}
+
+// porterStep4 - суффиксы, удаляемые на шаге 4 (при m > 1)
+var porterStep4 = []string{
+	"al", "ance", "ence", "er", "ic", "able", "ible", "ant", "ement", "ment",
+	"ent", "ion", "ou", "ism", "ate", "iti", "ous", "ive", "ize",
+}
+
+// consonant сообщает, является ли буква в позиции i согласной
+func (s *porterStemmer) consonant(i int) bool {
+	switch s.b[i] {
+	case 'a', 'e', 'i', 'o', 'u':
+		return false
+	case 'y':
+		return i == 0 || !s.consonant(i-1)
+	}
+	return true
+}
+
+// measure возвращает число последовательностей "гласные-согласные" в первых n буквах
+func (s *porterStemmer) measure(n int) int {
+	m, i := 0, 0
+	for i < n && s.consonant(i) {
+		i++
+	}
+	for i < n {
+		for i < n && !s.consonant(i) {
+			i++
+		}
+		if i >= n {
+			break
+		}
+		for i < n && s.consonant(i) {
+			i++
+		}
+		m++
+	}
+	return m
+}
+
+// hasVowel сообщает, есть ли гласная в первых n буквах
+func (s *porterStemmer) hasVowel(n int) bool {
+	for i := 0; i < n; i++ {
+		if !s.consonant(i) {
+			return true
+		}
+	}
+	return false
+}
+
+// doubleConsonant сообщает, заканчиваются ли первые n букв удвоенной согласной
+func (s *porterStemmer) doubleConsonant(n int) bool {
+	return n >= 2 && s.b[n-1] == s.b[n-2] && s.consonant(n-1)
+}
+
+// cvc сообщает, заканчиваются ли первые n букв на "согласная-гласная-согласная",
+// где последняя согласная - не w, x или y
+func (s *porterStemmer) cvc(n int) bool {
+	if n < 3 || !s.consonant(n-1) || s.consonant(n-2) || !s.consonant(n-3) {
+		return false
+	}
+	last := s.b[n-1]
+	return last != 'w' && last != 'x' && last != 'y'
+}
+
+// hasSuffix сообщает, заканчивается ли слово суффиксом
+func (s *porterStemmer) hasSuffix(suffix string) bool {
+	return strings.HasSuffix(string(s.b), suffix)
+}
+
+// replace заменяет суффикс длины n строкой
+func (s *porterStemmer) replace(n int, with string) {
+	s.b = append(s.b[:len(s.b)-n], with...)
+}
+
+// replaceFirst находит первый совпавший суффикс из списка и заменяет его,
+// если мера основы больше minMeasure
+func (s *porterStemmer) replaceFirst(minMeasure int, rules [][2]string) {
+	for _, rule := range rules {
+		if s.hasSuffix(rule[0]) {
+			if s.measure(len(s.b)-len(rule[0])) > minMeasure {
+				s.replace(len(rule[0]), rule[1])
+			}
+			return
+		}
+	}
+}
+
+// step1a обрабатывает множественное число
+func (s *porterStemmer) step1a() {
+	switch {
+	case s.hasSuffix("sses"), s.hasSuffix("ies"):
+		s.replace(2, "")
+	case s.hasSuffix("ss"):
+	case s.hasSuffix("s"):
+		s.replace(1, "")
+	}
+}
+
+// step1b обрабатывает окончания -eed, -ed и -ing
+func (s *porterStemmer) step1b() {
+	if s.hasSuffix("eed") {
+		if s.measure(len(s.b)-3) > 0 {
+			s.replace(1, "")
+		}
+		return
+	}
+	
+	removed := false
+	for _, suffix := range []string{"ed", "ing"} {
+		if s.hasSuffix(suffix) && s.hasVowel(len(s.b)-len(suffix)) {
+			s.replace(len(suffix), "")
+			removed = true
+			break
+		}
+	}
+	if !removed {
+		return
+	}
+	
+	n := len(s.b)
+	switch {
+	case s.hasSuffix("at"), s.hasSuffix("bl"), s.hasSuffix("iz"):
+		s.replace(0, "e")
+	case s.doubleConsonant(n):
+		if last := s.b[n-1]; last != 'l' && last != 's' && last != 'z' {
+			s.replace(1, "")
+		}
+	case s.measure(n) == 1 && s.cvc(n):
+		s.replace(0, "e")
+	}
+}
+
+// step1c заменяет конечную y на i, если в основе есть гласная
+func (s *porterStemmer) step1c() {
+	if s.hasSuffix("y") && s.hasVowel(len(s.b)-1) {
+		s.b[len(s.b)-1] = 'i'
+	}
+}
+
+// step4 удаляет суффиксы при m > 1
+func (s *porterStemmer) step4() {
+	for _, suffix := range porterStep4 {
+		if !s.hasSuffix(suffix) {
+			continue
+		}
+		
+		n := len(s.b) - len(suffix)
+		if s.measure(n) > 1 && (suffix != "ion" || n > 0 && (s.b[n-1] == 's' || s.b[n-1] == 't')) {
+			s.replace(len(suffix), "")
+		}
+		return
+	}
+}
+
+// step5 удаляет конечную e и упрощает конечную ll
+func (s *porterStemmer) step5() {
+	n := len(s.b)
+	if s.b[n-1] == 'e' {
+		m := s.measure(n - 1)
+		if m > 1 || m == 1 && !s.cvc(n-1) {
+			s.replace(1, "")
+		}
+	}
+	
+	n = len(s.b)
+	if s.b[n-1] == 'l' && s.doubleConsonant(n) && s.measure(n) > 1 {
+		s.replace(1, "")
+	}
+}
+
+// Окончания русского стеммера Snowball. Окончания групп "AfterA" удаляются,
+// только если им предшествует "а" или "я"
+var (
+	ruGerundAfterA     = []string{"в", "вши", "вшись"}
+	ruGerund           = []string{"ив", "ивши", "ившись", "ыв", "ывши", "ывшись"}
+	ruAdjective        = []string{"ее", "ие", "ые", "ое", "ими", "ыми", "ей", "ий", "ый", "ой", "ем", "им", "ым", "ом", "его", "ого", "ему", "ому", "их", "ых", "ую", "юю", "ая", "яя", "ою", "ею"}
+	ruParticipleAfterA = []string{"ем", "нн", "вш", "ющ", "щ"}
+	ruParticiple       = []string{"ивш", "ывш", "ующ"}
+	ruReflexive        = []string{"ся", "сь"}
+	ruVerbAfterA       = []string{"ла", "на", "ете", "йте", "ли", "й", "л", "ем", "н", "ло", "но", "ет", "ют", "ны", "ть", "ешь", "нно"}
+	ruVerb             = []string{"ила", "ыла", "ена", "ейте", "уйте", "ите", "или", "ыли", "ей", "уй", "ил", "ыл", "им", "ым", "ен", "ило", "ыло", "ено", "ят", "ует", "уют", "ит", "ыт", "ены", "ить", "ыть", "ишь", "ую", "ю"}
+	ruNoun             = []string{"а", "ев", "ов", "ие", "ье", "е", "иями", "ями", "ами", "еи", "ии", "и", "ией", "ей", "ой", "ий", "й", "иям", "ям", "ием", "ем", "ам", "ом", "о", "у", "ах", "иях", "ях", "ы", "ь", "ию", "ью", "ю", "ия", "ья", "я"}
+	ruDerivational     = []string{"ост", "ость"}
+	ruSuperlative      = []string{"ейш", "ейше"}
+)
+
+// russianStemmer хранит слово и границу области RV, за которую не
+// заходят удаляемые окончания
+type russianStemmer struct {
+	w  []rune
+	rv int
+}
+
+// stemRussian сводит русское слово к основе по алгоритму Snowball
+func stemRussian(word string) string {
+	w := []rune(word)
+	rv, r2 := russianRegions(w)
+	s := &russianStemmer{w: w, rv: rv}
+	
+	// Шаг 1: деепричастие, иначе возвратная частица и затем
+	// прилагательное, глагол или существительное
+	if !s.removeGrouped(ruGerundAfterA, ruGerund) {
+		s.remove(ruReflexive)
+		if s.remove(ruAdjective) {
+			s.removeGrouped(ruParticipleAfterA, ruParticiple)
+		} else if !s.removeGrouped(ruVerbAfterA, ruVerb) {
+			s.remove(ruNoun)
+		}
+	}
+	
+	// Шаг 2
+	s.remove([]string{"и"})
+	
+	// Шаг 3: словообразовательный суффикс в области R2
+	if suffix := s.longest(ruDerivational); suffix != "" && len(s.w)-len([]rune(suffix)) >= r2 {
+		s.w = s.w[:len(s.w)-len([]rune(suffix))]
+	}
+	
+	// Шаг 4
+	switch {
+	case s.remove(ruSuperlative):
+		s.undoubleN()
+	case s.undoubleN():
+	default:
+		s.remove([]string{"ь"})
+	}
+	
+	return string(s.w)
+}
+
+// russianRegions возвращает начало областей RV и R2 слова
+func russianRegions(w []rune) (int, int) {
+	rv, r2 := len(w), len(w)
+	
+	i := 0
+	next := func(vowel bool) bool {
+		for i < len(w) && isRussianVowel(w[i]) != vowel {
+			i++
+		}
+		if i >= len(w) {
+			return false
+		}
+		i++
+		return true
+	}
+	
+	if !next(true) {
+		return rv, r2
+	}
+	rv = i
+	if next(false) && next(true) && next(false) {
+		r2 = i
+	}
+	return rv, r2
+}
+
+// isRussianVowel сообщает, является ли буква гласной
+func isRussianVowel(r rune) bool {
+	return strings.ContainsRune("аеиоуыэюя", r)
+}
+
+// longest возвращает самое длинное окончание из списка, целиком лежащее в RV
+func (s *russianStemmer) longest(suffixes []string) string {
+	best := ""
+	for _, suffix := range suffixes {
+		n := len([]rune(suffix))
+		if n > len([]rune(best)) && len(s.w)-n >= s.rv && strings.HasSuffix(string(s.w), suffix) {
+			best = suffix
+		}
+	}
+	return best
+}
+
+// remove удаляет самое длинное окончание из списка
+func (s *russianStemmer) remove(suffixes []string) bool {
+	suffix := s.longest(suffixes)
+	if suffix == "" {
+		return false
+	}
+	s.w = s.w[:len(s.w)-len([]rune(suffix))]
+	return true
+}
+
+// removeGrouped удаляет самое длинное окончание из двух групп; окончание
+// первой группы удаляется, только если перед ним в RV стоит "а" или "я"
+func (s *russianStemmer) removeGrouped(afterA, plain []string) bool {
+	suffix := s.longest(append(append([]string(nil), afterA...), plain...))
+	if suffix == "" {
+		return false
+	}
+	
+	n := len(s.w) - len([]rune(suffix))
+	if s.longest(plain) != suffix {
+		if n-1 < s.rv || s.w[n-1] != 'а' && s.w[n-1] != 'я' {
+			return false
+		}
+	}
+	s.w = s.w[:n]
+	return true
+}
+
+// undoubleN заменяет конечное "нн" на "н"
+func (s *russianStemmer) undoubleN() bool {
+	n := len(s.w)
+	if n-2 < s.rv || s.w[n-1] != 'н' || s.w[n-2] != 'н' {
+		return false
+	}
+	s.w = s.w[:n-1]
+	return true
+}
@@ -0,0 +1,46 @@
+package index
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/urusofam/jsondb/storage"
+)
+
+// ErrDuplicateKey возвращается при нарушении ограничения уникальности
+var ErrDuplicateKey = errors.New("нарушение ограничения уникальности")
+
+// DuplicateKeyError описывает нарушение ограничения уникальности:
+// значение Value поля Field уже есть у документа ID
+type DuplicateKeyError struct {
+	Field string
+	Value interface{}
+	ID    string
+}
+
+// Error возвращает текст ошибки с конфликтующим документом
+func (e *DuplicateKeyError) Error() string {
+	return fmt.Sprintf("%v: значение %s поля %s уже есть у документа %s", ErrDuplicateKey, formatKey(e.Value), e.Field, e.ID)
+}
+
+// Unwrap позволяет проверять ошибку через errors.Is(err, ErrDuplicateKey)
+func (e *DuplicateKeyError) Unwrap() error {
+	return ErrDuplicateKey
+}
+
+// Constraint определяет индекс с ограничением на значения документов
+type Constraint interface {
+	// Check проверяет, что документ можно добавить в индекс, не нарушив ограничение
+	Check(doc storage.Document) error
+}
+
+// formatKey форматирует ключ индекса для сообщения об ошибке
+func formatKey(v interface{}) string {
+	switch val := v.(type) {
+	case nil:
+		return "null"
+	case string:
+		return fmt.Sprintf("%q", val)
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,424 @@
+package query
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/urusofam/jsondb/index"
+	"github.com/urusofam/jsondb/storage"
+)
+
+// visit вызывает fn для всех выражений запроса, включая вложенные
+func (q *Query) visit(fn func(Expr)) {
+	for _, item := range q.Select {
+		visitExpr(item.Expr, fn)
+	}
+	for _, cond := range []*Condition{q.Where, q.Having} {
+		if cond != nil {
+			cond.walk(func(c *Condition) {
+				visitExpr(c.Left, fn)
+				visitExpr(c.Right, fn)
+			})
+		}
+	}
+	for _, expr := range q.GroupBy {
+		visitExpr(expr, fn)
+	}
+	for _, item := range q.OrderBy {
+		visitExpr(item.Expr, fn)
+	}
+	for _, join := range q.Joins {
+		join.On.walk(func(c *Condition) {
+			visitExpr(c.Left, fn)
+			visitExpr(c.Right, fn)
+		})
+	}
+}
+
+// grouped проверяет, требует ли запрос группировки:
+// есть GROUP BY, HAVING или агрегатные функции
+func (q *Query) grouped() bool {
+	return len(q.GroupBy) > 0 || q.Having != nil || len(q.aggregates()) > 0
+}
+
+// aggregates возвращает различные агрегатные функции из SELECT, HAVING и ORDER BY
+func (q *Query) aggregates() []*Aggregate {
+	result := make([]*Aggregate, 0)
+	seen := make(map[string]bool)
+	
+	add := func(expr Expr) {
+		if agg, ok := expr.(*Aggregate); ok && !seen[agg.String()] {
+			seen[agg.String()] = true
+			result = append(result, agg)
+		}
+	}
+	
+	for _, item := range q.Select {
+		visitExpr(item.Expr, add)
+	}
+	if q.Having != nil {
+		q.Having.walk(func(cond *Condition) {
+			visitExpr(cond.Left, add)
+			visitExpr(cond.Right, add)
+		})
+	}
+	for _, item := range q.OrderBy {
+		visitExpr(item.Expr, add)
+	}
+	
+	return result
+}
+
+// walk вызывает fn для каждого простого условия
+func (c *Condition) walk(fn func(cond *Condition)) {
+	if len(c.Children) == 0 {
+		fn(c)
+		return
+	}
+	for _, child := range c.Children {
+		child.walk(fn)
+	}
+}
+
+// validateGrouping проверяет использование агрегатных функций и полей группировки
+func (q *Query) validateGrouping() error {
+	if q.Where != nil {
+		var err error
+		q.Where.walk(func(cond *Condition) {
+			for _, expr := range []Expr{cond.Left, cond.Right} {
+				visitExpr(expr, func(e Expr) {
+					if agg, ok := e.(*Aggregate); ok && err == nil {
+						err = fmt.Errorf("агрегатная функция %s недопустима в WHERE, используйте HAVING", agg)
+					}
+				})
+			}
+		})
+		if err != nil {
+			return err
+		}
+	}
+	
+	if !q.grouped() {
+		return nil
+	}
+	
+	for _, expr := range q.GroupBy {
+		if storage.HasWildcard(expr.String()) {
+			return fmt.Errorf("группировка по пути с [*] не поддерживается: %s", expr)
+		}
+	}
+	
+	// Вне агрегатных функций допустимы только поля группировки
+	var check func(expr Expr, clause string) error
+	check = func(expr Expr, clause string) error {
+		switch e := expr.(type) {
+		case *Star:
+			return fmt.Errorf("SELECT * недопустим в запросе с группировкой")
+		case *Match:
+			return fmt.Errorf("MATCH в %s недопустим в запросе с группировкой", clause)
+		case *FieldRef:
+			// Поле внешнего запроса одинаково для всех строк группы
+			if e.Depth == 0 && !q.groupedBy(e.Path) {
+				return fmt.Errorf("поле %s в %s должно входить в GROUP BY или использоваться в агрегатной функции", e.Path, clause)
+			}
+		case *FuncCall:
+			for _, arg := range e.Args {
+				if err := check(arg, clause); err != nil {
+					return err
+				}
+			}
+		case *Coalesce:
+			for _, arg := range e.Args {
+				if err := check(arg, clause); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	
+	for _, item := range q.Select {
+		if err := check(item.Expr, "SELECT"); err != nil {
+			return err
+		}
+	}
+	
+	var err error
+	if q.Having != nil {
+		q.Having.walk(func(cond *Condition) {
+			for _, expr := range []Expr{cond.Left, cond.Right} {
+				if err == nil {
+					err = check(expr, "HAVING")
+				}
+			}
+		})
+		if err != nil {
+			return err
+		}
+	}
+	
+	for _, item := range q.OrderBy {
+		if err := check(item.Expr, "ORDER BY"); err != nil {
+			return err
+		}
+	}
+	
+	return nil
+}
+
+// groupedBy проверяет, входит ли поле в GROUP BY
+func (q *Query) groupedBy(path string) bool {
+	for _, expr := range q.GroupBy {
+		if expr.String() == path {
+			return true
+		}
+	}
+	return false
+}
+
+// group объединяет документы в группы по ключам GROUP BY и вычисляет агрегатные функции.
+// Каждая группа представляется документом, в котором значения ключей группировки
+// и агрегатных функций сохранены под их текстовой записью. Документы с NULL
+// и с отсутствующим ключом попадают в разные группы; в группе отсутствующего
+// ключа поля ключа нет. Без GROUP BY все документы образуют одну группу, даже если их нет
+func (qe *QueryExecutor) group(docs []storage.Document, groupBy []Expr, aggregates []*Aggregate) ([]storage.Document, error) {
+	type groupState struct {
+		keys         []interface{}
+		present      []bool
+		accumulators []*accumulator
+	}
+	
+	newGroup := func(keys []interface{}, present []bool) *groupState {
+		g := &groupState{keys: keys, present: present, accumulators: make([]*accumulator, len(aggregates))}
+		for i, agg := range aggregates {
+			g.accumulators[i] = newAccumulator(agg)
+		}
+		return g
+	}
+	
+	groups := make([]*groupState, 0)
+	byKey := make(map[string]*groupState)
+	
+	if len(groupBy) == 0 {
+		groups = append(groups, newGroup(nil, nil))
+	}
+	
+	for _, doc := range docs {
+		var g *groupState
+		
+		if len(groupBy) == 0 {
+			g = groups[0]
+		} else {
+			keys := make([]interface{}, len(groupBy))
+			present := make([]bool, len(groupBy))
+			parts := make([]string, len(groupBy))
+			for i, expr := range groupBy {
+				value, ok, err := qe.evalExpr(doc, expr)
+				if err != nil {
+					return nil, err
+				}
+				keys[i], present[i] = value, ok
+				parts[i] = distinctKey(value)
+				if !ok {
+					parts[i] = "missing"
+				}
+			}
+			
+			key := strings.Join(parts, "\x00")
+			if g = byKey[key]; g == nil {
+				g = newGroup(keys, present)
+				byKey[key] = g
+				groups = append(groups, g)
+			}
+		}
+		
+		for i, agg := range aggregates {
+			if agg.Arg == nil {
+				g.accumulators[i].add(nil, true)
+				continue
+			}
+			value, ok, err := qe.evalExpr(doc, agg.Arg)
+			if err != nil {
+				return nil, err
+			}
+			g.accumulators[i].add(value, ok)
+		}
+	}
+	
+	result := make([]storage.Document, 0, len(groups))
+	for _, g := range groups {
+		row := storage.Document{Content: make(map[string]interface{})}
+		
+		for i, expr := range groupBy {
+			if !g.present[i] {
+				continue
+			}
+			// Ключи сохраняются по своему пути, чтобы их можно было выбрать как обычные поля
+			storage.SetPath(row.Content, expr.String(), g.keys[i])
+			if expr.String() == "_id" {
+				row.ID = fmt.Sprint(g.keys[i])
+			}
+		}
+		for i, agg := range aggregates {
+			row.Content[agg.String()] = g.accumulators[i].result()
+		}
+		
+		result = append(result, row)
+	}
+	
+	return result, nil
+}
+
+// accumulator накапливает значение агрегатной функции для одной группы
+type accumulator struct {
+	agg     *Aggregate
+	count   int
+	sum     float64
+	extreme interface{}
+	values  []interface{}
+	seen    map[string]bool
+}
+
+// newAccumulator создает накопитель для агрегатной функции
+func newAccumulator(agg *Aggregate) *accumulator {
+	return &accumulator{
+		agg:    agg,
+		values: make([]interface{}, 0),
+		seen:   make(map[string]bool),
+	}
+}
+
+// add учитывает значение аргумента; ok равно false для отсутствующего поля.
+// NULL и отсутствующие значения пропускаются всеми функциями, кроме COUNT(*) и ARRAY_AGG
+func (a *accumulator) add(value interface{}, ok bool) {
+	if a.agg.Arg == nil {
+		a.count++
+		return
+	}
+	
+	if a.agg.Distinct {
+		key := distinctKey(value)
+		if a.seen[key] {
+			return
+		}
+		a.seen[key] = true
+	}
+	
+	if a.agg.Func == "ARRAY_AGG" {
+		a.values = append(a.values, value)
+		return
+	}
+	
+	if !ok || value == nil {
+		return
+	}
+	
+	switch a.agg.Func {
+	case "COUNT":
+		a.count++
+	case "SUM", "AVG":
+		if n, isNumber := toNumber(value); isNumber {
+			a.sum += n
+			a.count++
+		}
+	case "MIN":
+		if a.extreme == nil || index.Compare(value, a.extreme) < 0 {
+			a.extreme = value
+		}
+	case "MAX":
+		if a.extreme == nil || index.Compare(value, a.extreme) > 0 {
+			a.extreme = value
+		}
+	}
+}
+
+// result возвращает итоговое значение агрегатной функции.
+// SUM, AVG, MIN и MAX без подходящих значений возвращают NULL
+func (a *accumulator) result() interface{} {
+	switch a.agg.Func {
+	case "COUNT":
+		return a.count
+	case "SUM":
+		if a.count == 0 {
+			return nil
+		}
+		return a.sum
+	case "AVG":
+		if a.count == 0 {
+			return nil
+		}
+		return a.sum / float64(a.count)
+	case "MIN", "MAX":
+		return a.extreme
+	case "ARRAY_AGG":
+		return a.values
+	}
+	return nil
+}
+
+// toNumber приводит числовое значение к float64
+func toNumber(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	}
+	return 0, false
+}
+
+// distinctKey возвращает ключ для сравнения значений на совпадение.
+// Числа разных типов с одинаковым значением дают один ключ. Массивы и
+// объекты кодируются поэлементно, строки - вместе с длиной, поэтому
+// ключи разных значений не совпадают и их можно соединять подряд
+func distinctKey(v interface{}) string {
+	var b strings.Builder
+	writeDistinctKey(&b, v)
+	return b.String()
+}
+
+// writeDistinctKey дописывает ключ значения v в b
+func writeDistinctKey(b *strings.Builder, v interface{}) {
+	switch val := v.(type) {
+	case nil:
+		b.WriteString("null;")
+	case bool:
+		b.WriteString("b:" + strconv.FormatBool(val) + ";")
+	case string:
+		writeKeyString(b, "s", val)
+	case []interface{}:
+		b.WriteString("a" + strconv.Itoa(len(val)) + "[")
+		for _, item := range val {
+			writeDistinctKey(b, item)
+		}
+		b.WriteString("]")
+	case map[string]interface{}:
+		fields := make([]string, 0, len(val))
+		for field := range val {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+		
+		b.WriteString("o" + strconv.Itoa(len(val)) + "{")
+		for _, field := range fields {
+			writeKeyString(b, "k", field)
+			writeDistinctKey(b, val[field])
+		}
+		b.WriteString("}")
+	default:
+		if n, ok := toNumber(v); ok {
+			b.WriteString("n:" + strconv.FormatFloat(n, 'g', -1, 64) + ";")
+		} else {
+			writeKeyString(b, "v", fmt.Sprint(v))
+		}
+	}
+}
+
+// writeKeyString дописывает в b строку s с меткой tag и длиной
+func writeKeyString(b *strings.Builder, tag, s string) {
+	b.WriteString(tag + strconv.Itoa(len(s)) + ":" + s)
+}
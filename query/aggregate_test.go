@@ -0,0 +1,179 @@
+package query
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/urusofam/jsondb/index"
+	"github.com/urusofam/jsondb/storage"
+)
+
+// groupDocs возвращает документы пользователей; у каждого пятого нет поля age
+func groupDocs() []storage.Document {
+	docs := make([]storage.Document, 20)
+	for i := range docs {
+		content := map[string]interface{}{"city": fmt.Sprint("c", i%3), "age": float64(20 + i%7), "tier": float64(i % 2)}
+		if i%5 == 0 {
+			delete(content, "age")
+		}
+		docs[i] = storage.Document{ID: fmt.Sprint("u", i), Content: content}
+	}
+	return docs
+}
+
+func TestGroupBy(t *testing.T) {
+	indexed, scan := plannerExecutors(t, groupDocs(), "age")
+	
+	for q, want := range map[string]string{
+		"SELECT COUNT(*) FROM t": "[map[COUNT(*):20]]",
+		"SELECT COUNT(*) AS n, COUNT(age), SUM(age), AVG(age), MIN(age), MAX(age) FROM t WHERE age > 100":                  "[map[AVG(age):<nil> COUNT(age):0 MAX(age):<nil> MIN(age):<nil> SUM(age):<nil> n:0]]",
+		"SELECT city, COUNT(*) AS n, AVG(age) avg_age, COUNT(DISTINCT age) FROM t GROUP BY city ORDER BY city":             "[map[COUNT(DISTINCT age):5 avg_age:24 city:c0 n:7] map[COUNT(DISTINCT age):6 avg_age:23 city:c1 n:7] map[COUNT(DISTINCT age):5 avg_age:22 city:c2 n:6]]",
+		"SELECT city, tier, COUNT(*) FROM t GROUP BY city, tier HAVING COUNT(*) >= 4 ORDER BY COUNT(*) DESC, city, tier":   "[map[COUNT(*):4 city:c0 tier:0] map[COUNT(*):4 city:c1 tier:1]]",
+		"SELECT city, COUNT(*) AS n FROM t GROUP BY city HAVING 7 <= COUNT(*) ORDER BY n DESC, city LIMIT 1":               "[map[city:c0 n:7]]",
+		"SELECT city FROM t GROUP BY city ORDER BY city DESC":                                                              "[map[city:c2] map[city:c1] map[city:c0]]",
+		"SELECT city, MIN(age) AS lo, MAX(age) AS hi FROM t WHERE age >= 24 GROUP BY city ORDER BY city":                   "[map[city:c0 hi:26 lo:24] map[city:c1 hi:26 lo:24] map[city:c2 hi:24 lo:24]]",
+		"SELECT tier, SUM(age) AS s FROM t WHERE age >= 21 AND age <= 23 GROUP BY tier HAVING SUM(age) > 70 ORDER BY tier": "[map[s:89 tier:1]]",
+	} {
+		for _, qe := range []*QueryExecutor{indexed, scan} {
+			if got := mustRun(t, qe, q); got != want {
+				t.Errorf("%s: %s, ожидалось %s", q, got, want)
+			}
+		}
+	}
+	
+	// Порядок элементов ARRAY_AGG зависит от порядка документов в хранилище
+	for _, row := range mustRows(t, scan, "SELECT city, ARRAY_AGG(DISTINCT tier) AS tiers FROM t GROUP BY city") {
+		tiers := row["tiers"].([]interface{})
+		sort.Slice(tiers, func(i, j int) bool { return tiers[i].(float64) < tiers[j].(float64) })
+		if fmt.Sprint(tiers) != "[0 1]" {
+			t.Errorf("%v: ARRAY_AGG(DISTINCT tier) = %v, ожидалось [0 1]", row["city"], tiers)
+		}
+	}
+}
+
+func TestGroupByMatchesBruteForce(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	docs := make([]storage.Document, 300)
+	for i := range docs {
+		content := map[string]interface{}{"g": fmt.Sprint("g", rnd.Intn(6)), "v": float64(rnd.Intn(100))}
+		if rnd.Intn(8) == 0 {
+			delete(content, "v")
+		}
+		docs[i] = storage.Document{ID: fmt.Sprint("d", i), Content: content}
+	}
+	indexed, scan := plannerExecutors(t, docs, "v", "g")
+	
+	type stats struct {
+		count, values int
+		sum, min, max float64
+	}
+	want := make(map[string]*stats)
+	for _, doc := range docs {
+		v, ok := doc.Content["v"].(float64)
+		if !ok || v < 30 {
+			continue
+		}
+		s := want[doc.Content["g"].(string)]
+		if s == nil {
+			s = &stats{min: v, max: v}
+			want[doc.Content["g"].(string)] = s
+		}
+		s.count++
+		s.values++
+		s.sum += v
+		if v < s.min {
+			s.min = v
+		}
+		if v > s.max {
+			s.max = v
+		}
+	}
+	
+	q := "SELECT g, COUNT(*) AS n, SUM(v) AS s, MIN(v) AS lo, MAX(v) AS hi, AVG(v) AS a FROM t WHERE v >= 30 GROUP BY g"
+	for _, qe := range []*QueryExecutor{indexed, scan} {
+		rows := mustRows(t, qe, q)
+		if len(rows) != len(want) {
+			t.Fatalf("%d групп, ожидалось %d", len(rows), len(want))
+		}
+		for _, row := range rows {
+			s := want[row["g"].(string)]
+			if s == nil || row["n"] != s.count || row["s"] != s.sum || row["lo"] != s.min || row["hi"] != s.max || row["a"] != s.sum/float64(s.count) {
+				t.Fatalf("группа %v: %v, ожидалось %+v", row["g"], row, s)
+			}
+		}
+	}
+}
+
+func TestGroupByArraysAndObjects(t *testing.T) {
+	// Значения, которые совпадают при печати через fmt, должны попадать в разные группы
+	values := []interface{}{
+		[]interface{}{"a b"},
+		[]interface{}{"a", "b"},
+		[]interface{}{"a", "b"},
+		[]interface{}{1.0},
+		[]interface{}{"1"},
+		map[string]interface{}{"a": 1.0},
+		map[string]interface{}{"a": "1"},
+		map[string]interface{}{"a": 1.0},
+		map[string]interface{}{"a": []interface{}{"x", "y"}, "b": nil},
+		map[string]interface{}{"a": []interface{}{"x y"}, "b": nil},
+	}
+	docs := make([]storage.Document, len(values))
+	for i, value := range values {
+		docs[i] = storage.Document{ID: fmt.Sprint("d", i), Content: map[string]interface{}{"v": value}}
+	}
+	qe := testExecutor(t, "t", docs...)
+	
+	if got := mustRun(t, qe, "SELECT COUNT(DISTINCT v) AS n FROM t"); got != "[map[n:8]]" {
+		t.Errorf("COUNT(DISTINCT v): %s, ожидалось [map[n:8]]", got)
+	}
+	
+	var groups []string
+	for _, row := range mustRows(t, qe, "SELECT v, COUNT(*) AS n FROM t GROUP BY v") {
+		data, _ := json.Marshal(row["v"])
+		groups = append(groups, fmt.Sprint(string(data), "=", row["n"]))
+	}
+	sort.Strings(groups)
+	want := `[["1"]=1 ["a b"]=1 ["a","b"]=2 [1]=1 {"a":"1"}=1 {"a":1}=2 {"a":["x y"],"b":null}=1 {"a":["x","y"],"b":null}=1]`
+	if got := fmt.Sprint(groups); got != want {
+		t.Errorf("GROUP BY v: %s, ожидалось %s", got, want)
+	}
+}
+
+// testExecutor создает исполнитель запросов над коллекцией name с документами docs
+func testExecutor(t *testing.T, name string, docs ...storage.Document) *QueryExecutor {
+	t.Helper()
+	
+	store := storage.NewMemoryStorage()
+	for _, doc := range docs {
+		if err := store.Save(doc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return NewQueryExecutor(map[string]Collection{
+		name: {Storage: store, Indexes: map[string]index.Index{}},
+	})
+}
+
+func TestGroupByErrors(t *testing.T) {
+	qe := testExecutor(t, "t", groupDocs()...)
+	for q, want := range map[string]string{
+		"SELECT * FROM t GROUP BY city":                       "SELECT * недопустим в запросе с группировкой",
+		"SELECT name, COUNT(*) FROM t GROUP BY city":          "поле name в SELECT должно входить в GROUP BY или использоваться в агрегатной функции",
+		"SELECT city FROM t WHERE COUNT(*) > 1 GROUP BY city": "агрегатная функция COUNT(*) недопустима в WHERE, используйте HAVING",
+		"SELECT SUM(*) FROM t":                                "строка 1, столбец 12: SUM(*) не поддерживается, допустимо только COUNT(*)",
+		"SELECT city FROM t GROUP BY city ORDER BY age":       "поле age в ORDER BY должно входить в GROUP BY или использоваться в агрегатной функции",
+	} {
+		if _, err := run(qe, q); err == nil || err.Error() != want {
+			t.Errorf("%s: %v, ожидалась ошибка %q", q, err, want)
+		}
+	}
+	
+	// Имена агрегатных функций без скобок остаются именами полей
+	if got := mustRun(t, qe, "SELECT count, sum FROM t LIMIT 1"); got != "[map[]]" {
+		t.Fatal(got)
+	}
+}
@@ -0,0 +1,120 @@
+package query
+
+import "strings"
+
+// Expr представляет выражение - операнд условия запроса
+type Expr interface {
+	// String возвращает текстовое представление выражения
+	String() string
+}
+
+// FieldRef ссылается на поле документа.
+// В подзапросе поле может относиться к строке внешнего запроса: Depth - число
+// уровней вложенности до него, Qualifier - снятый с пути псевдоним его коллекции
+type FieldRef struct {
+	Path      string
+	Depth     int
+	Qualifier string
+}
+
+// String возвращает путь к полю
+func (f *FieldRef) String() string {
+	if f.Qualifier != "" {
+		return f.Qualifier + "." + f.Path
+	}
+	return f.Path
+}
+
+// Literal представляет константу: строку, число, логическое значение или NULL
+type Literal struct {
+	Value interface{}
+}
+
+// String возвращает запись константы
+func (l *Literal) String() string {
+	return formatValue(l.Value)
+}
+
+// Star обозначает выбор всех полей документа в SELECT *
+type Star struct{}
+
+// String возвращает запись "*"
+func (s *Star) String() string {
+	return "*"
+}
+
+// Aggregate представляет вызов агрегатной функции.
+// Arg равен nil для COUNT(*)
+type Aggregate struct {
+	Func     string
+	Arg      Expr
+	Distinct bool
+}
+
+// String возвращает запись вызова агрегатной функции
+func (a *Aggregate) String() string {
+	if a.Arg == nil {
+		return a.Func + "(*)"
+	}
+	if a.Distinct {
+		return a.Func + "(DISTINCT " + a.Arg.String() + ")"
+	}
+	return a.Func + "(" + a.Arg.String() + ")"
+}
+
+// SelectItem представляет элемент списка SELECT
+type SelectItem struct {
+	Expr  Expr
+	Alias string
+}
+
+// Name возвращает имя столбца результата: псевдоним или запись выражения
+func (s *SelectItem) Name() string {
+	if s.Alias != "" {
+		return s.Alias
+	}
+	return s.Expr.String()
+}
+
+// FuncCall представляет вызов скалярной функции из реестра функций
+type FuncCall struct {
+	Name string
+	Args []Expr
+}
+
+// String возвращает запись вызова функции
+func (f *FuncCall) String() string {
+	args := make([]string, len(f.Args))
+	for i, arg := range f.Args {
+		args[i] = arg.String()
+	}
+	return f.Name + "(" + strings.Join(args, ", ") + ")"
+}
+
+// visitExpr вызывает fn для выражения и всех вложенных в него выражений.
+// Выражения подзапроса принадлежат его собственному запросу и не посещаются
+func visitExpr(expr Expr, fn func(Expr)) {
+	if expr == nil {
+		return
+	}
+	
+	fn(expr)
+	switch e := expr.(type) {
+	case *Aggregate:
+		visitExpr(e.Arg, fn)
+	case *FuncCall:
+		for _, arg := range e.Args {
+			visitExpr(arg, fn)
+		}
+	case *Coalesce:
+		for _, arg := range e.Args {
+			visitExpr(arg, fn)
+		}
+	case *Match:
+		visitExpr(e.Field, fn)
+	case *List:
+		for _, item := range e.Items {
+			visitExpr(item, fn)
+		}
+	}
+}
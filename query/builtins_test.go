@@ -0,0 +1,65 @@
+package query
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/urusofam/jsondb/storage"
+)
+
+// builtinDocs возвращает документы с числами и датами, записанными строками
+func builtinDocs(t *testing.T) []storage.Document {
+	t.Helper()
+	
+	var contents []map[string]interface{}
+	data := `[{"name":"Ivan","price":10.6,"created":"2024-01-01","city":"M"},` +
+		`{"name":"PETR","price":"3.2","created":"2024-03-01T10:00:00Z","city":"M"},` +
+		`{"name":"anna","created":"bad","city":"K"}]`
+	if err := json.Unmarshal([]byte(data), &contents); err != nil {
+		t.Fatal(err)
+	}
+	
+	docs := make([]storage.Document, len(contents))
+	for i, content := range contents {
+		docs[i] = storage.Document{ID: string(rune('a' + i)), Content: content}
+	}
+	return docs
+}
+
+func TestBuiltinFunctions(t *testing.T) {
+	indexed, scan := plannerExecutors(t, builtinDocs(t), "name", "city")
+	
+	for q, want := range map[string]string{
+		"SELECT name FROM t WHERE LOWER(name) = 'ivan'":                                                "[map[name:Ivan]]",
+		"SELECT ROUND(price), ROUND(price) AS r, SUBSTRING(name, 0, 2) FROM t ORDER BY name":           "[map[ROUND(price):11 SUBSTRING(name, 0, 2):Iv r:11] map[ROUND(price):3 SUBSTRING(name, 0, 2):PE r:3] map[ROUND(price):<nil> SUBSTRING(name, 0, 2):an r:<nil>]]",
+		"SELECT name, DAYS_BETWEEN(created, '2024-03-02') AS d FROM t WHERE name != 'anna' ORDER BY d": "[map[d:0 name:PETR] map[d:61 name:Ivan]]",
+		"SELECT city, ROUND(AVG(price)) AS avg FROM t GROUP BY city HAVING COUNT(*) > 1":               "[map[avg:11 city:M]]",
+		"SELECT name FROM t ORDER BY LENGTH(name) DESC, name":                                          "[map[name:Ivan] map[name:PETR] map[name:anna]]",
+		"SELECT UPPER(city), COUNT(*) FROM t GROUP BY city ORDER BY UPPER(city)":                       "[map[COUNT(*):1 UPPER(city):K] map[COUNT(*):2 UPPER(city):M]]",
+		"SELECT SUM(ROUND(price)) FROM t":                                                              "[map[SUM(ROUND(price)):14]]",
+		"SELECT name FROM t WHERE city = 'M' AND UPPER(name) > 'J'":                                    "[map[name:PETR]]",
+		"SELECT name FROM t WHERE name != 'anna' AND DAYS_BETWEEN(created, NOW()) > 30 ORDER BY name":  "[map[name:Ivan] map[name:PETR]]",
+	} {
+		for _, qe := range []*QueryExecutor{indexed, scan} {
+			if got := mustRun(t, qe, q); got != want {
+				t.Errorf("%s: %s, ожидалось %s", q, got, want)
+			}
+		}
+	}
+}
+
+func TestBuiltinFunctionErrors(t *testing.T) {
+	qe := testExecutor(t, "t", builtinDocs(t)...)
+	for q, want := range map[string]string{
+		"SELECT FOO(name) FROM t":                                   "неизвестная функция FOO",
+		"SELECT LOWER(name, 1) FROM t":                              "функция LOWER ожидает аргументов: 1, передано: 2",
+		"SELECT name FROM t WHERE DAYS_BETWEEN(created, NOW()) > 1": `функция DAYS_BETWEEN, аргумент 1: значение 'bad' нельзя привести к типу "дата"`,
+		"SELECT SUBSTRING(name, 0.5, 2) FROM t":                     `функция SUBSTRING, аргумент 2: значение 0.5 нельзя привести к типу "целое число"`,
+		"SELECT city, LOWER(name) FROM t GROUP BY city":             "поле name в SELECT должно входить в GROUP BY или использоваться в агрегатной функции",
+		"SELECT COUNT(MAX(price)) FROM t":                           "строка 1, столбец 14: вложенные агрегатные функции не допускаются",
+	} {
+		if _, err := run(qe, q); err == nil || err.Error() != want {
+			t.Errorf("%s: %v, ожидалась ошибка %q", q, err, want)
+		}
+	}
+}
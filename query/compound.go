@@ -0,0 +1,342 @@
+package query
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/urusofam/jsondb/index"
+	"github.com/urusofam/jsondb/storage"
+)
+
+// compoundIndexes возвращает составные индексы коллекции, упорядоченные по имени
+func compoundIndexes(coll Collection) []index.CompoundIndex {
+	names := make([]string, 0)
+	for name, idx := range coll.Indexes {
+		if compound, ok := idx.(index.CompoundIndex); ok && len(compound.KeyFields()) > 1 {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	
+	result := make([]index.CompoundIndex, len(names))
+	for i, name := range names {
+		result[i] = coll.Indexes[name].(index.CompoundIndex)
+	}
+	return result
+}
+
+// planCompound подбирает составной индекс для конъюнкции условий: условия
+// на равенство задают начало ключа, сравнения следующего поля - диапазон.
+// Возвращает узел плана, число использованных полей и признаки использованных условий
+func (qe *QueryExecutor) planCompound(coll Collection, conds []*Condition) (*PlanNode, int, []bool) {
+	var best *PlanNode
+	bestScore := 0
+	var bestUsed []bool
+	
+	for _, idx := range compoundIndexes(coll) {
+		fields := idx.KeyFields()
+		used := make([]bool, len(conds))
+		
+		prefix := make([]interface{}, 0)
+		for _, field := range fields {
+			pos := -1
+			for i, cond := range conds {
+				if path, _, ok := fieldComparison(cond); ok && path == field && cond.Operator == "=" {
+					pos = i
+					break
+				}
+			}
+			if pos < 0 {
+				break
+			}
+			
+			_, value, _ := fieldComparison(conds[pos])
+			prefix = append(prefix, value)
+			used[pos] = true
+		}
+		
+		score := len(prefix)
+		r := index.Range{}
+		if len(prefix) < len(fields) {
+			ranged := false
+			for i, cond := range conds {
+				path, value, ok := fieldComparison(cond)
+				if !ok || path != fields[len(prefix)] {
+					continue
+				}
+				switch cond.Operator {
+				case ">", ">=", "<", "<=":
+					r = intersectRanges(r, rangeFor(cond.Operator, value))
+					used[i] = true
+					ranged = true
+				}
+			}
+			if ranged {
+				score++
+			}
+		}
+		
+		if score > bestScore {
+			best = &PlanNode{Type: PlanIndexRange, Field: index.CompoundName(fields), KeyFields: fields, Prefix: prefix, Range: r}
+			bestScore = score
+			bestUsed = used
+		}
+	}
+	
+	return best, bestScore, bestUsed
+}
+
+// planCompoundOrder пытается обеспечить порядок ORDER BY обходом составного
+// индекса: ключи сортировки должны совпадать с полями индекса, следующими
+// за полями, заданными условием на равенство, и иметь одно направление
+func (qe *QueryExecutor) planCompoundOrder(coll Collection, query *Query, access *PlanNode) (*PlanNode, bool) {
+	paths := make([]string, len(query.OrderBy))
+	for i, item := range query.OrderBy {
+		field, ok := item.Expr.(*FieldRef)
+		if !ok || storage.HasWildcard(field.Path) {
+			return access, false
+		}
+		paths[i] = field.Path
+	}
+	
+	switch {
+	case access.KeyFields != nil:
+		// Поля, заданные условием на равенство, постоянны и на порядок не влияют
+		items := make([]*OrderItem, 0)
+		for i, item := range query.OrderBy {
+			constant := false
+			for _, field := range access.KeyFields[:len(access.Prefix)] {
+				constant = constant || field == paths[i]
+			}
+			if !constant {
+				items = append(items, item)
+			}
+		}
+		
+		if len(items) == 0 {
+			return access, true
+		}
+		if !matchesFields(items, access.KeyFields[len(access.Prefix):]) {
+			return access, false
+		}
+		access.OrderBy = items
+	case access.Type == PlanFullScan:
+		node := (*PlanNode)(nil)
+		for _, idx := range compoundIndexes(coll) {
+			if matchesFields(query.OrderBy, idx.KeyFields()) && coversCollection(coll, idx) {
+				node = &PlanNode{Type: PlanIndexScan, Field: index.CompoundName(idx.KeyFields()), KeyFields: idx.KeyFields()}
+				break
+			}
+		}
+		if node == nil {
+			return access, false
+		}
+		access = node
+		access.OrderBy = query.OrderBy
+	default:
+		return access, false
+	}
+	
+	// Обход можно остановить после OFFSET + LIMIT документов, если условие
+	// полностью проверяется индексом, а NULL уже стоят на своих местах
+	if query.Limit > 0 && (query.Where == nil || coveredByCompound(query.Where, access)) && nullsInPlace(access) {
+		access.StopAfter = query.Offset + query.Limit
+	}
+	
+	return access, true
+}
+
+// matchesFields проверяет, что ключи сортировки - это начало списка полей
+// и все они имеют одно направление
+func matchesFields(items []*OrderItem, fields []string) bool {
+	if len(items) == 0 || len(items) > len(fields) {
+		return false
+	}
+	
+	for i, item := range items {
+		field, ok := item.Expr.(*FieldRef)
+		if !ok || field.Path != fields[i] || item.Descending != items[0].Descending {
+			return false
+		}
+	}
+	return true
+}
+
+// nullsInPlace проверяет, что обход индекса размещает NULL так, как требует
+// ORDER BY: NULL - наименьший ключ, поэтому при обходе по возрастанию он
+// встречается первым, а при обходе по убыванию - последним
+func nullsInPlace(node *PlanNode) bool {
+	for i, item := range node.OrderBy {
+		if item.NullsFirst == !item.Descending {
+			continue
+		}
+		// Односторонний или ограниченный снизу диапазон не содержит NULL
+		if i == 0 && len(node.Prefix) < len(node.KeyFields) && !rangeHasNull(node.Range) {
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+// coveredByCompound проверяет, что условие состоит только из сравнений на
+// равенство полей начала ключа и сравнений следующего поля с числами или
+// строками, то есть полностью выражается диапазоном составного индекса
+func coveredByCompound(cond *Condition, node *PlanNode) bool {
+	if len(cond.Children) > 0 {
+		if cond.ChildOp != "AND" {
+			return false
+		}
+		for _, child := range cond.Children {
+			if !coveredByCompound(child, node) {
+				return false
+			}
+		}
+		return true
+	}
+	
+	path, value, ok := fieldComparison(cond)
+	if !ok {
+		return false
+	}
+	switch value.(type) {
+	case int, float64, string:
+	default:
+		return false
+	}
+	
+	for i, field := range node.KeyFields[:len(node.Prefix)] {
+		if path == field {
+			return cond.Operator == "=" && index.Compare(value, node.Prefix[i]) == 0
+		}
+	}
+	
+	if len(node.Prefix) < len(node.KeyFields) && path == node.KeyFields[len(node.Prefix)] {
+		switch cond.Operator {
+		case ">", ">=", "<", "<=":
+			return true
+		}
+	}
+	return false
+}
+
+// compoundEntry - ключ составного индекса и документы с этим ключом
+type compoundEntry struct {
+	key []interface{}
+	ids []string
+}
+
+// compoundIDs возвращает ID документов по узлу плана над составным индексом.
+// При заданном порядке ключи обходятся в порядке ORDER BY
+func compoundIDs(idx index.CompoundIndex, node *PlanNode) []string {
+	descending := len(node.OrderBy) > 0 && node.OrderBy[0].Descending
+	
+	entries := make([]compoundEntry, 0)
+	count := 0
+	idx.ScanPrefix(node.Prefix, node.Range, descending, func(key []interface{}, ids []string) bool {
+		entries = append(entries, compoundEntry{key: key, ids: ids})
+		count += len(ids)
+		return node.StopAfter == 0 || count < node.StopAfter
+	})
+	
+	if len(node.OrderBy) > 0 {
+		entries = placeNulls(entries, len(node.Prefix), node.OrderBy)
+	}
+	
+	result := make([]string, 0, count)
+	for _, entry := range entries {
+		result = append(result, entry.ids...)
+	}
+	return result
+}
+
+// placeNulls переставляет ключи с NULL согласно NULLS FIRST/LAST. Для каждого
+// поля сортировки ключи с NULL в пределах группы с одинаковыми предыдущими
+// полями образуют непрерывный блок, который переносится в начало или в конец группы
+func placeNulls(entries []compoundEntry, start int, items []*OrderItem) []compoundEntry {
+	for level, item := range items {
+		pos := start + level
+		result := make([]compoundEntry, 0, len(entries))
+		
+		for i := 0; i < len(entries); {
+			// Группа ключей с одинаковыми значениями предыдущих полей сортировки
+			j := i + 1
+			for j < len(entries) && samePrefix(entries[i].key, entries[j].key, start, pos) {
+				j++
+			}
+			
+			nulls := make([]compoundEntry, 0)
+			values := make([]compoundEntry, 0, j-i)
+			for _, entry := range entries[i:j] {
+				if entry.key[pos] == nil {
+					nulls = append(nulls, entry)
+				} else {
+					values = append(values, entry)
+				}
+			}
+			
+			if item.NullsFirst {
+				result = append(append(result, nulls...), values...)
+			} else {
+				result = append(append(result, values...), nulls...)
+			}
+			i = j
+		}
+		
+		entries = result
+	}
+	
+	return entries
+}
+
+// samePrefix проверяет, совпадают ли значения ключей на позициях [from, to)
+func samePrefix(a, b []interface{}, from, to int) bool {
+	for i := from; i < to; i++ {
+		if index.Compare(a[i], b[i]) != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// estimateCompound оценивает число документов для узла над составным индексом:
+// каждое условие на равенство оставляет 1/10 документов, диапазон - 1/3 или 1/9
+func estimateCompound(stats index.Statistics, node *PlanNode) int {
+	if node.Type == PlanIndexScan {
+		return stats.Len()
+	}
+	if len(node.Prefix) == len(node.KeyFields) {
+		if stats.KeyCount() == 0 {
+			return 0
+		}
+		return int(math.Ceil(float64(stats.Len()) / float64(stats.KeyCount())))
+	}
+	
+	rows := float64(stats.Len()) * math.Pow(0.1, float64(len(node.Prefix)))
+	switch {
+	case node.Range.Lower != nil && node.Range.Upper != nil:
+		rows /= 9
+	case node.Range.Lower != nil || node.Range.Upper != nil:
+		rows /= 3
+	}
+	return int(math.Ceil(rows))
+}
+
+// describeCompound описывает условия узла над составным индексом
+func (n *PlanNode) describeCompound() string {
+	parts := make([]string, 0, len(n.Prefix)+1)
+	for i, value := range n.Prefix {
+		parts = append(parts, fmt.Sprintf("%s = %s", n.KeyFields[i], formatValue(value)))
+	}
+	if n.Range.Lower != nil || n.Range.Upper != nil {
+		parts = append(parts, fmt.Sprintf("%s %s", n.KeyFields[len(n.Prefix)], formatRange(n.Range)))
+	}
+	
+	result := fmt.Sprintf("%s по составному индексу (%s)", n.Type, strings.Join(n.KeyFields, ", "))
+	if len(parts) > 0 {
+		result += ": " + strings.Join(parts, ", ")
+	}
+	return result + n.describeOrder()
+}
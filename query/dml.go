@@ -0,0 +1,282 @@
+package query
+
+import (
+	"encoding/json"
+
+	"github.com/urusofam/jsondb/storage"
+)
+
+// Statement представляет разобранную инструкцию: *Query, *Insert, *Update или *Delete
+type Statement interface {
+	statement()
+}
+
+func (*Query) statement()  {}
+func (*Insert) statement() {}
+func (*Update) statement() {}
+func (*Delete) statement() {}
+
+// Insert представляет инструкцию INSERT INTO ... VALUES {...}, {...}
+type Insert struct {
+	Into      string
+	Documents []storage.Document
+}
+
+// Update представляет инструкцию UPDATE ... SET ... [WHERE ...]
+type Update struct {
+	Collection string
+	Set        []*Assignment
+	Where      *Condition
+}
+
+// Assignment задает присваивание SET: значение Value вычисляется
+// по документу до изменения и записывается по пути Path
+type Assignment struct {
+	Path  string
+	Value Expr
+}
+
+// Delete представляет инструкцию DELETE FROM ... [WHERE ...]
+type Delete struct {
+	From  string
+	Where *Condition
+}
+
+// ParseStatement разбирает инструкцию SELECT, INSERT, UPDATE или DELETE.
+// Ошибки разбора возвращаются как *ParseError с номером строки и столбца
+func (qp *QueryParser) ParseStatement(queryStr string) (Statement, error) {
+	tokens, err := Tokenize(queryStr)
+	if err != nil {
+		return nil, err
+	}
+	
+	p := &parser{tokens: tokens}
+	return p.parseStatement()
+}
+
+// parseInsert разбирает INSERT INTO ident VALUES object { "," object }.
+// Каждый документ должен содержать строковое поле _id; ошибка для документа
+// без него содержит номер документа в списке VALUES
+func (p *parser) parseInsert() (*Insert, error) {
+	p.next() // INSERT
+	if err := p.expectKeyword("INTO"); err != nil {
+		return nil, err
+	}
+	
+	into, err := p.parseIdent()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expectKeyword("VALUES"); err != nil {
+		return nil, err
+	}
+	
+	stmt := &Insert{Into: into}
+	for {
+		tok := p.peek()
+		if tok.Type != TokenObject {
+			return nil, p.errorf(tok, "ожидался JSON-документ, получено %s", tok)
+		}
+		
+		content, err := p.parseObject()
+		if err != nil {
+			return nil, err
+		}
+		
+		id, ok := content["_id"].(string)
+		if !ok || id == "" {
+			return nil, p.errorf(tok, "документ %d в VALUES должен содержать непустое поле _id строкового типа", len(stmt.Documents)+1)
+		}
+		delete(content, "_id")
+		stmt.Documents = append(stmt.Documents, storage.Document{ID: id, Content: content})
+		
+		if !p.acceptSymbol(",") {
+			return stmt, nil
+		}
+	}
+}
+
+// parseUpdate разбирает UPDATE ident SET assignment { "," assignment } [ WHERE or ]
+func (p *parser) parseUpdate() (*Update, error) {
+	p.next() // UPDATE
+	
+	collection, err := p.parseIdent()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expectKeyword("SET"); err != nil {
+		return nil, err
+	}
+	
+	stmt := &Update{Collection: collection}
+	for {
+		assignment, err := p.parseAssignment()
+		if err != nil {
+			return nil, err
+		}
+		stmt.Set = append(stmt.Set, assignment)
+		
+		if !p.acceptSymbol(",") {
+			break
+		}
+	}
+	
+	if p.acceptKeyword("WHERE") {
+		if stmt.Where, err = p.parseOr(); err != nil {
+			return nil, err
+		}
+	}
+	
+	return stmt, nil
+}
+
+// parseAssignment разбирает присваивание path "=" operand
+func (p *parser) parseAssignment() (*Assignment, error) {
+	pathTok := p.peek()
+	path, err := p.parsePath()
+	if err != nil {
+		return nil, err
+	}
+	if path == "_id" {
+		return nil, p.errorf(pathTok, "поле _id нельзя изменить")
+	}
+	if storage.HasWildcard(path) {
+		return nil, p.errorf(pathTok, "присваивание по пути с [*] не поддерживается: %s", path)
+	}
+	
+	if err := p.expectSymbol("="); err != nil {
+		return nil, err
+	}
+	
+	valueTok := p.peek()
+	value, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	
+	// Значение вычисляется по одному документу
+	var invalid Expr
+	visitExpr(value, func(e Expr) {
+		switch e.(type) {
+		case *Aggregate, *Match:
+			if invalid == nil {
+				invalid = e
+			}
+		}
+	})
+	if invalid != nil {
+		return nil, p.errorf(valueTok, "%s недопустим в SET", invalid)
+	}
+	
+	return &Assignment{Path: path, Value: value}, nil
+}
+
+// parseDelete разбирает DELETE FROM ident [ WHERE or ]
+func (p *parser) parseDelete() (*Delete, error) {
+	p.next() // DELETE
+	if err := p.expectKeyword("FROM"); err != nil {
+		return nil, err
+	}
+	
+	from, err := p.parseIdent()
+	if err != nil {
+		return nil, err
+	}
+	
+	stmt := &Delete{From: from}
+	if p.acceptKeyword("WHERE") {
+		if stmt.Where, err = p.parseOr(); err != nil {
+			return nil, err
+		}
+	}
+	
+	return stmt, nil
+}
+
+// parseObject разбирает JSON-объект в фигурных скобках
+func (p *parser) parseObject() (map[string]interface{}, error) {
+	tok := p.next()
+	
+	var content map[string]interface{}
+	if err := json.Unmarshal([]byte(tok.Text), &content); err != nil {
+		return nil, p.errorf(tok, "неверный JSON-документ: %v", err)
+	}
+	return content, nil
+}
+
+// Select возвращает документы коллекции, удовлетворяющие условию where
+// (все документы, если where равен nil). Документы выбираются по плану
+// запроса, поэтому применимые индексы используются так же, как в SELECT
+func (qe *QueryExecutor) Select(from string, where *Condition) ([]storage.Document, error) {
+	rows, _, err := qe.run(&Query{
+		Select: []*SelectItem{{Expr: &Star{}}},
+		From:   from,
+		Alias:  from,
+		Where:  where,
+		Limit:  -1,
+	})
+	if err != nil {
+		return nil, err
+	}
+	
+	docs := make([]storage.Document, len(rows))
+	for i, row := range rows {
+		docs[i].ID = row["_id"].(string)
+		delete(row, "_id")
+		docs[i].Content = row
+	}
+	return docs, nil
+}
+
+// Assign возвращает копию документа с примененными присваиваниями SET.
+// Все значения вычисляются по исходному документу; отсутствующее поле дает NULL
+func (qe *QueryExecutor) Assign(doc storage.Document, set []*Assignment) (storage.Document, error) {
+	for _, assignment := range set {
+		var err error
+		visitExpr(assignment.Value, func(e Expr) {
+			if call, ok := e.(*FuncCall); ok && err == nil {
+				err = qe.Functions.CheckCall(call.Name, len(call.Args))
+			}
+		})
+		if err != nil {
+			return storage.Document{}, err
+		}
+	}
+	
+	values := make([]interface{}, len(set))
+	for i, assignment := range set {
+		value, _, err := qe.evalExpr(doc, assignment.Value)
+		if err != nil {
+			return storage.Document{}, err
+		}
+		values[i] = copyValue(value)
+	}
+	
+	updated := storage.Document{ID: doc.ID, Content: copyValue(doc.Content).(map[string]interface{})}
+	for i, assignment := range set {
+		if err := storage.SetPath(updated.Content, assignment.Path, values[i]); err != nil {
+			return storage.Document{}, err
+		}
+	}
+	return updated, nil
+}
+
+// copyValue возвращает глубокую копию объектов и массивов значения,
+// чтобы изменение не затронуло документ, хранящийся в коллекции
+func copyValue(v interface{}) interface{} {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(val))
+		for k, item := range val {
+			result[k] = copyValue(item)
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(val))
+		for i, item := range val {
+			result[i] = copyValue(item)
+		}
+		return result
+	}
+	return v
+}
@@ -0,0 +1,407 @@
+package query
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/urusofam/jsondb/index"
+	"github.com/urusofam/jsondb/storage"
+)
+
+// record сохраняет фактическую статистику выполнения узла
+func (n *PlanNode) record(rows int, start time.Time) {
+	n.ActualRows = rows
+	n.Duration = time.Since(start)
+}
+
+// estimate оценивает число документов, которые вернет узел плана доступа.
+// Оценки дочерних узлов сохраняются в них самих
+func (qe *QueryExecutor) estimate(coll Collection, node *PlanNode) int {
+	switch node.Type {
+	case PlanFullScan:
+		if counter, ok := coll.Storage.(storage.Counter); ok {
+			if count, err := counter.Count(); err == nil {
+				return count
+			}
+		}
+		return -1
+	case PlanIDLookup:
+		return 1
+	case PlanIndexLookup, PlanIndexRange, PlanIndexScan:
+		stats, ok := coll.Indexes[node.Field].(index.Statistics)
+		if !ok {
+			return -1
+		}
+		
+		if node.KeyFields != nil {
+			return estimateCompound(stats, node)
+		}
+		if node.Type == PlanIndexScan {
+			return stats.Len()
+		}
+		if node.Type == PlanIndexLookup {
+			if stats.KeyCount() == 0 {
+				return 0
+			}
+			return int(math.Ceil(float64(stats.Len()) / float64(stats.KeyCount())))
+		}
+		
+		// Классические оценки избирательности диапазона: 1/3 для
+		// одностороннего и 1/9 для двустороннего
+		if node.Range.Lower != nil && node.Range.Upper != nil {
+			return int(math.Ceil(float64(stats.Len()) / 9))
+		}
+		return int(math.Ceil(float64(stats.Len()) / 3))
+	case PlanFullText:
+		idx, ok := coll.Indexes[node.Field].(*index.FullTextIndex)
+		if !ok {
+			return -1
+		}
+		return idx.Estimate(node.Value.(string))
+	case PlanIntersect:
+		result := -1
+		for _, child := range node.Children {
+			child.EstimatedRows = qe.estimate(coll, child)
+			if child.EstimatedRows >= 0 && (result < 0 || child.EstimatedRows < result) {
+				result = child.EstimatedRows
+			}
+		}
+		return result
+	case PlanUnion:
+		result := 0
+		for _, child := range node.Children {
+			child.EstimatedRows = qe.estimate(coll, child)
+			if child.EstimatedRows < 0 {
+				result = -1
+			} else if result >= 0 {
+				result += child.EstimatedRows
+			}
+		}
+		return result
+	}
+	
+	return -1
+}
+
+// selectivity оценивает долю документов, удовлетворяющих условию
+func selectivity(cond *Condition) float64 {
+	if len(cond.Children) > 0 {
+		switch cond.ChildOp {
+		case "AND":
+			result := 1.0
+			for _, child := range cond.Children {
+				result *= selectivity(child)
+			}
+			return result
+		case "OR":
+			miss := 1.0
+			for _, child := range cond.Children {
+				miss *= 1 - selectivity(child)
+			}
+			return 1 - miss
+		case "NOT":
+			return 1 - selectivity(cond.Children[0])
+		}
+		return 0.5
+	}
+	
+	switch cond.Operator {
+	case "=", "MATCH":
+		return 0.1
+	case "!=":
+		return 0.9
+	case "IN", "NOT IN":
+		in := 0.5
+		if list, ok := cond.Right.(*List); ok {
+			in = math.Min(1, 0.1*float64(len(list.Items)))
+		}
+		if cond.Operator == "NOT IN" {
+			return 1 - in
+		}
+		return in
+	case ">", ">=", "<", "<=":
+		return 1.0 / 3
+	case "BETWEEN":
+		return 1.0 / 9
+	case "NOT BETWEEN":
+		return 1 - 1.0/9
+	case "LIKE", "ILIKE", "~":
+		return 0.25
+	case "IS NULL", "IS MISSING":
+		return 0.1
+	case "IS NOT NULL", "IS NOT MISSING":
+		return 0.9
+	case "NOT LIKE", "NOT ILIKE":
+		return 0.75
+	}
+	return 0.5
+}
+
+// groupRows оценивает число групп: одна группа без GROUP BY,
+// иначе в среднем по 10 документов на группу
+func groupRows(rows, keys int) int {
+	if keys == 0 {
+		return 1
+	}
+	if rows < 0 {
+		return -1
+	}
+	return int(math.Ceil(float64(rows) / 10))
+}
+
+// limitRows оценивает число строк после применения OFFSET и LIMIT
+func limitRows(rows, limit, offset int) int {
+	if rows < 0 {
+		if limit >= 0 {
+			return limit
+		}
+		return -1
+	}
+	
+	rows -= offset
+	if rows < 0 {
+		rows = 0
+	}
+	if limit >= 0 && limit < rows {
+		rows = limit
+	}
+	return rows
+}
+
+// String возвращает текстовое представление дерева плана
+func (n *PlanNode) String() string {
+	var sb strings.Builder
+	n.format(&sb, "", "")
+	return sb.String()
+}
+
+// Lines возвращает план построчно
+func (n *PlanNode) Lines() []string {
+	return strings.Split(strings.TrimRight(n.String(), "\n"), "\n")
+}
+
+// format выводит узел и его потомков с отступами
+func (n *PlanNode) format(sb *strings.Builder, prefix, childPrefix string) {
+	estimated := "?"
+	if n.EstimatedRows >= 0 {
+		estimated = fmt.Sprint(n.EstimatedRows)
+	}
+	
+	fmt.Fprintf(sb, "%s%s (оценка строк: %s, фактически строк: %d, время: %s)\n",
+		prefix, n.describe(), estimated, n.ActualRows, n.Duration)
+	
+	for i, child := range n.Children {
+		if i == len(n.Children)-1 {
+			child.format(sb, childPrefix+"└─ ", childPrefix+"   ")
+		} else {
+			child.format(sb, childPrefix+"├─ ", childPrefix+"│  ")
+		}
+	}
+}
+
+// describe возвращает описание узла плана
+func (n *PlanNode) describe() string {
+	if n.KeyFields != nil {
+		return n.describeCompound()
+	}
+	
+	switch n.Type {
+	case PlanFullScan:
+		return fmt.Sprintf("FullScan %s", n.Collection)
+	case PlanIDLookup:
+		return fmt.Sprintf("IDLookup _id = %s", formatValue(n.Value))
+	case PlanIndexLookup:
+		return fmt.Sprintf("IndexLookup по индексу %s: = %s", n.Field, formatValue(n.Value))
+	case PlanIndexRange:
+		return fmt.Sprintf("IndexRange по индексу %s: %s%s", n.Field, formatRange(n.Range), n.describeOrder())
+	case PlanFullText:
+		return n.describeMatch()
+	case PlanIndexScan:
+		return fmt.Sprintf("IndexScan по индексу %s%s", n.Field, n.describeOrder())
+	case PlanNestedLoop, PlanHashJoin, PlanIndexJoin:
+		return n.describeJoin()
+	case PlanFilter:
+		return fmt.Sprintf("Filter %s", n.Condition)
+	case PlanGroup:
+		return n.describeGroup()
+	case PlanHaving:
+		return fmt.Sprintf("Having %s", n.Condition)
+	case PlanSort:
+		return fmt.Sprintf("Sort %s", formatOrder(n.OrderBy))
+	case PlanLimit:
+		if n.Limit >= 0 {
+			return fmt.Sprintf("Limit %d offset %d", n.Limit, n.Offset)
+		}
+		return fmt.Sprintf("Offset %d", n.Offset)
+	case PlanProject:
+		return fmt.Sprintf("Project %s", strings.Join(n.Fields, ", "))
+	}
+	
+	return n.Type
+}
+
+// describeGroup описывает ключи группировки и вычисляемые агрегатные функции
+func (n *PlanNode) describeGroup() string {
+	aggregates := make([]string, len(n.Aggregates))
+	for i, agg := range n.Aggregates {
+		aggregates[i] = agg.String()
+	}
+	
+	if len(n.GroupBy) == 0 {
+		return "Aggregate " + strings.Join(aggregates, ", ")
+	}
+	
+	keys := make([]string, len(n.GroupBy))
+	for i, expr := range n.GroupBy {
+		keys[i] = expr.String()
+	}
+	
+	result := "Group by " + strings.Join(keys, ", ")
+	if len(aggregates) > 0 {
+		result += ": " + strings.Join(aggregates, ", ")
+	}
+	return result
+}
+
+// describeOrder описывает порядок обхода индекса, заменяющий сортировку
+func (n *PlanNode) describeOrder() string {
+	if len(n.OrderBy) == 0 {
+		return ""
+	}
+	
+	result := ", порядок " + formatOrder(n.OrderBy)
+	if n.StopAfter > 0 {
+		result += fmt.Sprintf(", первые %d", n.StopAfter)
+	}
+	return result
+}
+
+// formatOrder форматирует список ключей ORDER BY
+func formatOrder(orderBy []*OrderItem) string {
+	parts := make([]string, len(orderBy))
+	for i, item := range orderBy {
+		parts[i] = item.String()
+	}
+	return strings.Join(parts, ", ")
+}
+
+// String возвращает текстовое представление условия
+func (c *Condition) String() string {
+	if len(c.Children) > 0 {
+		parts := make([]string, len(c.Children))
+		for i, child := range c.Children {
+			if len(child.Children) > 0 && child.ChildOp != "NOT" {
+				parts[i] = "(" + child.String() + ")"
+			} else {
+				parts[i] = child.String()
+			}
+		}
+		
+		if c.ChildOp == "NOT" {
+			return "NOT " + parts[0]
+		}
+		return strings.Join(parts, " "+c.ChildOp+" ")
+	}
+	
+	switch c.Operator {
+	case "MATCH":
+		return c.Left.String()
+	case "EXISTS":
+		return "EXISTS " + c.Right.String()
+	case "BETWEEN", "NOT BETWEEN":
+		bounds := c.Right.(*List).Items
+		return fmt.Sprintf("%s %s %s AND %s", c.Left, c.Operator, bounds[0], bounds[1])
+	case "IS NULL", "IS NOT NULL", "IS MISSING", "IS NOT MISSING":
+		return c.Left.String() + " " + c.Operator
+	}
+	return fmt.Sprintf("%s %s %s", c.Left, c.Operator, c.Right)
+}
+
+// String возвращает текстовую запись запроса SELECT
+func (q *Query) String() string {
+	var sb strings.Builder
+	if q.Explain {
+		sb.WriteString("EXPLAIN ")
+	}
+	
+	columns := make([]string, len(q.Select))
+	for i, item := range q.Select {
+		columns[i] = item.Expr.String()
+		if item.Alias != "" {
+			columns[i] += " AS " + item.Alias
+		}
+	}
+	sb.WriteString("SELECT " + strings.Join(columns, ", ") + " FROM " + q.From)
+	if q.Alias != "" && q.Alias != q.From {
+		sb.WriteString(" " + q.Alias)
+	}
+	
+	for _, join := range q.Joins {
+		sb.WriteString(" " + join.String())
+	}
+	if q.Where != nil {
+		sb.WriteString(" WHERE " + q.Where.String())
+	}
+	if len(q.GroupBy) > 0 {
+		keys := make([]string, len(q.GroupBy))
+		for i, expr := range q.GroupBy {
+			keys[i] = expr.String()
+		}
+		sb.WriteString(" GROUP BY " + strings.Join(keys, ", "))
+	}
+	if q.Having != nil {
+		sb.WriteString(" HAVING " + q.Having.String())
+	}
+	if len(q.OrderBy) > 0 {
+		sb.WriteString(" ORDER BY " + formatOrder(q.OrderBy))
+	}
+	if q.Limit >= 0 {
+		fmt.Fprintf(&sb, " LIMIT %d", q.Limit)
+	}
+	if q.Offset > 0 {
+		fmt.Fprintf(&sb, " OFFSET %d", q.Offset)
+	}
+	
+	return sb.String()
+}
+
+// formatValue форматирует значение для вывода в плане
+func formatValue(v interface{}) string {
+	switch val := v.(type) {
+	case nil:
+		return "NULL"
+	case string:
+		return "'" + strings.ReplaceAll(val, "'", "''") + "'"
+	case map[string]interface{}:
+		if data, err := json.Marshal(val); err == nil {
+			return string(data)
+		}
+	}
+	return fmt.Sprint(v)
+}
+
+// formatRange форматирует диапазон индекса в виде интервала
+func formatRange(r index.Range) string {
+	lower, upper := "(-∞", "+∞)"
+	
+	if r.Lower != nil {
+		bracket := "("
+		if r.Lower.Inclusive {
+			bracket = "["
+		}
+		lower = bracket + formatValue(r.Lower.Value)
+	}
+	
+	if r.Upper != nil {
+		bracket := ")"
+		if r.Upper.Inclusive {
+			bracket = "]"
+		}
+		upper = formatValue(r.Upper.Value) + bracket
+	}
+	
+	return lower + ", " + upper
+}
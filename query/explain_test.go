@@ -0,0 +1,99 @@
+package query
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/urusofam/jsondb/storage"
+)
+
+// durations совпадает со временем выполнения этапа в строке плана
+var durations = regexp.MustCompile(`, время: [^)]*\)`)
+
+// explain выполняет запрос EXPLAIN и возвращает план без времени выполнения этапов
+func explain(t *testing.T, qe *QueryExecutor, q string) string {
+	t.Helper()
+	
+	lines := make([]string, 0)
+	for _, row := range mustRows(t, qe, q) {
+		lines = append(lines, durations.ReplaceAllString(row["plan"].(string), ")"))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func TestExplain(t *testing.T) {
+	indexed, _ := plannerExecutors(t, plannerDocs(), "age", "city")
+	
+	for q, want := range map[string]string{
+		"EXPLAIN SELECT _id FROM t WHERE age > 25 AND age <= 40 AND city = 'c1' LIMIT 3": `Project _id (оценка строк: 3, фактически строк: 3)
+└─ Limit 3 offset 0 (оценка строк: 3, фактически строк: 3)
+   └─ Filter age > 25 AND age <= 40 AND city = 'c1' (оценка строк: 21, фактически строк: 7)
+      └─ Intersect (оценка строк: 21, фактически строк: 7)
+         ├─ IndexLookup по индексу city: = 'c1' (оценка строк: 40, фактически строк: 40)
+         └─ IndexRange по индексу age: (25, 40] (оценка строк: 21, фактически строк: 37)`,
+		"explain SELECT * FROM t WHERE name = 'x' OR age < 3": `Project * (оценка строк: 79, фактически строк: 7)
+└─ Filter name = 'x' OR age < 3 (оценка строк: 79, фактически строк: 7)
+   └─ FullScan t (оценка строк: 200, фактически строк: 200)`,
+		"EXPLAIN SELECT city, COUNT(*) AS n FROM t WHERE age >= 50 GROUP BY city HAVING COUNT(*) > 1": `Project city, n (оценка строк: 2, фактически строк: 5)
+└─ Having COUNT(*) > 1 (оценка строк: 2, фактически строк: 5)
+   └─ Group by city: COUNT(*) (оценка строк: 7, фактически строк: 5)
+      └─ Filter age >= 50 (оценка строк: 63, фактически строк: 23)
+         └─ IndexRange по индексу age: [50, +∞) (оценка строк: 63, фактически строк: 23)`,
+		"EXPLAIN SELECT _id FROM t WHERE _id = 'd007'": `Project _id (оценка строк: 1, фактически строк: 1)
+└─ Filter _id = 'd007' (оценка строк: 1, фактически строк: 1)
+   └─ IDLookup _id = 'd007' (оценка строк: 1, фактически строк: 1)`,
+	} {
+		if got := explain(t, indexed, q); got != want {
+			t.Errorf("%s:\n%s\nожидалось:\n%s", q, got, want)
+		}
+	}
+}
+
+func TestExplainOrderedIndexScan(t *testing.T) {
+	docs := make([]storage.Document, 50)
+	for i := range docs {
+		docs[i] = storage.Document{ID: fmt.Sprint("d", i), Content: map[string]interface{}{"age": float64(i)}}
+	}
+	indexed, _ := plannerExecutors(t, docs, "age")
+	
+	for q, want := range map[string]string{
+		"EXPLAIN SELECT _id FROM t ORDER BY age DESC NULLS LAST LIMIT 2 OFFSET 1": `Project _id (оценка строк: 2, фактически строк: 2)
+└─ Limit 2 offset 1 (оценка строк: 2, фактически строк: 2)
+   └─ IndexScan по индексу age, порядок age DESC NULLS LAST, первые 3 (оценка строк: 50, фактически строк: 3)`,
+		// NULL при обходе по убыванию встречаются последними, поэтому обход не прерывается
+		"EXPLAIN SELECT _id FROM t ORDER BY age DESC LIMIT 2": `Project _id (оценка строк: 2, фактически строк: 2)
+└─ Limit 2 offset 0 (оценка строк: 2, фактически строк: 2)
+   └─ IndexScan по индексу age, порядок age DESC NULLS FIRST, первые 2 (оценка строк: 50, фактически строк: 50)`,
+	} {
+		if got := explain(t, indexed, q); got != want {
+			t.Errorf("%s:\n%s\nожидалось:\n%s", q, got, want)
+		}
+	}
+}
+
+func TestExplainCountsMatchResult(t *testing.T) {
+	indexed, _ := plannerExecutors(t, plannerDocs(), "age", "city")
+	
+	for _, q := range []string{
+		"SELECT * FROM t WHERE city = 'c1' OR age < 3",
+		"SELECT _id FROM t WHERE age >= 10 AND age <= 20 ORDER BY age LIMIT 4",
+		"SELECT city, COUNT(*) AS n FROM t GROUP BY city",
+	} {
+		parsed, err := NewQueryParser().Parse(q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		plan, err := indexed.Explain(parsed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rows := mustRows(t, indexed, q); plan.ActualRows != len(rows) {
+			t.Errorf("%s: в плане %d строк, запрос вернул %d", q, plan.ActualRows, len(rows))
+		}
+		if lines := plan.Lines(); len(lines) != strings.Count(plan.String(), "\n") {
+			t.Errorf("%s: %d строк плана, в тексте плана %d", q, len(lines), strings.Count(plan.String(), "\n"))
+		}
+	}
+}
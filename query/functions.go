@@ -1,9 +1,14 @@
 package query
 
 import (
+	"fmt"
 	"math"
+	"reflect"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -192,13 +197,502 @@ type FunctionRegistry struct {
 	StringFunctions *StringFunctions
 	NumberFunctions *NumberFunctions
 	DateFunctions   *DateFunctions
+	
+	// functions сопоставляет имена функций языка запросов с их реализациями
+	functions map[string]*Function
+	mu        sync.RWMutex
 }
 
 // NewFunctionRegistry создает новый реестр функций
 func NewFunctionRegistry() *FunctionRegistry {
-	return &FunctionRegistry{
+	r := &FunctionRegistry{
 		StringFunctions: NewStringFunctions(),
 		NumberFunctions: NewNumberFunctions(),
 		DateFunctions:   NewDateFunctions(),
+		functions:       make(map[string]*Function),
+	}
+	
+	r.registerBuiltins()
+	
+	return r
+}
+
+// ArgType определяет тип, к которому приводится аргумент функции
+type ArgType int
+
+const (
+	// ArgAny - значение передается без преобразования
+	ArgAny ArgType = iota
+	// ArgString - строка; числа и логические значения преобразуются в текст
+	ArgString
+	// ArgNumber - число с плавающей точкой; строки разбираются как числа
+	ArgNumber
+	// ArgInt - целое число; дробные значения не допускаются
+	ArgInt
+	// ArgBool - логическое значение
+	ArgBool
+	// ArgTime - дата и время; строки разбираются в форматах RFC 3339 и 2006-01-02
+	ArgTime
+)
+
+// String возвращает название типа для сообщений об ошибках
+func (t ArgType) String() string {
+	switch t {
+	case ArgString:
+		return "строка"
+	case ArgNumber:
+		return "число"
+	case ArgInt:
+		return "целое число"
+	case ArgBool:
+		return "логическое значение"
+	case ArgTime:
+		return "дата"
+	}
+	return "любое значение"
+}
+
+// Function описывает скалярную функцию языка запросов
+type Function struct {
+	Name    string
+	Args    []ArgType
+	Result  ArgType
+	Builtin bool
+	
+	// Call вызывается с аргументами, уже приведенными к типам Args
+	Call func(args []interface{}) (interface{}, error)
+}
+
+// Signature возвращает сигнатуру функции, например "ROUND(число) число"
+func (f *Function) Signature() string {
+	args := make([]string, len(f.Args))
+	for i, arg := range f.Args {
+		args[i] = arg.String()
+	}
+	return fmt.Sprintf("%s(%s) %s", f.Name, strings.Join(args, ", "), f.Result)
+}
+
+// Lookup возвращает функцию по имени без учета регистра
+func (r *FunctionRegistry) Lookup(name string) (*Function, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	
+	fn, ok := r.functions[strings.ToUpper(name)]
+	return fn, ok
+}
+
+// List возвращает зарегистрированные функции, упорядоченные по имени
+func (r *FunctionRegistry) List() []*Function {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	
+	result := make([]*Function, 0, len(r.functions))
+	for _, fn := range r.functions {
+		result = append(result, fn)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+// CheckCall проверяет, что функция существует и принимает argc аргументов
+func (r *FunctionRegistry) CheckCall(name string, argc int) error {
+	fn, ok := r.Lookup(name)
+	if !ok {
+		return fmt.Errorf("неизвестная функция %s", strings.ToUpper(name))
+	}
+	if len(fn.Args) != argc {
+		return fmt.Errorf("функция %s ожидает аргументов: %d, передано: %d", fn.Name, len(fn.Args), argc)
+	}
+	return nil
+}
+
+// Call приводит аргументы к типам параметров и вызывает функцию.
+// Если любой аргумент равен NULL, результатом будет NULL
+func (r *FunctionRegistry) Call(name string, args []interface{}) (result interface{}, err error) {
+	if err := r.CheckCall(name, len(args)); err != nil {
+		return nil, err
+	}
+	fn, _ := r.Lookup(name)
+	
+	// Сигнатура зарегистрированной функции проверена, поэтому преобразования
+	// типов при вызове не паникуют; паника возможна только в коде самой функции
+	defer func() {
+		if p := recover(); p != nil {
+			result, err = nil, fmt.Errorf("функция %s: %v", fn.Name, p)
+		}
+	}()
+	
+	converted := make([]interface{}, len(args))
+	for i, arg := range args {
+		if arg == nil {
+			return nil, nil
+		}
+		
+		value, err := coerceArg(arg, fn.Args[i])
+		if err != nil {
+			return nil, fmt.Errorf("функция %s, аргумент %d: %v", fn.Name, i+1, err)
+		}
+		converted[i] = value
+	}
+	
+	result, err = fn.Call(converted)
+	if err != nil {
+		return nil, fmt.Errorf("функция %s: %v", fn.Name, err)
+	}
+	return result, nil
+}
+
+// define добавляет встроенную функцию в реестр
+func (r *FunctionRegistry) define(name string, args []ArgType, result ArgType, call func(args []interface{}) (interface{}, error)) {
+	r.functions[name] = &Function{Name: name, Args: args, Result: result, Builtin: true, Call: call}
+}
+
+// registerBuiltins регистрирует строковые, числовые функции и функции для работы с датами
+func (r *FunctionRegistry) registerBuiltins() {
+	sf, nf, df := r.StringFunctions, r.NumberFunctions, r.DateFunctions
+	
+	// Строковые функции
+	r.define("LENGTH", []ArgType{ArgString}, ArgInt, func(a []interface{}) (interface{}, error) {
+		return sf.Length(a[0].(string)), nil
+	})
+	r.define("UPPER", []ArgType{ArgString}, ArgString, func(a []interface{}) (interface{}, error) {
+		return sf.ToUpper(a[0].(string)), nil
+	})
+	r.define("LOWER", []ArgType{ArgString}, ArgString, func(a []interface{}) (interface{}, error) {
+		return sf.ToLower(a[0].(string)), nil
+	})
+	r.define("SUBSTRING", []ArgType{ArgString, ArgInt, ArgInt}, ArgString, func(a []interface{}) (interface{}, error) {
+		return sf.Substring(a[0].(string), a[1].(int), a[2].(int)), nil
+	})
+	r.define("REPLACE", []ArgType{ArgString, ArgString, ArgString}, ArgString, func(a []interface{}) (interface{}, error) {
+		return sf.Replace(a[0].(string), a[1].(string), a[2].(string)), nil
+	})
+	r.define("REGEXP_MATCH", []ArgType{ArgString, ArgString}, ArgBool, func(a []interface{}) (interface{}, error) {
+		return sf.Match(a[0].(string), a[1].(string)), nil
+	})
+	
+	// Числовые функции
+	unary := map[string]func(float64) float64{
+		"ABS":   nf.Abs,
+		"ROUND": nf.Round,
+		"CEIL":  nf.Ceil,
+		"FLOOR": nf.Floor,
+		"SQRT":  nf.Sqrt,
+	}
+	for name, f := range unary {
+		f := f
+		r.define(name, []ArgType{ArgNumber}, ArgNumber, func(a []interface{}) (interface{}, error) {
+			return f(a[0].(float64)), nil
+		})
+	}
+	
+	binary := map[string]func(float64, float64) float64{
+		"POW":      nf.Pow,
+		"LEAST":    nf.Min,
+		"GREATEST": nf.Max,
+	}
+	for name, f := range binary {
+		f := f
+		r.define(name, []ArgType{ArgNumber, ArgNumber}, ArgNumber, func(a []interface{}) (interface{}, error) {
+			return f(a[0].(float64), a[1].(float64)), nil
+		})
+	}
+	
+	// Функции для работы с датами
+	r.define("DATE_PARSE", []ArgType{ArgString, ArgString}, ArgTime, func(a []interface{}) (interface{}, error) {
+		return df.Parse(a[0].(string), a[1].(string))
+	})
+	r.define("DATE_FORMAT", []ArgType{ArgTime, ArgString}, ArgString, func(a []interface{}) (interface{}, error) {
+		return df.Format(a[0].(time.Time), a[1].(string)), nil
+	})
+	r.define("NOW", []ArgType{}, ArgTime, func(a []interface{}) (interface{}, error) {
+		return df.Now(), nil
+	})
+	
+	shifts := map[string]func(time.Time, int) time.Time{
+		"ADD_DAYS":   df.AddDays,
+		"ADD_MONTHS": df.AddMonths,
+		"ADD_YEARS":  df.AddYears,
+	}
+	for name, f := range shifts {
+		f := f
+		r.define(name, []ArgType{ArgTime, ArgInt}, ArgTime, func(a []interface{}) (interface{}, error) {
+			return f(a[0].(time.Time), a[1].(int)), nil
+		})
+	}
+	
+	intervals := map[string]func(time.Time, time.Time) int{
+		"DAYS_BETWEEN":   df.DaysBetween,
+		"MONTHS_BETWEEN": df.MonthsBetween,
+		"YEARS_BETWEEN":  df.YearsBetween,
+	}
+	for name, f := range intervals {
+		f := f
+		r.define(name, []ArgType{ArgTime, ArgTime}, ArgInt, func(a []interface{}) (interface{}, error) {
+			return f(a[0].(time.Time), a[1].(time.Time)), nil
+		})
+	}
+}
+
+// Register добавляет пользовательскую функцию, доступную в запросах под именем name.
+// fn должна быть функцией Go с параметрами строкового, логического, целого или
+// вещественного типа (в том числе именованного), time.Time или interface{} и возвращать
+// одно значение такого типа либо пару (значение, error).
+// Аргументы запроса приводятся к типам параметров по тем же правилам, что и для встроенных функций
+func (r *FunctionRegistry) Register(name string, fn interface{}) error {
+	name = strings.ToUpper(name)
+	if err := validateFunctionName(name); err != nil {
+		return err
+	}
+	
+	v := reflect.ValueOf(fn)
+	if v.Kind() != reflect.Func || v.IsNil() {
+		return fmt.Errorf("функция %s: ожидалась функция Go, получено %T", name, fn)
+	}
+	
+	t := v.Type()
+	if t.IsVariadic() {
+		return fmt.Errorf("функция %s: переменное число аргументов не поддерживается", name)
+	}
+	
+	args := make([]ArgType, t.NumIn())
+	for i := range args {
+		argType, ok := goArgType(t.In(i))
+		if !ok {
+			return fmt.Errorf("функция %s: неподдерживаемый тип параметра %d: %s", name, i+1, t.In(i))
+		}
+		args[i] = argType
+	}
+	
+	if t.NumOut() == 0 || t.NumOut() > 2 {
+		return fmt.Errorf("функция %s: должна возвращать значение или пару (значение, error)", name)
+	}
+	result, ok := goArgType(t.Out(0))
+	if !ok {
+		return fmt.Errorf("функция %s: неподдерживаемый тип результата: %s", name, t.Out(0))
+	}
+	if t.NumOut() == 2 && t.Out(1) != reflect.TypeOf((*error)(nil)).Elem() {
+		return fmt.Errorf("функция %s: второй результат должен иметь тип error, получено %s", name, t.Out(1))
+	}
+	
+	call := func(a []interface{}) (interface{}, error) {
+		in := make([]reflect.Value, len(a))
+		// Аргументы уже приведены coerceArg и не равны nil: при NULL среди
+		// аргументов функция не вызывается
+		for i, arg := range a {
+			if n, ok := arg.(int); ok && !fitsInt(n, t.In(i)) {
+				return nil, fmt.Errorf("функция %s: аргумент %d: значение %d не помещается в тип %s", name, i+1, n, t.In(i))
+			}
+			in[i] = reflect.ValueOf(arg).Convert(t.In(i))
+		}
+		
+		out := v.Call(in)
+		if len(out) == 2 && !out[1].IsNil() {
+			return nil, out[1].Interface().(error)
+		}
+		return normalizeResult(out[0].Interface()), nil
+	}
+	
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	
+	if existing, ok := r.functions[name]; ok {
+		if existing.Builtin {
+			return fmt.Errorf("функция %s является встроенной и не может быть переопределена", name)
+		}
+		return fmt.Errorf("функция %s уже зарегистрирована", name)
+	}
+	
+	r.functions[name] = &Function{Name: name, Args: args, Result: result, Call: call}
+	return nil
+}
+
+// Unregister удаляет пользовательскую функцию
+func (r *FunctionRegistry) Unregister(name string) error {
+	name = strings.ToUpper(name)
+	
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	
+	fn, ok := r.functions[name]
+	if !ok {
+		return fmt.Errorf("неизвестная функция %s", name)
+	}
+	if fn.Builtin {
+		return fmt.Errorf("функция %s является встроенной и не может быть удалена", name)
+	}
+	
+	delete(r.functions, name)
+	return nil
+}
+
+// validateFunctionName проверяет, что имя функции можно записать в запросе
+func validateFunctionName(name string) error {
+	if name == "" {
+		return fmt.Errorf("имя функции не может быть пустым")
+	}
+	
+	for i, c := range name {
+		if c != '_' && !(c >= 'A' && c <= 'Z') && !(i > 0 && c >= '0' && c <= '9') {
+			return fmt.Errorf("недопустимое имя функции %q: разрешены латинские буквы, цифры и _", name)
+		}
+	}
+	
+	if reservedWords[name] || aggregateFunctions[name] || name == "MATCH" || name == "COALESCE" {
+		return fmt.Errorf("имя %s зарезервировано языком запросов", name)
+	}
+	return nil
+}
+
+// fitsInt проверяет, что целое значение представимо в целом типе t без переполнения
+func fitsInt(n int, t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return !reflect.Zero(t).OverflowInt(int64(n))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return n >= 0 && !reflect.Zero(t).OverflowUint(uint64(n))
+	}
+	return true
+}
+
+// goArgType сопоставляет тип Go с типом аргумента функции
+func goArgType(t reflect.Type) (ArgType, bool) {
+	if t == reflect.TypeOf(time.Time{}) {
+		return ArgTime, true
+	}
+	
+	switch t.Kind() {
+	case reflect.String:
+		return ArgString, true
+	case reflect.Bool:
+		return ArgBool, true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return ArgInt, true
+	case reflect.Float32, reflect.Float64:
+		return ArgNumber, true
+	case reflect.Interface:
+		if t.NumMethod() == 0 {
+			return ArgAny, true
+		}
+	}
+	return 0, false
+}
+
+// normalizeResult приводит результат пользовательской функции к типам,
+// с которыми работает исполнитель запросов: строки, логические значения и числа,
+// в том числе именованных типов, - к string, bool, int и float64.
+// Целые, не помещающиеся в int, приводятся к float64
+func normalizeResult(v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+	
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.String:
+		return rv.String()
+	case reflect.Bool:
+		return rv.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if n := rv.Int(); n >= math.MinInt && n <= math.MaxInt {
+			return int(n)
+		}
+		return float64(rv.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if n := rv.Uint(); n <= math.MaxInt {
+			return int(n)
+		}
+		return float64(rv.Uint())
+	case reflect.Float32, reflect.Float64:
+		return rv.Float()
+	}
+	return v
+}
+
+// timeLayouts содержит форматы, в которых строки приводятся к датам
+var timeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+// coerceArg приводит значение аргумента к типу параметра функции
+func coerceArg(v interface{}, t ArgType) (interface{}, error) {
+	switch t {
+	case ArgString:
+		switch val := v.(type) {
+		case string:
+			return val, nil
+		case float64:
+			return strconv.FormatFloat(val, 'f', -1, 64), nil
+		case int:
+			return strconv.Itoa(val), nil
+		case bool:
+			return strconv.FormatBool(val), nil
+		case time.Time:
+			return val.Format(time.RFC3339), nil
+		}
+	case ArgNumber:
+		switch val := v.(type) {
+		case float64:
+			return val, nil
+		case int:
+			return float64(val), nil
+		case string:
+			if f, err := strconv.ParseFloat(strings.TrimSpace(val), 64); err == nil {
+				return f, nil
+			}
+		}
+	case ArgInt:
+		switch val := v.(type) {
+		case int:
+			return val, nil
+		case float64:
+			// float64(math.MaxInt) округляется вверх до степени двойки,
+			// поэтому верхняя граница строгая
+			if val == math.Trunc(val) && val >= math.MinInt && val < math.MaxInt {
+				return int(val), nil
+			}
+		case string:
+			if i, err := strconv.Atoi(strings.TrimSpace(val)); err == nil {
+				return i, nil
+			}
+		}
+	case ArgBool:
+		switch val := v.(type) {
+		case bool:
+			return val, nil
+		case string:
+			if b, err := strconv.ParseBool(val); err == nil {
+				return b, nil
+			}
+		}
+	case ArgTime:
+		switch val := v.(type) {
+		case time.Time:
+			return val, nil
+		case string:
+			if t, ok := parseTime(val); ok {
+				return t, nil
+			}
+		}
+	default:
+		return v, nil
+	}
+	
+	return nil, fmt.Errorf("значение %v нельзя привести к типу \"%s\"", formatValue(v), t)
+}
+
+// parseTime разбирает строку даты в одном из поддерживаемых форматов
+func parseTime(s string) (time.Time, bool) {
+	for _, layout := range timeLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
 	}
+	return time.Time{}, false
 }
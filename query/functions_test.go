@@ -0,0 +1,169 @@
+package query
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/urusofam/jsondb/storage"
+)
+
+type currencyCode string
+
+type level int32
+
+type ratio float32
+
+type flag bool
+
+func TestRegisterFunction(t *testing.T) {
+	qe := testExecutor(t, "items",
+		storage.Document{ID: "a", Content: map[string]interface{}{"name": "x", "price": 10.0, "cur": "USD"}},
+		storage.Document{ID: "b", Content: map[string]interface{}{"name": "y", "price": 5.0, "cur": "EUR"}},
+	)
+	r := qe.Functions
+	must := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	
+	must(r.Register("convert", func(amount float64, from, to string) (float64, error) {
+		rates := map[string]float64{"USD": 1, "EUR": 3, "RUB": 0.5}
+		a, ok := rates[from]
+		b, ok2 := rates[to]
+		if !ok || !ok2 {
+			return 0, errors.New("неизвестная валюта")
+		}
+		return amount * a / b, nil
+	}))
+	must(r.Register("twice", func(n int64) int64 { return n * 2 }))
+	must(r.Register("yr", func(t time.Time) int { return t.Year() }))
+	must(r.Register("boom", func(v interface{}) interface{} { panic("сбой") }))
+	
+	if got := mustRun(t, qe, "SELECT name, CONVERT(price, cur, 'RUB') AS rub, TWICE(3) AS t FROM items ORDER BY rub"); got != "[map[name:x rub:20 t:6] map[name:y rub:30 t:6]]" {
+		t.Fatal(got)
+	}
+	if got := mustRun(t, qe, "SELECT _id FROM items WHERE twice(price) = 20"); got != "[map[_id:a]]" {
+		t.Fatal(got)
+	}
+	if got := mustRun(t, qe, "SELECT YR('2020-05-01') AS y FROM items LIMIT 1"); got != "[map[y:2020]]" {
+		t.Fatal(got)
+	}
+	
+	for _, q := range []string{
+		"SELECT CONVERT(price, cur, 'XXX') FROM items",
+		"SELECT BOOM(name) FROM items",
+		"SELECT TWICE(1.5) FROM items",
+	} {
+		if _, err := run(qe, q); err == nil {
+			t.Fatalf("%s: ожидалась ошибка", q)
+		}
+	}
+	
+	must(r.Unregister("twice"))
+	if _, err := run(qe, "SELECT TWICE(1) FROM items"); err == nil {
+		t.Fatal("удаленная функция доступна в запросах")
+	}
+	if err := r.Unregister("lower"); err == nil {
+		t.Fatal("встроенная функция удалена")
+	}
+}
+
+func TestRegisterFunctionRejectsInvalid(t *testing.T) {
+	r := NewFunctionRegistry()
+	for _, fn := range []interface{}{
+		1,
+		func(...int) int { return 0 },
+		func() {},
+		func(x []int) int { return 0 },
+		func() (int, int) { return 0, 0 },
+		func(x complex64) int { return 0 },
+	} {
+		if err := r.Register("f", fn); err == nil {
+			t.Fatalf("функция %T зарегистрирована", fn)
+		}
+	}
+	for _, name := range []string{"lower", "count", "select", "coalesce", "1x", "a-b", ""} {
+		if err := r.Register(name, func() int { return 1 }); err == nil {
+			t.Fatalf("функция с именем %q зарегистрирована", name)
+		}
+	}
+	if err := r.Register("one", func() int { return 1 }); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Register("ONE", func() int { return 2 }); err == nil {
+		t.Fatal("функция зарегистрирована повторно")
+	}
+}
+
+func TestRegisterFunctionNormalizesNamedTypes(t *testing.T) {
+	qe := testExecutor(t, "items",
+		storage.Document{ID: "a", Content: map[string]interface{}{"cur": "usd", "n": 3.0}},
+		storage.Document{ID: "b", Content: map[string]interface{}{"cur": "eur", "n": 300.0}},
+	)
+	r := qe.Functions
+	for name, fn := range map[string]interface{}{
+		"CODE":  func(s currencyCode) currencyCode { return currencyCode(strings.ToUpper(string(s))) },
+		"LEVEL": func(n level) level { return n + 1 },
+		"SMALL": func(n uint8) uint16 { return uint16(n) * 2 },
+		"HALF":  func(x ratio) ratio { return x / 2 },
+		"BIG":   func(n uint) flag { return n > 10 },
+		"ANY":   func(v interface{}) interface{} { return int32(7) },
+		"HUGE":  func(n int) uint64 { return math.MaxUint64 - uint64(n) },
+		"WIDE":  func(n int64) int64 { return n },
+	} {
+		if err := r.Register(name, fn); err != nil {
+			t.Fatal(err)
+		}
+	}
+	
+	// Результаты именованных и нестандартных типов сравниваются как обычные значения
+	if got := mustRun(t, qe, "SELECT _id FROM items WHERE CODE(cur) = 'USD'"); got != "[map[_id:a]]" {
+		t.Fatal(got)
+	}
+	if got := mustRun(t, qe, "SELECT _id FROM items WHERE LEVEL(n) = 4 AND SMALL(n) = 6 AND HALF(n) = 1.5 AND ANY(n) = 7"); got != "[map[_id:a]]" {
+		t.Fatal(got)
+	}
+	if got := mustRun(t, qe, "SELECT _id FROM items WHERE BIG(n) = true"); got != "[map[_id:b]]" {
+		t.Fatal(got)
+	}
+	if got := mustRun(t, qe, "SELECT CODE(cur) AS c, LEVEL(n) AS l, HALF(n) AS h FROM items WHERE _id = 'a'"); got != "[map[c:USD h:1.5 l:4]]" {
+		t.Fatal(got)
+	}
+	
+	rows, err := run(qe, "SELECT CODE(cur) AS c, LEVEL(n) AS l, HALF(n) AS h, BIG(n) AS b FROM items WHERE _id = 'a'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for field, want := range map[string]interface{}{"c": "USD", "l": 4, "h": 1.5, "b": false} {
+		if got := rows[0][field]; got != want {
+			t.Fatalf("%s: %#v, ожидалось %#v", field, got, want)
+		}
+	}
+	
+	// Целый результат, не помещающийся в int, возвращается как float64
+	rows, err = run(qe, "SELECT HUGE(0) AS h FROM items WHERE _id = 'a'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := rows[0]["h"]; got != float64(math.MaxUint64) {
+		t.Fatalf("HUGE(0): %#v, ожидалось %v", got, float64(math.MaxUint64))
+	}
+	
+	// Значение, не помещающееся в тип параметра, дает ошибку, а не переполнение
+	for _, q := range []string{
+		"SELECT SMALL(n) FROM items WHERE _id = 'b'",
+		"SELECT BIG(-1) FROM items",
+		"SELECT WIDE(100000000000000000000) FROM items",
+		"SELECT WIDE(-100000000000000000000) FROM items",
+		"SELECT WIDE(9223372036854775808) FROM items",
+	} {
+		if _, err := run(qe, q); err == nil {
+			t.Fatalf("%s: ожидалась ошибка", q)
+		}
+	}
+}
@@ -0,0 +1,575 @@
+package query
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/urusofam/jsondb/index"
+	"github.com/urusofam/jsondb/storage"
+)
+
+// Типы узлов соединения коллекций
+const (
+	// PlanNestedLoop - соединение вложенными циклами: условие ON проверяется
+	// для каждой пары строк
+	PlanNestedLoop = "NestedLoopJoin"
+	// PlanHashJoin - соединение по равенству через хеш-таблицу, построенную
+	// по присоединяемой коллекции
+	PlanHashJoin = "HashJoin"
+	// PlanIndexJoin - соединение вложенными циклами с поиском пары
+	// по индексу присоединяемой коллекции или по _id
+	PlanIndexJoin = "IndexNestedLoopJoin"
+)
+
+// Join описывает присоединение коллекции: INNER JOIN или LEFT JOIN ... ON ...
+// LEFT JOIN сохраняет строку без пары, в ней поля коллекции Alias отсутствуют
+type Join struct {
+	Left       bool
+	Collection string
+	Alias      string
+	On         *Condition
+}
+
+// String возвращает запись соединения
+func (j *Join) String() string {
+	kind := "JOIN"
+	if j.Left {
+		kind = "LEFT JOIN"
+	}
+	return fmt.Sprintf("%s %s %s ON %s", kind, j.Collection, j.Alias, j.On)
+}
+
+// parseJoin разбирает [ INNER ] JOIN или LEFT [ OUTER ] JOIN с условием ON
+func (p *parser) parseJoin() (*Join, error) {
+	join := &Join{}
+	switch {
+	case p.acceptKeyword("LEFT"):
+		join.Left = true
+		p.acceptKeyword("OUTER")
+	default:
+		p.acceptKeyword("INNER")
+	}
+	if err := p.expectKeyword("JOIN"); err != nil {
+		return nil, err
+	}
+	
+	var err error
+	if join.Collection, join.Alias, err = p.parseSource(); err != nil {
+		return nil, err
+	}
+	
+	if err := p.expectKeyword("ON"); err != nil {
+		return nil, err
+	}
+	if join.On, err = p.parseOr(); err != nil {
+		return nil, err
+	}
+	return join, nil
+}
+
+// parseSource разбирает коллекцию с необязательным псевдонимом: ident [ [ AS ] ident ].
+// Без псевдонима коллекция обозначается своим именем
+func (p *parser) parseSource() (string, string, error) {
+	name, err := p.parseIdent()
+	if err != nil {
+		return "", "", err
+	}
+	
+	tok := p.peek()
+	explicit := p.acceptKeyword("AS")
+	if explicit || tok.Type == TokenQuotedIdent || (tok.Type == TokenIdent && !reservedWords[strings.ToUpper(tok.Text)]) {
+		alias, err := p.parseIdent()
+		if err != nil {
+			return "", "", err
+		}
+		return name, alias, nil
+	}
+	return name, name, nil
+}
+
+// isJoin проверяет, начинается ли с текущей лексемы соединение
+func (p *parser) isJoin() bool {
+	return p.isKeyword("JOIN") || p.isKeyword("INNER") || p.isKeyword("LEFT")
+}
+
+// aliases возвращает псевдонимы коллекций запроса в порядке соединения
+func (q *Query) aliases() []string {
+	result := []string{q.Alias}
+	for _, join := range q.Joins {
+		result = append(result, join.Alias)
+	}
+	return result
+}
+
+// validateJoins проверяет коллекции и псевдонимы запроса с JOIN: псевдонимы
+// уникальны, каждое поле начинается с псевдонима коллекции, а условие ON
+// ссылается только на уже соединенные коллекции
+func (qe *QueryExecutor) validateJoins(query *Query) error {
+	if len(query.Joins) == 0 {
+		return nil
+	}
+	
+	known := map[string]bool{query.Alias: true}
+	for _, join := range query.Joins {
+		if _, ok := qe.DB[join.Collection]; !ok {
+			return fmt.Errorf("коллекция %s не найдена", join.Collection)
+		}
+		if known[join.Alias] {
+			return fmt.Errorf("псевдоним %s используется в запросе дважды", join.Alias)
+		}
+		known[join.Alias] = true
+	}
+	
+	var err error
+	query.visit(func(expr Expr) {
+		if err != nil {
+			return
+		}
+		switch e := expr.(type) {
+		case *FieldRef:
+			if e.Depth == 0 && !known[pathAlias(e.Path)] {
+				err = fmt.Errorf("поле %s в запросе с JOIN должно начинаться с псевдонима коллекции", e.Path)
+			}
+		case *Match:
+			err = fmt.Errorf("MATCH не поддерживается в запросе с JOIN")
+		}
+	})
+	if err != nil {
+		return err
+	}
+	
+	joined := map[string]bool{query.Alias: true}
+	for _, join := range query.Joins {
+		joined[join.Alias] = true
+		join.On.walk(func(cond *Condition) {
+			for _, expr := range []Expr{cond.Left, cond.Right} {
+				visitExpr(expr, func(e Expr) {
+					if err != nil {
+						return
+					}
+					switch e := e.(type) {
+					case *FieldRef:
+						if e.Depth == 0 && !joined[pathAlias(e.Path)] {
+							err = fmt.Errorf("поле %s в ON ссылается на коллекцию, присоединяемую позже", e.Path)
+						}
+					case *Aggregate:
+						err = fmt.Errorf("агрегатная функция %s недопустима в ON", e)
+					}
+				})
+			}
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pathAlias возвращает первый сегмент пути - псевдоним коллекции
+func pathAlias(path string) string {
+	if i := strings.IndexAny(path, ".["); i >= 0 {
+		return path[:i]
+	}
+	return path
+}
+
+// localCondition возвращает часть условия WHERE, относящуюся только к
+// коллекции alias, с путями без псевдонима; nil, если такой части нет.
+// Она используется для выбора плана доступа к первой коллекции запроса
+func localCondition(cond *Condition, alias string) *Condition {
+	if cond == nil {
+		return nil
+	}
+	
+	conjuncts := []*Condition{cond}
+	if cond.ChildOp == "AND" {
+		conjuncts = cond.Children
+	}
+	
+	local := make([]*Condition, 0)
+	for _, child := range conjuncts {
+		if stripped, ok := stripAlias(child, alias); ok {
+			local = append(local, stripped)
+		}
+	}
+	
+	switch len(local) {
+	case 0:
+		return nil
+	case 1:
+		return local[0]
+	}
+	return &Condition{ChildOp: "AND", Children: local}
+}
+
+// stripAlias возвращает копию условия с путями без псевдонима alias.
+// Второе значение равно false, если условие ссылается на другие коллекции
+func stripAlias(cond *Condition, alias string) (*Condition, bool) {
+	return rewrite(cond, func(expr Expr) (Expr, bool) {
+		return stripExpr(expr, alias)
+	})
+}
+
+// stripExpr возвращает копию выражения с путями без псевдонима alias.
+// Ссылки на строки внешних запросов и некоррелированные подзапросы
+// не зависят от строки и сохраняются как есть
+func stripExpr(expr Expr, alias string) (Expr, bool) {
+	switch e := expr.(type) {
+	case *FieldRef:
+		if e.Depth > 0 {
+			return e, true
+		}
+		if pathAlias(e.Path) != alias || len(e.Path) == len(alias) {
+			return nil, false
+		}
+		return &FieldRef{Path: strings.TrimPrefix(e.Path[len(alias):], ".")}, true
+	case *FuncCall:
+		call := &FuncCall{Name: e.Name, Args: make([]Expr, len(e.Args))}
+		for i, arg := range e.Args {
+			stripped, ok := stripExpr(arg, alias)
+			if !ok {
+				return nil, false
+			}
+			call.Args[i] = stripped
+		}
+		return call, true
+	case *Coalesce:
+		coalesce := &Coalesce{Args: make([]Expr, len(e.Args))}
+		for i, arg := range e.Args {
+			stripped, ok := stripExpr(arg, alias)
+			if !ok {
+				return nil, false
+			}
+			coalesce.Args[i] = stripped
+		}
+		return coalesce, true
+	case *List:
+		list := &List{Items: make([]Expr, len(e.Items))}
+		for i, item := range e.Items {
+			stripped, ok := stripExpr(item, alias)
+			if !ok {
+				return nil, false
+			}
+			list.Items[i] = stripped
+		}
+		return list, true
+	case *Subquery:
+		return e, !e.Correlated
+	case *Literal, nil:
+		return expr, true
+	}
+	return nil, false
+}
+
+// planJoin выбирает способ соединения с коллекцией join: поиск по индексу
+// или _id, если одно из равенств ON связывает поле присоединяемой коллекции
+// с уже соединенными строками и по этому полю есть индекс; хеш-соединение
+// для такого равенства без индекса; иначе вложенные циклы
+func planJoin(coll Collection, join *Join, joined map[string]bool) *PlanNode {
+	conjuncts := []*Condition{join.On}
+	if join.On.ChildOp == "AND" {
+		conjuncts = join.On.Children
+	}
+	
+	node := &PlanNode{Type: PlanNestedLoop, Collection: join.Collection, Join: join}
+	for _, cond := range conjuncts {
+		field, key, ok := joinKey(cond, join.Alias, joined)
+		if !ok {
+			continue
+		}
+		
+		if field == "_id" || equalityIndex(coll, field) {
+			return &PlanNode{Type: PlanIndexJoin, Collection: join.Collection, Field: field, JoinKey: key, Join: join}
+		}
+		if node.Type == PlanNestedLoop {
+			node = &PlanNode{Type: PlanHashJoin, Collection: join.Collection, Field: field, JoinKey: key, Join: join}
+		}
+	}
+	return node
+}
+
+// joinKey разбирает равенство "поле = выражение", где поле принадлежит
+// присоединяемой коллекции alias, а выражение - только уже соединенным.
+// Возвращает путь поля без псевдонима и выражение
+func joinKey(cond *Condition, alias string, joined map[string]bool) (string, Expr, bool) {
+	if len(cond.Children) > 0 || cond.Operator != "=" {
+		return "", nil, false
+	}
+	
+	for _, pair := range [][2]Expr{{cond.Left, cond.Right}, {cond.Right, cond.Left}} {
+		field, ok := pair[0].(*FieldRef)
+		if !ok || field.Depth > 0 {
+			continue
+		}
+		inner, ok := stripExpr(field, alias)
+		if !ok || !refersTo(pair[1], joined) {
+			continue
+		}
+		return inner.(*FieldRef).Path, pair[1], true
+	}
+	return "", nil, false
+}
+
+// refersTo проверяет, что выражение ссылается только на коллекции из aliases
+// или строки внешних запросов и содержит хотя бы одно поле
+func refersTo(expr Expr, aliases map[string]bool) bool {
+	fields, valid := 0, true
+	visitExpr(expr, func(e Expr) {
+		switch v := e.(type) {
+		case *FieldRef:
+			fields++
+			valid = valid && (v.Depth > 0 || aliases[pathAlias(v.Path)])
+		case *Aggregate:
+			valid = false
+		}
+	})
+	return valid && fields > 0
+}
+
+// equalityIndex проверяет, есть ли по полю индекс с поиском на равенство
+func equalityIndex(coll Collection, field string) bool {
+	idx, ok := coll.Indexes[field]
+	if !ok {
+		return false
+	}
+	_, fullText := idx.(*index.FullTextIndex)
+	return !fullText
+}
+
+// joinAll присоединяет коллекции запроса к документам коллекции FROM.
+// Каждое соединение становится узлом плана над предыдущим
+func (qe *QueryExecutor) joinAll(query *Query, sources map[string]storage.Storage, plan *PlanNode, docs []storage.Document) (*PlanNode, []storage.Document, error) {
+	rows := make([]storage.Document, len(docs))
+	for i, doc := range docs {
+		rows[i] = aliased(query.Alias, doc)
+	}
+	
+	joined := map[string]bool{query.Alias: true}
+	for _, join := range query.Joins {
+		coll := qe.DB[join.Collection]
+		node := planJoin(coll, join, joined)
+		node.Children = []*PlanNode{plan}
+		node.EstimatedRows = qe.estimateJoin(coll, node, plan.EstimatedRows)
+		
+		start := time.Now()
+		var err error
+		if rows, err = qe.joinRows(coll, sources[join.Collection], node, rows); err != nil {
+			return nil, nil, err
+		}
+		node.record(len(rows), start)
+		
+		plan = node
+		joined[join.Alias] = true
+	}
+	
+	return plan, rows, nil
+}
+
+// joinRows соединяет строки rows с коллекцией узла node. Строка результата
+// содержит документы соединенных коллекций под их псевдонимами
+func (qe *QueryExecutor) joinRows(coll Collection, source storage.Storage, node *PlanNode, rows []storage.Document) ([]storage.Document, error) {
+	pairs, err := qe.joinCandidates(coll, source, node)
+	if err != nil {
+		return nil, err
+	}
+	
+	join := node.Join
+	result := make([]storage.Document, 0, len(rows))
+	for _, row := range rows {
+		docs, err := pairs(row)
+		if err != nil {
+			return nil, err
+		}
+		
+		matched := false
+		for _, doc := range docs {
+			combined := withAlias(row, join.Alias, doc)
+			ok, err := qe.evalCondition(combined, join.On)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				result = append(result, combined)
+				matched = true
+			}
+		}
+		
+		if !matched && join.Left {
+			result = append(result, row)
+		}
+	}
+	
+	return result, nil
+}
+
+// joinCandidates возвращает функцию, выбирающую для строки документы
+// присоединяемой коллекции - кандидаты в пару согласно способу соединения
+func (qe *QueryExecutor) joinCandidates(coll Collection, source storage.Storage, node *PlanNode) (func(row storage.Document) ([]storage.Document, error), error) {
+	if node.Type == PlanIndexJoin {
+		var idx index.Index
+		if node.Field != "_id" {
+			idx = coll.Indexes[node.Field]
+		}
+		return qe.probe(node, func(value interface{}) ([]string, error) {
+			if idx == nil {
+				id, ok := value.(string)
+				if !ok {
+					return nil, nil
+				}
+				return []string{id}, nil
+			}
+			return idx.Search(node.Field, value)
+		}, source.Get), nil
+	}
+	
+	docs, err := source.List()
+	if err != nil {
+		return nil, err
+	}
+	if node.Type == PlanNestedLoop {
+		return func(storage.Document) ([]storage.Document, error) {
+			return docs, nil
+		}, nil
+	}
+	
+	// Хеш-таблица строится по присоединяемой коллекции один раз
+	table := index.NewHashIndex(node.Field)
+	byID := make(map[string]storage.Document, len(docs))
+	for _, doc := range docs {
+		if err := table.Add(doc); err != nil {
+			return nil, err
+		}
+		byID[doc.ID] = doc
+	}
+	
+	return qe.probe(node, func(value interface{}) ([]string, error) {
+		return table.Search(node.Field, value)
+	}, func(id string) (storage.Document, error) {
+		return byID[id], nil
+	}), nil
+}
+
+// probe возвращает функцию, которая вычисляет ключ соединения по строке
+// и находит документы с таким значением поля
+func (qe *QueryExecutor) probe(node *PlanNode, search func(value interface{}) ([]string, error), get func(id string) (storage.Document, error)) func(row storage.Document) ([]storage.Document, error) {
+	return func(row storage.Document) ([]storage.Document, error) {
+		values, err := qe.evalValues(row, node.JoinKey)
+		if err != nil {
+			return nil, err
+		}
+		
+		docs := make([]storage.Document, 0)
+		seen := make(map[string]bool)
+		for _, value := range values {
+			ids, err := search(value)
+			if err != nil {
+				return nil, err
+			}
+			for _, id := range ids {
+				if seen[id] {
+					continue
+				}
+				seen[id] = true
+				
+				doc, err := get(id)
+				if err != nil {
+					continue // Документа нет или он удален после выборки из индекса
+				}
+				docs = append(docs, doc)
+			}
+		}
+		return docs, nil
+	}
+}
+
+// aliased возвращает строку соединения из документа первой коллекции запроса
+func aliased(alias string, doc storage.Document) storage.Document {
+	return withAlias(storage.Document{Content: map[string]interface{}{}}, alias, doc)
+}
+
+// withAlias возвращает строку row, дополненную документом doc под псевдонимом alias.
+// Документ включает свой _id; строка соединения собственного ID не имеет
+func withAlias(row storage.Document, alias string, doc storage.Document) storage.Document {
+	content := make(map[string]interface{}, len(row.Content)+1)
+	for k, v := range row.Content {
+		content[k] = v
+	}
+	
+	fields := make(map[string]interface{}, len(doc.Content)+1)
+	for k, v := range doc.Content {
+		fields[k] = v
+	}
+	fields["_id"] = doc.ID
+	content[alias] = fields
+	
+	return storage.Document{Content: content}
+}
+
+// collections возвращает коллекции запроса, его соединений и подзапросов
+// в порядке имен - в этом порядке захватываются их блокировки, чтобы запросы
+// не блокировали друг друга
+func (q *Query) collections() []string {
+	seen := make(map[string]bool)
+	var add func(q *Query)
+	add = func(q *Query) {
+		seen[q.From] = true
+		for _, join := range q.Joins {
+			seen[join.Collection] = true
+		}
+		for _, sub := range q.subqueries() {
+			add(sub.Query)
+		}
+	}
+	add(q)
+	
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// estimateJoin оценивает число строк соединения по оценке left для входных строк
+func (qe *QueryExecutor) estimateJoin(coll Collection, node *PlanNode, left int) int {
+	if left < 0 {
+		return -1
+	}
+	
+	result := -1
+	switch node.Type {
+	case PlanIndexJoin:
+		result = left
+		if stats, ok := coll.Indexes[node.Field].(index.Statistics); ok && stats.KeyCount() > 0 {
+			result = left * ((stats.Len() + stats.KeyCount() - 1) / stats.KeyCount())
+		}
+	case PlanHashJoin:
+		result = left
+	case PlanNestedLoop:
+		if counter, ok := coll.Storage.(storage.Counter); ok {
+			if count, err := counter.Count(); err == nil {
+				result = int(float64(left*count) * selectivity(node.Join.On))
+			}
+		}
+	}
+	
+	if node.Join.Left && result >= 0 && result < left {
+		result = left
+	}
+	return result
+}
+
+// describeJoin описывает узел соединения
+func (n *PlanNode) describeJoin() string {
+	result := fmt.Sprintf("%s %s", n.Type, n.Join)
+	if n.Type == PlanIndexJoin {
+		if n.Field == "_id" {
+			result += " (поиск по _id)"
+		} else {
+			result += fmt.Sprintf(" (по индексу %s)", n.Field)
+		}
+	}
+	return result
+}
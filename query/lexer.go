@@ -0,0 +1,326 @@
+package query
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// TokenType определяет тип лексемы
+type TokenType int
+
+const (
+	// TokenEOF - конец входной строки
+	TokenEOF TokenType = iota
+	// TokenIdent - идентификатор или ключевое слово
+	TokenIdent
+	// TokenQuotedIdent - идентификатор в двойных кавычках
+	TokenQuotedIdent
+	// TokenString - строковая константа в одинарных кавычках
+	TokenString
+	// TokenNumber - числовая константа
+	TokenNumber
+	// TokenSymbol - оператор или знак пунктуации
+	TokenSymbol
+	// TokenObject - JSON-объект в фигурных скобках
+	TokenObject
+)
+
+// Token представляет лексему запроса
+type Token struct {
+	Type   TokenType
+	Text   string
+	Line   int
+	Column int
+}
+
+// String возвращает описание лексемы для сообщений об ошибках
+func (t Token) String() string {
+	switch t.Type {
+	case TokenEOF:
+		return "конец запроса"
+	case TokenString:
+		return "'" + t.Text + "'"
+	case TokenQuotedIdent:
+		return `"` + t.Text + `"`
+	case TokenObject:
+		return "JSON-объект"
+	}
+	return t.Text
+}
+
+// ParseError описывает ошибку разбора запроса с указанием позиции
+type ParseError struct {
+	Line    int
+	Column  int
+	Message string
+}
+
+// Error возвращает текст ошибки
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("строка %d, столбец %d: %s", e.Line, e.Column, e.Message)
+}
+
+// symbols содержит операторы и знаки пунктуации; более длинные проверяются первыми
+var symbols = []string{"<=", ">=", "!=", "<>", "=", "<", ">", "(", ")", "[", "]", ",", ".", "*", "-", "+", ";", "~"}
+
+// lexer разбивает строку запроса на лексемы
+type lexer struct {
+	input  []rune
+	pos    int
+	line   int
+	column int
+}
+
+// Tokenize разбивает строку запроса на лексемы.
+// Пробелы и комментарии (-- до конца строки и /* ... */) пропускаются
+func Tokenize(input string) ([]Token, error) {
+	l := &lexer{input: []rune(input), line: 1, column: 1}
+	tokens := make([]Token, 0)
+	
+	for {
+		if err := l.skipSpaceAndComments(); err != nil {
+			return nil, err
+		}
+		
+		tok, err := l.next()
+		if err != nil {
+			return nil, err
+		}
+		
+		tokens = append(tokens, tok)
+		if tok.Type == TokenEOF {
+			return tokens, nil
+		}
+	}
+}
+
+// peekRune возвращает символ со смещением от текущей позиции или 0 за концом строки
+func (l *lexer) peekRune(offset int) rune {
+	if l.pos+offset >= len(l.input) {
+		return 0
+	}
+	return l.input[l.pos+offset]
+}
+
+// advance переходит к следующему символу, отслеживая строку и столбец
+func (l *lexer) advance() rune {
+	r := l.input[l.pos]
+	l.pos++
+	if r == '\n' {
+		l.line++
+		l.column = 1
+	} else {
+		l.column++
+	}
+	return r
+}
+
+// errorf создает ошибку разбора в указанной позиции
+func (l *lexer) errorf(line, column int, format string, args ...interface{}) error {
+	return &ParseError{Line: line, Column: column, Message: fmt.Sprintf(format, args...)}
+}
+
+// skipSpaceAndComments пропускает пробельные символы и комментарии
+func (l *lexer) skipSpaceAndComments() error {
+	for l.pos < len(l.input) {
+		r := l.peekRune(0)
+		
+		switch {
+		case unicode.IsSpace(r):
+			l.advance()
+		case r == '-' && l.peekRune(1) == '-':
+			for l.pos < len(l.input) && l.peekRune(0) != '\n' {
+				l.advance()
+			}
+		case r == '/' && l.peekRune(1) == '*':
+			line, column := l.line, l.column
+			l.advance()
+			l.advance()
+			for {
+				if l.pos >= len(l.input) {
+					return l.errorf(line, column, "незакрытый комментарий")
+				}
+				if l.peekRune(0) == '*' && l.peekRune(1) == '/' {
+					l.advance()
+					l.advance()
+					break
+				}
+				l.advance()
+			}
+		default:
+			return nil
+		}
+	}
+	
+	return nil
+}
+
+// next считывает очередную лексему
+func (l *lexer) next() (Token, error) {
+	tok := Token{Line: l.line, Column: l.column}
+	
+	if l.pos >= len(l.input) {
+		tok.Type = TokenEOF
+		return tok, nil
+	}
+	
+	r := l.peekRune(0)
+	
+	switch {
+	case r == '\'':
+		text, err := l.readQuoted('\'')
+		if err != nil {
+			return tok, err
+		}
+		tok.Type = TokenString
+		tok.Text = text
+		return tok, nil
+	case r == '"':
+		text, err := l.readQuoted('"')
+		if err != nil {
+			return tok, err
+		}
+		tok.Type = TokenQuotedIdent
+		tok.Text = text
+		return tok, nil
+	case r == '{':
+		text, err := l.readObject()
+		if err != nil {
+			return tok, err
+		}
+		tok.Type = TokenObject
+		tok.Text = text
+		return tok, nil
+	case unicode.IsDigit(r):
+		tok.Type = TokenNumber
+		tok.Text = l.readNumber()
+		return tok, nil
+	case unicode.IsLetter(r) || r == '_':
+		start := l.pos
+		for l.pos < len(l.input) && (unicode.IsLetter(l.peekRune(0)) || unicode.IsDigit(l.peekRune(0)) || l.peekRune(0) == '_') {
+			l.advance()
+		}
+		tok.Type = TokenIdent
+		tok.Text = string(l.input[start:l.pos])
+		return tok, nil
+	}
+	
+	rest := string(l.input[l.pos:])
+	for _, sym := range symbols {
+		if strings.HasPrefix(rest, sym) {
+			for range sym {
+				l.advance()
+			}
+			tok.Type = TokenSymbol
+			tok.Text = sym
+			return tok, nil
+		}
+	}
+	
+	return tok, l.errorf(tok.Line, tok.Column, "неожиданный символ %q", r)
+}
+
+// readQuoted считывает строку в кавычках.
+// Кавычка внутри строки записывается удвоенной ('') или экранируется (\').
+// Неизвестные escape-последовательности сохраняются как есть, поэтому
+// шаблоны регулярных выражений вида '\d+' не требуют двойного экранирования
+func (l *lexer) readQuoted(quote rune) (string, error) {
+	line, column := l.line, l.column
+	l.advance()
+	
+	var sb strings.Builder
+	for {
+		if l.pos >= len(l.input) {
+			return "", l.errorf(line, column, "незакрытая строка")
+		}
+		
+		r := l.advance()
+		switch {
+		case r == quote && l.peekRune(0) == quote && l.pos < len(l.input):
+			l.advance()
+			sb.WriteRune(quote)
+		case r == quote:
+			return sb.String(), nil
+		case r == '\\' && l.pos < len(l.input):
+			next := l.peekRune(0)
+			switch next {
+			case '\'', '"', '\\':
+				l.advance()
+				sb.WriteRune(next)
+			case 'n':
+				l.advance()
+				sb.WriteRune('\n')
+			case 't':
+				l.advance()
+				sb.WriteRune('\t')
+			default:
+				sb.WriteRune(r)
+			}
+		default:
+			sb.WriteRune(r)
+		}
+	}
+}
+
+// readObject считывает JSON-объект до парной закрывающей скобки.
+// Скобки внутри строк в двойных кавычках не учитываются; корректность
+// JSON проверяется при разборе
+func (l *lexer) readObject() (string, error) {
+	line, column := l.line, l.column
+	start := l.pos
+	depth, inString := 0, false
+	
+	for l.pos < len(l.input) {
+		r := l.advance()
+		switch {
+		case inString && r == '\\' && l.pos < len(l.input):
+			l.advance()
+		case r == '"':
+			inString = !inString
+		case inString:
+		case r == '{' || r == '[':
+			depth++
+		case r == '}' || r == ']':
+			depth--
+			if depth == 0 {
+				return string(l.input[start:l.pos]), nil
+			}
+		}
+	}
+	
+	return "", l.errorf(line, column, "незакрытый JSON-объект")
+}
+
+// readNumber считывает целое или дробное число, в том числе с экспонентой
+func (l *lexer) readNumber() string {
+	start := l.pos
+	
+	for unicode.IsDigit(l.peekRune(0)) {
+		l.advance()
+	}
+	
+	if l.peekRune(0) == '.' && unicode.IsDigit(l.peekRune(1)) {
+		l.advance()
+		for unicode.IsDigit(l.peekRune(0)) {
+			l.advance()
+		}
+	}
+	
+	if e := l.peekRune(0); e == 'e' || e == 'E' {
+		offset := 1
+		if sign := l.peekRune(1); sign == '+' || sign == '-' {
+			offset = 2
+		}
+		if unicode.IsDigit(l.peekRune(offset)) {
+			for i := 0; i < offset; i++ {
+				l.advance()
+			}
+			for unicode.IsDigit(l.peekRune(0)) {
+				l.advance()
+			}
+		}
+	}
+	
+	return string(l.input[start:l.pos])
+}
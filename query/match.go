@@ -0,0 +1,148 @@
+package query
+
+import (
+	"fmt"
+
+	"github.com/urusofam/jsondb/index"
+	"github.com/urusofam/jsondb/storage"
+)
+
+// PlanFullText - поиск по полнотекстовому индексу; документы возвращаются
+// в порядке убывания релевантности
+const PlanFullText = "FullTextSearch"
+
+// Match представляет полнотекстовый поиск MATCH(поле, 'запрос').
+// В условии он выбирает документы, содержащие хотя бы одно слово запроса,
+// а как значение возвращает релевантность документа по BM25 (0, если слов нет)
+type Match struct {
+	Field *FieldRef
+	Query string
+	
+	scores map[string]float64 // Релевантность документов, вычисленная при выполнении запроса
+}
+
+// String возвращает запись MATCH
+func (m *Match) String() string {
+	return "MATCH(" + m.Field.String() + ", " + formatValue(m.Query) + ")"
+}
+
+// parseMatch разбирает MATCH "(" path "," string ")"
+func (p *parser) parseMatch() (Expr, error) {
+	p.next() // MATCH
+	p.next() // "("
+	
+	path, err := p.parsePath()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expectSymbol(","); err != nil {
+		return nil, err
+	}
+	
+	tok := p.next()
+	if tok.Type != TokenString {
+		return nil, p.errorf(tok, "ожидалась строка запроса MATCH, получено %s", tok)
+	}
+	
+	if err := p.expectSymbol(")"); err != nil {
+		return nil, err
+	}
+	
+	return &Match{Field: &FieldRef{Path: path}, Query: tok.Text}, nil
+}
+
+// scoreMatches вычисляет релевантность документов для каждого MATCH запроса.
+// Используется полнотекстовый индекс поля, а без него - временный индекс по
+// всем документам source, поскольку BM25 учитывает статистику всей коллекции
+func (qe *QueryExecutor) scoreMatches(coll Collection, source storage.Storage, query *Query) error {
+	var (
+		err    error
+		temp   = make(map[string]*index.FullTextIndex)
+		scores = make(map[string]map[string]float64)
+	)
+	
+	query.visit(func(expr Expr) {
+		match, ok := expr.(*Match)
+		if !ok || err != nil {
+			return
+		}
+		if cached, ok := scores[match.String()]; ok {
+			match.scores = cached
+			return
+		}
+		
+		idx, ok := coll.Indexes[match.Field.Path].(*index.FullTextIndex)
+		if !ok {
+			if idx, ok = temp[match.Field.Path]; !ok {
+				if idx, err = buildFullText(source, match.Field.Path); err != nil {
+					return
+				}
+				temp[match.Field.Path] = idx
+			}
+		}
+		
+		match.scores = idx.Score(match.Query)
+		scores[match.String()] = match.scores
+	})
+	
+	return err
+}
+
+// buildFullText строит временный полнотекстовый индекс по всем документам хранилища
+func buildFullText(source storage.Storage, field string) (*index.FullTextIndex, error) {
+	docs, err := source.List()
+	if err != nil {
+		return nil, err
+	}
+	
+	idx := index.NewFullTextIndex(field)
+	for _, doc := range docs {
+		if err := idx.Add(doc); err != nil {
+			return nil, err
+		}
+	}
+	return idx, nil
+}
+
+// ranking возвращает порядок по умолчанию для запроса без ORDER BY
+// и группировки: по убыванию релевантности первого MATCH из WHERE
+func (q *Query) ranking() []*OrderItem {
+	if q.Where == nil || len(q.OrderBy) > 0 || q.grouped() {
+		return nil
+	}
+	
+	var match *Match
+	q.Where.walk(func(cond *Condition) {
+		if m, ok := cond.Left.(*Match); ok && match == nil && cond.Operator == "MATCH" {
+			match = m
+		}
+	})
+	if match == nil {
+		return nil
+	}
+	return []*OrderItem{{Expr: match, Descending: true}}
+}
+
+// planMatch строит план для условия MATCH по полнотекстовому индексу
+func planMatch(coll Collection, match *Match) *PlanNode {
+	if _, ok := coll.Indexes[match.Field.Path].(*index.FullTextIndex); !ok {
+		return nil
+	}
+	return &PlanNode{Type: PlanFullText, Field: match.Field.Path, Value: match.Query}
+}
+
+// rankedByIndex проверяет, задает ли ORDER BY порядок убывания релевантности,
+// в котором документы уже возвращает узел FullTextSearch
+func rankedByIndex(query *Query, access *PlanNode) bool {
+	if access.Type != PlanFullText || len(query.OrderBy) != 1 || !query.OrderBy[0].Descending {
+		return false
+	}
+	
+	match, ok := query.OrderBy[0].Expr.(*Match)
+	return ok && match.Field.Path == access.Field && match.Query == access.Value
+}
+
+// describeMatch описывает узел FullTextSearch
+func (n *PlanNode) describeMatch() string {
+	return fmt.Sprintf("FullTextSearch по индексу %s: %s", n.Field, formatValue(n.Value))
+}
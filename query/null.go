@@ -0,0 +1,186 @@
+package query
+
+import (
+	"strings"
+
+	"github.com/urusofam/jsondb/index"
+	"github.com/urusofam/jsondb/storage"
+)
+
+// truth - значение условия в трехзначной логике: TRUE, FALSE или UNKNOWN.
+// Сравнение с NULL или отсутствующим полем дает UNKNOWN; WHERE, HAVING и ON
+// оставляют только строки, для которых условие равно TRUE
+type truth int
+
+const (
+	truthFalse truth = iota
+	truthUnknown
+	truthTrue
+)
+
+// truthOf возвращает TRUE или FALSE
+func truthOf(b bool) truth {
+	if b {
+		return truthTrue
+	}
+	return truthFalse
+}
+
+// and возвращает конъюнкцию: FALSE, если один из операндов FALSE,
+// иначе UNKNOWN, если один из операндов UNKNOWN
+func (t truth) and(other truth) truth {
+	if other < t {
+		return other
+	}
+	return t
+}
+
+// or возвращает дизъюнкцию: TRUE, если один из операндов TRUE,
+// иначе UNKNOWN, если один из операндов UNKNOWN
+func (t truth) or(other truth) truth {
+	if other > t {
+		return other
+	}
+	return t
+}
+
+// not возвращает отрицание; отрицание UNKNOWN остается UNKNOWN
+func (t truth) not() truth {
+	return truthTrue - t
+}
+
+// Coalesce представляет COALESCE(a, b, ...): первое из значений аргументов,
+// отличное от NULL, или NULL, если таких нет. Отсутствующее поле считается NULL
+type Coalesce struct {
+	Args []Expr
+}
+
+// String возвращает запись COALESCE
+func (c *Coalesce) String() string {
+	args := make([]string, len(c.Args))
+	for i, arg := range c.Args {
+		args[i] = arg.String()
+	}
+	return "COALESCE(" + strings.Join(args, ", ") + ")"
+}
+
+// parseCoalesce разбирает COALESCE "(" operand { "," operand } ")"
+func (p *parser) parseCoalesce() (Expr, error) {
+	tok := p.peek()
+	call, err := p.parseFuncCall()
+	if err != nil {
+		return nil, err
+	}
+	
+	args := call.(*FuncCall).Args
+	if len(args) == 0 {
+		return nil, p.errorf(tok, "COALESCE ожидает хотя бы один аргумент")
+	}
+	return &Coalesce{Args: args}, nil
+}
+
+// parseIs разбирает IS [ NOT ] ( NULL | MISSING ) после операнда left; IS уже прочитан
+func (p *parser) parseIs(left Expr) (*Condition, error) {
+	op := "IS "
+	if p.acceptKeyword("NOT") {
+		op += "NOT "
+	}
+	
+	tok := p.next()
+	kw := strings.ToUpper(tok.Text)
+	if tok.Type != TokenIdent || (kw != "NULL" && kw != "MISSING") {
+		return nil, p.errorf(tok, "ожидалось NULL или MISSING, получено %s", tok)
+	}
+	
+	return &Condition{Left: left, Operator: op + kw}, nil
+}
+
+// evalIs проверяет IS NULL, IS MISSING и их отрицания. В отличие от сравнений
+// результат всегда TRUE или FALSE: IS NULL выполняется для NULL и отсутствующего
+// поля, IS MISSING - только для отсутствующего. Путь с [*] отсутствует,
+// если не дает ни одного значения, и равен NULL, если все его значения NULL
+func (qe *QueryExecutor) evalIs(doc storage.Document, cond *Condition) (truth, error) {
+	values, err := qe.evalValues(doc, cond.Left)
+	if err != nil {
+		return truthFalse, err
+	}
+	
+	null := true
+	for _, value := range values {
+		if value != nil {
+			null = false
+		}
+	}
+	
+	switch cond.Operator {
+	case "IS NULL":
+		return truthOf(null), nil
+	case "IS NOT NULL":
+		return truthOf(!null), nil
+	case "IS MISSING":
+		return truthOf(len(values) == 0), nil
+	}
+	return truthOf(len(values) > 0), nil
+}
+
+// coalesce вычисляет COALESCE для документа
+func (qe *QueryExecutor) coalesce(doc storage.Document, c *Coalesce) (interface{}, error) {
+	for _, arg := range c.Args {
+		value, ok, err := qe.evalExpr(doc, arg)
+		if err != nil {
+			return nil, err
+		}
+		if ok && value != nil {
+			return value, nil
+		}
+	}
+	return nil, nil
+}
+
+// planIs строит план для проверки IS по индексу поля. Документ без поля
+// в индекс не попадает, кроме уникального неразреженного индекса, который
+// хранит его под ключом NULL. Поэтому IS NULL ищет ключ NULL только в таком
+// индексе или в индексе, содержащем все документы коллекции, а IS MISSING -
+// только в таком индексе. IS NOT NULL просматривает диапазон значений больше
+// NULL, IS NOT MISSING обходит индекс, если в нем есть не все документы
+func (qe *QueryExecutor) planIs(coll Collection, cond *Condition) *PlanNode {
+	field, ok := cond.Left.(*FieldRef)
+	if !ok || field.Depth > 0 || field.Path == "_id" || storage.HasWildcard(field.Path) {
+		return nil
+	}
+	
+	idx, ok := coll.Indexes[field.Path]
+	if !ok {
+		return nil
+	}
+	if _, ok := idx.(*index.FullTextIndex); ok {
+		return nil
+	}
+	_, ordered := idx.(index.OrderedIndex)
+	
+	switch cond.Operator {
+	case "IS NULL":
+		if missingAsNull(idx) || coversCollection(coll, idx) {
+			return &PlanNode{Type: PlanIndexLookup, Field: field.Path, Value: nil}
+		}
+	case "IS MISSING":
+		if missingAsNull(idx) {
+			return &PlanNode{Type: PlanIndexLookup, Field: field.Path, Value: nil}
+		}
+	case "IS NOT NULL":
+		if ordered {
+			return &PlanNode{Type: PlanIndexRange, Field: field.Path, Range: index.NotNull()}
+		}
+	case "IS NOT MISSING":
+		if ordered && !coversCollection(coll, idx) {
+			return &PlanNode{Type: PlanIndexScan, Field: field.Path}
+		}
+	}
+	return nil
+}
+
+// missingAsNull проверяет, хранит ли индекс документы без поля под ключом NULL
+func missingAsNull(idx index.Index) bool {
+	bt, ok := idx.(*index.BTreeIndex)
+	return ok && bt.Unique && !bt.Sparse
+}
@@ -0,0 +1,30 @@
+package query
+
+import (
+	"testing"
+
+	"github.com/urusofam/jsondb/storage"
+)
+
+func TestGroupByKeepsNullAndMissingApart(t *testing.T) {
+	qe := testExecutor(t, "t",
+		storage.Document{ID: "1", Content: map[string]interface{}{"c": "x", "v": 1.0}},
+		storage.Document{ID: "2", Content: map[string]interface{}{"c": nil, "v": 2.0}},
+		storage.Document{ID: "3", Content: map[string]interface{}{"c": nil, "v": 3.0}},
+		storage.Document{ID: "4", Content: map[string]interface{}{"v": 4.0}},
+		storage.Document{ID: "5", Content: map[string]interface{}{"c": "x", "d": nil, "v": 5.0}},
+	)
+	
+	for q, want := range map[string]string{
+		"SELECT c, COUNT(*) AS n, SUM(v) AS s FROM t GROUP BY c ORDER BY s":                               "[map[n:1 s:4] map[c:<nil> n:2 s:5] map[c:x n:2 s:6]]",
+		"SELECT COUNT(*) AS n FROM t GROUP BY c HAVING c IS MISSING":                                      "[map[n:1]]",
+		"SELECT COUNT(*) AS n FROM t GROUP BY c HAVING c IS NULL ORDER BY n":                              "[map[n:1] map[n:2]]",
+		"SELECT c, d, SUM(v) AS s FROM t WHERE c = 'x' GROUP BY c, d ORDER BY s":                          "[map[c:x s:1] map[c:x d:<nil> s:5]]",
+		"SELECT COALESCE(c, 'none') AS k, COUNT(*) AS n FROM t GROUP BY c HAVING COUNT(*) > 1 ORDER BY k": "[map[k:none n:2] map[k:x n:2]]",
+		"SELECT COUNT(*) AS n FROM t GROUP BY c HAVING c IS NOT MISSING AND c IS NOT NULL":                "[map[n:2]]",
+	} {
+		if got := mustRun(t, qe, q); got != want {
+			t.Fatalf("%s: %s, ожидалось %s", q, got, want)
+		}
+	}
+}
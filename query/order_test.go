@@ -0,0 +1,91 @@
+package query
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/urusofam/jsondb/storage"
+)
+
+// mustRun выполняет запрос и возвращает результат в виде строки
+func mustRun(t *testing.T, qe *QueryExecutor, q string) string {
+	t.Helper()
+	
+	rows, err := run(qe, q)
+	if err != nil {
+		t.Fatalf("%s: %v", q, err)
+	}
+	return fmt.Sprint(rows)
+}
+
+func TestOrderBy(t *testing.T) {
+	docs := []storage.Document{
+		{ID: "a", Content: map[string]interface{}{"k": 2.0, "c": "x"}},
+		{ID: "b", Content: map[string]interface{}{"k": nil, "c": "y"}},
+		{ID: "c", Content: map[string]interface{}{"k": "s", "c": "x"}},
+		{ID: "d", Content: map[string]interface{}{"c": "y"}},
+		{ID: "e", Content: map[string]interface{}{"k": true, "c": "x"}},
+		{ID: "f", Content: map[string]interface{}{"k": 1.0, "c": "y"}},
+	}
+	indexed, scan := plannerExecutors(t, docs, "k")
+	
+	for q, want := range map[string]string{
+		"SELECT _id FROM t ORDER BY k, _id":                       "[map[_id:e] map[_id:f] map[_id:a] map[_id:c] map[_id:b] map[_id:d]]",
+		"SELECT _id FROM t ORDER BY k DESC, _id":                  "[map[_id:b] map[_id:d] map[_id:c] map[_id:a] map[_id:f] map[_id:e]]",
+		"SELECT _id FROM t ORDER BY k NULLS FIRST, _id":           "[map[_id:b] map[_id:d] map[_id:e] map[_id:f] map[_id:a] map[_id:c]]",
+		"SELECT _id FROM t ORDER BY k DESC NULLS LAST, _id":       "[map[_id:c] map[_id:a] map[_id:f] map[_id:e] map[_id:b] map[_id:d]]",
+		"SELECT _id FROM t ORDER BY c DESC, k ASC, _id":           "[map[_id:f] map[_id:b] map[_id:d] map[_id:e] map[_id:a] map[_id:c]]",
+		"SELECT _id FROM t ORDER BY k, _id DESC LIMIT 2 OFFSET 3": "[map[_id:c] map[_id:d]]",
+		"SELECT _id FROM t WHERE k > 0 ORDER BY k DESC LIMIT 1":   "[map[_id:a]]",
+	} {
+		for _, qe := range []*QueryExecutor{indexed, scan} {
+			if got := mustRun(t, qe, q); got != want {
+				t.Errorf("%s: %s, ожидалось %s", q, got, want)
+			}
+		}
+	}
+}
+
+func TestOrderByMatchesFullScan(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	docs := make([]storage.Document, 200)
+	for i := range docs {
+		content := map[string]interface{}{"city": fmt.Sprint("c", rnd.Intn(5))}
+		switch rnd.Intn(10) {
+		case 0:
+			content["age"] = nil
+		case 1:
+		default:
+			content["age"] = float64(rnd.Intn(50))
+		}
+		docs[i] = storage.Document{ID: fmt.Sprintf("u%03d", i), Content: content}
+	}
+	indexed, scan := plannerExecutors(t, docs, "age", "city")
+	
+	for _, q := range []string{
+		"SELECT _id, age FROM t ORDER BY age, _id",
+		"SELECT _id, age FROM t ORDER BY age DESC, _id",
+		"SELECT _id, age FROM t ORDER BY age NULLS FIRST, _id LIMIT 30",
+		"SELECT _id, age FROM t ORDER BY age DESC NULLS LAST, _id LIMIT 30 OFFSET 5",
+		"SELECT _id, age FROM t WHERE age > 10 AND age < 30 ORDER BY age DESC, _id LIMIT 7",
+		"SELECT _id, age FROM t WHERE age > 10 ORDER BY age, _id LIMIT 7 OFFSET 2",
+		"SELECT _id FROM t WHERE city = 'c1' ORDER BY city, age DESC, _id",
+		"SELECT _id, age FROM t WHERE age > 10 AND city = 'c2' ORDER BY age, _id LIMIT 3",
+	} {
+		if got, want := mustRun(t, indexed, q), mustRun(t, scan, q); got != want {
+			t.Errorf("%s: %s, полный просмотр дает %s", q, got, want)
+		}
+	}
+	
+	// При обходе индекса с LIMIT результат совпадает с сортировкой, хотя ключи повторяются
+	for _, q := range []string{
+		"SELECT age FROM t ORDER BY age LIMIT 25",
+		"SELECT age FROM t ORDER BY age DESC NULLS LAST LIMIT 25 OFFSET 10",
+		"SELECT age FROM t WHERE age >= 20 ORDER BY age DESC LIMIT 9",
+	} {
+		if got, want := mustRun(t, indexed, q), mustRun(t, scan, q); got != want {
+			t.Errorf("%s: %s, полный просмотр дает %s", q, got, want)
+		}
+	}
+}
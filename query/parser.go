@@ -0,0 +1,788 @@
+package query
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// reservedWords содержит ключевые слова, которые нельзя использовать как имена полей без кавычек
+var reservedWords = map[string]bool{
+	"EXPLAIN":  true,
+	"SELECT":   true,
+	"FROM":     true,
+	"JOIN":     true,
+	"INNER":    true,
+	"LEFT":     true,
+	"OUTER":    true,
+	"ON":       true,
+	"IN":       true,
+	"EXISTS":   true,
+	"LIKE":     true,
+	"ILIKE":    true,
+	"BETWEEN":  true,
+	"IS":       true,
+	"MISSING":  true,
+	"WHERE":    true,
+	"GROUP":    true,
+	"HAVING":   true,
+	"AS":       true,
+	"DISTINCT": true,
+	"ORDER":    true,
+	"BY":       true,
+	"ASC":      true,
+	"DESC":     true,
+	"NULLS":    true,
+	"AND":      true,
+	"OR":       true,
+	"NOT":      true,
+	"LIMIT":    true,
+	"OFFSET":   true,
+	"TRUE":     true,
+	"FALSE":    true,
+	"NULL":     true,
+}
+
+// comparisonOperators сопоставляет операторы сравнения с их каноническим видом
+var comparisonOperators = map[string]string{
+	"=":  "=",
+	"!=": "!=",
+	"<>": "!=",
+	"<":  "<",
+	"<=": "<=",
+	">":  ">",
+	">=": ">=",
+}
+
+// negatable содержит операторы, допускающие отрицание NOT перед ними
+var negatable = map[string]bool{
+	"IN":      true,
+	"LIKE":    true,
+	"ILIKE":   true,
+	"BETWEEN": true,
+}
+
+// aggregateFunctions содержит имена агрегатных функций
+var aggregateFunctions = map[string]bool{
+	"COUNT":     true,
+	"SUM":       true,
+	"AVG":       true,
+	"MIN":       true,
+	"MAX":       true,
+	"ARRAY_AGG": true,
+}
+
+// mirroredOperators содержит операторы для сравнения с переставленными операндами
+var mirroredOperators = map[string]string{
+	"=":  "=",
+	"!=": "!=",
+	"<":  ">",
+	"<=": ">=",
+	">":  "<",
+	">=": "<=",
+}
+
+// parser выполняет рекурсивный спуск по списку лексем
+//
+// Грамматика:
+//
+//	statement  = ( [ EXPLAIN ] select | insert | update | delete ) [ ";" ] EOF
+//	select     = SELECT fields FROM source { join } [ WHERE or ]
+//	             [ GROUP BY path { "," path } ] [ HAVING or ]
+//	             [ ORDER BY order { "," order } ] { LIMIT int | OFFSET int }
+//	insert     = INSERT INTO ident VALUES object { "," object }
+//	update     = UPDATE ident SET path "=" operand { "," path "=" operand } [ WHERE or ]
+//	delete     = DELETE FROM ident [ WHERE or ]
+//	source     = ident [ [ AS ] ident ]
+//	join       = ( [ INNER ] JOIN | LEFT [ OUTER ] JOIN ) source ON or
+//	fields     = "*" | column { "," column }
+//	column     = operand [ [ AS ] ident ]
+//	order      = operand [ ASC | DESC ] [ NULLS ( FIRST | LAST ) ]
+//	value      = match | coalesce | aggregate | call | path
+//	match      = MATCH "(" path "," string ")"
+//	coalesce   = COALESCE "(" operand { "," operand } ")"
+//	aggregate  = COUNT "(" "*" ")" | name "(" [ DISTINCT ] operand ")"
+//	call       = ident "(" [ operand { "," operand } ] ")"
+//	or         = and { OR and }
+//	and        = not { AND not }
+//	not        = NOT not | "(" or ")" | comparison
+//	comparison = operand op operand | operand [ NOT ] IN "(" ( select | operand { "," operand } ) ")"
+//	           | operand [ NOT ] ( LIKE | ILIKE ) operand | operand "~" operand
+//	           | operand [ NOT ] BETWEEN operand AND operand
+//	           | operand IS [ NOT ] ( NULL | MISSING )
+//	           | EXISTS "(" select ")" | match
+//	operand    = value | string | [ "-" ] number | TRUE | FALSE | NULL | object
+//	object     = JSON-объект "{ ... }"
+//	path       = ident { "." ident | "[" ( int | "*" ) "]" }
+type parser struct {
+	tokens []Token
+	pos    int
+}
+
+// peek возвращает текущую лексему
+func (p *parser) peek() Token {
+	return p.tokens[p.pos]
+}
+
+// peekNext возвращает лексему, следующую за текущей
+func (p *parser) peekNext() Token {
+	if p.pos+1 < len(p.tokens) {
+		return p.tokens[p.pos+1]
+	}
+	return p.tokens[len(p.tokens)-1]
+}
+
+// next возвращает текущую лексему и переходит к следующей
+func (p *parser) next() Token {
+	tok := p.tokens[p.pos]
+	if tok.Type != TokenEOF {
+		p.pos++
+	}
+	return tok
+}
+
+// errorf создает ошибку разбора в позиции лексемы
+func (p *parser) errorf(tok Token, format string, args ...interface{}) error {
+	return &ParseError{Line: tok.Line, Column: tok.Column, Message: fmt.Sprintf(format, args...)}
+}
+
+// isKeyword проверяет, является ли текущая лексема ключевым словом kw
+func (p *parser) isKeyword(kw string) bool {
+	tok := p.peek()
+	return tok.Type == TokenIdent && strings.EqualFold(tok.Text, kw)
+}
+
+// acceptKeyword пропускает ключевое слово kw, если оно является текущей лексемой
+func (p *parser) acceptKeyword(kw string) bool {
+	if p.isKeyword(kw) {
+		p.next()
+		return true
+	}
+	return false
+}
+
+// expectKeyword требует ключевое слово kw
+func (p *parser) expectKeyword(kw string) error {
+	if !p.acceptKeyword(kw) {
+		return p.errorf(p.peek(), "ожидалось %s, получено %s", kw, p.peek())
+	}
+	return nil
+}
+
+// isSymbol проверяет, является ли текущая лексема символом sym
+func (p *parser) isSymbol(sym string) bool {
+	tok := p.peek()
+	return tok.Type == TokenSymbol && tok.Text == sym
+}
+
+// acceptSymbol пропускает символ sym, если он является текущей лексемой
+func (p *parser) acceptSymbol(sym string) bool {
+	if p.isSymbol(sym) {
+		p.next()
+		return true
+	}
+	return false
+}
+
+// expectSymbol требует символ sym
+func (p *parser) expectSymbol(sym string) error {
+	if !p.acceptSymbol(sym) {
+		return p.errorf(p.peek(), "ожидалось %q, получено %s", sym, p.peek())
+	}
+	return nil
+}
+
+// parseStatement разбирает инструкцию целиком
+func (p *parser) parseStatement() (Statement, error) {
+	var (
+		stmt Statement
+		err  error
+	)
+	
+	switch {
+	case p.isKeyword("INSERT"):
+		stmt, err = p.parseInsert()
+	case p.isKeyword("UPDATE"):
+		stmt, err = p.parseUpdate()
+	case p.isKeyword("DELETE"):
+		stmt, err = p.parseDelete()
+	default:
+		query := &Query{
+			Limit:  -1,
+			Offset: 0,
+		}
+		
+		// Разбор EXPLAIN
+		if p.acceptKeyword("EXPLAIN") {
+			query.Explain = true
+		}
+		
+		stmt, err = query, p.parseSelect(query)
+	}
+	if err != nil {
+		return nil, err
+	}
+	
+	p.acceptSymbol(";")
+	if tok := p.peek(); tok.Type != TokenEOF {
+		return nil, p.errorf(tok, "неожиданная лексема %s", tok)
+	}
+	
+	bindStatement(stmt)
+	return stmt, nil
+}
+
+// parseSelect разбирает оператор SELECT
+func (p *parser) parseSelect(query *Query) error {
+	if err := p.expectKeyword("SELECT"); err != nil {
+		return err
+	}
+	
+	// Разбор списка полей
+	if p.acceptSymbol("*") {
+		query.Select = []*SelectItem{{Expr: &Star{}}}
+	} else {
+		for {
+			item, err := p.parseSelectItem()
+			if err != nil {
+				return err
+			}
+			query.Select = append(query.Select, item)
+			
+			if !p.acceptSymbol(",") {
+				break
+			}
+		}
+	}
+	
+	// Разбор FROM
+	if err := p.expectKeyword("FROM"); err != nil {
+		return err
+	}
+	
+	from, alias, err := p.parseSource()
+	if err != nil {
+		return err
+	}
+	query.From, query.Alias = from, alias
+	
+	// Разбор JOIN
+	for p.isJoin() {
+		join, err := p.parseJoin()
+		if err != nil {
+			return err
+		}
+		query.Joins = append(query.Joins, join)
+	}
+	
+	// Разбор WHERE
+	if p.acceptKeyword("WHERE") {
+		condition, err := p.parseOr()
+		if err != nil {
+			return err
+		}
+		query.Where = condition
+	}
+	
+	// Разбор GROUP BY
+	if p.acceptKeyword("GROUP") {
+		if err := p.expectKeyword("BY"); err != nil {
+			return err
+		}
+		
+		for {
+			path, err := p.parsePath()
+			if err != nil {
+				return err
+			}
+			query.GroupBy = append(query.GroupBy, &FieldRef{Path: path})
+			
+			if !p.acceptSymbol(",") {
+				break
+			}
+		}
+	}
+	
+	// Разбор HAVING
+	if p.acceptKeyword("HAVING") {
+		condition, err := p.parseOr()
+		if err != nil {
+			return err
+		}
+		query.Having = condition
+	}
+	
+	// Разбор ORDER BY
+	if p.acceptKeyword("ORDER") {
+		if err := p.expectKeyword("BY"); err != nil {
+			return err
+		}
+		
+		for {
+			item, err := p.parseOrderItem(query.Select)
+			if err != nil {
+				return err
+			}
+			query.OrderBy = append(query.OrderBy, item)
+			
+			if !p.acceptSymbol(",") {
+				break
+			}
+		}
+	}
+	
+	// Разбор LIMIT и OFFSET в любом порядке
+	seenLimit, seenOffset := false, false
+	for {
+		tok := p.peek()
+		
+		switch {
+		case p.acceptKeyword("LIMIT"):
+			if seenLimit {
+				return p.errorf(tok, "LIMIT указан повторно")
+			}
+			seenLimit = true
+			
+			limit, err := p.parseCount()
+			if err != nil {
+				return err
+			}
+			query.Limit = limit
+		case p.acceptKeyword("OFFSET"):
+			if seenOffset {
+				return p.errorf(tok, "OFFSET указан повторно")
+			}
+			seenOffset = true
+			
+			offset, err := p.parseCount()
+			if err != nil {
+				return err
+			}
+			query.Offset = offset
+		default:
+			return nil
+		}
+	}
+}
+
+// parseSelectItem разбирает элемент списка SELECT с необязательным псевдонимом
+func (p *parser) parseSelectItem() (*SelectItem, error) {
+	expr, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	
+	item := &SelectItem{Expr: expr}
+	
+	tok := p.peek()
+	explicit := p.acceptKeyword("AS")
+	if explicit || tok.Type == TokenQuotedIdent || (tok.Type == TokenIdent && !reservedWords[strings.ToUpper(tok.Text)]) {
+		alias, err := p.parseIdent()
+		if err != nil {
+			return nil, err
+		}
+		item.Alias = alias
+	}
+	
+	return item, nil
+}
+
+// parseOrderItem разбирает ключ сортировки ORDER BY.
+// Имя, совпадающее с псевдонимом столбца SELECT, ссылается на выражение этого столбца.
+// Без NULLS FIRST/LAST значения NULL идут в конце при ASC и в начале при DESC
+func (p *parser) parseOrderItem(columns []*SelectItem) (*OrderItem, error) {
+	expr, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	
+	if field, ok := expr.(*FieldRef); ok {
+		for _, column := range columns {
+			if column.Alias != "" && column.Alias == field.Path {
+				expr = column.Expr
+				break
+			}
+		}
+	}
+	
+	item := &OrderItem{Expr: expr}
+	if p.acceptKeyword("DESC") {
+		item.Descending = true
+	} else {
+		p.acceptKeyword("ASC")
+	}
+	item.NullsFirst = item.Descending
+	
+	if p.acceptKeyword("NULLS") {
+		switch {
+		case p.acceptKeyword("FIRST"):
+			item.NullsFirst = true
+		case p.acceptKeyword("LAST"):
+			item.NullsFirst = false
+		default:
+			return nil, p.errorf(p.peek(), "ожидалось FIRST или LAST, получено %s", p.peek())
+		}
+	}
+	
+	return item, nil
+}
+
+// parseCount разбирает неотрицательное целое число для LIMIT и OFFSET
+func (p *parser) parseCount() (int, error) {
+	tok := p.next()
+	if tok.Type != TokenNumber {
+		return 0, p.errorf(tok, "ожидалось неотрицательное целое число, получено %s", tok)
+	}
+	
+	n, err := strconv.Atoi(tok.Text)
+	if err != nil {
+		return 0, p.errorf(tok, "ожидалось неотрицательное целое число, получено %s", tok)
+	}
+	
+	return n, nil
+}
+
+// parseIdent разбирает идентификатор
+func (p *parser) parseIdent() (string, error) {
+	tok := p.peek()
+	
+	switch {
+	case tok.Type == TokenQuotedIdent:
+		p.next()
+		return tok.Text, nil
+	case tok.Type == TokenIdent && !reservedWords[strings.ToUpper(tok.Text)]:
+		p.next()
+		return tok.Text, nil
+	}
+	
+	return "", p.errorf(tok, "ожидался идентификатор, получено %s", tok)
+}
+
+// parsePath разбирает путь к полю с обращениями к вложенным полям и элементам массивов
+func (p *parser) parsePath() (string, error) {
+	first, err := p.parseIdent()
+	if err != nil {
+		return "", err
+	}
+	
+	var sb strings.Builder
+	sb.WriteString(first)
+	for {
+		switch {
+		case p.acceptSymbol("."):
+			part, err := p.parseIdent()
+			if err != nil {
+				return "", err
+			}
+			sb.WriteString("." + part)
+		case p.acceptSymbol("["):
+			tok := p.next()
+			switch {
+			case tok.Type == TokenSymbol && tok.Text == "*":
+				sb.WriteString("[*]")
+			case tok.Type == TokenNumber && !strings.ContainsAny(tok.Text, ".eE"):
+				sb.WriteString("[" + tok.Text + "]")
+			default:
+				return "", p.errorf(tok, "ожидался индекс массива или *, получено %s", tok)
+			}
+			
+			if err := p.expectSymbol("]"); err != nil {
+				return "", err
+			}
+		default:
+			return sb.String(), nil
+		}
+	}
+}
+
+// parseValue разбирает MATCH, COALESCE, вызов агрегатной или скалярной функции либо путь к полю
+func (p *parser) parseValue() (Expr, error) {
+	tok := p.peek()
+	if next := p.peekNext(); tok.Type == TokenIdent && next.Type == TokenSymbol && next.Text == "(" {
+		switch strings.ToUpper(tok.Text) {
+		case "MATCH":
+			return p.parseMatch()
+		case "COALESCE":
+			return p.parseCoalesce()
+		}
+		if aggregateFunctions[strings.ToUpper(tok.Text)] {
+			return p.parseAggregate()
+		}
+		return p.parseFuncCall()
+	}
+	
+	path, err := p.parsePath()
+	if err != nil {
+		return nil, err
+	}
+	return &FieldRef{Path: path}, nil
+}
+
+// parseAggregate разбирает вызов агрегатной функции
+func (p *parser) parseAggregate() (Expr, error) {
+	name := strings.ToUpper(p.next().Text)
+	p.next() // "("
+	
+	agg := &Aggregate{Func: name}
+	
+	if p.isSymbol("*") {
+		if name != "COUNT" {
+			return nil, p.errorf(p.peek(), "%s(*) не поддерживается, допустимо только COUNT(*)", name)
+		}
+		p.next()
+	} else {
+		agg.Distinct = p.acceptKeyword("DISTINCT")
+		
+		argTok := p.peek()
+		arg, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		
+		nested := false
+		visitExpr(arg, func(e Expr) {
+			if _, ok := e.(*Aggregate); ok {
+				nested = true
+			}
+		})
+		if nested {
+			return nil, p.errorf(argTok, "вложенные агрегатные функции не допускаются")
+		}
+		agg.Arg = arg
+	}
+	
+	if err := p.expectSymbol(")"); err != nil {
+		return nil, err
+	}
+	
+	return agg, nil
+}
+
+// parseFuncCall разбирает вызов скалярной функции.
+// Существование функции и число аргументов проверяются при выполнении запроса
+func (p *parser) parseFuncCall() (Expr, error) {
+	call := &FuncCall{Name: strings.ToUpper(p.next().Text), Args: make([]Expr, 0)}
+	p.next() // "("
+	
+	if p.acceptSymbol(")") {
+		return call, nil
+	}
+	
+	for {
+		arg, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		call.Args = append(call.Args, arg)
+		
+		if !p.acceptSymbol(",") {
+			break
+		}
+	}
+	
+	if err := p.expectSymbol(")"); err != nil {
+		return nil, err
+	}
+	
+	return call, nil
+}
+
+// parseOr разбирает дизъюнкцию условий
+func (p *parser) parseOr() (*Condition, error) {
+	return p.parseLogical("OR", p.parseAnd)
+}
+
+// parseAnd разбирает конъюнкцию условий
+func (p *parser) parseAnd() (*Condition, error) {
+	return p.parseLogical("AND", p.parseNot)
+}
+
+// parseLogical разбирает цепочку условий, соединенных оператором op
+func (p *parser) parseLogical(op string, operand func() (*Condition, error)) (*Condition, error) {
+	first, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	
+	if !p.isKeyword(op) {
+		return first, nil
+	}
+	
+	children := []*Condition{first}
+	for p.acceptKeyword(op) {
+		child, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, child)
+	}
+	
+	return &Condition{
+		ChildOp:  op,
+		Children: children,
+	}, nil
+}
+
+// parseNot разбирает отрицание, условие в скобках или сравнение
+func (p *parser) parseNot() (*Condition, error) {
+	if p.acceptKeyword("NOT") {
+		child, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		
+		return &Condition{
+			ChildOp:  "NOT",
+			Children: []*Condition{child},
+		}, nil
+	}
+	
+	if p.acceptSymbol("(") {
+		cond, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		
+		if err := p.expectSymbol(")"); err != nil {
+			return nil, err
+		}
+		return cond, nil
+	}
+	
+	return p.parseComparison()
+}
+
+// parseComparison разбирает сравнение двух операндов, IN, EXISTS или IS.
+// Сравнение константы с полем или агрегатом приводится к виду "поле оператор константа"
+func (p *parser) parseComparison() (*Condition, error) {
+	if p.isKeyword("EXISTS") {
+		return p.parseExists()
+	}
+	
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	if p.acceptKeyword("IS") {
+		return p.parseIs(left)
+	}
+	
+	// NOT перед IN, LIKE, ILIKE и BETWEEN отрицает условие
+	not := ""
+	if next := p.peekNext(); p.isKeyword("NOT") && next.Type == TokenIdent && negatable[strings.ToUpper(next.Text)] {
+		p.next()
+		not = "NOT "
+	}
+	
+	switch {
+	case p.acceptKeyword("IN"):
+		return p.parseIn(left, not+"IN")
+	case p.acceptKeyword("LIKE"):
+		return p.parsePattern(left, not+"LIKE")
+	case p.acceptKeyword("ILIKE"):
+		return p.parsePattern(left, not+"ILIKE")
+	case p.acceptKeyword("BETWEEN"):
+		return p.parseBetween(left, not+"BETWEEN")
+	case p.acceptSymbol("~"):
+		return p.parsePattern(left, "~")
+	}
+	
+	// MATCH без оператора сравнения сам является условием
+	if match, ok := left.(*Match); ok {
+		if _, isOp := comparisonOperators[p.peek().Text]; p.peek().Type != TokenSymbol || !isOp {
+			return &Condition{Left: match, Operator: "MATCH"}, nil
+		}
+	}
+	
+	tok := p.next()
+	op, ok := comparisonOperators[tok.Text]
+	if tok.Type != TokenSymbol || !ok {
+		return nil, p.errorf(tok, "ожидался оператор сравнения, получено %s", tok)
+	}
+	
+	right, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	
+	_, leftIsLiteral := left.(*Literal)
+	_, rightIsLiteral := right.(*Literal)
+	if leftIsLiteral && !rightIsLiteral {
+		left, right = right, left
+		op = mirroredOperators[op]
+	}
+	
+	return &Condition{
+		Left:     left,
+		Operator: op,
+		Right:    right,
+	}, nil
+}
+
+// parseOperand разбирает операнд сравнения: путь к полю или константу
+func (p *parser) parseOperand() (Expr, error) {
+	tok := p.peek()
+	
+	switch tok.Type {
+	case TokenString:
+		p.next()
+		return &Literal{Value: tok.Text}, nil
+	case TokenObject:
+		content, err := p.parseObject()
+		if err != nil {
+			return nil, err
+		}
+		return &Literal{Value: content}, nil
+	case TokenNumber:
+		p.next()
+		return p.parseNumber(tok, false)
+	case TokenSymbol:
+		if tok.Text == "-" {
+			p.next()
+			numTok := p.next()
+			if numTok.Type != TokenNumber {
+				return nil, p.errorf(numTok, "ожидалось число после \"-\", получено %s", numTok)
+			}
+			return p.parseNumber(numTok, true)
+		}
+	case TokenIdent:
+		switch strings.ToUpper(tok.Text) {
+		case "TRUE":
+			p.next()
+			return &Literal{Value: true}, nil
+		case "FALSE":
+			p.next()
+			return &Literal{Value: false}, nil
+		case "NULL":
+			p.next()
+			return &Literal{Value: nil}, nil
+		}
+	}
+	
+	if tok.Type == TokenIdent || tok.Type == TokenQuotedIdent {
+		return p.parseValue()
+	}
+	
+	return nil, p.errorf(tok, "ожидалось поле или значение, получено %s", tok)
+}
+
+// parseNumber преобразует числовую лексему в целое число или число с плавающей точкой
+func (p *parser) parseNumber(tok Token, negative bool) (Expr, error) {
+	text := tok.Text
+	if negative {
+		text = "-" + text
+	}
+	
+	if !strings.ContainsAny(text, ".eE") {
+		if i, err := strconv.Atoi(text); err == nil {
+			return &Literal{Value: i}, nil
+		}
+	}
+	
+	f, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return nil, p.errorf(tok, "неверное число %s", text)
+	}
+	
+	return &Literal{Value: f}, nil
+}
@@ -0,0 +1,59 @@
+package query
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	p := NewQueryParser()
+	for q, want := range map[string]string{
+		"SELECT * FROM users WHERE name = 'AND WHERE LIMIT' LIMIT 5":                                          "SELECT * FROM users WHERE name = 'AND WHERE LIMIT' LIMIT 5",
+		"select name, age from users where (a = 1 or b = 2) and not c = -3.5e2 -- comment\n offset 2 limit 3": "SELECT name, age FROM users WHERE (a = 1 OR b = 2) AND NOT c = -350 LIMIT 3 OFFSET 2",
+		"SELECT * FROM users WHERE name = 'O''Brien' OR name = 'It\\'s' /* c */;":                             "SELECT * FROM users WHERE name = 'O''Brien' OR name = 'It''s'",
+		"SELECT a.b FROM t WHERE 5 < age":                                                                     "SELECT a.b FROM t WHERE age > 5",
+		"EXPLAIN SELECT * FROM t":                                                                             "EXPLAIN SELECT * FROM t",
+	} {
+		parsed, err := p.Parse(q)
+		if err != nil {
+			t.Fatalf("%q: %v", q, err)
+		}
+		if got := parsed.String(); got != want {
+			t.Fatalf("%q: разобрано как %q, ожидалось %q", q, got, want)
+		}
+		
+		// Текст запроса разбирается в тот же запрос
+		again, err := p.Parse(want)
+		if err != nil || again.String() != want {
+			t.Fatalf("%q: повторный разбор дает %v, %v", want, again, err)
+		}
+	}
+}
+
+func TestParseErrorPosition(t *testing.T) {
+	p := NewQueryParser()
+	for _, c := range []struct {
+		q            string
+		line, column int
+		message      string
+	}{
+		{"SELECT * FROM", 1, 14, "ожидался идентификатор, получено конец запроса"},
+		{"SELECT * FROM t WHERE a = 'x", 1, 27, "незакрытая строка"},
+		{"SELECT * FROM t\nWHERE a = = 1", 2, 11, "ожидалось поле или значение, получено ="},
+		{"SELECT * FROM t WHERE (a = 1", 1, 29, `ожидалось ")", получено конец запроса`},
+		{"SELECT * FROM t LIMIT -1", 1, 23, "ожидалось неотрицательное целое число, получено -"},
+		{"SELECT * FROM t WHERE a = 1 /* x", 1, 29, "незакрытый комментарий"},
+		{"SELECT * FROM t\n  WHERE a = 1\n  ORDER age", 3, 9, "ожидалось BY, получено age"},
+		{"SELECT * FROM t WHERE a = 1 extra", 1, 29, "неожиданная лексема extra"},
+		{"SELECT * FROM t WHERE a @ 1", 1, 25, "неожиданный символ '@'"},
+	} {
+		_, err := p.Parse(c.q)
+		var perr *ParseError
+		if !errors.As(err, &perr) {
+			t.Fatalf("%q: ожидалась *ParseError, получено %v", c.q, err)
+		}
+		if perr.Line != c.line || perr.Column != c.column || perr.Message != c.message {
+			t.Errorf("%q: %v, ожидалось строка %d, столбец %d: %s", c.q, err, c.line, c.column, c.message)
+		}
+	}
+}
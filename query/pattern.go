@@ -0,0 +1,262 @@
+package query
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/urusofam/jsondb/index"
+	"github.com/urusofam/jsondb/storage"
+)
+
+// patternCache хранит скомпилированные шаблоны условия LIKE, ILIKE или ~.
+// Шаблон, вычисляемый по документу, компилируется один раз за запрос
+// для каждого различного значения; неверному выражению соответствует nil
+type patternCache struct {
+	mu       sync.Mutex
+	patterns map[string]*regexp.Regexp
+}
+
+// parsePattern разбирает [ NOT ] LIKE, [ NOT ] ILIKE и ~ с шаблоном-операндом
+func (p *parser) parsePattern(left Expr, op string) (*Condition, error) {
+	right, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	
+	return &Condition{
+		Left:     left,
+		Operator: op,
+		Right:    right,
+		patterns: &patternCache{patterns: make(map[string]*regexp.Regexp)},
+	}, nil
+}
+
+// parseBetween разбирает [ NOT ] BETWEEN operand AND operand
+func (p *parser) parseBetween(left Expr, op string) (*Condition, error) {
+	low, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expectKeyword("AND"); err != nil {
+		return nil, err
+	}
+	high, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	
+	return &Condition{Left: left, Operator: op, Right: &List{Items: []Expr{low, high}}}, nil
+}
+
+// pattern возвращает скомпилированный шаблон условия или nil для неверного выражения
+func (c *Condition) pattern(text string) *regexp.Regexp {
+	if c.patterns == nil {
+		return compilePattern(c.Operator, text)
+	}
+	
+	c.patterns.mu.Lock()
+	defer c.patterns.mu.Unlock()
+	
+	re, ok := c.patterns.patterns[text]
+	if !ok {
+		re = compilePattern(c.Operator, text)
+		c.patterns.patterns[text] = re
+	}
+	return re
+}
+
+// compilePattern компилирует шаблон LIKE или ILIKE в регулярное выражение
+// для всей строки; шаблон ~ - регулярное выражение, как в REGEXP_MATCH
+func compilePattern(op, text string) *regexp.Regexp {
+	expr := text
+	switch strings.TrimPrefix(op, "NOT ") {
+	case "LIKE":
+		expr = likeRegexp(text)
+	case "ILIKE":
+		expr = "(?i)" + likeRegexp(text)
+	}
+	
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil
+	}
+	return re
+}
+
+// likeRegexp переводит шаблон LIKE в регулярное выражение: % - любая
+// последовательность символов, _ - один символ, \ экранирует следующий символ
+func likeRegexp(pattern string) string {
+	var sb strings.Builder
+	sb.WriteString(`(?s)\A`)
+	
+	runes := []rune(pattern)
+	for i := 0; i < len(runes); i++ {
+		switch r := runes[i]; {
+		case r == '\\' && i+1 < len(runes):
+			i++
+			sb.WriteString(regexp.QuoteMeta(string(runes[i])))
+		case r == '%':
+			sb.WriteString(".*")
+		case r == '_':
+			sb.WriteString(".")
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	
+	sb.WriteString(`\z`)
+	return sb.String()
+}
+
+// likePrefix возвращает постоянное начало шаблона LIKE до первого символа
+// подстановки. Второе значение равно true, если символов подстановки нет
+func likePrefix(pattern string) (string, bool) {
+	var sb strings.Builder
+	
+	runes := []rune(pattern)
+	for i := 0; i < len(runes); i++ {
+		switch r := runes[i]; {
+		case r == '\\' && i+1 < len(runes):
+			i++
+			sb.WriteRune(runes[i])
+		case r == '%' || r == '_':
+			return sb.String(), false
+		default:
+			sb.WriteRune(r)
+		}
+	}
+	return sb.String(), true
+}
+
+// evalPattern проверяет условие LIKE, ILIKE или ~ для строковых значений.
+// Значение другого типа и неверное регулярное выражение не соответствуют
+// шаблону; NULL в значении или шаблоне дает UNKNOWN. Форма с NOT - отрицание
+// условия без NOT
+func (qe *QueryExecutor) evalPattern(doc storage.Document, cond *Condition) (truth, error) {
+	lefts, err := qe.evalValues(doc, cond.Left)
+	if err != nil {
+		return truthFalse, err
+	}
+	rights, err := qe.evalValues(doc, cond.Right)
+	if err != nil {
+		return truthFalse, err
+	}
+	
+	result := truthFalse
+	if len(lefts) == 0 || len(rights) == 0 {
+		result = truthUnknown
+	}
+	for _, right := range rights {
+		for _, left := range lefts {
+			if left == nil || right == nil {
+				result = result.or(truthUnknown)
+				continue
+			}
+			
+			text, ok := right.(string)
+			if !ok {
+				continue
+			}
+			re := cond.pattern(text)
+			if s, ok := left.(string); ok && re != nil && re.MatchString(s) {
+				result = truthTrue
+			}
+		}
+	}
+	
+	if strings.HasPrefix(cond.Operator, "NOT ") {
+		return result.not(), nil
+	}
+	return result, nil
+}
+
+// evalBetween проверяет, лежит ли значение между границами включительно.
+// NULL в значении или границах дает UNKNOWN; NOT BETWEEN - отрицание BETWEEN
+func (qe *QueryExecutor) evalBetween(doc storage.Document, cond *Condition) (truth, error) {
+	bounds := cond.Right.(*List).Items
+	lows, err := qe.evalValues(doc, bounds[0])
+	if err != nil {
+		return truthFalse, err
+	}
+	highs, err := qe.evalValues(doc, bounds[1])
+	if err != nil {
+		return truthFalse, err
+	}
+	lefts, err := qe.evalValues(doc, cond.Left)
+	if err != nil {
+		return truthFalse, err
+	}
+	
+	result := truthFalse
+	if len(lefts) == 0 || len(lows) == 0 || len(highs) == 0 || lows[0] == nil || highs[0] == nil {
+		result = truthUnknown
+	} else {
+		for _, left := range lefts {
+			if left == nil {
+				result = result.or(truthUnknown)
+			} else if qe.between(left, lows[0], highs[0]) {
+				result = truthTrue
+			}
+		}
+	}
+	
+	if strings.HasPrefix(cond.Operator, "NOT ") {
+		return result.not(), nil
+	}
+	return result, nil
+}
+
+// between проверяет, что low <= value <= high. Значения разных типов
+// сравниваются по рангу типа, как в индексе, поэтому строка не лежит
+// между числами; даты сравниваются и со строками дат
+func (qe *QueryExecutor) between(value, low, high interface{}) bool {
+	if t, ok := value.(time.Time); ok {
+		return qe.compareValues(t, ">=", low) == truthTrue && qe.compareValues(t, "<=", high) == truthTrue
+	}
+	return index.Compare(value, low) >= 0 && index.Compare(value, high) <= 0
+}
+
+// planPattern строит план для LIKE с постоянным началом шаблона: шаблон без
+// символов подстановки - поиск на равенство, иначе - просмотр диапазона строк
+// с этим началом в упорядоченном индексе
+func (qe *QueryExecutor) planPattern(coll Collection, cond *Condition) *PlanNode {
+	field, value, ok := fieldComparison(cond)
+	if !ok {
+		return nil
+	}
+	pattern, ok := value.(string)
+	if !ok {
+		return nil
+	}
+	
+	prefix, exact := likePrefix(pattern)
+	if exact {
+		return qe.planCondition(coll, &Condition{Left: cond.Left, Operator: "=", Right: &Literal{Value: prefix}})
+	}
+	if prefix == "" || field == "_id" {
+		return nil
+	}
+	
+	if _, ok := coll.Indexes[field].(index.OrderedIndex); !ok {
+		return nil
+	}
+	return &PlanNode{Type: PlanIndexRange, Field: field, Range: index.Prefix(prefix)}
+}
+
+// betweenBounds возвращает поле и постоянные границы условия BETWEEN
+func betweenBounds(cond *Condition) (string, interface{}, interface{}, bool) {
+	field, ok := cond.Left.(*FieldRef)
+	if !ok || field.Depth > 0 || cond.Operator != "BETWEEN" {
+		return "", nil, nil, false
+	}
+	
+	bounds := cond.Right.(*List).Items
+	low, lowOK := bounds[0].(*Literal)
+	high, highOK := bounds[1].(*Literal)
+	if !lowOK || !highOK {
+		return "", nil, nil, false
+	}
+	return field.Path, low.Value, high.Value, true
+}
@@ -0,0 +1,188 @@
+package query
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/urusofam/jsondb/storage"
+)
+
+// likeMatch проверяет строку на соответствие шаблону LIKE перебором вариантов
+func likeMatch(s, pattern []rune) bool {
+	if len(pattern) == 0 {
+		return len(s) == 0
+	}
+	switch r := pattern[0]; {
+	case r == '\\' && len(pattern) > 1:
+		return len(s) > 0 && s[0] == pattern[1] && likeMatch(s[1:], pattern[2:])
+	case r == '%':
+		for i := 0; i <= len(s); i++ {
+			if likeMatch(s[i:], pattern[1:]) {
+				return true
+			}
+		}
+		return false
+	case r == '_':
+		return len(s) > 0 && likeMatch(s[1:], pattern[1:])
+	}
+	return len(s) > 0 && s[0] == pattern[0] && likeMatch(s[1:], pattern[1:])
+}
+
+func TestLikePrefix(t *testing.T) {
+	for pattern, want := range map[string]string{
+		"An%":     "An false",
+		"Anna":    "Anna true",
+		"_nna":    " false",
+		`a\_b\%c`: "a_b%c true",
+		`a\_b%`:   "a_b false",
+		`ab\`:     `ab\ true`,
+		"":        " true",
+		"ёж%":     "ёж false",
+	} {
+		prefix, exact := likePrefix(pattern)
+		if got := fmt.Sprint(prefix, " ", exact); got != want {
+			t.Errorf("%q: %s, ожидалось %s", pattern, got, want)
+		}
+	}
+}
+
+func TestLikeMatchesBruteForce(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	alphabet := []rune{'a', 'b', 'A', 'ё', 'Ё', '%', '_', '\\', '\n'}
+	randomString := func(symbols []rune, n int) string {
+		runes := make([]rune, rnd.Intn(n))
+		for i := range runes {
+			runes[i] = symbols[rnd.Intn(len(symbols))]
+		}
+		return string(runes)
+	}
+	
+	docs := make([]storage.Document, 0, 300)
+	for i := 0; i < 300; i++ {
+		var name interface{} = randomString(alphabet, 6)
+		switch i % 13 {
+		case 0:
+			name = float64(i)
+		case 1:
+			name = nil
+		case 2:
+			name = []interface{}{randomString(alphabet, 4), randomString(alphabet, 4)}
+		}
+		docs = append(docs, storage.Document{ID: fmt.Sprintf("d%03d", i), Content: map[string]interface{}{"name": name}})
+	}
+	indexed, scan := plannerExecutors(t, docs, "name")
+	
+	// Шаблон записывается строковым литералом, в котором \ и ' экранируются
+	quote := strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+	for i := 0; i < 300; i++ {
+		pattern := randomString(append(alphabet, '%', '%', '_'), 5)
+		for _, op := range []string{"LIKE", "NOT LIKE", "ILIKE"} {
+			q := fmt.Sprintf("SELECT _id FROM t WHERE name %s '%s'", op, quote.Replace(pattern))
+			got := rowSet(mustRows(t, indexed, q))
+			if want := rowSet(mustRows(t, scan, q)); got != want {
+				t.Fatalf("%q: %s, полный просмотр дает %s", q, got, want)
+			}
+			
+			// NOT LIKE выполняется и для значений других типов, поэтому перебором проверяются только LIKE и ILIKE
+			if op == "NOT LIKE" {
+				continue
+			}
+			var want []map[string]interface{}
+			for _, doc := range docs {
+				s, ok := doc.Content["name"].(string)
+				if !ok {
+					continue
+				}
+				text, p := []rune(s), []rune(pattern)
+				if op == "ILIKE" {
+					text, p = []rune(strings.ToLower(s)), []rune(strings.ToLower(pattern))
+				}
+				if likeMatch(text, p) {
+					want = append(want, map[string]interface{}{"_id": doc.ID})
+				}
+			}
+			if got != rowSet(want) {
+				t.Fatalf("%q: %s, перебор дает %s", q, got, rowSet(want))
+			}
+		}
+	}
+}
+
+func TestPatternConditions(t *testing.T) {
+	docs := []storage.Document{
+		{ID: "1", Content: map[string]interface{}{"name": "Anna", "age": 20.0, "tags": []interface{}{"go", "db"}}},
+		{ID: "2", Content: map[string]interface{}{"name": "anton", "age": 30.0}},
+		{ID: "3", Content: map[string]interface{}{"name": "Boris", "age": 40.0}},
+		{ID: "4", Content: map[string]interface{}{"name": "a_b%c", "age": "x"}},
+		{ID: "5", Content: map[string]interface{}{"name": "Ёжик\nx", "age": 25.0}},
+	}
+	indexed, scan := plannerExecutors(t, docs, "name", "age")
+	
+	for where, want := range map[string]string{
+		"name LIKE 'An%'":                          "1",
+		"name ILIKE 'an%'":                         "1,2",
+		"name NOT ILIKE 'an%'":                     "3,4,5",
+		"name LIKE '_nna'":                         "1",
+		`name LIKE 'a\_b\%c'`:                      "4",
+		"name LIKE 'a_b%'":                         "4",
+		"name LIKE 'Anna'":                         "1",
+		"name ILIKE 'ёЖ%'":                         "5",
+		"name LIKE '%x'":                           "5",
+		"name ~ '^[AB]'":                           "1,3",
+		"name ~ 'or'":                              "3",
+		"name ~ '('":                               "",
+		"NOT name ~ 'n'":                           "3,4,5",
+		"name LIKE name":                           "1,2,3,4,5",
+		"tags[*] LIKE 'd%'":                        "1",
+		"age BETWEEN 20 AND 30":                    "1,2,5",
+		"age NOT BETWEEN 21 AND 30 AND age > 0":    "1,3",
+		"age BETWEEN 20 AND 30 AND name LIKE 'a%'": "2",
+		"_id LIKE '1%'":                            "1",
+		"_id LIKE '3'":                             "3",
+	} {
+		q := "SELECT _id FROM t WHERE " + where + " ORDER BY _id"
+		for _, qe := range []*QueryExecutor{indexed, scan} {
+			rows := mustRows(t, qe, q)
+			ids := make([]string, len(rows))
+			for i, row := range rows {
+				ids[i] = fmt.Sprint(row["_id"])
+			}
+			if got := strings.Join(ids, ","); got != want {
+				t.Errorf("%s: %s, ожидалось %s", where, got, want)
+			}
+		}
+	}
+	
+	for where, want := range map[string]string{
+		"name LIKE 'An%'":       PlanIndexRange,
+		"name LIKE 'Anna'":      PlanIndexLookup,
+		"name LIKE '%nna'":      PlanFullScan,
+		"name ILIKE 'An%'":      PlanFullScan,
+		"name NOT LIKE 'An%'":   PlanFullScan,
+		"age BETWEEN 20 AND 30": PlanIndexRange,
+	} {
+		parsed, err := NewQueryParser().Parse("SELECT _id FROM t WHERE " + where)
+		if err != nil {
+			t.Fatal(err)
+		}
+		plan, err := indexed.Explain(parsed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := planShape(accessPlan(plan)); got != want {
+			t.Errorf("%s: план %s, ожидался %s", where, got, want)
+		}
+	}
+	
+	for _, q := range []string{
+		"SELECT _id FROM t WHERE age BETWEEN 1",
+		"SELECT _id FROM t WHERE name NOT ~ 'x'",
+		"SELECT _id FROM t WHERE name LIKE",
+	} {
+		if _, err := run(indexed, q); err == nil {
+			t.Errorf("%s: ожидалась ошибка", q)
+		}
+	}
+}
@@ -0,0 +1,563 @@
+package query
+
+import (
+	"sort"
+	"time"
+
+	"github.com/urusofam/jsondb/index"
+	"github.com/urusofam/jsondb/storage"
+)
+
+// Типы узлов плана доступа к документам
+const (
+	// PlanFullScan - полный просмотр коллекции
+	PlanFullScan = "FullScan"
+	// PlanIDLookup - выборка документа по _id
+	PlanIDLookup = "IDLookup"
+	// PlanIndexLookup - поиск по индексу на равенство
+	PlanIndexLookup = "IndexLookup"
+	// PlanIndexRange - просмотр диапазона упорядоченного индекса
+	PlanIndexRange = "IndexRange"
+	// PlanIndexScan - полный упорядоченный обход индекса вместо просмотра коллекции
+	PlanIndexScan = "IndexScan"
+	// PlanIntersect - пересечение результатов дочерних узлов (AND)
+	PlanIntersect = "Intersect"
+	// PlanUnion - объединение результатов дочерних узлов (OR)
+	PlanUnion = "Union"
+)
+
+// Типы этапов обработки документов, выбранных планом доступа
+const (
+	// PlanFilter - проверка условия WHERE
+	PlanFilter = "Filter"
+	// PlanGroup - группировка по GROUP BY и вычисление агрегатных функций
+	PlanGroup = "Group"
+	// PlanHaving - проверка условия HAVING для групп
+	PlanHaving = "Having"
+	// PlanSort - сортировка по ORDER BY
+	PlanSort = "Sort"
+	// PlanLimit - применение OFFSET и LIMIT
+	PlanLimit = "Limit"
+	// PlanProject - выбор полей результата
+	PlanProject = "Project"
+)
+
+// PlanNode представляет узел плана выполнения запроса.
+// Листья и узлы Intersect/Union образуют план доступа, который определяет
+// только набор документов-кандидатов: условие WHERE затем проверяется
+// для каждого кандидата полностью
+type PlanNode struct {
+	Type       string
+	Collection string
+	Field      string
+	Value      interface{}
+	Range      index.Range
+	Condition  *Condition
+	Limit      int
+	Offset     int
+	Fields     []string
+	Children   []*PlanNode
+	
+	// KeyFields и Prefix задаются для узлов над составным индексом:
+	// поля индекса и значения полей начала ключа, заданные условием на равенство.
+	// Range тогда ограничивает значение поля, следующего за началом ключа
+	KeyFields []string
+	Prefix    []interface{}
+	// OrderBy задает ключи сортировки узла Sort либо порядок обхода
+	// индекса узлами IndexRange и IndexScan
+	OrderBy []*OrderItem
+	// GroupBy и Aggregates задают ключи группировки и агрегатные функции узла Group
+	GroupBy    []Expr
+	Aggregates []*Aggregate
+	// Join и JoinKey задаются для узлов соединения: присоединяемая коллекция
+	// и выражение над уже соединенными строками, значение которого ищется
+	// в поле Field присоединяемой коллекции
+	Join    *Join
+	JoinKey Expr
+	// StopAfter ограничивает число ID, выбираемых упорядоченным обходом индекса (0 - без ограничения)
+	StopAfter int
+	
+	// EstimatedRows - оценка числа строк до выполнения (-1, если оценка невозможна)
+	EstimatedRows int
+	// ActualRows - фактическое число строк, полученных узлом
+	ActualRows int
+	// Duration - время выполнения этапа (для Intersect и Union - вместе с дочерними узлами)
+	Duration time.Duration
+}
+
+// planAccess выбирает способ доступа к документам для условия WHERE
+func (qe *QueryExecutor) planAccess(coll Collection, cond *Condition) *PlanNode {
+	if cond != nil {
+		if node := qe.planCondition(coll, cond); node != nil {
+			return node
+		}
+	}
+	
+	return &PlanNode{Type: PlanFullScan}
+}
+
+// planOrder пытается обеспечить порядок ORDER BY обходом индекса вместо сортировки.
+// Второе значение равно true, если план доступа возвращает документы в нужном порядке
+func (qe *QueryExecutor) planOrder(coll Collection, query *Query, access *PlanNode) (*PlanNode, bool) {
+	if rankedByIndex(query, access) {
+		return access, true
+	}
+	
+	if len(query.OrderBy) != 1 || access.KeyFields != nil {
+		return qe.planCompoundOrder(coll, query, access)
+	}
+	
+	item := query.OrderBy[0]
+	// Индекс по пути с [*] содержит документ под несколькими ключами
+	field, ok := item.Expr.(*FieldRef)
+	if !ok || storage.HasWildcard(field.Path) {
+		return access, false
+	}
+	
+	idx, ok := coll.Indexes[field.Path].(index.OrderedIndex)
+	if !ok {
+		return qe.planCompoundOrder(coll, query, access)
+	}
+	
+	switch {
+	case access.Type == PlanIndexLookup && access.Field == field.Path:
+		// Все найденные документы имеют одинаковое значение ключа
+		return access, true
+	case access.Type == PlanIndexRange && access.Field == field.Path:
+	case access.Type == PlanFullScan && coversCollection(coll, idx):
+		// Индекс содержит все документы коллекции, поэтому его обход заменяет просмотр
+		access = &PlanNode{Type: PlanIndexScan, Field: field.Path}
+	default:
+		return qe.planCompoundOrder(coll, query, access)
+	}
+	
+	access.OrderBy = query.OrderBy
+	
+	// Если условие полностью проверяется диапазоном индекса,
+	// обход можно остановить сразу после OFFSET + LIMIT документов
+	if query.Limit > 0 && (query.Where == nil || coveredByRange(query.Where, field.Path)) {
+		access.StopAfter = query.Offset + query.Limit
+	}
+	
+	return access, true
+}
+
+// coversCollection проверяет, проиндексированы ли все документы коллекции
+func coversCollection(coll Collection, idx index.Index) bool {
+	stats, ok := idx.(index.Statistics)
+	if !ok {
+		return false
+	}
+	
+	counter, ok := coll.Storage.(storage.Counter)
+	if !ok {
+		return false
+	}
+	
+	count, err := counter.Count()
+	return err == nil && count == stats.Len()
+}
+
+// coveredByRange проверяет, что условие состоит только из сравнений поля field
+// с числами или строками и проверки IS NOT NULL, то есть полностью выражается
+// диапазоном индекса
+func coveredByRange(cond *Condition, field string) bool {
+	if len(cond.Children) > 0 {
+		if cond.ChildOp != "AND" {
+			return false
+		}
+		for _, child := range cond.Children {
+			if !coveredByRange(child, field) {
+				return false
+			}
+		}
+		return true
+	}
+	
+	if path, low, high, ok := betweenBounds(cond); ok {
+		return path == field && scalarBound(low) && scalarBound(high)
+	}
+	if ref, ok := cond.Left.(*FieldRef); ok && cond.Operator == "IS NOT NULL" {
+		return ref.Depth == 0 && ref.Path == field
+	}
+	
+	path, value, ok := fieldComparison(cond)
+	if !ok || path != field || !scalarBound(value) {
+		return false
+	}
+	
+	switch cond.Operator {
+	case ">", ">=", "<", "<=":
+		return true
+	}
+	return false
+}
+
+// scalarBound проверяет, является ли граница диапазона числом или строкой
+func scalarBound(value interface{}) bool {
+	switch value.(type) {
+	case int, float64, string:
+		return true
+	}
+	return false
+}
+
+// planCondition строит план для условия; nil означает, что ни один индекс не применим
+func (qe *QueryExecutor) planCondition(coll Collection, cond *Condition) *PlanNode {
+	if len(cond.Children) > 0 {
+		switch cond.ChildOp {
+		case "AND":
+			return qe.planAnd(coll, cond.Children)
+		case "OR":
+			return qe.planOr(coll, cond.Children)
+		}
+		return nil
+	}
+	
+	if match, ok := cond.Left.(*Match); ok && cond.Operator == "MATCH" {
+		return planMatch(coll, match)
+	}
+	
+	switch cond.Operator {
+	case "IN":
+		// IN с известными значениями выполняется как объединение поисков по равенству
+		if expanded := expandIn(cond); expanded != nil {
+			return qe.planCondition(coll, expanded)
+		}
+		return nil
+	case "LIKE":
+		return qe.planPattern(coll, cond)
+	case "IS NULL", "IS NOT NULL", "IS MISSING", "IS NOT MISSING":
+		return qe.planIs(coll, cond)
+	case "BETWEEN":
+		field, low, high, ok := betweenBounds(cond)
+		if !ok || field == "_id" || low == nil || high == nil {
+			return nil
+		}
+		if _, ordered := coll.Indexes[field].(index.OrderedIndex); !ordered {
+			return nil
+		}
+		return &PlanNode{Type: PlanIndexRange, Field: field, Range: index.Between(low, high)}
+	}
+	
+	field, value, ok := fieldComparison(cond)
+	if !ok {
+		return nil
+	}
+	
+	if field == "_id" {
+		if id, ok := value.(string); ok && cond.Operator == "=" {
+			return &PlanNode{Type: PlanIDLookup, Field: field, Value: id}
+		}
+		return nil
+	}
+	
+	idx, ok := coll.Indexes[field]
+	if !ok {
+		// Поле может быть первым полем составного индекса
+		node, _, _ := qe.planCompound(coll, []*Condition{cond})
+		return node
+	}
+	
+	// Полнотекстовый индекс не хранит значения поля целиком
+	if _, ok := idx.(*index.FullTextIndex); ok {
+		return nil
+	}
+	
+	switch cond.Operator {
+	case "=":
+		return &PlanNode{Type: PlanIndexLookup, Field: field, Value: value}
+	case ">", ">=", "<", "<=":
+		if _, ok := idx.(index.OrderedIndex); !ok {
+			return nil
+		}
+		return &PlanNode{Type: PlanIndexRange, Field: field, Range: rangeFor(cond.Operator, value)}
+	}
+	
+	return nil
+}
+
+// fieldComparison возвращает поле и константу простого условия вида "поле оператор константа"
+func fieldComparison(cond *Condition) (string, interface{}, bool) {
+	field, ok := cond.Left.(*FieldRef)
+	if !ok || field.Depth > 0 {
+		return "", nil, false
+	}
+	
+	value, ok := cond.Right.(*Literal)
+	if !ok {
+		return "", nil, false
+	}
+	
+	return field.Path, value.Value, true
+}
+
+// planAnd строит план для конъюнкции: достаточно одного применимого индекса,
+// остальные условия отсеиваются фильтром. Составной индекс, покрывающий
+// несколько условий, заменяет отдельные индексы по этим полям
+func (qe *QueryExecutor) planAnd(coll Collection, children []*Condition) *PlanNode {
+	nodes := make([]*PlanNode, 0, len(children))
+	ranges := make(map[string]*PlanNode)
+	
+	compound, fields, used := qe.planCompound(coll, children)
+	if fields > 1 {
+		nodes = append(nodes, compound)
+	} else {
+		used = make([]bool, len(children))
+	}
+	
+	for i, child := range children {
+		if used[i] {
+			continue
+		}
+		
+		node := qe.planCondition(coll, child)
+		if node == nil {
+			continue
+		}
+		
+		// Диапазоны по одному полю объединяются в один просмотр индекса.
+		// Для пути с [*] каждому условию может удовлетворять свой элемент
+		// массива, поэтому такие диапазоны просматриваются отдельно
+		if node.Type == PlanIndexRange && len(node.Prefix) == 0 && !storage.HasWildcard(node.Field) {
+			if prev, ok := ranges[node.Field]; ok {
+				prev.Range = intersectRanges(prev.Range, node.Range)
+				continue
+			}
+			ranges[node.Field] = node
+		}
+		
+		nodes = append(nodes, node)
+	}
+	
+	switch len(nodes) {
+	case 0:
+		return nil
+	case 1:
+		return nodes[0]
+	}
+	
+	// Более избирательные узлы выполняются первыми
+	sort.SliceStable(nodes, func(i, j int) bool {
+		return planCost(nodes[i]) < planCost(nodes[j])
+	})
+	
+	return &PlanNode{Type: PlanIntersect, Children: nodes}
+}
+
+// planOr строит план для дизъюнкции: индекс должен быть применим к каждой ветви
+func (qe *QueryExecutor) planOr(coll Collection, children []*Condition) *PlanNode {
+	nodes := make([]*PlanNode, 0, len(children))
+	
+	for _, child := range children {
+		node := qe.planCondition(coll, child)
+		if node == nil {
+			return nil
+		}
+		nodes = append(nodes, node)
+	}
+	
+	return &PlanNode{Type: PlanUnion, Children: nodes}
+}
+
+// planCost возвращает относительную стоимость узла плана
+func planCost(node *PlanNode) int {
+	switch node.Type {
+	case PlanIDLookup:
+		return 0
+	case PlanIndexLookup:
+		return 1
+	case PlanIndexRange, PlanFullText:
+		return 2
+	}
+	return 3
+}
+
+// rangeFor возвращает диапазон индекса для оператора сравнения
+func rangeFor(op string, value interface{}) index.Range {
+	switch op {
+	case ">":
+		return index.GreaterThan(value)
+	case ">=":
+		return index.AtLeast(value)
+	case "<":
+		return index.LessThan(value)
+	}
+	return index.AtMost(value)
+}
+
+// intersectRanges возвращает пересечение двух диапазонов
+func intersectRanges(a, b index.Range) index.Range {
+	result := a
+	
+	if b.Lower != nil {
+		if result.Lower == nil {
+			result.Lower = b.Lower
+		} else if c := index.Compare(b.Lower.Value, result.Lower.Value); c > 0 || (c == 0 && !b.Lower.Inclusive) {
+			result.Lower = b.Lower
+		}
+	}
+	
+	if b.Upper != nil {
+		if result.Upper == nil {
+			result.Upper = b.Upper
+		} else if c := index.Compare(b.Upper.Value, result.Upper.Value); c < 0 || (c == 0 && !b.Upper.Inclusive) {
+			result.Upper = b.Upper
+		}
+	}
+	
+	// Верхняя граница сама ограничивает тип значения, поэтому условие
+	// IS NOT NULL не расширяет диапазон значениями других типов
+	if result.Upper != nil && result.Lower != nil && result.Lower.Value == nil && !result.Lower.Inclusive {
+		result.Lower = nil
+	}
+	
+	return result
+}
+
+// scan загружает из source документы по плану доступа: для полного просмотра -
+// все документы, иначе - документы-кандидаты ids
+func (qe *QueryExecutor) scan(source storage.Storage, plan *PlanNode, ids []string) ([]storage.Document, error) {
+	if plan.Type == PlanFullScan {
+		return source.List()
+	}
+	
+	docs := make([]storage.Document, 0, len(ids))
+	for _, id := range ids {
+		doc, err := source.Get(id)
+		if err != nil {
+			continue // Документ удален после выборки из индекса
+		}
+		docs = append(docs, doc)
+	}
+	
+	return docs, nil
+}
+
+// candidates возвращает ID документов-кандидатов для узла плана
+func (qe *QueryExecutor) candidates(coll Collection, node *PlanNode) ([]string, error) {
+	switch node.Type {
+	case PlanIDLookup:
+		return []string{node.Value.(string)}, nil
+	case PlanIndexLookup, PlanFullText:
+		return coll.Indexes[node.Field].Search(node.Field, node.Value)
+	case PlanIndexRange, PlanIndexScan:
+		if node.KeyFields != nil {
+			return compoundIDs(coll.Indexes[node.Field].(index.CompoundIndex), node), nil
+		}
+		
+		idx := coll.Indexes[node.Field].(index.OrderedIndex)
+		if len(node.OrderBy) > 0 {
+			return orderedIDs(idx, node), nil
+		}
+		return idx.SearchRange(node.Field, node.Range)
+	case PlanIntersect:
+		var result []string
+		for i, child := range node.Children {
+			start := time.Now()
+			ids, err := qe.candidates(coll, child)
+			if err != nil {
+				return nil, err
+			}
+			child.record(len(ids), start)
+			
+			if i == 0 {
+				result = ids
+			} else {
+				result = intersectIDs(result, ids)
+			}
+			
+			if len(result) == 0 {
+				break
+			}
+		}
+		return result, nil
+	case PlanUnion:
+		result := make([]string, 0)
+		seen := make(map[string]bool)
+		for _, child := range node.Children {
+			start := time.Now()
+			ids, err := qe.candidates(coll, child)
+			if err != nil {
+				return nil, err
+			}
+			child.record(len(ids), start)
+			
+			for _, id := range ids {
+				if !seen[id] {
+					seen[id] = true
+					result = append(result, id)
+				}
+			}
+		}
+		return result, nil
+	}
+	
+	return nil, nil
+}
+
+// intersectIDs возвращает ID из a, присутствующие в b, сохраняя порядок a
+func intersectIDs(a, b []string) []string {
+	set := make(map[string]bool, len(b))
+	for _, id := range b {
+		set[id] = true
+	}
+	
+	result := make([]string, 0)
+	for _, id := range a {
+		if set[id] {
+			result = append(result, id)
+		}
+	}
+	
+	return result
+}
+
+// orderedIDs обходит диапазон индекса в порядке ORDER BY.
+// Ключи NULL размещаются в начале или в конце согласно NULLS FIRST/LAST
+func orderedIDs(idx index.OrderedIndex, node *PlanNode) []string {
+	item := node.OrderBy[0]
+	ids := make([]string, 0)
+	nulls := make([]string, 0)
+	
+	// NULL - наименьший ключ индекса: при обходе по возрастанию он встречается
+	// первым, при обходе по убыванию - последним
+	collect := func(key interface{}, keyIDs []string) bool {
+		if key == nil {
+			nulls = append(nulls, keyIDs...)
+		} else {
+			ids = append(ids, keyIDs...)
+		}
+		
+		if node.StopAfter == 0 {
+			return true
+		}
+		switch {
+		case item.NullsFirst == !item.Descending:
+			// Порядок обхода совпадает с порядком результата
+			return len(ids)+len(nulls) < node.StopAfter
+		case item.Descending && rangeHasNull(node.Range):
+			// NULL нужны в начале, но будут найдены только в конце обхода
+			return true
+		default:
+			// NULL идут после значений, и для результата достаточно StopAfter значений
+			return len(ids) < node.StopAfter
+		}
+	}
+	
+	if item.Descending {
+		idx.Descend(node.Range, collect)
+	} else {
+		idx.Ascend(node.Range, collect)
+	}
+	
+	if item.NullsFirst {
+		return append(nulls, ids...)
+	}
+	return append(ids, nulls...)
+}
+
+// rangeHasNull проверяет, может ли диапазон содержать ключ NULL
+func rangeHasNull(r index.Range) bool {
+	return r.Lower == nil || (r.Lower.Value == nil && r.Lower.Inclusive)
+}
@@ -0,0 +1,208 @@
+package query
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/urusofam/jsondb/index"
+	"github.com/urusofam/jsondb/storage"
+)
+
+// plannerExecutors создает исполнители запросов над одной коллекцией t:
+// с B-tree индексами по полям fields и без индексов
+func plannerExecutors(t *testing.T, docs []storage.Document, fields ...string) (indexed, scan *QueryExecutor) {
+	t.Helper()
+	
+	store := storage.NewMemoryStorage()
+	indexes := make(map[string]index.Index)
+	for _, field := range fields {
+		indexes[field] = index.NewBTreeIndex(field, 4)
+	}
+	for _, doc := range docs {
+		if err := store.Save(doc); err != nil {
+			t.Fatal(err)
+		}
+		for _, idx := range indexes {
+			if err := idx.Add(doc); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	
+	indexed = NewQueryExecutor(map[string]Collection{"t": {Storage: store, Indexes: indexes}})
+	scan = NewQueryExecutor(map[string]Collection{"t": {Storage: store, Indexes: map[string]index.Index{}}})
+	return indexed, scan
+}
+
+// plannerDocs возвращает документы с числовыми, строковыми, NULL и отсутствующими значениями поля age
+func plannerDocs() []storage.Document {
+	docs := make([]storage.Document, 0, 200)
+	for i := 0; i < 200; i++ {
+		content := map[string]interface{}{"city": fmt.Sprint("c", i%5)}
+		switch i % 17 {
+		case 0:
+		case 1:
+			content["age"] = nil
+		case 2:
+			content["age"] = fmt.Sprint("a", i%7)
+		default:
+			content["age"] = float64(i % 60)
+		}
+		docs = append(docs, storage.Document{ID: fmt.Sprintf("d%03d", i), Content: content})
+	}
+	return docs
+}
+
+// rowSet возвращает строки результата в виде строки, не зависящей от их порядка
+func rowSet(rows []map[string]interface{}) string {
+	keys := make([]string, len(rows))
+	for i, row := range rows {
+		keys[i] = fmt.Sprint(row)
+	}
+	sort.Strings(keys)
+	return fmt.Sprint(keys)
+}
+
+// accessPlan возвращает корень плана доступа к документам
+func accessPlan(plan *PlanNode) *PlanNode {
+	switch plan.Type {
+	case PlanFullScan, PlanIDLookup, PlanIndexLookup, PlanIndexRange, PlanIndexScan, PlanIntersect, PlanUnion:
+		return plan
+	}
+	for _, child := range plan.Children {
+		if node := accessPlan(child); node != nil {
+			return node
+		}
+	}
+	return nil
+}
+
+// planShape описывает план доступа типами узлов, например Intersect(IndexLookup,IndexRange)
+func planShape(node *PlanNode) string {
+	if len(node.Children) == 0 {
+		return node.Type
+	}
+	shape := node.Type + "("
+	for i, child := range node.Children {
+		if i > 0 {
+			shape += ","
+		}
+		shape += planShape(child)
+	}
+	return shape + ")"
+}
+
+func TestPlannerChoosesIndexes(t *testing.T) {
+	indexed, scan := plannerExecutors(t, plannerDocs(), "age", "city")
+	
+	for where, want := range map[string]string{
+		"_id = 'd005'":              PlanIDLookup,
+		"city = 'c1'":               PlanIndexLookup,
+		"age > 25":                  PlanIndexRange,
+		"age > 25 AND age <= 40":    PlanIndexRange,
+		"age BETWEEN 10 AND 20":     PlanIndexRange,
+		"city = 'c2' AND age >= 50": "Intersect(IndexLookup,IndexRange)",
+		"city = 'c1' OR age < 3":    "Union(IndexLookup,IndexRange)",
+		"city IN ('c1', 'c3')":      "Union(IndexLookup,IndexLookup)",
+		"city = 'c1' OR name = 'x'": PlanFullScan,
+		"name = 'x'":                PlanFullScan,
+		"name = 'x' AND age < 10":   PlanIndexRange,
+		"age != 5":                  PlanFullScan,
+		"(city = 'c1' OR city = 'c2') AND _id = 'd001'": "Intersect(IDLookup,Union(IndexLookup,IndexLookup))",
+	} {
+		q := "SELECT _id FROM t WHERE " + where
+		parsed, err := NewQueryParser().Parse(q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		plan, err := indexed.Explain(parsed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := planShape(accessPlan(plan)); got != want {
+			t.Errorf("%s: план %s, ожидался %s", where, got, want)
+		}
+		
+		if got, want := rowSet(mustRows(t, indexed, q)), rowSet(mustRows(t, scan, q)); got != want {
+			t.Errorf("%s: %s, полный просмотр дает %s", where, got, want)
+		}
+	}
+}
+
+// run разбирает и выполняет запрос
+func run(qe *QueryExecutor, q string) ([]map[string]interface{}, error) {
+	parsed, err := NewQueryParser().Parse(q)
+	if err != nil {
+		return nil, err
+	}
+	return qe.Execute(parsed)
+}
+
+// mustRows выполняет запрос и возвращает строки результата
+func mustRows(t *testing.T, qe *QueryExecutor, q string) []map[string]interface{} {
+	t.Helper()
+	
+	rows, err := run(qe, q)
+	if err != nil {
+		t.Fatalf("%s: %v", q, err)
+	}
+	return rows
+}
+
+// randomCondition строит случайное условие WHERE над полями age, city и _id
+func randomCondition(rnd *rand.Rand, depth int) string {
+	if depth > 0 && rnd.Intn(3) > 0 {
+		op := " AND "
+		if rnd.Intn(2) == 0 {
+			op = " OR "
+		}
+		return "(" + randomCondition(rnd, depth-1) + op + randomCondition(rnd, depth-1) + ")"
+	}
+	
+	n := rnd.Intn(65)
+	switch rnd.Intn(10) {
+	case 0:
+		return fmt.Sprintf("city = 'c%d'", rnd.Intn(6))
+	case 1:
+		return fmt.Sprintf("_id = 'd%03d'", rnd.Intn(210))
+	case 2:
+		return fmt.Sprintf("NOT age = %d", n)
+	case 3:
+		return fmt.Sprintf("city IN ('c%d', 'c%d')", rnd.Intn(6), rnd.Intn(6))
+	case 4:
+		return fmt.Sprintf("age BETWEEN %d AND %d", n, n+rnd.Intn(20))
+	case 5:
+		return []string{"age IS NULL", "age IS NOT NULL", "age IS MISSING", "age > 'a3'"}[rnd.Intn(4)]
+	}
+	return fmt.Sprintf("age %s %d", []string{"=", ">", ">=", "<", "<="}[rnd.Intn(5)], n)
+}
+
+func TestPlannerMatchesFullScan(t *testing.T) {
+	indexed, scan := plannerExecutors(t, plannerDocs(), "age", "city")
+	rnd := rand.New(rand.NewSource(1))
+	
+	for i := 0; i < 500; i++ {
+		q := "SELECT _id, age FROM t WHERE " + randomCondition(rnd, 3)
+		if got, want := rowSet(mustRows(t, indexed, q)), rowSet(mustRows(t, scan, q)); got != want {
+			t.Fatalf("%s: %s, полный просмотр дает %s", q, got, want)
+		}
+	}
+}
+
+func TestPlannerOrderedScan(t *testing.T) {
+	indexed, scan := plannerExecutors(t, plannerDocs(), "age")
+	
+	for _, q := range []string{
+		"SELECT _id, age FROM t ORDER BY age, _id",
+		"SELECT _id, age FROM t WHERE age > 20 ORDER BY age DESC, _id LIMIT 7",
+		"SELECT age FROM t WHERE age >= 10 AND age < 30 ORDER BY age LIMIT 5 OFFSET 3",
+		"SELECT age FROM t WHERE age > 50 ORDER BY age DESC LIMIT 4",
+		"SELECT _id, age FROM t ORDER BY age NULLS FIRST, _id LIMIT 20",
+	} {
+		if got, want := fmt.Sprint(mustRows(t, indexed, q)), fmt.Sprint(mustRows(t, scan, q)); got != want {
+			t.Errorf("%s: %s, полный просмотр дает %s", q, got, want)
+		}
+	}
+}
@@ -1,349 +1,768 @@
 package query
 
 import (
-	"errors"
 	"fmt"
-	"regexp"
-	"strconv"
-	"strings"
+	"sort"
+	"sync"
+	"time"
 
+	"github.com/urusofam/jsondb/index"
 	"github.com/urusofam/jsondb/storage"
 )
 
 // Query представляет запрос к базе данных
 type Query struct {
-	Select []string
-	From   string
-	Where  *Condition
-	Limit  int
-	Offset int
+	Select  []*SelectItem
+	From    string
+	Where   *Condition
+	GroupBy []Expr
+	Having  *Condition
+	OrderBy []*OrderItem
+	Limit   int
+	Offset  int
+	Explain bool
+	
+	// Alias - псевдоним коллекции FROM (по умолчанию ее имя), Joins - присоединяемые
+	// коллекции. В запросе с JOIN пути полей начинаются с псевдонима коллекции
+	Alias string
+	Joins []*Join
+}
+
+// OrderItem задает ключ сортировки ORDER BY
+type OrderItem struct {
+	Expr       Expr
+	Descending bool
+	
+	// NullsFirst размещает NULL и отсутствующие значения в начале результата.
+	// По умолчанию NULL считается больше любого значения: в конце при ASC и в начале при DESC
+	NullsFirst bool
+}
+
+// String возвращает текстовое представление ключа сортировки
+func (o *OrderItem) String() string {
+	direction, nulls := "ASC", "NULLS LAST"
+	if o.Descending {
+		direction = "DESC"
+	}
+	if o.NullsFirst {
+		nulls = "NULLS FIRST"
+	}
+	return fmt.Sprintf("%s %s %s", o.Expr, direction, nulls)
 }
 
-// Condition представляет условие запроса
+// Condition представляет условие запроса.
+// Простое условие сравнивает операнды Left и Right оператором Operator.
+// Составное условие объединяет Children логическим оператором ChildOp:
+// AND, OR или NOT (с единственным потомком)
 type Condition struct {
-	Left     interface{}
+	Left     Expr
 	Operator string
-	Right    interface{}
+	Right    Expr
 	ChildOp  string
 	Children []*Condition
+	
+	patterns *patternCache
 }
 
 // QueryParser разбирает строки запросов в объекты Query
-type QueryParser struct {
-	// Регулярные выражения для токенизации
-	selectRegex *regexp.Regexp
-	fromRegex   *regexp.Regexp
-	whereRegex  *regexp.Regexp
-	limitRegex  *regexp.Regexp
-	offsetRegex *regexp.Regexp
-}
+type QueryParser struct{}
 
 // NewQueryParser создает новый парсер запросов
 func NewQueryParser() *QueryParser {
-	return &QueryParser{
-		selectRegex: regexp.MustCompile(`(?i)SELECT\s+(.*?)\s+FROM`),
-		fromRegex:   regexp.MustCompile(`(?i)FROM\s+(.*?)(\s+WHERE|\s+LIMIT|\s+OFFSET|$)`),
-		whereRegex:  regexp.MustCompile(`(?i)WHERE\s+(.*?)(\s+LIMIT|\s+OFFSET|$)`),
-		limitRegex:  regexp.MustCompile(`(?i)LIMIT\s+(\d+)`),
-		offsetRegex: regexp.MustCompile(`(?i)OFFSET\s+(\d+)`),
-	}
+	return &QueryParser{}
 }
 
-// Parse разбирает строку запроса в объект Query
+// Parse разбирает строку запроса SELECT в объект Query.
+// Ошибки разбора возвращаются как *ParseError с номером строки и столбца
 func (qp *QueryParser) Parse(queryStr string) (*Query, error) {
-	query := &Query{
-		Limit:  -1,
-		Offset: 0,
+	stmt, err := qp.ParseStatement(queryStr)
+	if err != nil {
+		return nil, err
+	}
+	
+	query, ok := stmt.(*Query)
+	if !ok {
+		return nil, fmt.Errorf("ожидался запрос SELECT")
+	}
+	return query, nil
+}
+
+// QueryExecutor выполняет запросы к базе данных
+type QueryExecutor struct {
+	DB map[string]Collection
+	
+	// Functions содержит скалярные функции, доступные в запросах
+	Functions *FunctionRegistry
+	
+	// outer содержит строки внешних запросов для коррелированного подзапроса
+	outer []storage.Document
+}
+
+// Collection представляет коллекцию документов
+type Collection struct {
+	Storage storage.Storage
+	
+	// Indexes содержит индексы коллекции по именам полей
+	Indexes map[string]index.Index
+	
+	// Lock блокирует коллекцию для чтения на время выполнения запроса.
+	// Если хранилище реализует storage.Snapshotter, блокировка снимается
+	// после выборки из индексов, а документы читаются из снимка
+	Lock sync.Locker
+}
+
+// NewQueryExecutor создает новый исполнитель запросов
+func NewQueryExecutor(collections map[string]Collection) *QueryExecutor {
+	return &QueryExecutor{
+		DB:        collections,
+		Functions: NewFunctionRegistry(),
+	}
+}
+
+// Execute выполняет запрос к базе данных
+func (qe *QueryExecutor) Execute(query *Query) ([]map[string]interface{}, error) {
+	results, plan, err := qe.run(query)
+	if err != nil {
+		return nil, err
+	}
+	
+	// Для EXPLAIN вместо документов возвращаются строки плана
+	if query.Explain {
+		rows := make([]map[string]interface{}, 0)
+		for _, line := range plan.Lines() {
+			rows = append(rows, map[string]interface{}{"plan": line})
+		}
+		return rows, nil
 	}
 	
-	// Разбор SELECT
-	selectMatches := qp.selectRegex.FindStringSubmatch(queryStr)
-	if len(selectMatches) < 2 {
-		return nil, errors.New("неверный оператор SELECT")
+	return results, nil
+}
+
+// Explain выполняет запрос и возвращает план выполнения со статистикой каждого этапа
+func (qe *QueryExecutor) Explain(query *Query) (*PlanNode, error) {
+	_, plan, err := qe.run(query)
+	return plan, err
+}
+
+// run выполняет запрос, строя по ходу выполнения дерево плана со статистикой
+func (qe *QueryExecutor) run(query *Query) ([]map[string]interface{}, *PlanNode, error) {
+	collection, ok := qe.DB[query.From]
+	if !ok {
+		return nil, nil, fmt.Errorf("коллекция %s не найдена", query.From)
 	}
 	
-	fields := strings.Split(selectMatches[1], ",")
-	for i, field := range fields {
-		fields[i] = strings.TrimSpace(field)
+	if err := qe.validateJoins(query); err != nil {
+		return nil, nil, err
+	}
+	if err := qe.validateFunctions(query); err != nil {
+		return nil, nil, err
 	}
-	query.Select = fields
+	if err := query.validateGrouping(); err != nil {
+		return nil, nil, err
+	}
+	grouped := query.grouped()
 	
-	// Разбор FROM
-	fromMatches := qp.fromRegex.FindStringSubmatch(queryStr)
-	if len(fromMatches) < 2 {
-		return nil, errors.New("неверный оператор FROM")
+	// Без ORDER BY результаты MATCH упорядочиваются по релевантности
+	if ranking := query.ranking(); ranking != nil {
+		ranked := *query
+		ranked.OrderBy = ranking
+		query = &ranked
 	}
 	
-	query.From = strings.TrimSpace(fromMatches[1])
+	// Многоверсионное хранилище читается через снимок. Снимок создается и
+	// индексы читаются под блокировкой коллекции, поэтому они согласованы;
+	// дальше запрос выполняется по снимку, не блокируя запись
+	sources, unlock, release := qe.acquire(query.collections())
+	defer release()
+	defer unlock(false)
+	source := sources[query.From]
 	
-	// Разбор WHERE
-	whereMatches := qp.whereRegex.FindStringSubmatch(queryStr)
-	if len(whereMatches) >= 2 {
-		condition, err := qp.parseCondition(whereMatches[1])
-		if err != nil {
-			return nil, err
-		}
-		query.Where = condition
+	if err := qe.scoreMatches(collection, source, query); err != nil {
+		return nil, nil, err
+	}
+	if err := qe.prepareSubqueries(query, sources); err != nil {
+		return nil, nil, err
 	}
+	// Коррелированные подзапросы читают индексы при проверке условий,
+	// поэтому блокировки снимаются только в конце выполнения запроса
+	correlated := query.correlated()
 	
-	// Разбор LIMIT
-	limitMatches := qp.limitRegex.FindStringSubmatch(queryStr)
-	if len(limitMatches) >= 2 {
-		limit, err := strconv.Atoi(limitMatches[1])
-		if err != nil {
-			return nil, err
+	// Получить документы по выбранному плану. В запросе с JOIN план доступа
+	// строится по части WHERE, относящейся только к коллекции FROM
+	where := query.Where
+	if len(query.Joins) > 0 {
+		where = localCondition(query.Where, query.Alias)
+	}
+	plan := qe.planAccess(collection, qe.bindOuter(where))
+	ordered := false
+	if !grouped && len(query.Joins) == 0 {
+		plan, ordered = qe.planOrder(collection, query, plan)
+	}
+	plan.Collection = query.From
+	plan.EstimatedRows = qe.estimate(collection, plan)
+	
+	start := time.Now()
+	var ids []string
+	if plan.Type != PlanFullScan {
+		var err error
+		if ids, err = qe.candidates(collection, plan); err != nil {
+			return nil, nil, err
 		}
-		query.Limit = limit
 	}
 	
-	// Разбор OFFSET
-	offsetMatches := qp.offsetRegex.FindStringSubmatch(queryStr)
-	if len(offsetMatches) >= 2 {
-		offset, err := strconv.Atoi(offsetMatches[1])
-		if err != nil {
-			return nil, err
+	if len(query.Joins) == 0 && !correlated {
+		unlock(true)
+	}
+	
+	docs, err := qe.scan(source, plan, ids)
+	if err != nil {
+		return nil, nil, err
+	}
+	plan.record(len(docs), start)
+	
+	// Соединить коллекции. Индексы присоединяемых коллекций читаются
+	// на этом этапе, поэтому блокировки снимаются после него
+	if len(query.Joins) > 0 {
+		if plan, docs, err = qe.joinAll(query, sources, plan, docs); err != nil {
+			return nil, nil, err
+		}
+		if !correlated {
+			unlock(true)
 		}
-		query.Offset = offset
 	}
 	
-	return query, nil
-}
-
-// parseCondition разбирает строку условия в объект Condition
-func (qp *QueryParser) parseCondition(condStr string) (*Condition, error) {
-	// Проверка на AND или OR
-	if strings.Contains(strings.ToUpper(condStr), " AND ") {
-		parts := strings.Split(condStr, " AND ")
-		conditions := make([]*Condition, len(parts))
+	// Применить условия
+	if query.Where != nil {
+		filter := &PlanNode{
+			Type:      PlanFilter,
+			Condition: query.Where,
+			Children:  []*PlanNode{plan},
+		}
+		filter.EstimatedRows = plan.EstimatedRows
+		if plan.Type == PlanFullScan && plan.EstimatedRows >= 0 {
+			filter.EstimatedRows = int(float64(plan.EstimatedRows) * selectivity(query.Where))
+		}
 		
-		for i, part := range parts {
-			cond, err := qp.parseCondition(part)
+		start = time.Now()
+		matched := make([]storage.Document, 0, len(docs))
+		for _, doc := range docs {
+			ok, err := qe.evalCondition(doc, query.Where)
 			if err != nil {
-				return nil, err
+				return nil, nil, err
+			}
+			if ok {
+				matched = append(matched, doc)
 			}
-			conditions[i] = cond
 		}
-		
-		return &Condition{
-			ChildOp:  "AND",
-			Children: conditions,
-		}, nil
+		docs = matched
+		filter.record(len(docs), start)
+		plan = filter
 	}
 	
-	if strings.Contains(strings.ToUpper(condStr), " OR ") {
-		parts := strings.Split(condStr, " OR ")
-		conditions := make([]*Condition, len(parts))
+	// Сгруппировать документы и вычислить агрегатные функции
+	if grouped {
+		aggregates := query.aggregates()
+		groupNode := &PlanNode{
+			Type:       PlanGroup,
+			GroupBy:    query.GroupBy,
+			Aggregates: aggregates,
+			Children:   []*PlanNode{plan},
+		}
+		groupNode.EstimatedRows = groupRows(plan.EstimatedRows, len(query.GroupBy))
 		
-		for i, part := range parts {
-			cond, err := qp.parseCondition(part)
-			if err != nil {
-				return nil, err
-			}
-			conditions[i] = cond
+		start = time.Now()
+		docs, err = qe.group(docs, query.GroupBy, aggregates)
+		if err != nil {
+			return nil, nil, err
 		}
+		groupNode.record(len(docs), start)
+		plan = groupNode
 		
-		return &Condition{
-			ChildOp:  "OR",
-			Children: conditions,
-		}, nil
-	}
-	
-	// Парсинг простого условия
-	for _, op := range []string{">=", "<=", "!=", ">", "<", "="} {
-		if strings.Contains(condStr, op) {
-			parts := strings.SplitN(condStr, op, 2)
-			if len(parts) != 2 {
-				return nil, errors.New("неверное условие")
+		if query.Having != nil {
+			having := &PlanNode{
+				Type:      PlanHaving,
+				Condition: query.Having,
+				Children:  []*PlanNode{plan},
+			}
+			having.EstimatedRows = plan.EstimatedRows
+			if plan.EstimatedRows >= 0 {
+				having.EstimatedRows = int(float64(plan.EstimatedRows) * selectivity(query.Having))
 			}
 			
-			left := strings.TrimSpace(parts[0])
-			right := strings.TrimSpace(parts[1])
-			
-			// Разбор правого значения
-			var rightVal interface{}
-			
-			if strings.HasPrefix(right, "'") && strings.HasSuffix(right, "'") {
-				// Строка
-				rightVal = right[1 : len(right)-1]
-			} else if strings.ToLower(right) == "true" || strings.ToLower(right) == "false" {
-				// Булево значение
-				rightVal = (strings.ToLower(right) == "true")
-			} else if strings.Contains(right, ".") {
-				// Число с плавающей точкой
-				f, err := strconv.ParseFloat(right, 64)
+			start = time.Now()
+			matched := make([]storage.Document, 0, len(docs))
+			for _, doc := range docs {
+				ok, err := qe.evalCondition(doc, query.Having)
 				if err != nil {
-					return nil, err
+					return nil, nil, err
 				}
-				rightVal = f
-			} else {
-				// Целое число
-				i, err := strconv.Atoi(right)
-				if err != nil {
-					return nil, err
+				if ok {
+					matched = append(matched, doc)
 				}
-				rightVal = i
 			}
-			
-			return &Condition{
-				Left:     left,
-				Operator: op,
-				Right:    rightVal,
-			}, nil
+			docs = matched
+			having.record(len(docs), start)
+			plan = having
 		}
 	}
 	
-	return nil, errors.New("неверное условие")
-}
-
-// QueryExecutor выполняет запросы к базе данных
-type QueryExecutor struct {
-	DB map[string]Collection
-}
-
-// Collection представляет коллекцию документов
-type Collection struct {
-	Storage storage.Storage
-}
-
-// NewQueryExecutor создает новый исполнитель запросов
-func NewQueryExecutor(collections map[string]Collection) *QueryExecutor {
-	return &QueryExecutor{
-		DB: collections,
+	// Упорядочить документы, если порядок не обеспечен индексом
+	if len(query.OrderBy) > 0 && !ordered {
+		sortNode := &PlanNode{
+			Type:          PlanSort,
+			OrderBy:       query.OrderBy,
+			EstimatedRows: plan.EstimatedRows,
+			Children:      []*PlanNode{plan},
+		}
+		
+		start = time.Now()
+		if err := qe.sortDocuments(docs, query.OrderBy); err != nil {
+			return nil, nil, err
+		}
+		sortNode.record(len(docs), start)
+		plan = sortNode
 	}
-}
-
-// Execute выполняет запрос к базе данных
-func (qe *QueryExecutor) Execute(query *Query) ([]map[string]interface{}, error) {
-	collection, ok := qe.DB[query.From]
-	if !ok {
-		return nil, fmt.Errorf("коллекция %s не найдена", query.From)
+	
+	// Применить OFFSET и LIMIT
+	if query.Offset > 0 || query.Limit >= 0 {
+		limit := &PlanNode{
+			Type:     PlanLimit,
+			Limit:    query.Limit,
+			Offset:   query.Offset,
+			Children: []*PlanNode{plan},
+		}
+		limit.EstimatedRows = limitRows(plan.EstimatedRows, query.Limit, query.Offset)
+		
+		start = time.Now()
+		if query.Offset >= len(docs) {
+			docs = docs[:0]
+		} else if query.Offset > 0 {
+			docs = docs[query.Offset:]
+		}
+		
+		if query.Limit >= 0 && query.Limit < len(docs) {
+			docs = docs[:query.Limit]
+		}
+		limit.record(len(docs), start)
+		plan = limit
 	}
 	
-	// Получить все документы
-	docs, err := collection.Storage.List()
-	if err != nil {
-		return nil, err
+	// Выбрать поля
+	fields := make([]string, len(query.Select))
+	for i, item := range query.Select {
+		fields[i] = item.Name()
 	}
 	
-	results := make([]map[string]interface{}, 0)
+	project := &PlanNode{
+		Type:          PlanProject,
+		Fields:        fields,
+		EstimatedRows: plan.EstimatedRows,
+		Children:      []*PlanNode{plan},
+	}
 	
-	// Применить условия
+	// Поля без псевдонимов выбираются с сохранением вложенной структуры документа
+	paths := make([]string, 0)
+	for _, item := range query.Select {
+		if field, ok := item.Expr.(*FieldRef); ok && item.Alias == "" && field.Path != "_id" && field.Depth == 0 {
+			paths = append(paths, field.Path)
+		}
+	}
+	
+	start = time.Now()
+	results := make([]map[string]interface{}, 0, len(docs))
 	for _, doc := range docs {
-		if query.Where == nil || qe.evalCondition(doc, query.Where) {
-			result := make(map[string]interface{})
-			
-			if len(query.Select) == 1 && query.Select[0] == "*" {
+		result := make(map[string]interface{})
+		
+		for _, item := range query.Select {
+			switch e := item.Expr.(type) {
+			case *Star:
 				// Выбрать все поля
 				for k, v := range doc.Content {
 					result[k] = v
 				}
-				result["_id"] = doc.ID
-			} else {
-				// Выбрать определенные поля
-				for _, field := range query.Select {
-					if field == "_id" {
-						result["_id"] = doc.ID
-					} else {
-						value, ok := getNestedValue(doc.Content, field)
-						if ok {
-							result[field] = value
-						}
-					}
+				// Строка соединения содержит _id каждого документа под его псевдонимом
+				if len(query.Joins) == 0 {
+					result["_id"] = doc.ID
+				}
+				continue
+			case *FieldRef:
+				if item.Alias == "" && e.Path != "_id" && e.Depth == 0 {
+					continue
 				}
 			}
 			
-			results = append(results, result)
+			// Выбрать значение под псевдонимом, _id, значение функции или агрегатной функции
+			value, ok, err := qe.evalExpr(doc, item.Expr)
+			if err != nil {
+				return nil, nil, err
+			}
+			if ok {
+				result[item.Name()] = value
+			}
+		}
+		
+		if len(paths) > 0 {
+			for k, v := range storage.ProjectPaths(doc.Content, paths) {
+				result[k] = v
+			}
+		}
+		
+		results = append(results, result)
+	}
+	project.record(len(results), start)
+	
+	return results, project, nil
+}
+
+// acquire блокирует коллекции names в указанном порядке и открывает снимки
+// многоверсионных хранилищ. Возвращает источники документов по именам
+// коллекций, функцию снятия блокировок и функцию освобождения снимков.
+// unlock(true) снимает только блокировки коллекций, читаемых через снимок;
+// повторно блокировка не снимается
+func (qe *QueryExecutor) acquire(names []string) (map[string]storage.Storage, func(versionedOnly bool), func()) {
+	sources := make(map[string]storage.Storage, len(names))
+	locked := make(map[string]bool, len(names))
+	snapshots := make([]*storage.Snapshot, 0)
+	
+	for _, name := range names {
+		coll, ok := qe.DB[name]
+		if !ok {
+			continue
+		}
+		if coll.Lock != nil {
+			coll.Lock.Lock()
+			locked[name] = true
+		}
+		
+		sources[name] = coll.Storage
+		if snapshotter, ok := coll.Storage.(storage.Snapshotter); ok {
+			snapshot := snapshotter.Snapshot()
+			snapshots = append(snapshots, snapshot)
+			sources[name] = snapshot
 		}
 	}
 	
-	// Применить OFFSET и LIMIT
-	if query.Offset > 0 && query.Offset < len(results) {
-		results = results[query.Offset:]
+	unlock := func(versionedOnly bool) {
+		for _, name := range names {
+			_, versioned := sources[name].(*storage.Snapshot)
+			if locked[name] && (versioned || !versionedOnly) {
+				qe.DB[name].Lock.Unlock()
+				locked[name] = false
+			}
+		}
+	}
+	release := func() {
+		for _, snapshot := range snapshots {
+			snapshot.Release()
+		}
+	}
+	return sources, unlock, release
+}
+
+// sortDocuments выполняет устойчивую сортировку документов по ключам ORDER BY.
+// Значения ключей вычисляются один раз для каждого документа
+func (qe *QueryExecutor) sortDocuments(docs []storage.Document, orderBy []*OrderItem) error {
+	type sortRow struct {
+		doc     storage.Document
+		values  []interface{}
+		present []bool
 	}
 	
-	if query.Limit >= 0 && query.Limit < len(results) {
-		results = results[:query.Limit]
+	rows := make([]sortRow, len(docs))
+	for i, doc := range docs {
+		row := sortRow{doc: doc, values: make([]interface{}, len(orderBy)), present: make([]bool, len(orderBy))}
+		for k, item := range orderBy {
+			value, ok, err := qe.evalExpr(doc, item.Expr)
+			if err != nil {
+				return err
+			}
+			row.values[k], row.present[k] = value, ok
+		}
+		rows[i] = row
 	}
 	
-	return results, nil
+	sort.SliceStable(rows, func(i, j int) bool {
+		for k, item := range orderBy {
+			c := compareForOrder(rows[i].values[k], rows[i].present[k], rows[j].values[k], rows[j].present[k], item)
+			if c != 0 {
+				return c < 0
+			}
+		}
+		return false
+	})
+	
+	for i, row := range rows {
+		docs[i] = row.doc
+	}
+	return nil
+}
+
+// compareForOrder сравнивает значения ключа сортировки с учетом направления и размещения NULL.
+// Отсутствующее поле упорядочивается так же, как NULL; значения разных типов
+// упорядочиваются по рангу типа, как в индексе
+func compareForOrder(a interface{}, aok bool, b interface{}, bok bool, item *OrderItem) int {
+	aNull, bNull := !aok || a == nil, !bok || b == nil
+	
+	switch {
+	case aNull && bNull:
+		return 0
+	case aNull:
+		if item.NullsFirst {
+			return -1
+		}
+		return 1
+	case bNull:
+		if item.NullsFirst {
+			return 1
+		}
+		return -1
+	}
+	
+	c := index.Compare(a, b)
+	if item.Descending {
+		c = -c
+	}
+	return c
 }
 
-// getNestedValue получает вложенные значения из JSON документа
-func getNestedValue(content map[string]interface{}, field string) (interface{}, bool) {
-	// Для простоты предположим, что вложенность отсутствует
-	val, ok := content[field]
-	return val, ok
+// evalCondition проверяет, выполняется ли условие для документа.
+// Условие с неизвестным значением (UNKNOWN) не выполняется
+func (qe *QueryExecutor) evalCondition(doc storage.Document, cond *Condition) (bool, error) {
+	t, err := qe.evalTruth(doc, cond)
+	return t == truthTrue, err
 }
 
-// evalCondition оценивает условие для документа
-func (qe *QueryExecutor) evalCondition(doc storage.Document, cond *Condition) bool {
+// evalTruth оценивает условие для документа по правилам трехзначной логики
+func (qe *QueryExecutor) evalTruth(doc storage.Document, cond *Condition) (truth, error) {
 	if len(cond.Children) > 0 {
-		// Оценить дочерние условия
-		results := make([]bool, len(cond.Children))
-		
-		for i, child := range cond.Children {
-			results[i] = qe.evalCondition(doc, child)
-		}
-		
-		if cond.ChildOp == "AND" {
-			for _, result := range results {
-				if !result {
-					return false
+		switch cond.ChildOp {
+		case "AND":
+			result := truthTrue
+			for _, child := range cond.Children {
+				t, err := qe.evalTruth(doc, child)
+				if err != nil || t == truthFalse {
+					return truthFalse, err
 				}
+				result = result.and(t)
 			}
-			return true
-		} else if cond.ChildOp == "OR" {
-			for _, result := range results {
-				if result {
-					return true
+			return result, nil
+		case "OR":
+			result := truthFalse
+			for _, child := range cond.Children {
+				t, err := qe.evalTruth(doc, child)
+				if err != nil || t == truthTrue {
+					return t, err
 				}
+				result = result.or(t)
 			}
-			return false
+			return result, nil
+		case "NOT":
+			t, err := qe.evalTruth(doc, cond.Children[0])
+			if err != nil {
+				return truthFalse, err
+			}
+			return t.not(), nil
 		}
+		return truthFalse, nil
 	}
 	
-	// Оценить простое условие
-	fieldName, ok := cond.Left.(string)
-	if !ok {
-		return false
+	switch cond.Operator {
+	case "MATCH":
+		_, found := cond.Left.(*Match).scores[doc.ID]
+		return truthOf(found), nil
+	case "EXISTS":
+		rows, err := cond.Right.(*Subquery).results(doc)
+		return truthOf(len(rows) > 0), err
+	case "IN", "NOT IN":
+		return qe.evalIn(doc, cond)
+	case "LIKE", "NOT LIKE", "ILIKE", "NOT ILIKE", "~":
+		return qe.evalPattern(doc, cond)
+	case "BETWEEN", "NOT BETWEEN":
+		return qe.evalBetween(doc, cond)
+	case "IS NULL", "IS NOT NULL", "IS MISSING", "IS NOT MISSING":
+		return qe.evalIs(doc, cond)
 	}
 	
-	var value interface{}
-	if fieldName == "_id" {
-		value = doc.ID
-	} else {
-		value, ok = getNestedValue(doc.Content, fieldName)
+	// Оценить простое условие. Путь с [*] дает несколько значений:
+	// условие выполняется, если ему удовлетворяет хотя бы одно из них.
+	// Отсутствующее поле, как и NULL, дает неизвестный результат
+	lefts, err := qe.evalValues(doc, cond.Left)
+	if err != nil {
+		return truthFalse, err
+	}
+	rights, err := qe.evalValues(doc, cond.Right)
+	if err != nil {
+		return truthFalse, err
+	}
+	if len(lefts) == 0 || len(rights) == 0 {
+		return truthUnknown, nil
+	}
+	
+	result := truthFalse
+	for _, left := range lefts {
+		for _, right := range rights {
+			result = result.or(qe.compareValues(left, cond.Operator, right))
+			if result == truthTrue {
+				return result, nil
+			}
+		}
+	}
+	
+	return result, nil
+}
+
+// evalValues возвращает все значения операнда для документа:
+// пусто для отсутствующего поля и все найденные значения для пути с [*]
+func (qe *QueryExecutor) evalValues(doc storage.Document, expr Expr) ([]interface{}, error) {
+	if field, ok := expr.(*FieldRef); ok && storage.HasWildcard(field.Path) {
+		row, ok := qe.rowOf(doc, field)
+		if !ok {
+			return nil, nil
+		}
+		return storage.ResolveAll(row.Content, field.Path), nil
+	}
+	
+	value, ok, err := qe.evalExpr(doc, expr)
+	if err != nil || !ok {
+		return nil, err
+	}
+	return []interface{}{value}, nil
+}
+
+// evalExpr вычисляет значение операнда для документа.
+// Второе значение равно false, если поле отсутствует в документе.
+// Ошибка возвращается, если аргумент функции нельзя привести к нужному типу
+func (qe *QueryExecutor) evalExpr(doc storage.Document, expr Expr) (interface{}, bool, error) {
+	switch e := expr.(type) {
+	case *Literal:
+		return e.Value, true, nil
+	case *FieldRef:
+		row, ok := qe.rowOf(doc, e)
 		if !ok {
-			return false
+			return nil, false, nil
+		}
+		if e.Path == "_id" {
+			return row.ID, true, nil
 		}
+		value, ok := storage.ResolvePath(row.Content, e.Path)
+		return value, ok, nil
+	case *Match:
+		return e.scores[doc.ID], true, nil
+	case *Coalesce:
+		value, err := qe.coalesce(doc, e)
+		return value, err == nil, err
+	case *Aggregate:
+		// Значения агрегатных функций вычислены на этапе группировки
+		value, ok := doc.Content[e.String()]
+		return value, ok, nil
+	case *FuncCall:
+		// Отсутствующее поле передается в функцию как NULL
+		args := make([]interface{}, len(e.Args))
+		for i, arg := range e.Args {
+			value, _, err := qe.evalExpr(doc, arg)
+			if err != nil {
+				return nil, false, err
+			}
+			args[i] = value
+		}
+		
+		value, err := qe.Functions.Call(e.Name, args)
+		if err != nil {
+			return nil, false, err
+		}
+		return value, true, nil
 	}
 	
-	return qe.compareValues(value, cond.Operator, cond.Right)
+	return nil, false, nil
+}
+
+// validateFunctions проверяет, что все функции запроса существуют и вызваны с нужным числом аргументов
+func (qe *QueryExecutor) validateFunctions(query *Query) error {
+	var err error
+	query.visit(func(expr Expr) {
+		if call, ok := expr.(*FuncCall); ok && err == nil {
+			err = qe.Functions.CheckCall(call.Name, len(call.Args))
+		}
+	})
+	return err
 }
 
-// compareValues сравнивает два значения с использованием указанного оператора
-func (qe *QueryExecutor) compareValues(left interface{}, op string, right interface{}) bool {
+// compareValues сравнивает два значения с использованием указанного оператора.
+// Сравнение с NULL дает UNKNOWN; значения несравнимых типов не равны друг другу
+// и не упорядочены, поэтому для них выполняется только "!="
+func (qe *QueryExecutor) compareValues(left interface{}, op string, right interface{}) truth {
+	if left == nil || right == nil {
+		return truthUnknown
+	}
+	
+	c, ok := qe.order(left, right)
+	if !ok {
+		return truthOf(op == "!=")
+	}
+	
 	switch op {
 	case "=":
-		return qe.equals(left, right)
+		return truthOf(c == 0)
 	case "!=":
-		return !qe.equals(left, right)
+		return truthOf(c != 0)
 	case ">":
-		return qe.compare(left, right) > 0
+		return truthOf(c > 0)
 	case ">=":
-		return qe.compare(left, right) >= 0
+		return truthOf(c >= 0)
 	case "<":
-		return qe.compare(left, right) < 0
+		return truthOf(c < 0)
 	case "<=":
-		return qe.compare(left, right) <= 0
-	default:
-		return false
+		return truthOf(c <= 0)
 	}
+	return truthFalse
 }
 
-// equals проверяет, равны ли два значения
-func (qe *QueryExecutor) equals(a, b interface{}) bool {
-	return qe.compare(a, b) == 0
+// order сравнивает два значения, отличные от NULL. Второе значение равно false,
+// если значения несравнимы: числа сравниваются только с числами, строки - со
+// строками, даты - с датами и строками дат. Массивы и объекты сравниваются
+// поэлементно, как в индексе
+func (qe *QueryExecutor) order(a, b interface{}) (int, bool) {
+	if t, ok := b.(time.Time); ok {
+		if _, ok := a.(time.Time); !ok {
+			c, ok := qe.order(t, a)
+			return -c, ok
+		}
+	}
+	
+	comparable := false
+	switch v := a.(type) {
+	case string:
+		_, comparable = b.(string)
+	case float64, int:
+		switch b.(type) {
+		case float64, int:
+			comparable = true
+		}
+	case bool:
+		_, comparable = b.(bool)
+	case time.Time:
+		switch b := b.(type) {
+		case time.Time:
+			comparable = true
+		case string:
+			_, comparable = parseTime(b)
+		}
+	case []interface{}:
+		_, comparable = b.([]interface{})
+		if comparable {
+			return index.Compare(v, b), true
+		}
+	case map[string]interface{}:
+		_, comparable = b.(map[string]interface{})
+		if comparable {
+			return index.Compare(v, b), true
+		}
+	}
+	
+	if !comparable {
+		return 0, false
+	}
+	return qe.compare(a, b), true
 }
 
 // compare сравнивает два значения
@@ -381,7 +800,7 @@ func (qe *QueryExecutor) compare(a, b interface{}) int {
 		case int:
 			v2 = b
 		case float64:
-			v2 = int(b)
+			return qe.compare(float64(v1), b)
 		default:
 			return 0
 		}
@@ -401,6 +820,28 @@ func (qe *QueryExecutor) compare(a, b interface{}) int {
 			}
 			return 0
 		}
+	case time.Time:
+		// Даты, возвращаемые функциями, сравниваются с датами и строками дат
+		var v2 time.Time
+		switch b := b.(type) {
+		case time.Time:
+			v2 = b
+		case string:
+			t, ok := parseTime(b)
+			if !ok {
+				return 0
+			}
+			v2 = t
+		default:
+			return 0
+		}
+		
+		if v1.Before(v2) {
+			return -1
+		} else if v1.After(v2) {
+			return 1
+		}
+		return 0
 	}
 	
 	return 0
@@ -0,0 +1,494 @@
+package query
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/urusofam/jsondb/storage"
+)
+
+// List представляет список значений в IN ( ... )
+type List struct {
+	Items []Expr
+}
+
+// String возвращает запись списка в скобках
+func (l *List) String() string {
+	items := make([]string, len(l.Items))
+	for i, item := range l.Items {
+		items[i] = item.String()
+	}
+	return "(" + strings.Join(items, ", ") + ")"
+}
+
+// Subquery представляет подзапрос в IN или EXISTS.
+// Коррелированный подзапрос ссылается на строки внешнего запроса и выполняется
+// для каждой из них; остальные выполняются один раз до выбора плана
+type Subquery struct {
+	Query      *Query
+	Correlated bool
+	
+	exists   bool
+	executor *QueryExecutor
+	rows     []map[string]interface{}
+	values   []interface{}
+	keys     map[string]bool
+}
+
+// String возвращает запись подзапроса в скобках
+func (s *Subquery) String() string {
+	return "(" + s.Query.String() + ")"
+}
+
+// parseSubquery разбирает запрос SELECT подзапроса; открывающая скобка уже пропущена
+func (p *parser) parseSubquery() (*Subquery, error) {
+	query := &Query{Limit: -1}
+	if err := p.parseSelect(query); err != nil {
+		return nil, err
+	}
+	if err := p.expectSymbol(")"); err != nil {
+		return nil, err
+	}
+	return &Subquery{Query: query}, nil
+}
+
+// parseExists разбирает EXISTS "(" select ")"
+func (p *parser) parseExists() (*Condition, error) {
+	p.next() // EXISTS
+	if err := p.expectSymbol("("); err != nil {
+		return nil, err
+	}
+	
+	sub, err := p.parseSubquery()
+	if err != nil {
+		return nil, err
+	}
+	sub.exists = true
+	
+	return &Condition{Operator: "EXISTS", Right: sub}, nil
+}
+
+// parseIn разбирает правую часть IN и NOT IN: список операндов или подзапрос,
+// выбирающий один столбец
+func (p *parser) parseIn(left Expr, op string) (*Condition, error) {
+	if err := p.expectSymbol("("); err != nil {
+		return nil, err
+	}
+	
+	if p.isKeyword("SELECT") {
+		tok := p.peek()
+		sub, err := p.parseSubquery()
+		if err != nil {
+			return nil, err
+		}
+		if _, star := sub.Query.Select[0].Expr.(*Star); star || len(sub.Query.Select) != 1 {
+			return nil, p.errorf(tok, "подзапрос в %s должен выбирать один столбец", op)
+		}
+		return &Condition{Left: left, Operator: op, Right: sub}, nil
+	}
+	
+	list := &List{}
+	for {
+		item, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		list.Items = append(list.Items, item)
+		
+		if !p.acceptSymbol(",") {
+			break
+		}
+	}
+	
+	if err := p.expectSymbol(")"); err != nil {
+		return nil, err
+	}
+	return &Condition{Left: left, Operator: op, Right: list}, nil
+}
+
+// scope описывает коллекции одного уровня вложенности запроса
+type scope struct {
+	aliases map[string]bool
+	joined  bool
+	sub     *Subquery
+}
+
+// bindStatement связывает поля инструкции с коллекциями
+func bindStatement(stmt Statement) {
+	switch s := stmt.(type) {
+	case *Query:
+		bind(s, nil, nil)
+	case *Update:
+		query := &Query{From: s.Collection, Alias: s.Collection, Where: s.Where}
+		for _, assignment := range s.Set {
+			query.Select = append(query.Select, &SelectItem{Expr: assignment.Value})
+		}
+		bind(query, nil, nil)
+	case *Delete:
+		bind(&Query{From: s.From, Alias: s.From, Where: s.Where}, nil, nil)
+	}
+}
+
+// bind связывает поля запроса q с коллекциями. В запросе без JOIN путь может
+// начинаться с псевдонима коллекции - он снимается. Поле, путь которого
+// начинается с псевдонима коллекции внешнего запроса, становится ссылкой
+// на строку этого запроса, а подзапросы до него - коррелированными
+func bind(q *Query, outer []*scope, sub *Subquery) {
+	current := &scope{aliases: make(map[string]bool), joined: len(q.Joins) > 0, sub: sub}
+	for _, alias := range q.aliases() {
+		current.aliases[alias] = true
+	}
+	scopes := append(outer[:len(outer):len(outer)], current)
+	
+	// Поле ORDER BY может совпадать с выражением столбца SELECT
+	seen := make(map[*FieldRef]bool)
+	q.visit(func(expr Expr) {
+		switch e := expr.(type) {
+		case *FieldRef:
+			if !seen[e] {
+				seen[e] = true
+				bindField(e, scopes)
+			}
+		case *Subquery:
+			bind(e.Query, scopes, e)
+		}
+	})
+}
+
+// bindField связывает поле с ближайшей коллекцией, псевдоним которой начинает путь
+func bindField(field *FieldRef, scopes []*scope) {
+	alias := pathAlias(field.Path)
+	if len(alias) == len(field.Path) {
+		return
+	}
+	
+	for depth := 0; depth < len(scopes); depth++ {
+		s := scopes[len(scopes)-1-depth]
+		if !s.aliases[alias] {
+			continue
+		}
+		
+		for i := 0; i < depth; i++ {
+			scopes[len(scopes)-1-i].sub.Correlated = true
+		}
+		field.Depth = depth
+		
+		// Строки запроса с JOIN содержат документы под псевдонимами
+		if !s.joined && field.Path[len(alias)] == '.' {
+			field.Path = field.Path[len(alias)+1:]
+			if depth > 0 {
+				field.Qualifier = alias
+			}
+		}
+		return
+	}
+}
+
+// subqueries возвращает подзапросы из условий запроса, без вложенных в них
+func (q *Query) subqueries() []*Subquery {
+	result := make([]*Subquery, 0)
+	q.visit(func(expr Expr) {
+		if sub, ok := expr.(*Subquery); ok {
+			result = append(result, sub)
+		}
+	})
+	return result
+}
+
+// correlated проверяет, есть ли в запросе коррелированные подзапросы
+func (q *Query) correlated() bool {
+	for _, sub := range q.subqueries() {
+		if sub.Correlated {
+			return true
+		}
+	}
+	return false
+}
+
+// prepareSubqueries готовит подзапросы к выполнению. Они читают коллекции
+// через источники sources, заблокированные внешним запросом, поэтому сами
+// блокировок не захватывают. Некоррелированные подзапросы выполняются сразу
+func (qe *QueryExecutor) prepareSubqueries(query *Query, sources map[string]storage.Storage) error {
+	subs := query.subqueries()
+	if len(subs) == 0 {
+		return nil
+	}
+	
+	executor := &QueryExecutor{DB: make(map[string]Collection, len(sources)), Functions: qe.Functions, outer: qe.outer}
+	for name, source := range sources {
+		executor.DB[name] = Collection{Storage: source, Indexes: qe.DB[name].Indexes}
+	}
+	
+	for _, sub := range subs {
+		sub.executor = executor
+		if sub.Correlated {
+			continue
+		}
+		
+		rows, err := executor.runSubquery(sub)
+		if err != nil {
+			return err
+		}
+		sub.rows, sub.values = rows, sub.column(rows)
+		sub.keys = valueKeys(sub.values)
+	}
+	return nil
+}
+
+// runSubquery выполняет подзапрос. Для EXISTS достаточно одной строки
+func (qe *QueryExecutor) runSubquery(sub *Subquery) ([]map[string]interface{}, error) {
+	query := sub.Query
+	if sub.exists && query.Limit != 0 {
+		limited := *query
+		limited.Limit = 1
+		query = &limited
+	}
+	
+	rows, _, err := qe.run(query)
+	if err != nil {
+		return nil, fmt.Errorf("подзапрос: %w", err)
+	}
+	return rows, nil
+}
+
+// results возвращает строки подзапроса для строки doc внешнего запроса
+func (sub *Subquery) results(doc storage.Document) ([]map[string]interface{}, error) {
+	if !sub.Correlated {
+		return sub.rows, nil
+	}
+	return sub.executor.withOuter(doc).runSubquery(sub)
+}
+
+// column возвращает значения единственного столбца строк подзапроса
+func (sub *Subquery) column(rows []map[string]interface{}) []interface{} {
+	item := sub.Query.Select[0]
+	if _, ok := item.Expr.(*Star); ok {
+		return nil
+	}
+	
+	values := make([]interface{}, 0, len(rows))
+	for _, row := range rows {
+		var (
+			value interface{}
+			ok    bool
+		)
+		// Поле без псевдонима выбирается с сохранением вложенной структуры
+		if field, isField := item.Expr.(*FieldRef); isField && item.Alias == "" && field.Path != "_id" && field.Depth == 0 {
+			value, ok = storage.ResolvePath(row, field.Path)
+		} else {
+			value, ok = row[item.Name()]
+		}
+		if ok {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// withOuter возвращает исполнитель подзапроса, для которого doc - строка
+// ближайшего внешнего запроса
+func (qe *QueryExecutor) withOuter(doc storage.Document) *QueryExecutor {
+	outer := make([]storage.Document, len(qe.outer), len(qe.outer)+1)
+	copy(outer, qe.outer)
+	return &QueryExecutor{DB: qe.DB, Functions: qe.Functions, outer: append(outer, doc)}
+}
+
+// rowOf возвращает строку, к которой относится поле: doc или строку внешнего запроса
+func (qe *QueryExecutor) rowOf(doc storage.Document, field *FieldRef) (storage.Document, bool) {
+	if field.Depth == 0 {
+		return doc, true
+	}
+	if field.Depth > len(qe.outer) {
+		return storage.Document{}, false
+	}
+	return qe.outer[len(qe.outer)-field.Depth], true
+}
+
+// evalIn проверяет вхождение значения в список или результат подзапроса.
+// Значения сравниваются с учетом типа, как в индексе. Если совпадения нет,
+// а значение или один из элементов равен NULL, результат - UNKNOWN.
+// NOT IN - отрицание IN: NOT IN (1, NULL) не выполняется ни для какого значения
+func (qe *QueryExecutor) evalIn(doc storage.Document, cond *Condition) (truth, error) {
+	lefts, err := qe.evalValues(doc, cond.Left)
+	if err != nil {
+		return truthFalse, err
+	}
+	
+	var (
+		rights []interface{}
+		keys   map[string]bool
+	)
+	switch right := cond.Right.(type) {
+	case *List:
+		for _, item := range right.Items {
+			values, err := qe.evalValues(doc, item)
+			if err != nil {
+				return truthFalse, err
+			}
+			rights = append(rights, values...)
+		}
+		keys = valueKeys(rights)
+	case *Subquery:
+		if !right.Correlated {
+			rights, keys = right.values, right.keys
+			break
+		}
+		
+		rows, err := right.results(doc)
+		if err != nil {
+			return truthFalse, err
+		}
+		rights = right.column(rows)
+		keys = valueKeys(rights)
+	}
+	
+	if len(rights) == 0 {
+		// Пустой набор не содержит ни одного значения, в том числе NULL
+		return truthOf(cond.Operator == "NOT IN"), nil
+	}
+	
+	result := truthFalse
+	if len(lefts) == 0 {
+		result = truthUnknown
+	}
+	for _, left := range lefts {
+		if left == nil {
+			result = result.or(truthUnknown)
+			continue
+		}
+		
+		if t, ok := left.(time.Time); ok {
+			// Даты, возвращаемые функциями, совпадают с датами и строками дат
+			for _, right := range rights {
+				switch right.(type) {
+				case time.Time, string:
+					if qe.compare(t, right) == 0 {
+						result = truthTrue
+					}
+				}
+			}
+		} else if keys[distinctKey(left)] {
+			result = truthTrue
+		}
+	}
+	
+	// Несовпадение с NULL среди элементов не означает, что значения нет в наборе
+	if result == truthFalse && keys[distinctKey(nil)] {
+		result = truthUnknown
+	}
+	
+	if cond.Operator == "NOT IN" {
+		return result.not(), nil
+	}
+	return result, nil
+}
+
+// valueKeys возвращает множество ключей значений. NULL входит в множество
+// под своим ключом, но ни с каким значением не совпадает
+func valueKeys(values []interface{}) map[string]bool {
+	keys := make(map[string]bool, len(values))
+	for _, value := range values {
+		keys[distinctKey(value)] = true
+	}
+	return keys
+}
+
+// expandIn представляет условие "поле IN (...)" с известными до выполнения
+// значениями дизъюнкцией равенств, чтобы для него можно было использовать индекс
+func expandIn(cond *Condition) *Condition {
+	field, ok := cond.Left.(*FieldRef)
+	if !ok || field.Depth > 0 {
+		return nil
+	}
+	
+	var values []interface{}
+	switch right := cond.Right.(type) {
+	case *List:
+		for _, item := range right.Items {
+			literal, ok := item.(*Literal)
+			if !ok {
+				return nil
+			}
+			values = append(values, literal.Value)
+		}
+	case *Subquery:
+		if right.Correlated || right.executor == nil {
+			return nil
+		}
+		values = right.values
+	default:
+		return nil
+	}
+	
+	children := make([]*Condition, 0, len(values))
+	seen := make(map[string]bool)
+	for _, value := range values {
+		key := distinctKey(value)
+		if value == nil || seen[key] {
+			continue
+		}
+		seen[key] = true
+		children = append(children, &Condition{Left: field, Operator: "=", Right: &Literal{Value: value}})
+	}
+	
+	if len(children) == 1 {
+		return children[0]
+	}
+	return &Condition{ChildOp: "OR", Children: children}
+}
+
+// bindOuter возвращает копию условия, в которой ссылки на строки внешних
+// запросов заменены их значениями: для планировщика это константы
+func (qe *QueryExecutor) bindOuter(cond *Condition) *Condition {
+	if cond == nil || len(qe.outer) == 0 {
+		return cond
+	}
+	
+	bound, _ := rewrite(cond, func(expr Expr) (Expr, bool) {
+		field, ok := expr.(*FieldRef)
+		if !ok || field.Depth == 0 || storage.HasWildcard(field.Path) {
+			return expr, true
+		}
+		if value, ok, _ := qe.evalExpr(storage.Document{}, field); ok {
+			return &Literal{Value: value}, true
+		}
+		return expr, true
+	})
+	
+	// Сравнение константы с полем приводится к виду "поле оператор константа"
+	bound.walk(func(c *Condition) {
+		_, leftIsLiteral := c.Left.(*Literal)
+		_, rightIsLiteral := c.Right.(*Literal)
+		if op, ok := mirroredOperators[c.Operator]; ok && leftIsLiteral && !rightIsLiteral {
+			c.Left, c.Right, c.Operator = c.Right, c.Left, op
+		}
+	})
+	return bound
+}
+
+// rewrite возвращает копию условия, в которой операнды заменены функцией fn.
+// Второе значение равно false, если fn не смогла заменить какой-либо операнд
+func rewrite(cond *Condition, fn func(Expr) (Expr, bool)) (*Condition, bool) {
+	if len(cond.Children) > 0 {
+		result := &Condition{ChildOp: cond.ChildOp}
+		for _, child := range cond.Children {
+			rewritten, ok := rewrite(child, fn)
+			if !ok {
+				return nil, false
+			}
+			result.Children = append(result.Children, rewritten)
+		}
+		return result, true
+	}
+	
+	left, ok := fn(cond.Left)
+	if !ok {
+		return nil, false
+	}
+	right, ok := fn(cond.Right)
+	if !ok {
+		return nil, false
+	}
+	return &Condition{Left: left, Operator: cond.Operator, Right: right}, true
+}
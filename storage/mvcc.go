@@ -0,0 +1,334 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+// DefaultVacuumInterval - период фоновой очистки устаревших версий документов
+const DefaultVacuumInterval = 30 * time.Second
+
+// errSnapshotReadOnly возвращается при попытке изменить снимок
+var errSnapshotReadOnly = errors.New("снимок хранилища доступен только для чтения")
+
+// Snapshotter реализуется хранилищами, способными выдать согласованный снимок
+type Snapshotter interface {
+	// Snapshot возвращает снимок текущего состояния хранилища
+	Snapshot() *Snapshot
+}
+
+// version хранит прежнее содержимое документа, замененное записью с отметкой
+// времени ts. doc равен nil, если до этой записи документа не было
+type version struct {
+	ts  uint64
+	doc *Document
+}
+
+// VersionedStorage добавляет к хранилищу многоверсионность (MVCC).
+// Актуальные документы хранятся в Base; при изменении документа, пока открыт
+// хотя бы один снимок, прежняя версия сохраняется в памяти. Снимок читает
+// состояние хранилища на момент своего создания и не блокирует запись.
+// Версии, не нужные ни одному открытому снимку, удаляются фоновой очисткой
+type VersionedStorage struct {
+	Base Storage
+	
+	mu        sync.RWMutex
+	clock     uint64
+	versions  map[string][]version
+	snapshots map[uint64]int
+	stop      chan struct{}
+	closed    bool
+}
+
+// NewVersionedStorage создает многоверсионное хранилище поверх base.
+// При положительном vacuumInterval устаревшие версии удаляются в фоне с этим периодом
+func NewVersionedStorage(base Storage, vacuumInterval time.Duration) *VersionedStorage {
+	vs := &VersionedStorage{
+		Base:      base,
+		versions:  make(map[string][]version),
+		snapshots: make(map[uint64]int),
+		stop:      make(chan struct{}),
+	}
+	
+	if vacuumInterval > 0 {
+		go vs.vacuumLoop(vacuumInterval)
+	}
+	
+	return vs
+}
+
+// vacuumLoop периодически удаляет устаревшие версии до закрытия хранилища
+func (vs *VersionedStorage) vacuumLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	
+	for {
+		select {
+		case <-ticker.C:
+			vs.Vacuum()
+		case <-vs.stop:
+			return
+		}
+	}
+}
+
+// write изменяет документ, сохраняя прежнюю версию для открытых снимков
+func (vs *VersionedStorage) write(id string, apply func() error) error {
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
+	
+	if len(vs.snapshots) == 0 {
+		if err := apply(); err != nil {
+			return err
+		}
+		vs.clock++
+		return nil
+	}
+	
+	// Прежняя версия запоминается до изменения Base, поэтому снимок,
+	// читающий Base параллельно с записью, всегда найдет ее
+	previous := version{ts: vs.clock + 1}
+	if doc, err := vs.Base.Get(id); err == nil {
+		previous.doc = &doc
+	}
+	vs.versions[id] = append(vs.versions[id], previous)
+	
+	if err := apply(); err != nil {
+		chain := vs.versions[id]
+		if len(chain) == 1 {
+			delete(vs.versions, id)
+		} else {
+			vs.versions[id] = chain[:len(chain)-1]
+		}
+		return err
+	}
+	
+	vs.clock++
+	return nil
+}
+
+// Save сохраняет документ
+func (vs *VersionedStorage) Save(doc Document) error {
+	return vs.write(doc.ID, func() error {
+		return vs.Base.Save(doc)
+	})
+}
+
+// Get извлекает актуальную версию документа по ID
+func (vs *VersionedStorage) Get(id string) (Document, error) {
+	return vs.Base.Get(id)
+}
+
+// Delete удаляет документ по ID
+func (vs *VersionedStorage) Delete(id string) error {
+	return vs.write(id, func() error {
+		return vs.Base.Delete(id)
+	})
+}
+
+// List возвращает актуальные версии всех документов
+func (vs *VersionedStorage) List() ([]Document, error) {
+	return vs.Base.List()
+}
+
+// Count возвращает количество документов
+func (vs *VersionedStorage) Count() (int, error) {
+	if counter, ok := vs.Base.(Counter); ok {
+		return counter.Count()
+	}
+	
+	docs, err := vs.Base.List()
+	if err != nil {
+		return 0, err
+	}
+	return len(docs), nil
+}
+
+// Snapshot возвращает снимок текущего состояния хранилища.
+// Снимок необходимо освободить вызовом Release
+func (vs *VersionedStorage) Snapshot() *Snapshot {
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
+	
+	vs.snapshots[vs.clock]++
+	return &Snapshot{storage: vs, ts: vs.clock}
+}
+
+// release освобождает снимок с отметкой времени ts
+func (vs *VersionedStorage) release(ts uint64) {
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
+	
+	vs.snapshots[ts]--
+	if vs.snapshots[ts] <= 0 {
+		delete(vs.snapshots, ts)
+	}
+}
+
+// Vacuum удаляет версии, которые не нужны ни одному открытому снимку,
+// и возвращает количество удаленных версий
+func (vs *VersionedStorage) Vacuum() int {
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
+	
+	// Снимку с отметкой ts нужны только версии новее ts, поэтому
+	// версии не новее самого старого снимка можно удалить
+	oldest, ok := uint64(0), false
+	for ts := range vs.snapshots {
+		if !ok || ts < oldest {
+			oldest, ok = ts, true
+		}
+	}
+	
+	removed := 0
+	for id, chain := range vs.versions {
+		keep := 0
+		if ok {
+			for keep < len(chain) && chain[keep].ts <= oldest {
+				keep++
+			}
+		} else {
+			keep = len(chain)
+		}
+		
+		removed += keep
+		if keep == len(chain) {
+			delete(vs.versions, id)
+		} else if keep > 0 {
+			vs.versions[id] = append([]version(nil), chain[keep:]...)
+		}
+	}
+	
+	return removed
+}
+
+// Versions возвращает количество хранимых прежних версий документов
+func (vs *VersionedStorage) Versions() int {
+	vs.mu.RLock()
+	defer vs.mu.RUnlock()
+	
+	count := 0
+	for _, chain := range vs.versions {
+		count += len(chain)
+	}
+	return count
+}
+
+// Close останавливает фоновую очистку и закрывает Base, если оно это поддерживает
+func (vs *VersionedStorage) Close() error {
+	vs.mu.Lock()
+	if vs.closed {
+		vs.mu.Unlock()
+		return nil
+	}
+	vs.closed = true
+	close(vs.stop)
+	vs.mu.Unlock()
+	
+	if closer, ok := vs.Base.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// Snapshot представляет согласованное состояние многоверсионного хранилища
+// на момент создания снимка. Реализует Storage только для чтения
+type Snapshot struct {
+	storage *VersionedStorage
+	ts      uint64
+	once    sync.Once
+}
+
+// visible возвращает версию документа, видимую снимку, если документ был
+// изменен после создания снимка
+func (s *Snapshot) visible(id string) (*Document, bool) {
+	for _, v := range s.storage.versions[id] {
+		if v.ts > s.ts {
+			return v.doc, true
+		}
+	}
+	return nil, false
+}
+
+// Get извлекает документ в том состоянии, в котором он был при создании снимка
+func (s *Snapshot) Get(id string) (Document, error) {
+	// Base читается до проверки версий: если запись произошла параллельно,
+	// ее прежняя версия к моменту проверки уже сохранена
+	doc, err := s.storage.Base.Get(id)
+	
+	s.storage.mu.RLock()
+	defer s.storage.mu.RUnlock()
+	
+	if old, ok := s.visible(id); ok {
+		if old == nil {
+			return Document{}, errors.New("документ не найден")
+		}
+		return *old, nil
+	}
+	return doc, err
+}
+
+// List возвращает документы в том состоянии, в котором они были при создании снимка
+func (s *Snapshot) List() ([]Document, error) {
+	docs, err := s.storage.Base.List()
+	if err != nil {
+		return nil, err
+	}
+	
+	s.storage.mu.RLock()
+	defer s.storage.mu.RUnlock()
+	
+	result := make([]Document, 0, len(docs))
+	seen := make(map[string]bool, len(docs))
+	for _, doc := range docs {
+		seen[doc.ID] = true
+		if old, ok := s.visible(doc.ID); ok {
+			if old != nil {
+				result = append(result, *old)
+			}
+			continue
+		}
+		result = append(result, doc)
+	}
+	
+	// Документы, удаленные после создания снимка
+	for id := range s.storage.versions {
+		if seen[id] {
+			continue
+		}
+		if old, ok := s.visible(id); ok && old != nil {
+			result = append(result, *old)
+		}
+	}
+	
+	return result, nil
+}
+
+// Count возвращает количество документов в снимке
+func (s *Snapshot) Count() (int, error) {
+	docs, err := s.List()
+	if err != nil {
+		return 0, err
+	}
+	return len(docs), nil
+}
+
+// Save не поддерживается снимком
+func (s *Snapshot) Save(doc Document) error {
+	return errSnapshotReadOnly
+}
+
+// Delete не поддерживается снимком
+func (s *Snapshot) Delete(id string) error {
+	return errSnapshotReadOnly
+}
+
+// Release освобождает снимок, позволяя удалить нужные только ему версии.
+// Повторный вызов ничего не делает
+func (s *Snapshot) Release() {
+	s.once.Do(func() {
+		s.storage.release(s.ts)
+	})
+}
@@ -0,0 +1,154 @@
+package storage
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+// snapshotState возвращает содержимое хранилища в виде строки, отсортированной по ID
+func snapshotState(t *testing.T, s Storage) string {
+	t.Helper()
+	
+	docs, err := s.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Slice(docs, func(i, j int) bool { return docs[i].ID < docs[j].ID })
+	
+	state := ""
+	for _, doc := range docs {
+		state += fmt.Sprintf("%s=%v;", doc.ID, doc.Content["v"])
+	}
+	return state
+}
+
+// modelState возвращает содержимое эталонной карты в том же виде, что snapshotState
+func modelState(model map[string]float64) string {
+	ids := make([]string, 0, len(model))
+	for id := range model {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	
+	state := ""
+	for _, id := range ids {
+		state += fmt.Sprintf("%s=%v;", id, model[id])
+	}
+	return state
+}
+
+func TestSnapshotsMatchModel(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	vs := NewVersionedStorage(NewMemoryStorage(), 0)
+	defer vs.Close()
+	
+	type opened struct {
+		snap  *Snapshot
+		model map[string]float64
+	}
+	var snaps []opened
+	model := map[string]float64{}
+	
+	for step := 0; step < 3000; step++ {
+		id := fmt.Sprint("d", rnd.Intn(30))
+		switch rnd.Intn(10) {
+		case 0:
+			copied := make(map[string]float64, len(model))
+			for k, v := range model {
+				copied[k] = v
+			}
+			snaps = append(snaps, opened{vs.Snapshot(), copied})
+		case 1:
+			if len(snaps) > 0 {
+				i := rnd.Intn(len(snaps))
+				snaps[i].snap.Release()
+				snaps = append(snaps[:i], snaps[i+1:]...)
+			}
+		case 2:
+			vs.Vacuum()
+		case 3:
+			if err := vs.Delete(id); err == nil {
+				delete(model, id)
+			} else if _, ok := model[id]; ok {
+				t.Fatalf("шаг %d: удаление %s: %v", step, id, err)
+			}
+		default:
+			v := float64(step)
+			if err := vs.Save(Document{ID: id, Content: map[string]interface{}{"v": v}}); err != nil {
+				t.Fatal(err)
+			}
+			model[id] = v
+		}
+		
+		if step%50 != 0 {
+			continue
+		}
+		if got := snapshotState(t, vs); got != modelState(model) {
+			t.Fatalf("шаг %d: текущее состояние %s, ожидалось %s", step, got, modelState(model))
+		}
+		for _, o := range snaps {
+			if got := snapshotState(t, o.snap); got != modelState(o.model) {
+				t.Fatalf("шаг %d: снимок видит %s, ожидалось %s", step, got, modelState(o.model))
+			}
+			if n, _ := o.snap.Count(); n != len(o.model) {
+				t.Fatalf("шаг %d: в снимке %d документов, ожидалось %d", step, n, len(o.model))
+			}
+			if doc, err := o.snap.Get(id); err == nil {
+				if v, ok := o.model[id]; !ok || doc.Content["v"] != v {
+					t.Fatalf("шаг %d: снимок вернул %v для %s", step, doc.Content, id)
+				}
+			} else if _, ok := o.model[id]; ok {
+				t.Fatalf("шаг %d: снимок не нашел документ %s", step, id)
+			}
+		}
+	}
+	
+	for _, o := range snaps {
+		o.snap.Release()
+	}
+	vs.Vacuum()
+	if n := vs.Versions(); n != 0 {
+		t.Fatalf("после освобождения снимков осталось %d версий", n)
+	}
+}
+
+func TestSnapshotVacuum(t *testing.T) {
+	vs := NewVersionedStorage(NewMemoryStorage(), 0)
+	defer vs.Close()
+	for i := 0; i < 3; i++ {
+		vs.Save(Document{ID: fmt.Sprint(i), Content: map[string]interface{}{"v": 1.0}})
+	}
+	
+	snap := vs.Snapshot()
+	vs.Save(Document{ID: "0", Content: map[string]interface{}{"v": -5.0}})
+	vs.Delete("1")
+	vs.Save(Document{ID: "new", Content: map[string]interface{}{}})
+	
+	if doc, _ := snap.Get("0"); doc.Content["v"] != 1.0 {
+		t.Fatalf("снимок видит изменение после своего создания: %v", doc.Content)
+	}
+	if _, err := snap.Get("1"); err != nil {
+		t.Fatal("снимок не видит удаленный позже документ")
+	}
+	if _, err := snap.Get("new"); err == nil {
+		t.Fatal("снимок видит документ, созданный позже")
+	}
+	if err := snap.Save(Document{ID: "x"}); err == nil {
+		t.Fatal("запись в снимок должна вернуть ошибку")
+	}
+	
+	// Версии нужны открытому снимку и не удаляются, пока он не освобожден
+	if n := vs.Vacuum(); n != 0 {
+		t.Fatalf("удалено %d версий, нужных снимку", n)
+	}
+	snap.Release()
+	snap.Release()
+	if n := vs.Vacuum(); n != 3 {
+		t.Fatalf("удалено %d версий, ожидалось 3", n)
+	}
+	if n := vs.Versions(); n != 0 {
+		t.Fatalf("осталось %d версий", n)
+	}
+}
@@ -0,0 +1,839 @@
+package storage
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// pagedFileName - имя файла страниц в директории хранилища
+const pagedFileName = "data.pages"
+
+// Разметка страницы данных: после общего заголовка идут число слотов,
+// начало области записей и каталог слотов [смещение uint16][длина uint16].
+// Записи размещаются от конца страницы к началу
+const (
+	dataSlotCountOffset = pageHeaderSize
+	dataFreeEndOffset   = dataSlotCountOffset + 2
+	dataSlotsOffset     = dataFreeEndOffset + 2
+	slotSize            = 4
+)
+
+// Разметка страницы продолжения: ссылка на следующую страницу, число
+// занятых байт и данные
+const (
+	overflowUsedOffset = nextPageOffset + 4
+	overflowDataOffset = overflowUsedOffset + 2
+)
+
+// Флаги записи документа
+const (
+	recordInline   byte = 0
+	recordOverflow byte = 1
+)
+
+// recordHeaderSize - заголовок записи: [флаг byte][длина ID uint16]
+const recordHeaderSize = 3
+
+// location указывает на запись документа: страницу и номер слота
+type location struct {
+	page uint32
+	slot int
+}
+
+// PageStats содержит статистику файла страничного хранилища
+type PageStats struct {
+	PageSize  int
+	Pages     int
+	FreePages int
+	Documents int
+}
+
+// PagedStorage реализует Storage, храня всю коллекцию в одном файле,
+// разбитом на страницы фиксированного размера. Небольшие документы
+// размещаются по нескольку на странице данных, большие - в цепочках страниц
+// продолжения. Освобожденные страницы попадают в список свободных и
+// используются повторно, а Compact переписывает файл без пустот.
+// Изменения, как и в FileStorage, сначала записываются в журнал, а
+// страницы сбрасываются на диск только при контрольной точке, поэтому
+// файл всегда соответствует последней контрольной точке
+type PagedStorage struct {
+	Dir   string
+	Mutex sync.RWMutex
+	
+	// CheckpointInterval - число записей журнала между контрольными точками
+	CheckpointInterval int
+	
+	// Skipped содержит записи журнала, которые не удалось применить
+	// при восстановлении после сбоя
+	Skipped []error
+	
+	cacheSize int
+	pager     *pager
+	locations map[string]location
+	available map[uint32]bool
+	current   uint32
+	wal       *wal
+	closed    bool
+}
+
+// NewPagedStorage открывает или создает страничное хранилище в директории dir.
+// Нулевые pageSize и cacheSize заменяются значениями по умолчанию;
+// размер страницы существующего файла берется из его заголовка
+func NewPagedStorage(dir string, pageSize, cacheSize int) (*PagedStorage, error) {
+	if pageSize == 0 {
+		pageSize = DefaultPageSize
+	}
+	if pageSize < MinPageSize || pageSize > MaxPageSize {
+		return nil, fmt.Errorf("размер страницы должен быть от %d до %d байт", MinPageSize, MaxPageSize)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	if err := removeTempFiles(dir); err != nil {
+		return nil, err
+	}
+	
+	ps := &PagedStorage{
+		Dir:                dir,
+		CheckpointInterval: DefaultCheckpointInterval,
+		cacheSize:          cacheSize,
+	}
+	
+	path := filepath.Join(dir, pagedFileName)
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		p, err := createPager(path, pageSize, cacheSize)
+		if err != nil {
+			return nil, err
+		}
+		if err := p.sync(); err != nil {
+			p.close()
+			return nil, err
+		}
+		p.close()
+	}
+	
+	if err := ps.load(); err != nil {
+		return nil, err
+	}
+	
+	w, records, err := openWAL(filepath.Join(dir, walFileName))
+	if err != nil {
+		ps.pager.close()
+		return nil, err
+	}
+	ps.wal = w
+	
+	if err := ps.replay(records); err != nil {
+		ps.pager.close()
+		w.close()
+		return nil, fmt.Errorf("восстановление из журнала: %w", err)
+	}
+	if err := ps.checkpoint(); err != nil {
+		ps.pager.close()
+		w.close()
+		return nil, err
+	}
+	
+	return ps, nil
+}
+
+// load открывает файл страниц и строит по нему карту документов
+// и список страниц данных со свободным местом
+func (ps *PagedStorage) load() error {
+	p, err := openPager(filepath.Join(ps.Dir, pagedFileName), ps.cacheSize)
+	if err != nil {
+		return err
+	}
+	
+	ps.pager = p
+	ps.locations = make(map[string]location)
+	ps.available = make(map[uint32]bool)
+	ps.current = 0
+	
+	for no := uint32(1); no < p.pageCount; no++ {
+		data, err := p.read(no)
+		if err != nil {
+			p.close()
+			return err
+		}
+		
+		pg := &page{no: no, data: data}
+		switch pg.kind() {
+		case pageTypeFree:
+			p.freeCount++
+		case pageTypeData:
+			for slot := 0; slot < slotCount(pg); slot++ {
+				offset, length := slotAt(pg, slot)
+				if length == 0 {
+					continue
+				}
+				idLen := int(binary.LittleEndian.Uint16(pg.data[offset+1:]))
+				id := string(pg.data[offset+recordHeaderSize : offset+recordHeaderSize+idLen])
+				ps.locations[id] = location{page: no, slot: slot}
+			}
+			ps.updateAvailable(pg)
+		}
+	}
+	
+	return nil
+}
+
+// replay применяет записи журнала, оставшиеся после предыдущего запуска.
+// Запись, которую нельзя применить (нет документа, документ не кодируется,
+// неизвестная операция), пропускается и попадает в Skipped
+func (ps *PagedStorage) replay(records []walRecord) error {
+	for i, record := range records {
+		switch record.Op {
+		case walOpSave:
+			if record.Doc == nil {
+				ps.Skipped = append(ps.Skipped, skippedRecord(i+1, record, errors.New("запись не содержит документа")))
+				continue
+			}
+			content, err := ps.encode(*record.Doc)
+			if err != nil {
+				ps.Skipped = append(ps.Skipped, skippedRecord(i+1, record, err))
+				continue
+			}
+			if err := ps.put(record.Doc.ID, content); err != nil {
+				return err
+			}
+		case walOpDelete:
+			if err := ps.remove(record.ID); err != nil {
+				return err
+			}
+		default:
+			ps.Skipped = append(ps.Skipped, skippedRecord(i+1, record, fmt.Errorf("неизвестная операция журнала %q", record.Op)))
+		}
+	}
+	
+	return ps.pager.trim()
+}
+
+// slotCount возвращает число слотов страницы данных
+func slotCount(pg *page) int {
+	return int(binary.LittleEndian.Uint16(pg.data[dataSlotCountOffset:]))
+}
+
+// slotAt возвращает смещение и длину записи в слоте; длина 0 означает пустой слот
+func slotAt(pg *page, slot int) (int, int) {
+	pos := dataSlotsOffset + slot*slotSize
+	return int(binary.LittleEndian.Uint16(pg.data[pos:])), int(binary.LittleEndian.Uint16(pg.data[pos+2:]))
+}
+
+// setSlot записывает смещение и длину записи в слот
+func setSlot(pg *page, slot, offset, length int) {
+	pos := dataSlotsOffset + slot*slotSize
+	binary.LittleEndian.PutUint16(pg.data[pos:], uint16(offset))
+	binary.LittleEndian.PutUint16(pg.data[pos+2:], uint16(length))
+}
+
+// freeEnd возвращает начало области записей страницы данных
+func freeEnd(pg *page) int {
+	end := int(binary.LittleEndian.Uint16(pg.data[dataFreeEndOffset:]))
+	if end == 0 {
+		return len(pg.data)
+	}
+	return end
+}
+
+// setFreeEnd сохраняет начало области записей страницы данных
+func setFreeEnd(pg *page, end int) {
+	binary.LittleEndian.PutUint16(pg.data[dataFreeEndOffset:], uint16(end))
+}
+
+// freeSpace возвращает общий объем свободного места на странице данных,
+// включая пустоты, оставшиеся от удаленных записей
+func freeSpace(pg *page) int {
+	used := dataSlotsOffset + slotCount(pg)*slotSize
+	for slot := 0; slot < slotCount(pg); slot++ {
+		_, length := slotAt(pg, slot)
+		used += length
+	}
+	return len(pg.data) - used
+}
+
+// compactPage сдвигает записи к концу страницы, объединяя пустоты
+func compactPage(pg *page) {
+	type entry struct {
+		slot int
+		data []byte
+	}
+	
+	entries := make([]entry, 0, slotCount(pg))
+	for slot := 0; slot < slotCount(pg); slot++ {
+		offset, length := slotAt(pg, slot)
+		if length > 0 {
+			entries = append(entries, entry{slot: slot, data: append([]byte(nil), pg.data[offset:offset+length]...)})
+		}
+	}
+	
+	end := len(pg.data)
+	for _, e := range entries {
+		end -= len(e.data)
+		copy(pg.data[end:], e.data)
+		setSlot(pg, e.slot, end, len(e.data))
+	}
+	setFreeEnd(pg, end)
+	pg.dirty = true
+}
+
+// insertRecord размещает запись на странице данных и возвращает номер слота.
+// Возвращает false, если места недостаточно
+func insertRecord(pg *page, record []byte) (int, bool) {
+	count := slotCount(pg)
+	slot := count
+	for i := 0; i < count; i++ {
+		if _, length := slotAt(pg, i); length == 0 {
+			slot = i
+			break
+		}
+	}
+	
+	need := len(record)
+	if slot == count {
+		need += slotSize
+	}
+	if freeSpace(pg) < need {
+		return 0, false
+	}
+	if freeEnd(pg)-(dataSlotsOffset+count*slotSize) < need {
+		compactPage(pg)
+	}
+	
+	if slot == count {
+		binary.LittleEndian.PutUint16(pg.data[dataSlotCountOffset:], uint16(count+1))
+	}
+	end := freeEnd(pg) - len(record)
+	copy(pg.data[end:], record)
+	setFreeEnd(pg, end)
+	setSlot(pg, slot, end, len(record))
+	pg.dirty = true
+	
+	return slot, true
+}
+
+// maxInline возвращает наибольший размер записи, помещающейся на страницу данных
+func (ps *PagedStorage) maxInline() int {
+	return ps.pager.pageSize - dataSlotsOffset - slotSize
+}
+
+// updateAvailable учитывает страницу данных среди страниц для вставки,
+// если на ней свободно не меньше четверти
+func (ps *PagedStorage) updateAvailable(pg *page) {
+	if freeSpace(pg) >= ps.pager.pageSize/4 {
+		ps.available[pg.no] = true
+	} else {
+		delete(ps.available, pg.no)
+	}
+}
+
+// encode проверяет, что документ можно разместить в файле, и кодирует его содержимое
+func (ps *PagedStorage) encode(doc Document) ([]byte, error) {
+	if len(doc.ID) > ps.maxInline()-recordHeaderSize-8 {
+		return nil, fmt.Errorf("ID документа длиннее %d байт", ps.maxInline()-recordHeaderSize-8)
+	}
+	return json.Marshal(doc.Content)
+}
+
+// put записывает закодированное содержимое документа, заменяя его прежнюю версию
+func (ps *PagedStorage) put(id string, content []byte) error {
+	if err := ps.remove(id); err != nil {
+		return err
+	}
+	
+	record := make([]byte, recordHeaderSize+len(id), recordHeaderSize+len(id)+len(content))
+	binary.LittleEndian.PutUint16(record[1:], uint16(len(id)))
+	copy(record[recordHeaderSize:], id)
+	
+	if len(record)+len(content) <= ps.maxInline() {
+		record[0] = recordInline
+		record = append(record, content...)
+	} else {
+		first, err := ps.writeOverflow(content)
+		if err != nil {
+			return err
+		}
+		pointer := make([]byte, 8)
+		binary.LittleEndian.PutUint32(pointer, first)
+		binary.LittleEndian.PutUint32(pointer[4:], uint32(len(content)))
+		record[0] = recordOverflow
+		record = append(record, pointer...)
+	}
+	
+	loc, err := ps.insert(record)
+	if err != nil {
+		return err
+	}
+	
+	ps.locations[id] = loc
+	return nil
+}
+
+// insert размещает запись на текущей странице, на одной из страниц со
+// свободным местом или на новой странице
+func (ps *PagedStorage) insert(record []byte) (location, error) {
+	candidates := make([]uint32, 0, len(ps.available)+1)
+	if ps.current != 0 {
+		candidates = append(candidates, ps.current)
+	}
+	for no := range ps.available {
+		if no != ps.current {
+			candidates = append(candidates, no)
+		}
+	}
+	
+	for _, no := range candidates {
+		pg, err := ps.pager.get(no)
+		if err != nil {
+			return location{}, err
+		}
+		if slot, ok := insertRecord(pg, record); ok {
+			ps.current = no
+			ps.updateAvailable(pg)
+			return location{page: no, slot: slot}, nil
+		}
+		delete(ps.available, no)
+	}
+	
+	pg, err := ps.pager.allocate(pageTypeData)
+	if err != nil {
+		return location{}, err
+	}
+	slot, _ := insertRecord(pg, record)
+	ps.current = pg.no
+	ps.updateAvailable(pg)
+	
+	return location{page: pg.no, slot: slot}, nil
+}
+
+// writeOverflow записывает данные в цепочку страниц продолжения
+// и возвращает номер первой страницы
+func (ps *PagedStorage) writeOverflow(data []byte) (uint32, error) {
+	chunk := ps.pager.pageSize - overflowDataOffset
+	
+	var first uint32
+	var prev *page
+	for len(data) > 0 {
+		pg, err := ps.pager.allocate(pageTypeOverflow)
+		if err != nil {
+			return 0, err
+		}
+		
+		n := len(data)
+		if n > chunk {
+			n = chunk
+		}
+		copy(pg.data[overflowDataOffset:], data[:n])
+		binary.LittleEndian.PutUint16(pg.data[overflowUsedOffset:], uint16(n))
+		data = data[n:]
+		
+		if prev == nil {
+			first = pg.no
+		} else {
+			binary.LittleEndian.PutUint32(prev.data[nextPageOffset:], pg.no)
+		}
+		prev = pg
+	}
+	
+	return first, nil
+}
+
+// readOverflow читает данные из цепочки страниц продолжения
+func (ps *PagedStorage) readOverflow(first uint32, total int) ([]byte, error) {
+	data := make([]byte, 0, total)
+	for no := first; no != 0 && len(data) < total; {
+		pg, err := ps.pager.get(no)
+		if err != nil {
+			return nil, err
+		}
+		if pg.kind() != pageTypeOverflow {
+			return nil, fmt.Errorf("страница %d не является страницей продолжения", no)
+		}
+		
+		used := int(binary.LittleEndian.Uint16(pg.data[overflowUsedOffset:]))
+		data = append(data, pg.data[overflowDataOffset:overflowDataOffset+used]...)
+		no = binary.LittleEndian.Uint32(pg.data[nextPageOffset:])
+	}
+	
+	if len(data) != total {
+		return nil, errors.New("цепочка страниц продолжения оборвана")
+	}
+	return data, nil
+}
+
+// freeOverflow освобождает цепочку страниц продолжения
+func (ps *PagedStorage) freeOverflow(first uint32) error {
+	for no := first; no != 0; {
+		pg, err := ps.pager.get(no)
+		if err != nil {
+			return err
+		}
+		
+		next := binary.LittleEndian.Uint32(pg.data[nextPageOffset:])
+		if err := ps.pager.release(pg); err != nil {
+			return err
+		}
+		no = next
+	}
+	
+	return nil
+}
+
+// read читает документ по положению его записи
+func (ps *PagedStorage) read(id string, loc location) (Document, error) {
+	pg, err := ps.pager.get(loc.page)
+	if err != nil {
+		return Document{}, err
+	}
+	
+	offset, length := slotAt(pg, loc.slot)
+	record := pg.data[offset : offset+length]
+	body := record[recordHeaderSize+int(binary.LittleEndian.Uint16(record[1:])):]
+	
+	content := body
+	if record[0] == recordOverflow {
+		first := binary.LittleEndian.Uint32(body)
+		total := int(binary.LittleEndian.Uint32(body[4:]))
+		if content, err = ps.readOverflow(first, total); err != nil {
+			return Document{}, err
+		}
+	}
+	
+	doc := Document{ID: id}
+	if err := json.Unmarshal(content, &doc.Content); err != nil {
+		return Document{}, err
+	}
+	return doc, nil
+}
+
+// remove удаляет запись документа, если она есть
+func (ps *PagedStorage) remove(id string) error {
+	loc, ok := ps.locations[id]
+	if !ok {
+		return nil
+	}
+	
+	pg, err := ps.pager.get(loc.page)
+	if err != nil {
+		return err
+	}
+	
+	offset, _ := slotAt(pg, loc.slot)
+	if pg.data[offset] == recordOverflow {
+		body := offset + recordHeaderSize + int(binary.LittleEndian.Uint16(pg.data[offset+1:]))
+		if err := ps.freeOverflow(binary.LittleEndian.Uint32(pg.data[body:])); err != nil {
+			return err
+		}
+	}
+	
+	setSlot(pg, loc.slot, 0, 0)
+	pg.dirty = true
+	delete(ps.locations, id)
+	
+	// Отбросить пустые слоты в конце каталога
+	count := slotCount(pg)
+	for count > 0 {
+		if _, length := slotAt(pg, count-1); length > 0 {
+			break
+		}
+		count--
+	}
+	binary.LittleEndian.PutUint16(pg.data[dataSlotCountOffset:], uint16(count))
+	
+	if count == 0 {
+		delete(ps.available, pg.no)
+		if ps.current == pg.no {
+			ps.current = 0
+		}
+		return ps.pager.release(pg)
+	}
+	
+	ps.updateAvailable(pg)
+	return nil
+}
+
+// logged записывает изменение в журнал, применяет его и при необходимости
+// выполняет контрольную точку. Изменение проверяется до вызова logged; если
+// применить его все же не удалось, запись остается в журнале: страницы в памяти
+// могли измениться частично, и восстановление доведет изменение до конца
+func (ps *PagedStorage) logged(record walRecord, apply func() error) error {
+	if ps.closed {
+		return ErrStorageClosed
+	}
+	
+	if err := ps.wal.append(record); err != nil {
+		return err
+	}
+	if err := apply(); err != nil {
+		return err
+	}
+	if err := ps.pager.trim(); err != nil {
+		return err
+	}
+	
+	if ps.CheckpointInterval > 0 && ps.wal.records >= ps.CheckpointInterval {
+		return ps.checkpoint()
+	}
+	return nil
+}
+
+// checkpoint сбрасывает страницы на диск и очищает журнал. Если больше
+// половины страниц файла свободны, файл уплотняется
+func (ps *PagedStorage) checkpoint() error {
+	if err := ps.pager.sync(); err != nil {
+		return err
+	}
+	if err := ps.wal.reset(); err != nil {
+		return err
+	}
+	
+	if ps.pager.pageCount > 64 && ps.pager.freeCount*2 > int(ps.pager.pageCount) {
+		return ps.compact()
+	}
+	return nil
+}
+
+// Save сохраняет документ
+func (ps *PagedStorage) Save(doc Document) error {
+	ps.Mutex.Lock()
+	defer ps.Mutex.Unlock()
+	
+	if ps.closed {
+		return ErrStorageClosed
+	}
+	content, err := ps.encode(doc)
+	if err != nil {
+		return err
+	}
+	
+	return ps.logged(walRecord{Op: walOpSave, ID: doc.ID, Doc: &doc}, func() error {
+		return ps.put(doc.ID, content)
+	})
+}
+
+// Get извлекает документ по ID
+func (ps *PagedStorage) Get(id string) (Document, error) {
+	ps.Mutex.RLock()
+	defer ps.Mutex.RUnlock()
+	
+	if ps.closed {
+		return Document{}, ErrStorageClosed
+	}
+	
+	loc, ok := ps.locations[id]
+	if !ok {
+		return Document{}, errors.New("документ не найден")
+	}
+	
+	doc, err := ps.read(id, loc)
+	if err != nil {
+		return Document{}, err
+	}
+	return doc, ps.pager.trim()
+}
+
+// Delete удаляет документ по ID
+func (ps *PagedStorage) Delete(id string) error {
+	ps.Mutex.Lock()
+	defer ps.Mutex.Unlock()
+	
+	if _, ok := ps.locations[id]; !ok {
+		return errors.New("документ не найден")
+	}
+	
+	return ps.logged(walRecord{Op: walOpDelete, ID: id}, func() error {
+		return ps.remove(id)
+	})
+}
+
+// List возвращает все документы в порядке их размещения в файле
+func (ps *PagedStorage) List() ([]Document, error) {
+	ps.Mutex.RLock()
+	defer ps.Mutex.RUnlock()
+	
+	if ps.closed {
+		return nil, ErrStorageClosed
+	}
+	
+	ids := make([]string, 0, len(ps.locations))
+	for id := range ps.locations {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		a, b := ps.locations[ids[i]], ps.locations[ids[j]]
+		if a.page != b.page {
+			return a.page < b.page
+		}
+		return a.slot < b.slot
+	})
+	
+	docs := make([]Document, 0, len(ids))
+	for _, id := range ids {
+		doc, err := ps.read(id, ps.locations[id])
+		if err != nil {
+			return nil, err
+		}
+		docs = append(docs, doc)
+	}
+	
+	return docs, ps.pager.trim()
+}
+
+// Count возвращает количество документов
+func (ps *PagedStorage) Count() (int, error) {
+	ps.Mutex.RLock()
+	defer ps.Mutex.RUnlock()
+	
+	return len(ps.locations), nil
+}
+
+// Stats возвращает статистику файла страниц
+func (ps *PagedStorage) Stats() PageStats {
+	ps.Mutex.RLock()
+	defer ps.Mutex.RUnlock()
+	
+	return PageStats{
+		PageSize:  ps.pager.pageSize,
+		Pages:     int(ps.pager.pageCount),
+		FreePages: ps.pager.freeCount,
+		Documents: len(ps.locations),
+	}
+}
+
+// Path возвращает директорию хранилища
+func (ps *PagedStorage) Path() string {
+	return ps.Dir
+}
+
+// Fingerprint возвращает отпечаток содержимого файла страниц: номер поколения,
+// который увеличивается при каждой записи страниц на диск. Изменения,
+// еще не прошедшие контрольную точку, в отпечатке не отражаются
+func (ps *PagedStorage) Fingerprint() (string, error) {
+	ps.Mutex.RLock()
+	defer ps.Mutex.RUnlock()
+	
+	if ps.closed {
+		return "", ErrStorageClosed
+	}
+	return fmt.Sprintf("paged:%d:%d", ps.pager.generation, ps.pager.pageCount), nil
+}
+
+// Checkpoint сбрасывает страницы на диск и очищает журнал
+func (ps *PagedStorage) Checkpoint() error {
+	ps.Mutex.Lock()
+	defer ps.Mutex.Unlock()
+	
+	if ps.closed {
+		return ErrStorageClosed
+	}
+	return ps.checkpoint()
+}
+
+// Compact переписывает файл страниц без свободных страниц и пустот
+func (ps *PagedStorage) Compact() error {
+	ps.Mutex.Lock()
+	defer ps.Mutex.Unlock()
+	
+	if ps.closed {
+		return ErrStorageClosed
+	}
+	if err := ps.pager.sync(); err != nil {
+		return err
+	}
+	return ps.compact()
+}
+
+// compact копирует документы в новый файл и атомарно заменяет им текущий.
+// Вызывается после сброса страниц на диск
+func (ps *PagedStorage) compact() error {
+	path := filepath.Join(ps.Dir, pagedFileName)
+	tmpPath := path + ".tmp"
+	
+	p, err := createPager(tmpPath, ps.pager.pageSize, ps.cacheSize)
+	if err != nil {
+		return err
+	}
+	target := &PagedStorage{
+		Dir:       ps.Dir,
+		cacheSize: ps.cacheSize,
+		pager:     p,
+		locations: make(map[string]location),
+		available: make(map[uint32]bool),
+	}
+	
+	fail := func(err error) error {
+		p.close()
+		os.Remove(tmpPath)
+		return err
+	}
+	
+	ids := make([]string, 0, len(ps.locations))
+	for id := range ps.locations {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	
+	for _, id := range ids {
+		doc, err := ps.read(id, ps.locations[id])
+		if err != nil {
+			return fail(err)
+		}
+		content, err := target.encode(doc)
+		if err != nil {
+			return fail(err)
+		}
+		if err := target.put(id, content); err != nil {
+			return fail(err)
+		}
+		if err := p.spill(); err != nil {
+			return fail(err)
+		}
+	}
+	
+	if err := p.sync(); err != nil {
+		return fail(err)
+	}
+	if err := p.close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	
+	if err := ps.pager.close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return err
+	}
+	if err := syncDir(ps.Dir); err != nil {
+		return err
+	}
+	
+	return ps.load()
+}
+
+// Close выполняет контрольную точку и закрывает файлы хранилища.
+// Повторный вызов ничего не делает
+func (ps *PagedStorage) Close() error {
+	ps.Mutex.Lock()
+	defer ps.Mutex.Unlock()
+	
+	if ps.closed {
+		return nil
+	}
+	ps.closed = true
+	
+	err := ps.checkpoint()
+	if closeErr := ps.wal.close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := ps.pager.close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,236 @@
+package storage
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// checkPaged сравнивает содержимое хранилища с эталонной картой документов
+func checkPaged(t *testing.T, ps *PagedStorage, model map[string]map[string]interface{}) {
+	t.Helper()
+	
+	docs, err := ps.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(docs) != len(model) {
+		t.Fatalf("в хранилище %d документов, ожидалось %d", len(docs), len(model))
+	}
+	for _, doc := range docs {
+		if !reflect.DeepEqual(doc.Content, model[doc.ID]) {
+			t.Fatalf("документ %s: %v, ожидалось %v", doc.ID, doc.Content, model[doc.ID])
+		}
+	}
+	for id, content := range model {
+		doc, err := ps.Get(id)
+		if err != nil || !reflect.DeepEqual(doc.Content, content) {
+			t.Fatalf("документ %s: %v, %v", id, doc.Content, err)
+		}
+	}
+}
+
+// crashPaged закрывает файлы хранилища без контрольной точки и открывает его заново
+func crashPaged(t *testing.T, ps *PagedStorage) *PagedStorage {
+	t.Helper()
+	
+	ps.wal.close()
+	ps.pager.close()
+	reopened, err := NewPagedStorage(ps.Dir, 0, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return reopened
+}
+
+func TestPagedStorageRandomWorkload(t *testing.T) {
+	dir := t.TempDir()
+	ps, err := NewPagedStorage(dir, 1024, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ps.CheckpointInterval = 37
+	
+	model := map[string]map[string]interface{}{}
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 3000; i++ {
+		id := fmt.Sprintf("d%d", r.Intn(300))
+		if r.Intn(4) == 0 {
+			if _, ok := model[id]; ok {
+				if err := ps.Delete(id); err != nil {
+					t.Fatal(err)
+				}
+				delete(model, id)
+			} else if ps.Delete(id) == nil {
+				t.Fatalf("удаление отсутствующего документа %s должно вернуть ошибку", id)
+			}
+			continue
+		}
+		
+		// Каждый десятый документ не помещается на страницу и уходит в страницы продолжения
+		size := r.Intn(50)
+		if r.Intn(10) == 0 {
+			size = 500 + r.Intn(3000)
+		}
+		content := map[string]interface{}{"n": float64(i), "s": strings.Repeat("x", size)}
+		if err := ps.Save(Document{ID: id, Content: content}); err != nil {
+			t.Fatal(err)
+		}
+		model[id] = content
+		
+		if i%500 == 0 {
+			checkPaged(t, ps, model)
+			ps = crashPaged(t, ps)
+			ps.CheckpointInterval = 37
+			checkPaged(t, ps, model)
+		}
+	}
+	checkPaged(t, ps, model)
+	
+	for id := range model {
+		if r.Intn(10) > 0 {
+			ps.Delete(id)
+			delete(model, id)
+		}
+	}
+	if err := ps.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	checkPaged(t, ps, model)
+	if free := ps.Stats().FreePages; free != 0 {
+		t.Fatalf("после уплотнения осталось %d свободных страниц", free)
+	}
+	
+	if err := ps.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.Save(Document{ID: "x"}); err != ErrStorageClosed {
+		t.Fatalf("ожидалась ErrStorageClosed, получено %v", err)
+	}
+	ps, err = NewPagedStorage(dir, 0, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkPaged(t, ps, model)
+}
+
+func TestPagedStorageRecoversFromPageJournal(t *testing.T) {
+	dir := t.TempDir()
+	ps, err := NewPagedStorage(dir, 1024, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	model := map[string]map[string]interface{}{}
+	for i := 0; i < 20; i++ {
+		id := fmt.Sprintf("d%d", i)
+		model[id] = map[string]interface{}{"s": strings.Repeat("y", i*100)}
+		if err := ps.Save(Document{ID: id, Content: model[id]}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ps.Checkpoint(); err != nil {
+		t.Fatal(err)
+	}
+	
+	// Сбой после записи журнала страниц: страницы в файле повреждены,
+	// восстановление берет их из журнала
+	ps.Save(Document{ID: "jr", Content: map[string]interface{}{"a": 1.0}})
+	model["jr"] = map[string]interface{}{"a": 1.0}
+	var dirty []*page
+	for _, pg := range ps.pager.cache {
+		if pg.dirty {
+			ps.pager.write(pg)
+			dirty = append(dirty, pg)
+		}
+	}
+	path := filepath.Join(dir, pagedFileName)
+	if err := writePageJournal(path+journalSuffix, dirty, 1024); err != nil {
+		t.Fatal(err)
+	}
+	ps.wal.reset()
+	ps.wal.close()
+	ps.pager.close()
+	
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, pg := range dirty {
+		f.WriteAt(make([]byte, 100), int64(pg.no)*1024)
+	}
+	f.Close()
+	
+	ps, err = NewPagedStorage(dir, 0, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkPaged(t, ps, model)
+	
+	// Оборванный журнал страниц не применяется
+	os.WriteFile(path+journalSuffix, []byte("garbage-garbage"), 0644)
+	ps.Close()
+	ps, err = NewPagedStorage(dir, 0, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkPaged(t, ps, model)
+}
+
+func TestPagedStorageReopensAfterFailedWrite(t *testing.T) {
+	dir := t.TempDir()
+	ps, err := NewPagedStorage(dir, 1024, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ps.CheckpointInterval = 0
+	
+	if err := ps.Save(Document{ID: "a", Content: map[string]interface{}{"v": 1.0}}); err != nil {
+		t.Fatal(err)
+	}
+	records := ps.wal.records
+	
+	if err := ps.Save(Document{ID: strings.Repeat("x", 2000), Content: map[string]interface{}{}}); err == nil {
+		t.Fatal("сохранение со слишком длинным ID должно вернуть ошибку")
+	}
+	if err := ps.Save(Document{ID: "b", Content: map[string]interface{}{"f": func() {}}}); err == nil {
+		t.Fatal("сохранение некодируемого документа должно вернуть ошибку")
+	}
+	if ps.wal.records != records {
+		t.Fatalf("неудачные записи попали в журнал: %d записей, ожидалось %d", ps.wal.records, records)
+	}
+	
+	ps = crashPaged(t, ps)
+	if len(ps.Skipped) != 0 {
+		t.Fatalf("пропущены записи: %v", ps.Skipped)
+	}
+	checkPaged(t, ps, map[string]map[string]interface{}{"a": {"v": 1.0}})
+}
+
+func TestPagedStorageSkipsInapplicableRecords(t *testing.T) {
+	dir := t.TempDir()
+	ps, err := NewPagedStorage(dir, 1024, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, record := range []walRecord{
+		{Op: walOpSave, ID: "a", Doc: &Document{ID: "a", Content: map[string]interface{}{"v": 1.0}}},
+		{Op: walOpSave, ID: "long", Doc: &Document{ID: strings.Repeat("x", 2000)}},
+		{Op: walOpSave, ID: "b"},
+		{Op: "move", ID: "a"},
+		{Op: walOpDelete, ID: "c"},
+	} {
+		if err := ps.wal.append(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	
+	ps = crashPaged(t, ps)
+	if len(ps.Skipped) != 3 {
+		t.Fatalf("пропущено %d записей, ожидалось 3: %v", len(ps.Skipped), ps.Skipped)
+	}
+	checkPaged(t, ps, map[string]map[string]interface{}{"a": {"v": 1.0}})
+}
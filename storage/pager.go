@@ -0,0 +1,478 @@
+package storage
+
+import (
+	"container/list"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Размеры страниц страничного хранилища
+const (
+	// DefaultPageSize - размер страницы по умолчанию
+	DefaultPageSize = 4096
+	// MinPageSize - минимальный размер страницы
+	MinPageSize = 1024
+	// MaxPageSize - максимальный размер страницы: смещения внутри страницы 16-битные
+	MaxPageSize = 32768
+	// DefaultPageCacheSize - число страниц в кэше по умолчанию
+	DefaultPageCacheSize = 256
+)
+
+// pageMagic - сигнатура файла страничного хранилища
+const pageMagic = "JSONDBPG"
+
+// pageVersion - версия формата файла
+const pageVersion = 1
+
+// Типы страниц
+const (
+	pageTypeFree     byte = 0
+	pageTypeHeader   byte = 1
+	pageTypeData     byte = 2
+	pageTypeOverflow byte = 3
+)
+
+// pageHeaderSize - общий заголовок страницы: [crc32 uint32][тип byte][резерв 3 байта].
+// Контрольная сумма покрывает всю страницу после своего поля и позволяет
+// обнаружить страницу, записанную не полностью
+const pageHeaderSize = 8
+
+// Смещения полей заголовочной страницы (страница 0)
+const (
+	headerMagicOffset      = pageHeaderSize
+	headerVersionOffset    = headerMagicOffset + 8
+	headerPageSizeOffset   = headerVersionOffset + 4
+	headerPageCountOffset  = headerPageSizeOffset + 4
+	headerFreeHeadOffset   = headerPageCountOffset + 4
+	headerGenerationOffset = headerFreeHeadOffset + 4
+)
+
+// nextPageOffset - смещение ссылки на следующую страницу в свободной
+// странице и странице продолжения
+const nextPageOffset = pageHeaderSize
+
+// journalSuffix - суффикс файла журнала страниц
+const journalSuffix = ".journal"
+
+// page представляет страницу в кэше
+type page struct {
+	no    uint32
+	data  []byte
+	dirty bool
+	elem  *list.Element
+}
+
+// kind возвращает тип страницы
+func (p *page) kind() byte {
+	return p.data[4]
+}
+
+// pager управляет страницами файла: читает и записывает их через LRU-кэш
+// и ведет список свободных страниц. Страница 0 хранит заголовок файла
+type pager struct {
+	file       *os.File
+	pageSize   int
+	pageCount  uint32
+	freeHead   uint32
+	freeCount  int
+	generation uint64
+	
+	mu       sync.Mutex
+	cache    map[uint32]*page
+	lru      *list.List
+	capacity int
+}
+
+// createPager создает новый файл страниц с единственной заголовочной страницей
+func createPager(path string, pageSize, cacheSize int) (*pager, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, err
+	}
+	
+	p := newPager(file, pageSize, cacheSize)
+	p.pageCount = 1
+	
+	header := &page{no: 0, data: make([]byte, pageSize)}
+	header.data[4] = pageTypeHeader
+	p.put(header)
+	p.writeHeader()
+	
+	return p, nil
+}
+
+// openPager открывает существующий файл страниц, предварительно
+// завершая контрольную точку, прерванную сбоем
+func openPager(path string, cacheSize int) (*pager, error) {
+	file, err := os.OpenFile(path, os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := recoverPageJournal(path+journalSuffix, file); err != nil {
+		file.Close()
+		return nil, err
+	}
+	
+	fixed := make([]byte, headerGenerationOffset+8)
+	if _, err := io.ReadFull(file, fixed); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("чтение заголовка файла страниц: %w", err)
+	}
+	if string(fixed[headerMagicOffset:headerMagicOffset+8]) != pageMagic {
+		file.Close()
+		return nil, errors.New("файл не является файлом страничного хранилища")
+	}
+	if version := binary.LittleEndian.Uint32(fixed[headerVersionOffset:]); version != pageVersion {
+		file.Close()
+		return nil, fmt.Errorf("неподдерживаемая версия файла страниц: %d", version)
+	}
+	
+	pageSize := int(binary.LittleEndian.Uint32(fixed[headerPageSizeOffset:]))
+	if pageSize < MinPageSize || pageSize > MaxPageSize {
+		file.Close()
+		return nil, fmt.Errorf("недопустимый размер страницы: %d", pageSize)
+	}
+	
+	p := newPager(file, pageSize, cacheSize)
+	header, err := p.get(0)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	p.pageCount = binary.LittleEndian.Uint32(header.data[headerPageCountOffset:])
+	p.freeHead = binary.LittleEndian.Uint32(header.data[headerFreeHeadOffset:])
+	p.generation = binary.LittleEndian.Uint64(header.data[headerGenerationOffset:])
+	
+	return p, nil
+}
+
+// newPager создает пейджер для открытого файла
+func newPager(file *os.File, pageSize, cacheSize int) *pager {
+	if cacheSize <= 0 {
+		cacheSize = DefaultPageCacheSize
+	}
+	
+	return &pager{
+		file:     file,
+		pageSize: pageSize,
+		cache:    make(map[uint32]*page),
+		lru:      list.New(),
+		capacity: cacheSize,
+	}
+}
+
+// get возвращает страницу, читая ее с диска при промахе кэша
+func (p *pager) get(no uint32) (*page, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	
+	if pg, ok := p.cache[no]; ok {
+		p.lru.MoveToFront(pg.elem)
+		return pg, nil
+	}
+	
+	data, err := p.read(no)
+	if err != nil {
+		return nil, err
+	}
+	
+	pg := &page{no: no, data: data}
+	pg.elem = p.lru.PushFront(pg)
+	p.cache[no] = pg
+	return pg, nil
+}
+
+// read читает страницу с диска в обход кэша и проверяет ее контрольную сумму
+func (p *pager) read(no uint32) ([]byte, error) {
+	if no >= p.pageCount && no != 0 {
+		return nil, fmt.Errorf("страница %d за пределами файла", no)
+	}
+	
+	data := make([]byte, p.pageSize)
+	if _, err := p.file.ReadAt(data, int64(no)*int64(p.pageSize)); err != nil {
+		return nil, fmt.Errorf("чтение страницы %d: %w", no, err)
+	}
+	if binary.LittleEndian.Uint32(data[:4]) != crc32.Checksum(data[4:], walTable) {
+		return nil, fmt.Errorf("страница %d повреждена: неверная контрольная сумма", no)
+	}
+	
+	return data, nil
+}
+
+// put помещает новую страницу в кэш
+func (p *pager) put(pg *page) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	
+	if old, ok := p.cache[pg.no]; ok {
+		p.lru.Remove(old.elem)
+	}
+	pg.dirty = true
+	pg.elem = p.lru.PushFront(pg)
+	p.cache[pg.no] = pg
+}
+
+// write записывает страницу на диск, обновляя ее контрольную сумму
+func (p *pager) write(pg *page) error {
+	binary.LittleEndian.PutUint32(pg.data[:4], crc32.Checksum(pg.data[4:], walTable))
+	if _, err := p.file.WriteAt(pg.data, int64(pg.no)*int64(p.pageSize)); err != nil {
+		return err
+	}
+	
+	pg.dirty = false
+	return nil
+}
+
+// trim вытесняет из кэша давно не использованные неизмененные страницы
+// сверх емкости. Измененные страницы остаются в кэше до sync, чтобы файл
+// не содержал изменений после последней контрольной точки. Вызывается после
+// завершения операции, когда ссылки на страницы больше не удерживаются
+func (p *pager) trim() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	
+	for elem := p.lru.Back(); elem != nil && p.lru.Len() > p.capacity; {
+		pg := elem.Value.(*page)
+		elem = elem.Prev()
+		if pg.dirty {
+			continue
+		}
+		p.lru.Remove(pg.elem)
+		delete(p.cache, pg.no)
+	}
+	
+	return nil
+}
+
+// spill записывает измененные страницы без журнала и вытесняет лишние
+// страницы из кэша. Используется только для нового файла при уплотнении,
+// пока он не заменил рабочий
+func (p *pager) spill() error {
+	p.mu.Lock()
+	if p.lru.Len() <= p.capacity {
+		p.mu.Unlock()
+		return nil
+	}
+	for _, pg := range p.cache {
+		if pg.dirty {
+			if err := p.write(pg); err != nil {
+				p.mu.Unlock()
+				return err
+			}
+		}
+	}
+	p.mu.Unlock()
+	
+	return p.trim()
+}
+
+// hasDirty проверяет, есть ли в кэше измененные страницы
+func (p *pager) hasDirty() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	
+	for _, pg := range p.cache {
+		if pg.dirty {
+			return true
+		}
+	}
+	return false
+}
+
+// sync атомарно записывает измененные страницы на диск и увеличивает
+// номер поколения файла. Образы страниц сначала сохраняются в журнал
+// страниц, поэтому сбой во время записи в файл не оставит его в
+// промежуточном состоянии
+func (p *pager) sync() error {
+	if !p.hasDirty() {
+		return nil
+	}
+	
+	p.generation++
+	if err := p.writeHeader(); err != nil {
+		return err
+	}
+	
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	
+	dirty := make([]*page, 0)
+	for _, pg := range p.cache {
+		if pg.dirty {
+			binary.LittleEndian.PutUint32(pg.data[:4], crc32.Checksum(pg.data[4:], walTable))
+			dirty = append(dirty, pg)
+		}
+	}
+	if len(dirty) == 0 {
+		return nil
+	}
+	
+	journalPath := p.file.Name() + journalSuffix
+	if err := writePageJournal(journalPath, dirty, p.pageSize); err != nil {
+		return err
+	}
+	
+	for _, pg := range dirty {
+		if err := p.write(pg); err != nil {
+			return err
+		}
+	}
+	if err := p.file.Sync(); err != nil {
+		return err
+	}
+	
+	return os.Remove(journalPath)
+}
+
+// close закрывает файл без записи изменений
+func (p *pager) close() error {
+	return p.file.Close()
+}
+
+// writeHeader обновляет заголовочную страницу
+func (p *pager) writeHeader() error {
+	header, err := p.get(0)
+	if err != nil {
+		return err
+	}
+	
+	copy(header.data[headerMagicOffset:], pageMagic)
+	binary.LittleEndian.PutUint32(header.data[headerVersionOffset:], pageVersion)
+	binary.LittleEndian.PutUint32(header.data[headerPageSizeOffset:], uint32(p.pageSize))
+	binary.LittleEndian.PutUint32(header.data[headerPageCountOffset:], p.pageCount)
+	binary.LittleEndian.PutUint32(header.data[headerFreeHeadOffset:], p.freeHead)
+	binary.LittleEndian.PutUint64(header.data[headerGenerationOffset:], p.generation)
+	header.dirty = true
+	return nil
+}
+
+// allocate выделяет страницу указанного типа: из списка свободных
+// страниц или в конце файла
+func (p *pager) allocate(kind byte) (*page, error) {
+	var pg *page
+	
+	if p.freeHead != 0 {
+		free, err := p.get(p.freeHead)
+		if err != nil {
+			return nil, err
+		}
+		p.freeHead = binary.LittleEndian.Uint32(free.data[nextPageOffset:])
+		p.freeCount--
+		
+		pg = free
+		for i := range pg.data {
+			pg.data[i] = 0
+		}
+		pg.dirty = true
+	} else {
+		pg = &page{no: p.pageCount, data: make([]byte, p.pageSize)}
+		p.pageCount++
+		p.put(pg)
+	}
+	
+	pg.data[4] = kind
+	return pg, p.writeHeader()
+}
+
+// release возвращает страницу в список свободных страниц
+func (p *pager) release(pg *page) error {
+	for i := range pg.data {
+		pg.data[i] = 0
+	}
+	pg.data[4] = pageTypeFree
+	binary.LittleEndian.PutUint32(pg.data[nextPageOffset:], p.freeHead)
+	pg.dirty = true
+	
+	p.freeHead = pg.no
+	p.freeCount++
+	return p.writeHeader()
+}
+
+// writePageJournal записывает образы страниц в журнал страниц и сбрасывает его
+// на диск. Формат: размер страницы, затем для каждой страницы ее номер и
+// содержимое, в конце - число страниц и контрольная сумма всего журнала
+func writePageJournal(path string, pages []*page, pageSize int) error {
+	buf := make([]byte, 4, 4+len(pages)*(4+pageSize)+8)
+	binary.LittleEndian.PutUint32(buf, uint32(pageSize))
+	
+	no := make([]byte, 4)
+	for _, pg := range pages {
+		binary.LittleEndian.PutUint32(no, pg.no)
+		buf = append(buf, no...)
+		buf = append(buf, pg.data...)
+	}
+	
+	trailer := make([]byte, 8)
+	binary.LittleEndian.PutUint32(trailer, uint32(len(pages)))
+	binary.LittleEndian.PutUint32(trailer[4:], crc32.Checksum(buf, walTable))
+	buf = append(buf, trailer...)
+	
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(buf); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	
+	return syncDir(filepath.Dir(path))
+}
+
+// recoverPageJournal переписывает в файл страницы из полного журнала страниц,
+// оставшегося после сбоя. Неполный журнал означает, что файл страниц еще
+// не изменялся, и просто удаляется
+func recoverPageJournal(path string, file *os.File) error {
+	buf, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	
+	if journalComplete(buf) {
+		pageSize := int(binary.LittleEndian.Uint32(buf))
+		count := int(binary.LittleEndian.Uint32(buf[len(buf)-8:]))
+		for i := 0; i < count; i++ {
+			entry := buf[4+i*(4+pageSize):]
+			no := binary.LittleEndian.Uint32(entry)
+			if _, err := file.WriteAt(entry[4:4+pageSize], int64(no)*int64(pageSize)); err != nil {
+				return err
+			}
+		}
+		if err := file.Sync(); err != nil {
+			return err
+		}
+	}
+	
+	return os.Remove(path)
+}
+
+// journalComplete проверяет, что журнал страниц записан полностью
+func journalComplete(buf []byte) bool {
+	if len(buf) < 12 {
+		return false
+	}
+	
+	pageSize := int(binary.LittleEndian.Uint32(buf))
+	count := int(binary.LittleEndian.Uint32(buf[len(buf)-8:]))
+	if pageSize < MinPageSize || pageSize > MaxPageSize || len(buf) != 4+count*(4+pageSize)+8 {
+		return false
+	}
+	
+	return binary.LittleEndian.Uint32(buf[len(buf)-4:]) == crc32.Checksum(buf[:len(buf)-8], walTable)
+}
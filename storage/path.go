@@ -0,0 +1,337 @@
+package storage
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// PathSegment представляет шаг пути к полю документа: ключ объекта,
+// индекс элемента массива или [*] - все элементы массива
+type PathSegment struct {
+	Key      string
+	Index    int
+	IsIndex  bool
+	Wildcard bool
+}
+
+// ParsePath разбирает путь к полю вида "address.city", "tags[0]" или "items[*].price"
+func ParsePath(path string) ([]PathSegment, error) {
+	if path == "" {
+		return nil, fmt.Errorf("пустой путь к полю")
+	}
+	
+	segments := make([]PathSegment, 0)
+	for _, part := range strings.Split(path, ".") {
+		key := part
+		if i := strings.IndexByte(part, '['); i >= 0 {
+			key = part[:i]
+		}
+		if key == "" {
+			return nil, fmt.Errorf("неверный путь %q: пустое имя поля", path)
+		}
+		segments = append(segments, PathSegment{Key: key})
+		
+		// Индексы массива после имени поля: [0], [*], [1][2]
+		rest := part[len(key):]
+		for rest != "" {
+			end := strings.IndexByte(rest, ']')
+			if rest[0] != '[' || end < 0 {
+				return nil, fmt.Errorf("неверный путь %q: ожидался индекс массива в квадратных скобках", path)
+			}
+			
+			inner := rest[1:end]
+			if inner == "*" {
+				segments = append(segments, PathSegment{IsIndex: true, Wildcard: true})
+			} else {
+				n, err := strconv.Atoi(inner)
+				if err != nil || n < 0 {
+					return nil, fmt.Errorf("неверный путь %q: индекс массива %q должен быть неотрицательным целым числом", path, inner)
+				}
+				segments = append(segments, PathSegment{IsIndex: true, Index: n})
+			}
+			rest = rest[end+1:]
+		}
+	}
+	
+	return segments, nil
+}
+
+// HasWildcard проверяет, содержит ли путь [*]
+func HasWildcard(path string) bool {
+	return strings.Contains(path, "[*]")
+}
+
+// ResolvePath возвращает значение по пути в документе.
+// Для пути с [*] возвращается массив всех найденных значений.
+// Второе значение равно false, если путь не найден или записан неверно
+func ResolvePath(content map[string]interface{}, path string) (interface{}, bool) {
+	segments, err := ParsePath(path)
+	if err != nil {
+		return nil, false
+	}
+	
+	if !HasWildcard(path) {
+		return resolve(content, segments)
+	}
+	
+	values := ResolveAll(content, path)
+	if len(values) == 0 {
+		return nil, false
+	}
+	return values, true
+}
+
+// ResolveAll возвращает все значения, найденные по пути.
+// Без [*] результат содержит не более одного значения
+func ResolveAll(content map[string]interface{}, path string) []interface{} {
+	segments, err := ParsePath(path)
+	if err != nil {
+		return nil
+	}
+	
+	result := make([]interface{}, 0)
+	collect(content, segments, &result)
+	return result
+}
+
+// resolve проходит путь без [*]
+func resolve(value interface{}, segments []PathSegment) (interface{}, bool) {
+	for _, seg := range segments {
+		if seg.IsIndex {
+			arr, ok := value.([]interface{})
+			if !ok || seg.Index >= len(arr) {
+				return nil, false
+			}
+			value = arr[seg.Index]
+			continue
+		}
+		
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if value, ok = obj[seg.Key]; !ok {
+			return nil, false
+		}
+	}
+	
+	return value, true
+}
+
+// collect проходит путь, раскрывая [*], и добавляет найденные значения в result
+func collect(value interface{}, segments []PathSegment, result *[]interface{}) {
+	if len(segments) == 0 {
+		*result = append(*result, value)
+		return
+	}
+	
+	seg := segments[0]
+	switch {
+	case seg.Wildcard:
+		arr, ok := value.([]interface{})
+		if !ok {
+			return
+		}
+		for _, elem := range arr {
+			collect(elem, segments[1:], result)
+		}
+	case seg.IsIndex:
+		arr, ok := value.([]interface{})
+		if ok && seg.Index < len(arr) {
+			collect(arr[seg.Index], segments[1:], result)
+		}
+	default:
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return
+		}
+		if next, ok := obj[seg.Key]; ok {
+			collect(next, segments[1:], result)
+		}
+	}
+}
+
+// SetPath записывает значение по пути, создавая недостающие объекты.
+// Массивы дополняются значениями nil до нужного индекса; путь с [*] не допускается
+func SetPath(content map[string]interface{}, path string, value interface{}) error {
+	segments, err := ParsePath(path)
+	if err != nil {
+		return err
+	}
+	if HasWildcard(path) {
+		return fmt.Errorf("запись по пути %q с [*] не поддерживается", path)
+	}
+	
+	_, err = set(content, segments, value, path)
+	return err
+}
+
+// set записывает значение по пути внутри container и возвращает измененный контейнер.
+// Возврат нужен потому, что дополнение массива создает новый срез
+func set(container interface{}, segments []PathSegment, value interface{}, path string) (interface{}, error) {
+	if len(segments) == 0 {
+		return value, nil
+	}
+	
+	seg := segments[0]
+	if seg.IsIndex {
+		arr, ok := container.([]interface{})
+		if container != nil && !ok {
+			return nil, fmt.Errorf("путь %q: значение не является массивом", path)
+		}
+		for len(arr) <= seg.Index {
+			arr = append(arr, nil)
+		}
+		
+		elem, err := set(arr[seg.Index], segments[1:], value, path)
+		if err != nil {
+			return nil, err
+		}
+		arr[seg.Index] = elem
+		return arr, nil
+	}
+	
+	obj, ok := container.(map[string]interface{})
+	if container != nil && !ok {
+		return nil, fmt.Errorf("путь %q: нельзя записать поле %s, значение не является объектом", path, seg.Key)
+	}
+	if obj == nil {
+		obj = make(map[string]interface{})
+	}
+	
+	elem, err := set(obj[seg.Key], segments[1:], value, path)
+	if err != nil {
+		return nil, err
+	}
+	obj[seg.Key] = elem
+	return obj, nil
+}
+
+// ProjectPaths возвращает часть документа, содержащую только указанные пути,
+// с сохранением вложенной структуры. Из массивов выбираются только указанные
+// элементы в исходном порядке; отсутствующие пути пропускаются
+func ProjectPaths(content map[string]interface{}, paths []string) map[string]interface{} {
+	var result interface{} = projectedObject{}
+	
+	for _, path := range paths {
+		segments, err := ParsePath(path)
+		if err != nil {
+			continue
+		}
+		if projected, ok := project(content, segments); ok {
+			result = mergeProjection(result, projected)
+		}
+	}
+	
+	return finishProjection(result).(map[string]interface{})
+}
+
+// projectedObject и sparseArray - промежуточные результаты проекции: объект
+// с выбранными ключами и выбранные элементы массива по исходным индексам.
+// Значения других типов взяты из документа целиком и не изменяются
+type (
+	projectedObject map[string]interface{}
+	sparseArray     map[int]interface{}
+)
+
+// project выделяет из значения часть, соответствующую пути
+func project(value interface{}, segments []PathSegment) (interface{}, bool) {
+	if len(segments) == 0 {
+		return value, true
+	}
+	
+	seg := segments[0]
+	if seg.IsIndex {
+		arr, ok := value.([]interface{})
+		if !ok {
+			return nil, false
+		}
+		
+		result := make(sparseArray)
+		for i, elem := range arr {
+			if !seg.Wildcard && i != seg.Index {
+				continue
+			}
+			if projected, ok := project(elem, segments[1:]); ok {
+				result[i] = projected
+			}
+		}
+		return result, len(result) > 0
+	}
+	
+	obj, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, false
+	}
+	next, ok := obj[seg.Key]
+	if !ok {
+		return nil, false
+	}
+	
+	projected, ok := project(next, segments[1:])
+	if !ok {
+		return nil, false
+	}
+	return projectedObject{seg.Key: projected}, true
+}
+
+// mergeProjection объединяет проекции разных путей
+func mergeProjection(a, b interface{}) interface{} {
+	switch av := a.(type) {
+	case projectedObject:
+		if bv, ok := b.(projectedObject); ok {
+			for k, v := range bv {
+				if existing, ok := av[k]; ok {
+					av[k] = mergeProjection(existing, v)
+				} else {
+					av[k] = v
+				}
+			}
+			return av
+		}
+	case sparseArray:
+		if bv, ok := b.(sparseArray); ok {
+			for i, v := range bv {
+				if existing, ok := av[i]; ok {
+					av[i] = mergeProjection(existing, v)
+				} else {
+					av[i] = v
+				}
+			}
+			return av
+		}
+	default:
+		// Значение выбрано целиком и уже включает проекцию другого пути
+		return a
+	}
+	
+	return b
+}
+
+// finishProjection превращает выбранные элементы массивов в обычные массивы
+func finishProjection(value interface{}) interface{} {
+	switch v := value.(type) {
+	case projectedObject:
+		result := make(map[string]interface{}, len(v))
+		for k, elem := range v {
+			result[k] = finishProjection(elem)
+		}
+		return result
+	case sparseArray:
+		indexes := make([]int, 0, len(v))
+		for i := range v {
+			indexes = append(indexes, i)
+		}
+		sort.Ints(indexes)
+		
+		result := make([]interface{}, len(indexes))
+		for i, idx := range indexes {
+			result[i] = finishProjection(v[idx])
+		}
+		return result
+	}
+	
+	return value
+}
@@ -0,0 +1,101 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+// pathDoc возвращает документ с вложенными объектами и массивами
+func pathDoc(t *testing.T) map[string]interface{} {
+	t.Helper()
+	
+	var doc map[string]interface{}
+	data := `{"name":"a","address":{"city":"M","zip":"1"},"tags":["x","y","z"],` +
+		`"items":[{"n":"p","price":1},{"n":"q","price":2,"extra":{"k":1}}],"m":[[1,2],[3,4]]}`
+	if err := json.Unmarshal([]byte(data), &doc); err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+func TestResolvePath(t *testing.T) {
+	doc := pathDoc(t)
+	for _, c := range []struct {
+		path  string
+		value string
+		found bool
+		all   string
+	}{
+		{"address.city", "M", true, "[M]"},
+		{"tags[1]", "y", true, "[y]"},
+		{"tags[5]", "<nil>", false, "[]"},
+		{"items[*].price", "[1 2]", true, "[1 2]"},
+		{"items[1].extra.k", "1", true, "[1]"},
+		{"m[1][0]", "3", true, "[3]"},
+		{"m[*][1]", "[2 4]", true, "[2 4]"},
+		{"nope", "<nil>", false, "[]"},
+		{"address.city.x", "<nil>", false, "[]"},
+		{"tags[*]", "[x y z]", true, "[x y z]"},
+	} {
+		value, found := ResolvePath(doc, c.path)
+		if fmt.Sprint(value) != c.value || found != c.found {
+			t.Errorf("ResolvePath(%s) = %v, %v, ожидалось %s, %v", c.path, value, found, c.value, c.found)
+		}
+		if all := fmt.Sprint(ResolveAll(doc, c.path)); all != c.all {
+			t.Errorf("ResolveAll(%s) = %s, ожидалось %s", c.path, all, c.all)
+		}
+	}
+}
+
+func TestProjectPaths(t *testing.T) {
+	doc := pathDoc(t)
+	original, _ := json.Marshal(doc)
+	
+	for _, c := range []struct {
+		paths []string
+		want  string
+	}{
+		{
+			[]string{"address.city", "items[*].n", "items[1].price", "tags[2]", "tags[0]", "m[1][0]"},
+			`{"address":{"city":"M"},"items":[{"n":"p"},{"n":"q","price":2}],"m":[[3]],"tags":["x","z"]}`,
+		},
+		{[]string{"address", "address.city"}, `{"address":{"city":"M","zip":"1"}}`},
+		{[]string{"nope", "tags[7]"}, `{}`},
+	} {
+		got, _ := json.Marshal(ProjectPaths(doc, c.paths))
+		if string(got) != c.want {
+			t.Errorf("ProjectPaths(%v) = %s, ожидалось %s", c.paths, got, c.want)
+		}
+	}
+	
+	if after, _ := json.Marshal(doc); string(after) != string(original) {
+		t.Fatalf("проекция изменила документ: %s", after)
+	}
+}
+
+func TestSetPath(t *testing.T) {
+	content := map[string]interface{}{}
+	if err := SetPath(content, "a.b[2].c", 5); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetPath(content, "a.x", 1); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"a.b[*]", "a.x.y"} {
+		if err := SetPath(content, path, 1); err == nil {
+			t.Errorf("запись по пути %s должна вернуть ошибку", path)
+		}
+	}
+	if got, _ := json.Marshal(content); string(got) != `{"a":{"b":[null,null,{"c":5}],"x":1}}` {
+		t.Fatalf("документ после записи: %s", got)
+	}
+}
+
+func TestParsePathRejectsInvalid(t *testing.T) {
+	for _, path := range []string{"", "a..b", "a[", "a[-1]", "a[x]", "[0]", "a[0]b"} {
+		if _, err := ParsePath(path); err == nil {
+			t.Errorf("путь %q разобран без ошибки", path)
+		}
+	}
+}
@@ -3,8 +3,11 @@ package storage
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
+	"hash/fnv"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 )
 
@@ -29,44 +32,176 @@ type Storage interface {
 	List() ([]Document, error)
 }
 
-// FileStorage реализует Storage используя файловую систему
+// Counter реализуется хранилищами, способными подсчитать документы без их загрузки
+type Counter interface {
+	// Count возвращает количество документов
+	Count() (int, error)
+}
+
+// DefaultCheckpointInterval - число записей журнала, после которого
+// файловое хранилище выполняет контрольную точку
+const DefaultCheckpointInterval = 1000
+
+// ErrStorageClosed возвращается при изменении закрытого хранилища
+var ErrStorageClosed = errors.New("хранилище закрыто")
+
+// maxFileNameLength - наибольшая длина имени файла документа в байтах
+const maxFileNameLength = 255
+
+// Persistent реализуется хранилищами, размещающими данные на диске
+type Persistent interface {
+	// Path возвращает директорию хранилища
+	Path() string
+	
+	// Fingerprint возвращает отпечаток содержимого хранилища на диске.
+	// Отпечаток меняется при изменении документов; вызывается после Checkpoint
+	Fingerprint() (string, error)
+	
+	// Checkpoint сбрасывает все подтвержденные изменения на диск
+	Checkpoint() error
+}
+
+// FileStorage реализует Storage используя файловую систему.
+// Каждое изменение сначала записывается в журнал упреждающей записи и
+// сбрасывается на диск, а затем применяется к файлу документа
 type FileStorage struct {
 	Dir      string
 	Mutex    sync.RWMutex
 	UseCache bool
 	Cache    map[string]Document
+	
+	// CheckpointInterval - число записей журнала между контрольными точками
+	CheckpointInterval int
+	
+	// Skipped содержит записи журнала, которые не удалось применить
+	// при восстановлении после сбоя
+	Skipped []error
+	
+	wal    *wal
+	dirty  map[string]bool
+	closed bool
 }
 
-// NewFileStorage создает новое файловое хранилище
+// NewFileStorage создает новое файловое хранилище. Если в директории остался
+// журнал после сбоя, его записи применяются к файлам документов
 func NewFileStorage(dir string, useCache bool) (*FileStorage, error) {
 	if err := os.MkdirAll(dir, 0755); err != nil {
 		return nil, err
 	}
+	if err := removeTempFiles(dir); err != nil {
+		return nil, err
+	}
+	
+	w, records, err := openWAL(filepath.Join(dir, walFileName))
+	if err != nil {
+		return nil, err
+	}
 	
 	fs := &FileStorage{
-		Dir:      dir,
-		UseCache: useCache,
-		Cache:    make(map[string]Document),
+		Dir:                dir,
+		UseCache:           useCache,
+		Cache:              make(map[string]Document),
+		CheckpointInterval: DefaultCheckpointInterval,
+		wal:                w,
+		dirty:              make(map[string]bool),
+	}
+	
+	if err := fs.replay(records); err != nil {
+		w.close()
+		return nil, fmt.Errorf("восстановление из журнала: %w", err)
+	}
+	if err := fs.checkpoint(); err != nil {
+		w.close()
+		return nil, err
 	}
 	
 	return fs, nil
 }
 
+// validateFileID проверяет, что ID документа можно использовать как имя файла
+func validateFileID(id string) error {
+	switch {
+	case id == "" || id == "." || id == "..":
+		return fmt.Errorf("недопустимый ID документа %q", id)
+	case strings.ContainsAny(id, "/\\\x00"):
+		return fmt.Errorf("ID документа %q содержит разделитель пути или нулевой байт", id)
+	case len(id)+len(".json") > maxFileNameLength:
+		return fmt.Errorf("ID документа длиннее %d байт", maxFileNameLength-len(".json"))
+	}
+	return nil
+}
+
+// documentPath возвращает путь к файлу документа
+func (fs *FileStorage) documentPath(id string) string {
+	return filepath.Join(fs.Dir, id+".json")
+}
+
+// writeDocument атомарно записывает файл документа
+func (fs *FileStorage) writeDocument(doc Document) error {
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	
+	if err := WriteFileAtomic(fs.documentPath(doc.ID), data); err != nil {
+		return err
+	}
+	
+	fs.dirty[doc.ID] = true
+	return nil
+}
+
+// removeDocument удаляет файл документа
+func (fs *FileStorage) removeDocument(id string) error {
+	if err := os.Remove(fs.documentPath(id)); err != nil {
+		return err
+	}
+	
+	delete(fs.dirty, id)
+	return nil
+}
+
+// logged записывает изменение в журнал и при необходимости выполняет
+// контрольную точку после его применения. Если изменение применить не удалось,
+// его запись удаляется из журнала
+func (fs *FileStorage) logged(record walRecord, apply func() error) error {
+	if fs.closed {
+		return ErrStorageClosed
+	}
+	
+	frameStart := fs.wal.size
+	if err := fs.wal.append(record); err != nil {
+		return err
+	}
+	if err := apply(); err != nil {
+		if rollbackErr := fs.wal.rollback(frameStart); rollbackErr != nil {
+			return fmt.Errorf("%v; откат журнала не выполнен: %w", err, rollbackErr)
+		}
+		return err
+	}
+	
+	if fs.CheckpointInterval > 0 && fs.wal.records >= fs.CheckpointInterval {
+		return fs.checkpoint()
+	}
+	return nil
+}
+
 // Save сохраняет документ
 func (fs *FileStorage) Save(doc Document) error {
+	if err := validateFileID(doc.ID); err != nil {
+		return err
+	}
+	
 	fs.Mutex.Lock()
 	defer fs.Mutex.Unlock()
 	
-	data, err := json.Marshal(doc)
+	err := fs.logged(walRecord{Op: walOpSave, ID: doc.ID, Doc: &doc}, func() error {
+		return fs.writeDocument(doc)
+	})
 	if err != nil {
 		return err
 	}
 	
-	filePath := filepath.Join(fs.Dir, doc.ID+".json")
-	if err := os.WriteFile(filePath, data, 0644); err != nil {
-		return err
-	}
-	
 	if fs.UseCache {
 		fs.Cache[doc.ID] = doc
 	}
@@ -76,6 +211,10 @@ func (fs *FileStorage) Save(doc Document) error {
 
 // Get извлекает документ по ID
 func (fs *FileStorage) Get(id string) (Document, error) {
+	if err := validateFileID(id); err != nil {
+		return Document{}, err
+	}
+	
 	fs.Mutex.RLock()
 	defer fs.Mutex.RUnlock()
 	
@@ -85,8 +224,7 @@ func (fs *FileStorage) Get(id string) (Document, error) {
 		}
 	}
 	
-	filePath := filepath.Join(fs.Dir, id+".json")
-	data, err := os.ReadFile(filePath)
+	data, err := os.ReadFile(fs.documentPath(id))
 	if err != nil {
 		return Document{}, err
 	}
@@ -105,11 +243,21 @@ func (fs *FileStorage) Get(id string) (Document, error) {
 
 // Delete удаляет документ по ID
 func (fs *FileStorage) Delete(id string) error {
+	if err := validateFileID(id); err != nil {
+		return err
+	}
+	
 	fs.Mutex.Lock()
 	defer fs.Mutex.Unlock()
 	
-	filePath := filepath.Join(fs.Dir, id+".json")
-	if err := os.Remove(filePath); err != nil {
+	if _, err := os.Stat(fs.documentPath(id)); err != nil {
+		return err
+	}
+	
+	err := fs.logged(walRecord{Op: walOpDelete, ID: id}, func() error {
+		return fs.removeDocument(id)
+	})
+	if err != nil {
 		return err
 	}
 	
@@ -150,6 +298,89 @@ func (fs *FileStorage) List() ([]Document, error) {
 	return docs, nil
 }
 
+// Count возвращает количество документов
+func (fs *FileStorage) Count() (int, error) {
+	fs.Mutex.RLock()
+	defer fs.Mutex.RUnlock()
+	
+	files, err := os.ReadDir(fs.Dir)
+	if err != nil {
+		return 0, err
+	}
+	
+	count := 0
+	for _, file := range files {
+		if filepath.Ext(file.Name()) == ".json" {
+			count++
+		}
+	}
+	
+	return count, nil
+}
+
+// Path возвращает директорию хранилища
+func (fs *FileStorage) Path() string {
+	return fs.Dir
+}
+
+// Fingerprint возвращает отпечаток файлов документов: хэш их имен,
+// размеров и времени изменения
+func (fs *FileStorage) Fingerprint() (string, error) {
+	fs.Mutex.RLock()
+	defer fs.Mutex.RUnlock()
+	
+	files, err := os.ReadDir(fs.Dir)
+	if err != nil {
+		return "", err
+	}
+	
+	h := fnv.New64a()
+	count := 0
+	for _, file := range files {
+		if filepath.Ext(file.Name()) != ".json" {
+			continue
+		}
+		
+		info, err := file.Info()
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "%s\x00%d\x00%d\n", file.Name(), info.Size(), info.ModTime().UnixNano())
+		count++
+	}
+	
+	return fmt.Sprintf("file:%d:%x", count, h.Sum64()), nil
+}
+
+// Checkpoint сбрасывает на диск измененные файлы документов и очищает журнал
+func (fs *FileStorage) Checkpoint() error {
+	fs.Mutex.Lock()
+	defer fs.Mutex.Unlock()
+	
+	if fs.closed {
+		return ErrStorageClosed
+	}
+	return fs.checkpoint()
+}
+
+// Close выполняет контрольную точку и закрывает журнал.
+// Повторный вызов ничего не делает
+func (fs *FileStorage) Close() error {
+	fs.Mutex.Lock()
+	defer fs.Mutex.Unlock()
+	
+	if fs.closed {
+		return nil
+	}
+	fs.closed = true
+	
+	err := fs.checkpoint()
+	if closeErr := fs.wal.close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
 // MemoryStorage реализует Storage используя память
 type MemoryStorage struct {
 	Docs  map[string]Document
@@ -206,3 +437,11 @@ func (ms *MemoryStorage) List() ([]Document, error) {
 	
 	return docs, nil
 }
+
+// Count возвращает количество документов
+func (ms *MemoryStorage) Count() (int, error) {
+	ms.Mutex.RLock()
+	defer ms.Mutex.RUnlock()
+	
+	return len(ms.Docs), nil
+}
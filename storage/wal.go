@@ -0,0 +1,379 @@
+package storage
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// walFileName - имя файла журнала упреждающей записи в директории хранилища
+const walFileName = "wal.log"
+
+// walHeaderSize - размер заголовка кадра журнала: длина записи и ее контрольная сумма
+const walHeaderSize = 8
+
+// walMaxRecordSize ограничивает размер одной записи журнала; кадр с большей
+// длиной считается поврежденным
+const walMaxRecordSize = 1 << 30
+
+// Операции, записываемые в журнал
+const (
+	walOpSave   = "save"
+	walOpDelete = "delete"
+)
+
+// walTable - таблица CRC-32 (Castagnoli) для контрольных сумм кадров
+var walTable = crc32.MakeTable(crc32.Castagnoli)
+
+// walRecord представляет запись журнала - одно изменение хранилища
+type walRecord struct {
+	Op  string    `json:"op"`
+	ID  string    `json:"id"`
+	Doc *Document `json:"doc,omitempty"`
+}
+
+// wal реализует журнал упреждающей записи. Каждая запись хранится в кадре
+// [длина uint32][crc32 uint32][JSON записи] и сбрасывается на диск до того,
+// как изменение применяется к файлам документов
+type wal struct {
+	file    *os.File
+	records int
+	size    int64
+}
+
+// openWAL открывает или создает журнал хранилища и возвращает все целые записи
+func openWAL(path string) (*wal, []walRecord, error) {
+	w, payloads, err := openFrames(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	
+	records := make([]walRecord, len(payloads))
+	for i, payload := range payloads {
+		if err := json.Unmarshal(payload, &records[i]); err != nil {
+			w.close()
+			return nil, nil, fmt.Errorf("запись журнала %d: %w", i+1, err)
+		}
+	}
+	return w, records, nil
+}
+
+// openFrames открывает или создает журнал и возвращает содержимое всех целых
+// кадров. Оборванный или поврежденный хвост журнала (результат сбоя во время
+// записи) отбрасывается: такая запись не была подтверждена
+func openFrames(path string) (*wal, []json.RawMessage, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, nil, err
+	}
+	
+	payloads, valid, err := readFrames(file)
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	
+	// Отрезать оборванный хвост, чтобы новые записи шли сразу за последней целой
+	if err := file.Truncate(valid); err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	if _, err := file.Seek(valid, io.SeekStart); err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	
+	return &wal{file: file, records: len(payloads), size: valid}, payloads, nil
+}
+
+// readFrames читает кадры журнала с начала файла до первого неполного или
+// поврежденного кадра. Возвращает содержимое кадров и длину корректной части файла
+func readFrames(file *os.File) ([]json.RawMessage, int64, error) {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, 0, err
+	}
+	
+	records := make([]json.RawMessage, 0)
+	var offset int64
+	header := make([]byte, walHeaderSize)
+	
+	for {
+		if _, err := io.ReadFull(file, header); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return records, offset, nil
+			}
+			return nil, 0, err
+		}
+		
+		length := binary.LittleEndian.Uint32(header[:4])
+		checksum := binary.LittleEndian.Uint32(header[4:])
+		if length == 0 || length > walMaxRecordSize {
+			return records, offset, nil
+		}
+		
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(file, payload); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return records, offset, nil
+			}
+			return nil, 0, err
+		}
+		
+		if crc32.Checksum(payload, walTable) != checksum {
+			return records, offset, nil
+		}
+		
+		if !json.Valid(payload) {
+			return records, offset, nil
+		}
+		
+		records = append(records, payload)
+		offset += walHeaderSize + int64(length)
+	}
+}
+
+// append записывает кадр в конец журнала и дожидается его сброса на диск
+func (w *wal) append(record interface{}) error {
+	payload, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	
+	frame := make([]byte, walHeaderSize+len(payload))
+	binary.LittleEndian.PutUint32(frame[:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(frame[4:walHeaderSize], crc32.Checksum(payload, walTable))
+	copy(frame[walHeaderSize:], payload)
+	
+	// Неудачно записанный кадр отрезается, чтобы не оставлять в журнале
+	// неподтвержденное изменение
+	if _, err := w.file.Write(frame); err != nil {
+		w.truncate(w.size)
+		return err
+	}
+	if err := w.file.Sync(); err != nil {
+		w.truncate(w.size)
+		return err
+	}
+	
+	w.records++
+	w.size += int64(len(frame))
+	return nil
+}
+
+// rollback отбрасывает последний кадр журнала: изменение не удалось применить,
+// и при восстановлении оно повторяться не должно
+func (w *wal) rollback(frameStart int64) error {
+	if err := w.truncate(frameStart); err != nil {
+		return err
+	}
+	if err := w.file.Sync(); err != nil {
+		return err
+	}
+	
+	w.records--
+	w.size = frameStart
+	return nil
+}
+
+// truncate обрезает файл журнала до длины size
+func (w *wal) truncate(size int64) error {
+	if err := w.file.Truncate(size); err != nil {
+		return err
+	}
+	_, err := w.file.Seek(size, io.SeekStart)
+	return err
+}
+
+// reset очищает журнал после контрольной точки
+func (w *wal) reset() error {
+	if err := w.truncate(0); err != nil {
+		return err
+	}
+	if err := w.file.Sync(); err != nil {
+		return err
+	}
+	
+	w.records = 0
+	w.size = 0
+	return nil
+}
+
+// skippedRecord описывает запись журнала, пропущенную при восстановлении
+func skippedRecord(n int, record walRecord, err error) error {
+	return fmt.Errorf("запись журнала %d (%s %s) пропущена: %w", n, record.Op, record.ID, err)
+}
+
+// close закрывает файл журнала
+func (w *wal) close() error {
+	return w.file.Close()
+}
+
+// WriteFileAtomic записывает файл через временный файл и переименование,
+// поэтому после сбоя на диске остается либо старая, либо новая версия
+func WriteFileAtomic(path string, data []byte) error {
+	tmpPath := path + ".tmp"
+	
+	file, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	
+	return os.Rename(tmpPath, path)
+}
+
+// syncFile сбрасывает содержимое файла на диск; отсутствующий файл пропускается
+func syncFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer file.Close()
+	
+	return file.Sync()
+}
+
+// syncDir сбрасывает на диск записи директории: созданные, переименованные
+// и удаленные файлы. В Windows синхронизация директорий не поддерживается
+func syncDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	
+	return d.Sync()
+}
+
+// removeTempFiles удаляет временные файлы, оставшиеся после сбоя во время записи
+func removeTempFiles(dir string) error {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	
+	for _, file := range files {
+		if filepath.Ext(file.Name()) != ".tmp" {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, file.Name())); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	
+	return nil
+}
+
+// replay применяет к файлам документов записи журнала, оставшиеся после
+// предыдущего запуска. Повторное применение записи безопасно. Запись, которую
+// нельзя применить ни при каких условиях (неверный ID, нет документа,
+// неизвестная операция), пропускается и попадает в Skipped; ошибка
+// ввода-вывода прерывает восстановление
+func (fs *FileStorage) replay(records []walRecord) error {
+	for i, record := range records {
+		if err := checkRecord(record); err != nil {
+			fs.Skipped = append(fs.Skipped, skippedRecord(i+1, record, err))
+			continue
+		}
+		
+		switch record.Op {
+		case walOpSave:
+			if err := fs.writeDocument(*record.Doc); err != nil {
+				return err
+			}
+		case walOpDelete:
+			if err := fs.removeDocument(record.ID); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	
+	return nil
+}
+
+// checkRecord проверяет, что запись журнала файлового хранилища применима
+func checkRecord(record walRecord) error {
+	switch record.Op {
+	case walOpSave:
+		if record.Doc == nil {
+			return errors.New("запись не содержит документа")
+		}
+		return validateFileID(record.Doc.ID)
+	case walOpDelete:
+		return validateFileID(record.ID)
+	}
+	return fmt.Errorf("неизвестная операция журнала %q", record.Op)
+}
+
+// checkpoint сбрасывает на диск файлы документов, измененные после предыдущей
+// контрольной точки, и очищает журнал. Вызывается с захваченной блокировкой
+func (fs *FileStorage) checkpoint() error {
+	for id := range fs.dirty {
+		if err := syncFile(fs.documentPath(id)); err != nil {
+			return err
+		}
+	}
+	if err := syncDir(fs.Dir); err != nil {
+		return err
+	}
+	if err := fs.wal.reset(); err != nil {
+		return err
+	}
+	
+	fs.dirty = make(map[string]bool)
+	return nil
+}
+
+// Journal - журнал упреждающей записи для произвольных записей, кодируемых
+// в JSON. Записи хранятся в тех же кадрах с контрольной суммой, что и журнал
+// хранилища. Journal не защищен от параллельного использования
+type Journal struct {
+	wal *wal
+}
+
+// OpenJournal открывает или создает журнал и возвращает его целые записи;
+// оборванный хвост журнала отбрасывается
+func OpenJournal(path string) (*Journal, []json.RawMessage, error) {
+	w, records, err := openFrames(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &Journal{wal: w}, records, nil
+}
+
+// Append записывает запись в журнал и дожидается ее сброса на диск
+func (j *Journal) Append(record interface{}) error {
+	return j.wal.append(record)
+}
+
+// Reset очищает журнал
+func (j *Journal) Reset() error {
+	return j.wal.reset()
+}
+
+// Close закрывает файл журнала
+func (j *Journal) Close() error {
+	return j.wal.close()
+}
@@ -0,0 +1,251 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// fileSize возвращает размер файла
+func fileSize(t *testing.T, path string) int64 {
+	t.Helper()
+	
+	st, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return st.Size()
+}
+
+// walSize возвращает размер журнала хранилища в директории dir
+func walSize(t *testing.T, dir string) int64 {
+	t.Helper()
+	
+	return fileSize(t, filepath.Join(dir, walFileName))
+}
+
+func TestFileStorageRecoversAfterCrash(t *testing.T) {
+	dir := t.TempDir()
+	fs, err := NewFileStorage(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs.CheckpointInterval = 0
+	
+	for _, id := range []string{"a", "b", "c"} {
+		if err := fs.Save(Document{ID: id, Content: map[string]interface{}{"v": id}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := fs.Delete("b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Delete("zzz"); err == nil {
+		t.Fatal("удаление несуществующего документа должно вернуть ошибку")
+	}
+	
+	// Сбой: недописанный файл документа, временный файл и оборванный кадр журнала
+	os.WriteFile(filepath.Join(dir, "a.json"), []byte(`{"_id":"a","con`), 0644)
+	os.WriteFile(filepath.Join(dir, "c.json.tmp"), []byte(`junk`), 0644)
+	os.WriteFile(filepath.Join(dir, "b.json"), []byte(`{"_id":"b","content":{}}`), 0644)
+	f, err := os.OpenFile(filepath.Join(dir, walFileName), os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{50, 0, 0, 0, 1, 2, 3, 4, '{'})
+	f.Close()
+	
+	fs2, err := NewFileStorage(dir, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if docs, _ := fs2.List(); len(docs) != 2 {
+		t.Fatalf("после восстановления %d документов, ожидалось 2", len(docs))
+	}
+	if doc, err := fs2.Get("a"); err != nil || doc.Content["v"] != "a" {
+		t.Fatalf("документ a не восстановлен: %v, %v", doc, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "c.json.tmp")); !os.IsNotExist(err) {
+		t.Fatal("временный файл не удален")
+	}
+	if size := walSize(t, dir); size != 0 {
+		t.Fatalf("журнал не очищен: %d байт", size)
+	}
+	
+	fs2.CheckpointInterval = 2
+	fs2.Save(Document{ID: "d", Content: map[string]interface{}{}})
+	if walSize(t, dir) == 0 {
+		t.Fatal("запись не попала в журнал")
+	}
+	fs2.Save(Document{ID: "e", Content: map[string]interface{}{}})
+	if size := walSize(t, dir); size != 0 {
+		t.Fatalf("контрольная точка не выполнена: %d байт", size)
+	}
+	fs2.Save(Document{ID: "f", Content: map[string]interface{}{}})
+	
+	if err := fs2.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs2.Save(Document{ID: "g"}); err != ErrStorageClosed {
+		t.Fatalf("ожидалась ErrStorageClosed, получено %v", err)
+	}
+	
+	fs3, err := NewFileStorage(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := fs3.Count(); n != 5 {
+		t.Fatalf("после повторного открытия %d документов, ожидалось 5", n)
+	}
+}
+
+func TestFileStorageReopensAfterFailedWrite(t *testing.T) {
+	dir := t.TempDir()
+	fs, err := NewFileStorage(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs.CheckpointInterval = 0
+	
+	if err := fs.Save(Document{ID: "a", Content: map[string]interface{}{}}); err != nil {
+		t.Fatal(err)
+	}
+	size := walSize(t, dir)
+	
+	for _, id := range []string{"no/such/dir", "", "..", `a\b`} {
+		if err := fs.Save(Document{ID: id, Content: map[string]interface{}{}}); err == nil {
+			t.Fatalf("сохранение с ID %q должно вернуть ошибку", id)
+		}
+	}
+	if err := fs.Delete("no/such/dir"); err == nil {
+		t.Fatal("удаление с ID no/such/dir должно вернуть ошибку")
+	}
+	if got := walSize(t, dir); got != size {
+		t.Fatalf("неудачная запись осталась в журнале: %d байт, ожидалось %d", got, size)
+	}
+	
+	// Изменение, которое не удалось применить, откатывается из журнала
+	os.Mkdir(filepath.Join(dir, "b.json"), 0755)
+	if err := fs.Save(Document{ID: "b", Content: map[string]interface{}{}}); err == nil {
+		t.Fatal("запись поверх директории должна вернуть ошибку")
+	}
+	if got := walSize(t, dir); got != size {
+		t.Fatalf("непримененная запись осталась в журнале: %d байт, ожидалось %d", got, size)
+	}
+	os.Remove(filepath.Join(dir, "b.json"))
+	if err := fs.Save(Document{ID: "c", Content: map[string]interface{}{}}); err != nil {
+		t.Fatal(err)
+	}
+	
+	fs2, err := NewFileStorage(dir, false)
+	if err != nil {
+		t.Fatalf("хранилище не открывается после неудачной записи: %v", err)
+	}
+	if len(fs2.Skipped) != 0 {
+		t.Fatalf("пропущены записи: %v", fs2.Skipped)
+	}
+	if n, _ := fs2.Count(); n != 2 {
+		t.Fatalf("после повторного открытия %d документов, ожидалось 2", n)
+	}
+}
+
+func TestFileStorageSkipsInapplicableRecords(t *testing.T) {
+	dir := t.TempDir()
+	w, _, err := openWAL(filepath.Join(dir, walFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, record := range []walRecord{
+		{Op: walOpSave, ID: "a", Doc: &Document{ID: "a", Content: map[string]interface{}{}}},
+		{Op: walOpSave, ID: "x/y", Doc: &Document{ID: "x/y"}},
+		{Op: walOpSave, ID: "b"},
+		{Op: "move", ID: "a"},
+		{Op: walOpDelete, ID: "c"},
+	} {
+		if err := w.append(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w.close()
+	
+	fs, err := NewFileStorage(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fs.Skipped) != 3 {
+		t.Fatalf("пропущено %d записей, ожидалось 3: %v", len(fs.Skipped), fs.Skipped)
+	}
+	if _, err := fs.Get("a"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWALDropsTornTail(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "full.log")
+	w, _, err := openWAL(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bounds := []int64{0}
+	for _, id := range []string{"a", "bb", "ccc", "dddd"} {
+		if err := w.append(walRecord{Op: walOpSave, ID: id, Doc: &Document{ID: id, Content: map[string]interface{}{"v": id}}}); err != nil {
+			t.Fatal(err)
+		}
+		bounds = append(bounds, fileSize(t, path))
+	}
+	w.close()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	
+	// complete возвращает число кадров, целиком лежащих в первых n байтах
+	complete := func(n int64) int {
+		k := 0
+		for k+1 < len(bounds) && bounds[k+1] <= n {
+			k++
+		}
+		return k
+	}
+	
+	// Журнал, оборванный на любом байте, открывается с целыми кадрами до места обрыва
+	cut := filepath.Join(dir, "cut.log")
+	for n := int64(0); n <= int64(len(data)); n++ {
+		os.WriteFile(cut, data[:n], 0644)
+		w, records, err := openWAL(cut)
+		if err != nil {
+			t.Fatalf("обрыв на %d байте: %v", n, err)
+		}
+		k := complete(n)
+		if size := fileSize(t, cut); len(records) != k || size != bounds[k] {
+			t.Fatalf("обрыв на %d байте: %d записей и %d байт, ожидалось %d и %d", n, len(records), size, k, bounds[k])
+		}
+		
+		// Новая запись следует сразу за последним целым кадром
+		if err := w.append(walRecord{Op: walOpDelete, ID: "a"}); err != nil {
+			t.Fatal(err)
+		}
+		w.close()
+		w, records, err = openWAL(cut)
+		if err != nil || len(records) != k+1 || records[k].Op != walOpDelete {
+			t.Fatalf("обрыв на %d байте: после дозаписи %d записей, %v", n, len(records), err)
+		}
+		w.close()
+	}
+	
+	// Поврежденный кадр отбрасывается вместе со всеми следующими
+	for k := 0; k+1 < len(bounds); k++ {
+		corrupted := append([]byte(nil), data...)
+		corrupted[bounds[k]+walHeaderSize+1] ^= 0xff
+		os.WriteFile(cut, corrupted, 0644)
+		w, records, err := openWAL(cut)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.close()
+		if len(records) != k {
+			t.Fatalf("поврежден кадр %d: прочитано %d записей", k+1, len(records))
+		}
+	}
+}